@@ -0,0 +1,563 @@
+package main
+
+import (
+	"content-coding-gpt/pkg/data"
+	"content-coding-gpt/pkg/openai"
+	"content-coding-gpt/pkg/store"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// initBatchCmd initializes the batch commands.
+func initBatchCmd(root *cobra.Command) {
+	// Batch Command
+	batchCmd := &cobra.Command{
+		Use:   "batch",
+		Short: "Chat complete essays with the Batch API",
+		Long: "Chat complete essays asynchronously with the OpenAI Batch API, at half the price of " +
+			"chat batch, within a 24 hour completion window.",
+	}
+	root.AddCommand(batchCmd)
+
+	// Submit Command
+	submitCmd := &cobra.Command{
+		Use:   "submit <essayType>",
+		Short: "Submit a batch of essays",
+		Long: "Write a chat completion request for every essay of the specified type from the input file " +
+			"(default data/original/essays.csv) to a batch input file, with the pid as the custom ID, " +
+			"upload it, and create a batch. The scoring flags are saved in <dir>/<batchID>.json for " +
+			"batch collect.",
+		Args: cobra.ExactArgs(1),
+		RunE: submitBatch,
+	}
+	addBatchScoringFlags(submitCmd)
+	submitCmd.Flags().String("dir", "data/batches", "Directory of the batch input and state files")
+	submitCmd.Flags().String("window", "24h", "Completion window")
+	submitCmd.Flags().Bool("dry-run", false, "Write the batch input file without submitting it")
+	batchCmd.AddCommand(submitCmd)
+
+	// Status Command
+	statusCmd := &cobra.Command{
+		Use:   "status [batchID]...",
+		Short: "Show the status of batches",
+		Long:  "Show the status and request counts of the specified batches, or else list the batches.",
+		RunE:  batchStatus,
+	}
+	statusCmd.Flags().BoolP("raw", "r", false, "Raw OpenAI Response?")
+	addListFlags(statusCmd, true)
+	batchCmd.AddCommand(statusCmd)
+
+	// Collect Command
+	collectCmd := &cobra.Command{
+		Use:   "collect <batchID> <csvFile>",
+		Short: "Collect the scores of a batch",
+		Long: "Wait for a batch to finish, download its output and error files to --dir, and write " +
+			"the scores to the results file, as chat batch does. The scoring flags saved by batch submit " +
+			"are used unless they are given again. An expired or cancelled batch is collected with " +
+			"whatever output it has. Errors reading the batch are retried, up to 5 in a row.",
+		Args: cobra.ExactArgs(2),
+		RunE: collectBatch,
+	}
+	addBatchScoringFlags(collectCmd)
+	collectCmd.Flags().String("dir", "data/batches", "Directory of the batch state and output files")
+	collectCmd.Flags().Duration("interval", time.Minute, "Polling interval")
+	collectCmd.Flags().Duration("timeout", 0, "Give up waiting after this long, with exit status 3 (default: never)")
+	addFormatFlag(collectCmd)
+	addStoreFlag(collectCmd, "Record the collected run in this results database")
+	batchCmd.AddCommand(collectCmd)
+
+	// Cancel Command
+	cancelCmd := &cobra.Command{
+		Use:   "cancel <batchID> [batchID]...",
+		Short: "Cancel batches",
+		Long:  "Cancel one or more batches in progress. Their partial output can still be collected.",
+		Args:  cobra.MinimumNArgs(1),
+		RunE:  cancelBatch,
+	}
+	batchCmd.AddCommand(cancelCmd)
+}
+
+// addBatchScoringFlags adds the flags that configure the chat requests and
+// score extraction of a batch.
+func addBatchScoringFlags(cmd *cobra.Command) {
+	addInputFlags(cmd)
+	cmd.Flags().BoolP("reverse", "R", false, "Extract the score from the end of the response?")
+	cmd.Flags().StringP("extractor", "x", "", "Score extractor: first, last, label[:Label], regex:Pattern, json[:field], or likert (default first)")
+	cmd.Flags().String("score-range", "", "Allowed score range, e.g. 0,5 (default -1,1 for the built-in prompt)")
+	cmd.Flags().IntP("max-tokens", "t", 0, "Maximum number of tokens to generate")
+	cmd.Flags().Float32P("temperature", "T", 0.2, "Temperature for sampling")
+	cmd.Flags().StringP("model", "m", "gpt-3.5-turbo", "Model ID")
+	cmd.Flags().StringP("prompt", "p", "", "Prompt template file (text/template with optional YAML front matter)")
+	addFewShotFlags(cmd)
+	addSamplingFlags(cmd)
+}
+
+// batchState is the local record of a submitted batch, which batch collect
+// needs to score it.
+type batchState struct {
+	BatchID   string              `json:"batch_id"`
+	EssayType string              `json:"essay_type"`
+	Input     string              `json:"input"` // the batch input file
+	Requests  int                 `json:"requests"`
+	Flags     map[string][]string `json:"flags,omitempty"` // scoring flags given to batch submit
+	Submitted time.Time           `json:"submitted"`
+}
+
+// batchStatePath returns the path of a batch's state file.
+func batchStatePath(dir, batchID string) string {
+	return filepath.Join(dir, batchID+".json")
+}
+
+// scoringFlags returns the scoring flags given on the command line.
+func scoringFlags(cmd *cobra.Command) map[string][]string {
+	flags := map[string][]string{}
+	cmd.Flags().Visit(func(f *pflag.Flag) {
+		switch f.Name {
+		case "dir", "window", "dry-run":
+			return
+		}
+		if s, ok := f.Value.(pflag.SliceValue); ok {
+			flags[f.Name] = s.GetSlice()
+		} else {
+			flags[f.Name] = []string{f.Value.String()}
+		}
+	})
+	return flags
+}
+
+// applyScoringFlags sets the saved scoring flags that were not given on the
+// command line.
+func applyScoringFlags(cmd *cobra.Command, flags map[string][]string) error {
+	for name, values := range flags {
+		f := cmd.Flags().Lookup(name)
+		if f == nil || f.Changed {
+			continue
+		}
+		var err error
+		if s, ok := f.Value.(pflag.SliceValue); ok {
+			err = s.Replace(values)
+		} else if len(values) > 0 {
+			err = f.Value.Set(values[0])
+		}
+		if err != nil {
+			return fmt.Errorf("saved flag --%s: %w", name, err)
+		}
+		f.Changed = true
+	}
+	return nil
+}
+
+// uniqueEssays returns the essays without any repeated pids, which can't be
+// told apart in a batch, since the pid is the custom ID.
+func uniqueEssays(essays []data.EssayRecord) []data.EssayRecord {
+	seen := make(map[int]bool, len(essays))
+	unique := make([]data.EssayRecord, 0, len(essays))
+	for _, essay := range essays {
+		if seen[essay.ID] {
+			fmt.Printf("pid %d: repeated pid skipped\n", essay.ID)
+			continue
+		}
+		seen[essay.ID] = true
+		unique = append(unique, essay)
+	}
+	return unique
+}
+
+// submitBatch writes a batch input file of chat requests for the essays of a
+// specified type, uploads it, and creates a batch.
+func submitBatch(cmd *cobra.Command, args []string) error {
+	ctx := context.Background()
+	dir, _ := cmd.Flags().GetString("dir")
+	window, _ := cmd.Flags().GetString("window")
+	dryRun, _ := cmd.Flags().GetBool("dry-run")
+	essayType := args[0]
+
+	// Configure the chat requests, checking the extractor for batch collect:
+	builder, err := chatBuilder(cmd)
+	if err != nil {
+		return err
+	}
+	if _, err := scoreExtractor(cmd, builder); err != nil {
+		return err
+	}
+	builder.FewShot, err = fewShot(cmd, essayType)
+	if err != nil {
+		return err
+	}
+	sampling, err := samplingOptions(cmd)
+	if err != nil {
+		return err
+	}
+	if !apiClient.ValidModel(ctx, builder.Model) {
+		return fmt.Errorf("model %s is not a recognized model ID", builder.Model)
+	}
+
+	// Generate the chat requests, with the pid as the custom ID:
+	essays, _, err := readEssays(cmd, essayType)
+	if err != nil {
+		return err
+	}
+	essays = uniqueEssays(essays)
+	chats := make([]openai.Chat, 0, len(essays)*sampling.N)
+	for _, essay := range essays {
+		request, err := builder.ChatRequest(essay, essayType)
+		if err != nil {
+			return err
+		}
+		for j, r := range sampling.Requests(request) {
+			chats = append(chats, openai.Chat{ID: sampleID(essay.ID, j), Request: r})
+		}
+	}
+
+	// Write the batch input file:
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("submit batch: %w", err)
+	}
+	input := filepath.Join(dir, fmt.Sprintf("%s_%s.jsonl", essayType, time.Now().Format("20060102-150405")))
+	f, err := os.Create(input)
+	if err != nil {
+		return fmt.Errorf("submit batch: %w", err)
+	}
+	err = openai.WriteBatchChats(f, chats)
+	if e := f.Close(); err == nil && e != nil {
+		err = fmt.Errorf("submit batch: %w", e)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("wrote %s: %d requests for %d essays\n", input, len(chats), len(essays))
+	if dryRun {
+		return nil
+	}
+
+	// Upload the input file, and create the batch:
+	file, err := uploadPath(ctx, input, "batch")
+	if err != nil {
+		return err
+	}
+	batch, err := apiClient.CreateBatch(ctx, openai.BatchRequest{
+		InputFileID:      file.ID,
+		Endpoint:         openai.ChatCompletionsEndpoint,
+		CompletionWindow: window,
+		Metadata:         map[string]string{"essay_type": essayType, "input": filepath.Base(input)},
+	})
+	if err != nil {
+		return err
+	}
+	state := batchState{
+		BatchID:   batch.ID,
+		EssayType: essayType,
+		Input:     input,
+		Requests:  len(chats),
+		Flags:     scoringFlags(cmd),
+		Submitted: time.Now().UTC(),
+	}
+	b, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("submit batch: %w", err)
+	}
+	if err := os.WriteFile(batchStatePath(dir, batch.ID), append(b, '\n'), 0644); err != nil {
+		return fmt.Errorf("submit batch: %w", err)
+	}
+	fmt.Printf("submitted batch %s (%s)\n", batch.ID, batch.Status)
+	fmt.Printf("collect the scores with: gpt batch collect %s <csvFile>\n", batch.ID)
+	return nil
+}
+
+// batchStatus shows the status of the specified batches, or lists them.
+func batchStatus(cmd *cobra.Command, args []string) error {
+	ctx := context.Background()
+	raw, _ := cmd.Flags().GetBool("raw")
+	for _, id := range args {
+		if raw {
+			body, err := apiClient.ReadBatchRaw(ctx, id)
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(body))
+			continue
+		}
+		batch, err := apiClient.ReadBatch(ctx, id)
+		if err != nil {
+			return err
+		}
+		printBatch(batch)
+	}
+	if len(args) > 0 {
+		return nil
+	}
+	it := apiClient.IterateBatches(ctx, listOptions(cmd))
+	for it.Next() {
+		printBatch(it.Value())
+	}
+	return it.Err()
+}
+
+// printBatch prints a one-line summary of a batch.
+func printBatch(batch openai.Batch) {
+	created := time.Unix(batch.CreatedAt, 0).Format(time.DateTime)
+	fmt.Printf("%s %s %s\n", batch, created, batch.Metadata["essay_type"])
+	if batch.Errors != nil {
+		fmt.Println(batch.Errors)
+	}
+}
+
+// cancelBatch cancels the specified batches.
+func cancelBatch(cmd *cobra.Command, args []string) error {
+	ctx := context.Background()
+	for _, id := range args {
+		batch, err := apiClient.CancelBatch(ctx, id)
+		if err != nil {
+			return err
+		}
+		printBatch(batch)
+	}
+	return nil
+}
+
+// collectBatch waits for a batch to finish, and writes the scores of its
+// responses to the specified results file.
+func collectBatch(cmd *cobra.Command, args []string) error {
+	startTime := time.Now()
+	ctx := context.Background()
+	dir, _ := cmd.Flags().GetString("dir")
+	interval, _ := cmd.Flags().GetDuration("interval")
+	timeout, _ := cmd.Flags().GetDuration("timeout")
+	batchID, csvFile := args[0], args[1]
+	if interval <= 0 {
+		return fmt.Errorf("interval must be positive")
+	}
+	format, err := resultsFormat(cmd, csvFile)
+	if err != nil {
+		return err
+	}
+
+	// Read the batch state, and configure the scoring as submitted:
+	var state batchState
+	b, err := os.ReadFile(batchStatePath(dir, batchID))
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("collect batch %s: no state file in %s; was it submitted with batch submit?", batchID, dir)
+	} else if err != nil {
+		return fmt.Errorf("collect batch %s: %w", batchID, err)
+	}
+	if err := json.Unmarshal(b, &state); err != nil {
+		return fmt.Errorf("collect batch %s: %w", batchID, err)
+	}
+	if err := applyScoringFlags(cmd, state.Flags); err != nil {
+		return err
+	}
+	builder, err := chatBuilder(cmd)
+	if err != nil {
+		return err
+	}
+	extractor, err := scoreExtractor(cmd, builder)
+	if err != nil {
+		return err
+	}
+	sampling, err := samplingOptions(cmd)
+	if err != nil {
+		return err
+	}
+	essays, schema, err := readEssays(cmd, state.EssayType)
+	if err != nil {
+		return err
+	}
+	essays = uniqueEssays(essays)
+	manifest, err := newProvenance(cmd, state.EssayType)
+	if err != nil {
+		return err
+	}
+
+	// Wait for the batch to finish, giving up after 5 consecutive failures to
+	// read it, as tune watch does:
+	var batch openai.Batch
+	var status string
+	var failures int
+	for {
+		batch, err = apiClient.ReadBatch(ctx, batchID)
+		if err != nil {
+			failures++
+			if failures >= 5 {
+				return err
+			}
+			fmt.Printf("%s: %v (retrying)\n", time.Now().Format(time.TimeOnly), err)
+		} else {
+			failures = 0
+			if batch.Status != status {
+				fmt.Printf("%s: %s\n", time.Now().Format(time.TimeOnly), batch)
+				status = batch.Status
+			}
+			if batch.Done() {
+				break
+			}
+		}
+		if timeout > 0 && time.Since(startTime) >= timeout {
+			return exitError{code: 3, err: fmt.Errorf("batch %s still %s after %s", batchID, status, timeout)}
+		}
+		time.Sleep(interval)
+	}
+	if batch.OutputFileID == "" && batch.ErrorFileID == "" {
+		if batch.Errors != nil {
+			return fmt.Errorf("batch %s %s:\n%w", batchID, batch.Status, batch.Errors)
+		}
+		return fmt.Errorf("batch %s %s without output", batchID, batch.Status)
+	}
+
+	// Download the output and error files, and read the responses:
+	results := make(map[string]openai.Chat, state.Requests)
+	for suffix, fileID := range map[string]string{"output": batch.OutputFileID, "errors": batch.ErrorFileID} {
+		if fileID == "" {
+			continue
+		}
+		path := filepath.Join(dir, batchID+"_"+suffix+".jsonl")
+		if err := downloadPath(ctx, fileID, path, openai.TransferOptions{}); err != nil {
+			return err
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("collect batch %s: %w", batchID, err)
+		}
+		chats, err := openai.ReadBatchChats(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("collect batch %s: %s: %w", batchID, path, err)
+		}
+		for id, chat := range chats {
+			results[id] = chat
+		}
+		if err := manifest.AddFile("batch_"+suffix, path); err != nil {
+			return err
+		}
+	}
+	for _, chat := range results {
+		if chat.ErrMsg == "" {
+			manifest.AddChat(chat.Response)
+		}
+	}
+
+	// Add the requests of the batch input file, for the JSONL format and the
+	// results store:
+	storePath, _ := cmd.Flags().GetString("store")
+	if format == data.JSONLFormat || storePath != "" {
+		f, err := os.Open(state.Input)
+		if err != nil {
+			return fmt.Errorf("collect batch %s: %w", batchID, err)
+		}
+		requests, err := openai.ReadBatchRequests(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("collect batch %s: %s: %w", batchID, state.Input, err)
+		}
+		for id, chat := range results {
+			chat.Request = requests[id]
+			results[id] = chat
+		}
+	}
+
+	// Record the run in the results store, if any:
+	run := chatRun(cmd, store.BatchCollectCommand, builder, extractor, sampling, state.EssayType,
+		[]string{builder.Model}, csvFile)
+	db, err := openRunStore(cmd, &run, essays)
+	if err != nil {
+		return err
+	}
+	if db != nil {
+		defer db.Close()
+	}
+
+	// Score the essays:
+	var count, failed, notFound, outOfRange, review int
+	scores := make([]data.EssayScore, 0, len(essays))
+	var exchanges [][]data.Exchange
+	var storedRequests []store.Request
+	var storedErrors []store.Error
+	samples := len(sampling.Requests(openai.ChatRequest{}))
+	for _, essay := range essays {
+		count++
+		label := fmt.Sprintf("%d: pid %d", count, essay.ID)
+		for j := 0; j < samples; j++ {
+			if chat, ok := results[sampleID(essay.ID, j)]; ok {
+				storedRequests = append(storedRequests, store.Request{PID: essay.ID, Model: builder.Model, Sample: j, Chat: chat})
+			}
+		}
+		// Score the samples that succeeded, if any:
+		sampled, errMsg := sampleChats(results, samples, func(j int) string { return sampleID(essay.ID, j) })
+		if len(sampled) == 0 {
+			failed++
+			fmt.Printf("%s: %s\n", label, errMsg)
+			storedErrors = append(storedErrors, store.Error{PID: essay.ID, Model: builder.Model, Stage: store.RequestStage, Message: errMsg})
+			continue
+		}
+		var score data.EssayScore
+		if sampling.N > 1 {
+			responses := make([]openai.ChatResponse, len(sampled))
+			for j, chat := range sampled {
+				responses[j] = chat.Response
+			}
+			score, err = data.NewSampledEssayScore(essay, state.EssayType, responses, extractor, 0, sampling)
+		} else {
+			score, err = data.NewEssayScore(essay, state.EssayType, sampled[0].Response, extractor, 0)
+		}
+		if err != nil {
+			if errors.Is(err, data.ErrScoreOutOfRange) {
+				outOfRange++
+			} else {
+				notFound++
+			}
+			fmt.Printf("%s: %v\n", label, err)
+			storedErrors = append(storedErrors, store.Error{PID: essay.ID, Model: builder.Model, Stage: store.ExtractStage, Message: err.Error()})
+			continue
+		}
+		scores = append(scores, score)
+		if format == data.JSONLFormat {
+			var chats []openai.Chat
+			for j := 0; j < samples; j++ {
+				if chat, ok := results[sampleID(essay.ID, j)]; ok {
+					chats = append(chats, chat)
+				}
+			}
+			exchanges = append(exchanges, data.ChatExchanges(chats...))
+		}
+		if sampling.N > 1 {
+			if score.Review {
+				review++
+			}
+			fmt.Printf("%s: %.2f sd=%.2f n=%d review=%t\n", label, score.Score, score.SD, len(score.Samples), score.Review)
+			continue
+		}
+		fmt.Printf("%s: %.1f\n", label, score.Score)
+	}
+
+	// Write the scores to the specified results file:
+	out := data.EssayScoreResults(scores, schema.ExtraColumns...)
+	out.Exchanges = exchanges
+	err = data.WriteResults(csvFile, format, out)
+	if err == nil {
+		err = manifest.Write(csvFile)
+	}
+	if err == nil && db != nil {
+		err = storeBatch(db, run.ID, storedRequests, map[string][]data.EssayScore{builder.Model: scores}, storedErrors)
+	}
+	if err == nil {
+		err = endRun(db, run)
+	}
+	fmt.Printf("collected %d of %d essays from batch %s\n", len(scores), len(essays), batchID)
+	if failed > 0 {
+		fmt.Printf("%d requests failed or have no response\n", failed)
+	}
+	if notFound > 0 || outOfRange > 0 {
+		fmt.Printf("%s: %d scores not found, %d scores out of range\n", extractor, notFound, outOfRange)
+	}
+	if review > 0 {
+		fmt.Printf("%d essays flagged for review (sample sd > %g, or invalid samples)\n", review, sampling.ReviewSD)
+	}
+	return err
+}
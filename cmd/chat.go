@@ -3,8 +3,11 @@ package main
 import (
 	"content-coding-gpt/pkg/data"
 	"content-coding-gpt/pkg/openai"
+	"content-coding-gpt/pkg/stats"
+	"content-coding-gpt/pkg/store"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -45,35 +48,69 @@ func initChatCmd(root *cobra.Command) {
 	randomCmd := &cobra.Command{
 		Use:   "random <essayType>",
 		Short: "Chat complete a random essay",
-		Long:  "Chat complete a random essay of the specified type from data/original/essays.csv",
+		Long:  "Chat complete a random essay of the specified type from the input file (default data/original/essays.csv)",
 		Args:  cobra.ExactArgs(1),
 		RunE:  chatRandom,
 	}
+	addInputFlags(randomCmd)
 	randomCmd.Flags().BoolP("raw", "r", false, "Raw OpenAI Response?")
 	randomCmd.Flags().BoolP("verbose", "v", false, "Verbose output?")
 	randomCmd.Flags().BoolP("reverse", "R", false, "Extract the score from the end of the response?")
+	randomCmd.Flags().StringP("extractor", "x", "", "Score extractor: first, last, label[:Label], regex:Pattern, json[:field], or likert (default first)")
+	randomCmd.Flags().String("score-range", "", "Allowed score range, e.g. 0,5 (default -1,1 for the built-in prompt)")
 	randomCmd.Flags().IntP("max-tokens", "t", 0, "Maximum number of tokens to generate")
 	randomCmd.Flags().Float32P("temperature", "T", 0.2, "Temperature for sampling")
 	randomCmd.Flags().StringP("model", "m", "gpt-3.5-turbo", "Model ID")
 	randomCmd.Flags().IntP("id", "i", 0, "Essay ID (okay, not random :)")
-	randomCmd.Flags().StringP("prompt", "p", "", "Prompt template text file")
+	randomCmd.Flags().StringP("prompt", "p", "", "Prompt template file (text/template with optional YAML front matter)")
+	addFewShotFlags(randomCmd)
+	addSamplingFlags(randomCmd)
 	chatCmd.AddCommand(randomCmd)
 
 	// Batch Command
 	batchCmd := &cobra.Command{
 		Use:   "batch <essayType> <csvFile>",
 		Short: "Chat complete a batch of essays",
-		Long:  "Chat complete a batch of essays of the specified type from data/original/essays.csv",
+		Long:  "Chat complete a batch of essays of the specified type from the input file (default data/original/essays.csv)",
 		Args:  cobra.ExactArgs(2),
 		RunE:  chatBatch,
 	}
+	addInputFlags(batchCmd)
 	batchCmd.Flags().BoolP("reverse", "R", false, "Extract the score from the end of the response?")
+	batchCmd.Flags().StringP("extractor", "x", "", "Score extractor: first, last, label[:Label], regex:Pattern, json[:field], or likert (default first)")
+	batchCmd.Flags().String("score-range", "", "Allowed score range, e.g. 0,5 (default -1,1 for the built-in prompt)")
 	batchCmd.Flags().IntP("max-tokens", "t", 0, "Maximum number of tokens to generate")
 	batchCmd.Flags().Float32P("temperature", "T", 0.2, "Temperature for sampling")
-	batchCmd.Flags().StringP("model", "m", "gpt-3.5-turbo", "Model ID")
+	batchCmd.Flags().StringSliceP("model", "m", []string{"gpt-3.5-turbo"}, "Model ID(s); repeat for a multi-model ensemble")
+	batchCmd.Flags().StringToString("weight", nil, "Ensemble weight per model, e.g. gpt-4=2 (default 1)")
 	batchCmd.Flags().IntP("batch-size", "b", 15, "Batch size for concurrent requests")
-	batchCmd.Flags().StringP("prompt", "p", "", "Prompt template text file")
+	batchCmd.Flags().StringP("prompt", "p", "", "Prompt template file (text/template with optional YAML front matter)")
+	addFewShotFlags(batchCmd)
+	addSamplingFlags(batchCmd)
+	addStoreFlag(batchCmd, "Record the run in this results database")
+	addFormatFlag(batchCmd)
 	chatCmd.AddCommand(batchCmd)
+
+	// Rubric Command
+	rubricCmd := &cobra.Command{
+		Use:   "rubric <essayType> <csvFile>",
+		Short: "Chat code the rubric items of a batch of essays",
+		Long: "Chat code each humility (hum1-hum6) or spirituality (spir1-spir4) rubric item of a batch of " +
+			"essays, in one structured request per essay or one request per item (--per-item). The " +
+			"standardized score is computed as in the human-coded data, and the results are written " +
+			"with the same columns as the humility or spiritual training CSV files.",
+		Args: cobra.ExactArgs(2),
+		RunE: chatRubric,
+	}
+	addInputFlags(rubricCmd)
+	rubricCmd.Flags().Bool("per-item", false, "Request each rubric item separately?")
+	rubricCmd.Flags().IntP("max-tokens", "t", 0, "Maximum number of tokens to generate")
+	rubricCmd.Flags().Float32P("temperature", "T", 0.2, "Temperature for sampling")
+	rubricCmd.Flags().StringP("model", "m", "gpt-3.5-turbo", "Model ID")
+	rubricCmd.Flags().IntP("batch-size", "b", 15, "Batch size for concurrent requests")
+	addFormatFlag(rubricCmd)
+	addStoreFlag(rubricCmd, "Record the run in this results database")
+	chatCmd.AddCommand(rubricCmd)
 }
 
 // chatPrompt processes completions for a specified prompt.
@@ -157,43 +194,51 @@ func chatRandom(cmd *cobra.Command, args []string) error {
 	ctx := context.Background()
 	raw, _ := cmd.Flags().GetBool("raw")
 	verbose, _ := cmd.Flags().GetBool("verbose")
-	reverse, _ := cmd.Flags().GetBool("reverse")
-	maxTokens, _ := cmd.Flags().GetInt("max-tokens")
-	temperature, _ := cmd.Flags().GetFloat32("temperature")
-	model, _ := cmd.Flags().GetString("model")
 	id, _ := cmd.Flags().GetInt("id")
-	promptFile, _ := cmd.Flags().GetString("prompt")
 	essayType := args[0]
-	if !data.ValidEssayType(essayType) {
-		return fmt.Errorf("essay type %s is not one of: %s", essayType, strings.Join(data.EssayTypes, ", "))
+
+	// Configure the chat requests:
+	builder, err := chatBuilder(cmd)
+	if err != nil {
+		return err
+	}
+	extractor, err := scoreExtractor(cmd, builder)
+	if err != nil {
+		return err
+	}
+	builder.FewShot, err = fewShot(cmd, essayType)
+	if err != nil {
+		return err
+	}
+	sampling, err := samplingOptions(cmd)
+	if err != nil {
+		return err
 	}
 
 	// Validate the model:
-	if !apiClient.ValidModel(ctx, model) {
-		return fmt.Errorf("model %s is not a recognized model ID", model)
+	if !apiClient.ValidModel(ctx, builder.Model) {
+		return fmt.Errorf("model %s is not a recognized model ID", builder.Model)
 	}
 
 	// Select an essay for a chat request:
+	essays, _, err := readEssays(cmd, essayType)
+	if err != nil {
+		return err
+	}
 	var essay data.EssayRecord
-	var err error
 	if id > 0 {
-		essay, err = data.ReadEssayRecord(id)
+		essay, err = data.FindEssayRecord(essays, id)
 	} else {
-		essay, err = data.RandomEssayRecord()
+		essay, err = data.RandomEssayRecord(essays, essayType)
 	}
 	if err != nil {
 		return err
 	}
 
 	// Generate the chat request:
-	var request openai.ChatRequest
-	if promptFile != "" {
-		request, err = essay.ChatRequestTemplate(essayType, model, temperature, maxTokens, promptFile)
-		if err != nil {
-			return err
-		}
-	} else {
-		request = essay.ChatRequest(essayType, model, temperature, maxTokens)
+	request, err := builder.ChatRequest(essay, essayType)
+	if err != nil {
+		return err
 	}
 
 	// Raw response?
@@ -213,18 +258,28 @@ func chatRandom(cmd *cobra.Command, args []string) error {
 		return nil
 	}
 
-	// Chat complete the prompt:
-	response, err := apiClient.ChatCompletion(ctx, request)
-	if err != nil {
-		return err
+	// Chat complete the prompt, sampling if requested:
+	var responses []openai.ChatResponse
+	for _, r := range sampling.Requests(request) {
+		response, err := apiClient.ChatCompletion(ctx, r)
+		if err != nil {
+			return err
+		}
+		responses = append(responses, response)
 	}
 
 	// Extract the score:
 	duration := time.Since(startTime).Milliseconds()
-	score, err := data.NewEssayScore(essay, essayType, response, reverse, duration)
+	var score data.EssayScore
+	if sampling.N > 1 {
+		score, err = data.NewSampledEssayScore(essay, essayType, responses, extractor, duration, sampling)
+	} else {
+		score, err = data.NewEssayScore(essay, essayType, responses[0], extractor, duration)
+	}
 	results := data.EssayCompletion{
 		Request:  request,
-		Response: response,
+		Response: responses[0],
+		Samples:  responses[1:],
 		Score:    score,
 	}
 	if err != nil {
@@ -243,80 +298,199 @@ func chatRandom(cmd *cobra.Command, args []string) error {
 }
 
 // chatBatch processes completions for all essays of a specified type for
-// specified model. The output is is placed in the specified CSV file.
+// one or more models. The output is is placed in the specified CSV file, which
+// has a score column per model and a weighted ensemble score if there are
+// several models.
 func chatBatch(cmd *cobra.Command, args []string) error {
 	startTime := time.Now()
 	ctx := context.Background()
-	reverse, _ := cmd.Flags().GetBool("reverse")
-	maxTokens, _ := cmd.Flags().GetInt("max-tokens")
-	temperature, _ := cmd.Flags().GetFloat32("temperature")
-	model, _ := cmd.Flags().GetString("model")
 	batchSize, _ := cmd.Flags().GetInt("batch-size")
-	promptFile, _ := cmd.Flags().GetString("prompt")
+	weightFlags, _ := cmd.Flags().GetStringToString("weight")
+	essayType := args[0]
 	csvFile := args[1]
+	format, err := resultsFormat(cmd, csvFile)
+	if err != nil {
+		return err
+	}
 
-	// Validate the specified essay type:
-	essayType := args[0]
-	if !data.ValidEssayType(essayType) {
-		return fmt.Errorf("essay type %s is not one of: %s", essayType, strings.Join(data.EssayTypes, ", "))
+	// Configure the chat requests:
+	builder, err := chatBuilder(cmd)
+	if err != nil {
+		return err
+	}
+	extractor, err := scoreExtractor(cmd, builder)
+	if err != nil {
+		return err
+	}
+	builder.FewShot, err = fewShot(cmd, essayType)
+	if err != nil {
+		return err
+	}
+	sampling, err := samplingOptions(cmd)
+	if err != nil {
+		return err
 	}
 
-	// Validate the model:
-	if !apiClient.ValidModel(ctx, model) {
-		return fmt.Errorf("model %s is not a recognized model ID", model)
+	// Validate the models, and configure the ensemble:
+	models, err := chatModels(cmd, builder)
+	if err != nil {
+		return err
+	}
+	for _, model := range models {
+		if !apiClient.ValidModel(ctx, model) {
+			return fmt.Errorf("model %s is not a recognized model ID", model)
+		}
+	}
+	weights := make(map[string]float64, len(weightFlags))
+	for model, w := range weightFlags {
+		weights[model], err = strconv.ParseFloat(w, 64)
+		if err != nil {
+			return fmt.Errorf("invalid weight for model %s: %w", model, err)
+		}
+	}
+	ensemble, err := data.NewEnsemble(models, weights)
+	if err != nil {
+		return err
 	}
 
-	// Load the essays:
-	essays, err := data.ReadEssayRecords("data/original/essays.csv")
+	// Load the essays, and start the provenance manifest:
+	essays, schema, err := readEssays(cmd, essayType)
+	if err != nil {
+		return err
+	}
+	manifest, err := newProvenance(cmd, essayType)
 	if err != nil {
 		return err
 	}
 
-	// Process the essays in batches:
-	var count int
+	// Record the run in the results store, if any:
+	run := chatRun(cmd, store.ChatBatchCommand, builder, extractor, sampling, essayType, models, csvFile)
+	db, err := openRunStore(cmd, &run, essays)
+	if err != nil {
+		return err
+	}
+	if db != nil {
+		defer db.Close()
+	}
+
+	// Process the essays in batches, keeping the exchanges of each essay for
+	// the JSONL format:
+	var count, notFound, outOfRange, review int
 	scores := make([]data.EssayScore, 0, len(essays))
+	ensembleScores := make([]data.EnsembleScore, 0, len(essays))
+	var exchanges [][]data.Exchange
+	samples := len(sampling.Requests(openai.ChatRequest{}))
 	batches := data.Batch(essays, batchSize)
 	for i, batch := range batches {
 		batchStart := time.Now()
-		// Generate the chat requests:
-		chats := make([]openai.Chat, 0, len(batch))
-		for _, essay := range batch {
-			var request openai.ChatRequest
-			if promptFile != "" {
-				request, err = essay.ChatRequestTemplate(essayType, model, temperature, maxTokens, promptFile)
-				if err != nil {
-					return err // error reading the template file
+		// Generate the chat requests for every model:
+		chats := make([]openai.Chat, 0, len(batch)*len(models))
+		requests := make(map[string]store.Request, len(batch)*len(models))
+		for _, model := range models {
+			b := builder
+			b.Model = model
+			for _, essay := range batch {
+				request, e := b.ChatRequest(essay, essayType)
+				if e != nil {
+					return e // error executing the template
+				}
+				for j, r := range sampling.Requests(request) {
+					chat := openai.Chat{
+						ID:      modelSampleID(models, model, essay.ID, j),
+						Request: r,
+					}
+					chats = append(chats, chat)
+					requests[chat.ID] = store.Request{PID: essay.ID, Model: model, Sample: j}
 				}
-			} else {
-				request = essay.ChatRequest(essayType, model, temperature, maxTokens)
-			}
-			chat := openai.Chat{
-				ID:      strconv.Itoa(essay.ID),
-				Request: request,
 			}
-			chats = append(chats, chat)
 		}
 		// Process the batch:
 		results := apiClient.ChatBatch(ctx, chats)
+		storedRequests := make([]store.Request, 0, len(results))
+		for id, chat := range results {
+			if chat.ErrMsg == "" {
+				manifest.AddChat(chat.Response)
+			}
+			r := requests[id]
+			r.Chat = chat
+			storedRequests = append(storedRequests, r)
+		}
+		var storedErrors []store.Error
+		storedScores := make(map[string][]data.EssayScore, len(models))
 		for _, essay := range batch {
 			count++
-			chat, ok := results[strconv.Itoa(essay.ID)]
-			if !ok {
-				fmt.Printf("%d: pid %d: no response\n", count, essay.ID)
-				continue
+			modelScores := make([]*data.EssayScore, len(models))
+			var essayChats []openai.Chat
+			for m, model := range models {
+				for j := 0; format == data.JSONLFormat && j < samples; j++ {
+					if chat, ok := results[modelSampleID(models, model, essay.ID, j)]; ok {
+						essayChats = append(essayChats, chat)
+					}
+				}
+				label := fmt.Sprintf("%d: pid %d", count, essay.ID)
+				if len(models) > 1 {
+					label += ": " + model
+				}
+				// Score the samples that succeeded, if any:
+				modelChats, errMsg := sampleChats(results, samples, func(j int) string {
+					return modelSampleID(models, model, essay.ID, j)
+				})
+				if len(modelChats) == 0 {
+					fmt.Printf("%s: %s\n", label, errMsg)
+					storedErrors = append(storedErrors, store.Error{PID: essay.ID, Model: model, Stage: store.RequestStage, Message: errMsg})
+					continue
+				}
+				var score data.EssayScore
+				var e error
+				if sampling.N > 1 {
+					responses := make([]openai.ChatResponse, len(modelChats))
+					for j, chat := range modelChats {
+						responses[j] = chat.Response
+					}
+					score, e = data.NewSampledEssayScore(essay, essayType, responses, extractor, modelChats[0].Millis, sampling)
+				} else {
+					score, e = data.NewEssayScore(essay, essayType, modelChats[0].Response, extractor, modelChats[0].Millis)
+				}
+				if e != nil {
+					if errors.Is(e, data.ErrScoreOutOfRange) {
+						outOfRange++
+					} else {
+						notFound++
+					}
+					fmt.Printf("%s: %v\n", label, e)
+					storedErrors = append(storedErrors, store.Error{PID: essay.ID, Model: model, Stage: store.ExtractStage, Message: e.Error()})
+					continue
+				}
+				modelScores[m] = &score
+				storedScores[model] = append(storedScores[model], score)
+				if sampling.N > 1 {
+					if score.Review {
+						review++
+					}
+					fmt.Printf("%s: %.2f sd=%.2f n=%d review=%t %d\n", label,
+						score.Score, score.SD, len(score.Samples), score.Review, score.Millis)
+					continue
+				}
+				fmt.Printf("%s: %.1f %d\n", label, score.Score, score.Millis)
 			}
-			if chat.ErrMsg != "" {
-				fmt.Printf("%d: pid %d: %s", count, essay.ID, chat.ErrMsg)
-				continue
+			if len(models) == 1 {
+				if modelScores[0] != nil {
+					scores = append(scores, *modelScores[0])
+					exchanges = append(exchanges, data.ChatExchanges(essayChats...))
+				}
+			} else if ensembleScore, ok := ensemble.Combine(essay, essayType, modelScores); ok {
+				ensembleScores = append(ensembleScores, ensembleScore)
+				exchanges = append(exchanges, data.ChatExchanges(essayChats...))
+				fmt.Printf("%d: pid %d: ensemble %.2f\n", count, essay.ID, ensembleScore.Score)
 			}
-			score, e := data.NewEssayScore(essay, essayType, chat.Response, reverse, chat.Millis)
-			if e != nil {
-				fmt.Printf("%d: pid %d: %v\n", count, essay.ID, e)
-				continue
+		}
+		// Record the batch in the results store:
+		if db != nil {
+			if err := storeBatch(db, run.ID, storedRequests, storedScores, storedErrors); err != nil {
+				return err
 			}
-			scores = append(scores, score)
-			fmt.Printf("%d: pid %d: %.1f %d\n", count, essay.ID, score.Score, score.Millis)
 		}
+
 		// Report batch time taken, progress, and predicted time remaining:
 		batchDuration := time.Since(batchStart)
 		totalDuration := time.Since(startTime)
@@ -327,10 +501,455 @@ func chatBatch(cmd *cobra.Command, args []string) error {
 			i+1, batchDuration.Milliseconds(), percentComplete, timeRemaining)
 	}
 
-	// Write the scores to the specified CSV file:
-	err = data.WriteEssayScores(csvFile, scores)
+	// Write the scores to the specified results file:
+	var out data.Results
+	if len(models) == 1 {
+		out = data.EssayScoreResults(scores, schema.ExtraColumns...)
+	} else {
+		out = data.EnsembleResults(ensemble, ensembleScores, schema.ExtraColumns...)
+		printModelAgreement(models, ensembleScores)
+	}
+	out.Exchanges = exchanges
+	err = data.WriteResults(csvFile, format, out)
+	if err == nil {
+		err = manifest.Write(csvFile)
+	}
+	if err == nil {
+		err = endRun(db, run)
+	}
 
-	// Report the total time taken:
+	// Report the total time taken and any scores rejected by the extractor:
 	fmt.Printf("completed %d essays in %s\n", len(essays), time.Since(startTime))
+	if notFound > 0 || outOfRange > 0 {
+		fmt.Printf("%s: %d scores not found, %d scores out of range\n", extractor, notFound, outOfRange)
+	}
+	if review > 0 {
+		fmt.Printf("%d essays flagged for review (sample sd > %g, or invalid samples)\n", review, sampling.ReviewSD)
+	}
+	return err
+}
+
+// chatModels returns the models specified by the --model flag, which may be
+// repeated, but not with the same model, or else the model of the chat
+// builder (e.g. from a template).
+func chatModels(cmd *cobra.Command, builder data.ChatBuilder) ([]string, error) {
+	models, err := cmd.Flags().GetStringSlice("model")
+	if err != nil || !cmd.Flags().Changed("model") || len(models) == 0 {
+		return []string{builder.Model}, nil
+	}
+	seen := make(map[string]bool, len(models))
+	for _, model := range models {
+		if seen[model] {
+			return nil, fmt.Errorf("model %s is specified more than once", model)
+		}
+		seen[model] = true
+	}
+	return models, nil
+}
+
+// modelSampleID returns the batch ID of a model's sample request for an essay.
+// The model is omitted if there is only one.
+func modelSampleID(models []string, model string, id int, sample int) string {
+	if len(models) == 1 {
+		return sampleID(id, sample)
+	}
+	return model + "/" + sampleID(id, sample)
+}
+
+// printModelAgreement reports the agreement between the models' scores: the
+// Pearson correlation and ICC(2,1) of each pair, and ICC(2,1) and
+// Krippendorff's alpha across all models, for the essays every model scored.
+func printModelAgreement(models []string, scores []data.EnsembleScore) {
+	var complete stats.Ratings
+	for _, s := range scores {
+		row := make([]float64, 0, len(models))
+		for _, score := range s.Scores {
+			if score != nil {
+				row = append(row, float64(score.Score))
+			}
+		}
+		if len(row) == len(models) {
+			complete = append(complete, row)
+		}
+	}
+	fmt.Printf("inter-model agreement (%d essays scored by all models):\n", len(complete))
+	if len(complete) < 2 {
+		return
+	}
+	column := func(j int) []float64 {
+		x := make([]float64, len(complete))
+		for i, row := range complete {
+			x[i] = row[j]
+		}
+		return x
+	}
+	for a := 0; a < len(models); a++ {
+		for b := a + 1; b < len(models); b++ {
+			x, y := column(a), column(b)
+			fmt.Printf("  %s ~ %s: pearson=%s icc(2,1)=%s\n", models[a], models[b],
+				formatStat(stats.Pearson(x, y)), formatStat(stats.ICC21(stats.Columns(x, y))))
+		}
+	}
+	fmt.Printf("  all models: icc(2,1)=%s krippendorff alpha=%s\n",
+		formatStat(stats.ICC21(complete)), formatStat(stats.KrippendorffAlpha(complete)))
+}
+
+// chatRubric codes the rubric items for all essays of a specified type. The
+// output is placed in the specified CSV file.
+func chatRubric(cmd *cobra.Command, args []string) error {
+	startTime := time.Now()
+	ctx := context.Background()
+	perItem, _ := cmd.Flags().GetBool("per-item")
+	maxTokens, _ := cmd.Flags().GetInt("max-tokens")
+	temperature, _ := cmd.Flags().GetFloat32("temperature")
+	model, _ := cmd.Flags().GetString("model")
+	batchSize, _ := cmd.Flags().GetInt("batch-size")
+	essayType := args[0]
+	csvFile := args[1]
+	format, err := resultsFormat(cmd, csvFile)
+	if err != nil {
+		return err
+	}
+
+	// Select the rubric and validate the model:
+	rubric, err := data.RubricFor(essayType)
+	if err != nil {
+		return err
+	}
+	if !apiClient.ValidModel(ctx, model) {
+		return fmt.Errorf("model %s is not a recognized model ID", model)
+	}
+
+	// Load the essays, and start the provenance manifest:
+	essays, _, err := readEssays(cmd, essayType)
+	if err != nil {
+		return err
+	}
+	manifest, err := newProvenance(cmd, essayType)
+	if err != nil {
+		return err
+	}
+
+	// Record the run in the results store, if any:
+	run := store.Run{
+		Command:   store.ChatRubricCommand,
+		Args:      os.Args,
+		EssayType: essayType,
+		Models:    []string{model},
+		Results:   csvFile,
+	}
+	db, err := openRunStore(cmd, &run, essays)
+	if err != nil {
+		return err
+	}
+	if db != nil {
+		defer db.Close()
+	}
+
+	// Process the essays in batches:
+	var count int
+	scores := make([]data.RubricScore, 0, len(essays))
+	var exchanges [][]data.Exchange
+	for _, batch := range data.Batch(essays, batchSize) {
+		// Generate the chat requests, one per essay or per essay item:
+		chats := make([]openai.Chat, 0, len(batch))
+		requests := make(map[string]store.Request, len(batch))
+		for _, essay := range batch {
+			if !perItem {
+				chat := openai.Chat{
+					ID:      strconv.Itoa(essay.ID),
+					Request: rubric.ChatRequest(essay, essayType, model, temperature, maxTokens),
+				}
+				chats = append(chats, chat)
+				requests[chat.ID] = store.Request{PID: essay.ID, Model: model}
+				continue
+			}
+			for j, item := range rubric.Items {
+				chat := openai.Chat{
+					ID:      strconv.Itoa(essay.ID) + "/" + item.Name,
+					Request: rubric.ItemChatRequest(essay, essayType, item, model, temperature, maxTokens),
+				}
+				chats = append(chats, chat)
+				requests[chat.ID] = store.Request{PID: essay.ID, Model: model, Sample: j}
+			}
+		}
+		// Process the batch, and extract the ratings:
+		results := apiClient.ChatBatch(ctx, chats)
+		storedRequests := make([]store.Request, 0, len(results))
+		for id, chat := range results {
+			if chat.ErrMsg == "" {
+				manifest.AddChat(chat.Response)
+			}
+			r := requests[id]
+			r.Chat = chat
+			storedRequests = append(storedRequests, r)
+		}
+		var storedErrors []store.Error
+		for _, essay := range batch {
+			count++
+			ratings, e := rubricRatings(rubric, essay.ID, perItem, results)
+			if e != nil {
+				fmt.Printf("%d: pid %d: %v\n", count, essay.ID, e)
+				stage := store.ExtractStage
+				for _, chat := range rubricChats(rubric, essay.ID, perItem, results) {
+					if chat.ErrMsg != "" {
+						stage = store.RequestStage
+					}
+				}
+				storedErrors = append(storedErrors, store.Error{PID: essay.ID, Model: model, Stage: stage, Message: e.Error()})
+				continue
+			}
+			scores = append(scores, data.RubricScore{
+				ID:       essay.ID,
+				Response: essay.SelectEssay(essayType),
+				Ratings:  ratings,
+			})
+			if format == data.JSONLFormat {
+				exchanges = append(exchanges, data.ChatExchanges(rubricChats(rubric, essay.ID, perItem, results)...))
+			}
+			fmt.Printf("%d: pid %d: %s\n", count, essay.ID, rubric.FormatRatings(ratings))
+		}
+		// Record the batch in the results store:
+		if db != nil {
+			if err := storeBatch(db, run.ID, storedRequests, nil, storedErrors); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Write the standardized scores to the specified results file, and record
+	// them in the results store:
+	out, err := data.RubricResults(rubric, scores)
+	if err != nil {
+		return err
+	}
+	out.Exchanges = exchanges
+	err = data.WriteResults(csvFile, format, out)
+	if err == nil {
+		err = manifest.Write(csvFile)
+	}
+	if err == nil && db != nil {
+		storedScores := make([]data.EssayScore, len(scores))
+		for i, s := range scores {
+			storedScores[i] = data.EssayScore{ID: s.ID, EssayType: essayType, Essay: s.Response,
+				Score: float32(s.Std), Comments: rubric.FormatRatings(s.Ratings)}
+		}
+		err = db.AddScores(run.ID, model, storedScores)
+	}
+	if err == nil {
+		err = endRun(db, run)
+	}
+	fmt.Printf("completed %d essays (%d coded) in %s\n", len(essays), len(scores), time.Since(startTime))
 	return err
 }
+
+// rubricRatings extracts an essay's rubric ratings from the batch results.
+func rubricRatings(rubric data.Rubric, id int, perItem bool, results map[string]openai.Chat) ([]int, error) {
+	if !perItem {
+		chat, ok := results[strconv.Itoa(id)]
+		if !ok {
+			return nil, errors.New("no response")
+		}
+		if chat.ErrMsg != "" {
+			return nil, errors.New(chat.ErrMsg)
+		}
+		return rubric.ExtractRatings(chat.Response)
+	}
+	ratings := make([]int, len(rubric.Items))
+	for i, item := range rubric.Items {
+		chat, ok := results[strconv.Itoa(id)+"/"+item.Name]
+		if !ok {
+			return nil, fmt.Errorf("%s: no response", item.Name)
+		}
+		if chat.ErrMsg != "" {
+			return nil, fmt.Errorf("%s: %s", item.Name, chat.ErrMsg)
+		}
+		rating, err := rubric.ExtractItemRating(chat.Response)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", item.Name, err)
+		}
+		ratings[i] = rating
+	}
+	return ratings, nil
+}
+
+// rubricChats returns an essay's rubric chats from the batch results.
+func rubricChats(rubric data.Rubric, id int, perItem bool, results map[string]openai.Chat) []openai.Chat {
+	if !perItem {
+		return []openai.Chat{results[strconv.Itoa(id)]}
+	}
+	chats := make([]openai.Chat, len(rubric.Items))
+	for i, item := range rubric.Items {
+		chats[i] = results[strconv.Itoa(id)+"/"+item.Name]
+	}
+	return chats
+}
+
+// chatBuilder returns the chat request builder specified by the command flags.
+// The front matter of a prompt template overrides any flags not explicitly set.
+func chatBuilder(cmd *cobra.Command) (data.ChatBuilder, error) {
+	var builder data.ChatBuilder
+	builder.Model, _ = cmd.Flags().GetString("model")
+	if models, err := cmd.Flags().GetStringSlice("model"); err == nil && len(models) > 0 {
+		builder.Model = models[0]
+	}
+	builder.Temperature, _ = cmd.Flags().GetFloat32("temperature")
+	builder.MaxTokens, _ = cmd.Flags().GetInt("max-tokens")
+	promptFile, _ := cmd.Flags().GetString("prompt")
+	if promptFile == "" {
+		return builder, nil
+	}
+
+	// Load the template and apply its front matter:
+	t, err := data.LoadPromptTemplate(promptFile)
+	if err != nil {
+		return builder, err
+	}
+	builder.Template = t
+	if t.Model != "" && !cmd.Flags().Changed("model") {
+		builder.Model = t.Model
+	}
+	if t.Temperature != nil && !cmd.Flags().Changed("temperature") {
+		builder.Temperature = *t.Temperature
+	}
+	if t.MaxTokens > 0 && !cmd.Flags().Changed("max-tokens") {
+		builder.MaxTokens = t.MaxTokens
+	}
+	return builder, nil
+}
+
+// scoreExtractor returns the score extractor specified by the --extractor and
+// --score-range flags, or else by the prompt template's front matter. The
+// --reverse flag is equivalent to --extractor=last.
+func scoreExtractor(cmd *cobra.Command, builder data.ChatBuilder) (data.ScoreExtractor, error) {
+	spec, _ := cmd.Flags().GetString("extractor")
+	reverse, _ := cmd.Flags().GetBool("reverse")
+	if !cmd.Flags().Changed("extractor") {
+		if cmd.Flags().Changed("reverse") {
+			if reverse {
+				spec = "last"
+			}
+		} else if builder.Template != nil {
+			spec = builder.Template.ScoreParser
+		}
+	}
+	r, err := data.ParseScoreRange(scoreRangeSpec(cmd, builder))
+	if err != nil {
+		return nil, err
+	}
+	return data.NewScoreExtractor(spec, r)
+}
+
+// scoreRangeSpec returns the score range specified by the --score-range flag,
+// or else by the prompt template's front matter, or else the default range.
+func scoreRangeSpec(cmd *cobra.Command, builder data.ChatBuilder) string {
+	scoreRange, _ := cmd.Flags().GetString("score-range")
+	if cmd.Flags().Changed("score-range") {
+		return scoreRange
+	}
+	if builder.Template != nil {
+		return builder.Template.ScoreRange
+	}
+	return fmt.Sprintf("%g,%g", data.DefaultScoreRange.Min, data.DefaultScoreRange.Max)
+}
+
+// addSamplingFlags adds the self-consistency sampling flags to a command.
+func addSamplingFlags(cmd *cobra.Command) {
+	cmd.Flags().IntP("samples", "N", 1, "Number of samples to score per essay (self-consistency)")
+	cmd.Flags().String("sample-mode", data.SampleChoices, "Sample with one request for N choices (n) or N requests (repeat)")
+	cmd.Flags().Float64("review-sd", 0.2, "Flag essays for review when the sample standard deviation exceeds this")
+}
+
+// samplingOptions returns the self-consistency sampling options specified by
+// the command flags.
+func samplingOptions(cmd *cobra.Command) (data.Sampling, error) {
+	var s data.Sampling
+	s.N, _ = cmd.Flags().GetInt("samples")
+	s.Mode, _ = cmd.Flags().GetString("sample-mode")
+	s.ReviewSD, _ = cmd.Flags().GetFloat64("review-sd")
+	if s.N < 1 {
+		return s, fmt.Errorf("samples must be at least 1")
+	}
+	if s.Mode != data.SampleChoices && s.Mode != data.SampleRepeat {
+		return s, fmt.Errorf("sample mode %s is not one of: %s, %s", s.Mode, data.SampleChoices, data.SampleRepeat)
+	}
+	return s, nil
+}
+
+// sampleID returns the batch ID of an essay's sample request.
+func sampleID(id int, sample int) string {
+	if sample == 0 {
+		return strconv.Itoa(id)
+	}
+	return fmt.Sprintf("%d#%d", id, sample)
+}
+
+// sampleChats returns the successful chats of an essay's sample requests,
+// whose batch IDs are given by id, and the error of the first sample that
+// failed or has no response.
+func sampleChats(results map[string]openai.Chat, samples int, id func(sample int) string) ([]openai.Chat, string) {
+	var chats []openai.Chat
+	var errMsg string
+	for j := 0; j < samples; j++ {
+		chat, ok := results[id(j)]
+		switch {
+		case !ok && errMsg == "":
+			errMsg = "no response"
+		case ok && chat.ErrMsg != "" && errMsg == "":
+			errMsg = chat.ErrMsg
+		case ok && chat.ErrMsg == "":
+			chats = append(chats, chat)
+		}
+	}
+	return chats, errMsg
+}
+
+// addFewShotFlags adds the few-shot exemplar flags to a command.
+func addFewShotFlags(cmd *cobra.Command) {
+	cmd.Flags().String("exemplars", "", "Human-coded training CSV file of few-shot exemplars")
+	cmd.Flags().Int("shots", 3, "Number of few-shot exemplars per essay")
+	cmd.Flags().String("exemplar-strategy", data.RandomExemplars,
+		"Exemplar selection strategy: "+strings.Join(data.ExemplarStrategies, ", "))
+	cmd.Flags().Int64("seed", 1, "Random seed for exemplar selection")
+	cmd.Flags().String("embedding-model", "text-embedding-ada-002", "Embedding model for the similar strategy")
+	cmd.Flags().String("exemplar-format", "%.2f", "Format of the exemplar answers, e.g. \"Score: %.2f\"")
+}
+
+// fewShot returns the few-shot exemplar configuration specified by the command
+// flags, or nil if no exemplars were specified.
+func fewShot(cmd *cobra.Command, essayType string) (*data.FewShot, error) {
+	exemplarFile, _ := cmd.Flags().GetString("exemplars")
+	if exemplarFile == "" {
+		return nil, nil
+	}
+	f := &data.FewShot{}
+	f.K, _ = cmd.Flags().GetInt("shots")
+	f.Strategy, _ = cmd.Flags().GetString("exemplar-strategy")
+	f.Seed, _ = cmd.Flags().GetInt64("seed")
+	f.Format, _ = cmd.Flags().GetString("exemplar-format")
+	embeddingModel, _ := cmd.Flags().GetString("embedding-model")
+	switch f.Strategy {
+	case data.RandomExemplars, data.StratifiedExemplars, data.SimilarExemplars:
+	default:
+		return nil, fmt.Errorf("exemplar strategy %s is not one of: %s", f.Strategy, strings.Join(data.ExemplarStrategies, ", "))
+	}
+
+	// Read the exemplars, which must match the essay type's construct:
+	fileType, exemplars, err := data.ReadExemplars(exemplarFile)
+	if err != nil {
+		return nil, err
+	}
+	if (fileType == "humility" && data.IsSpiritual(essayType)) || (fileType == "spiritual" && data.IsHumility(essayType)) {
+		return nil, fmt.Errorf("exemplars %s are %s essays, which don't match essay type %s", exemplarFile, fileType, essayType)
+	}
+	f.Exemplars = exemplars
+	if f.Strategy == data.SimilarExemplars {
+		f.Embed = func(texts []string) ([][]float32, error) {
+			return apiClient.CreateEmbeddings(context.Background(), openai.EmbeddingRequest{
+				Model: embeddingModel,
+				Input: texts,
+			})
+		}
+	}
+	return f, nil
+}
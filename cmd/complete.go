@@ -2,10 +2,14 @@ package main
 
 import (
 	"content-coding-gpt/pkg/data"
+	"content-coding-gpt/pkg/openai"
+	"content-coding-gpt/pkg/provenance"
+	"content-coding-gpt/pkg/store"
 	"context"
 	"encoding/json"
 	"fmt"
-	"strings"
+	"os"
+	"strconv"
 	"time"
 
 	"github.com/spf13/cobra"
@@ -25,9 +29,11 @@ func initCompleteCmd(root *cobra.Command) {
 	randomCmd := &cobra.Command{
 		Use:   "random <essayType> <modelID>",
 		Short: "Complete a random essay",
-		Long:  "Complete a random essay of the specified type from data/original/essays.csv",
+		Long:  "Complete a random essay of the specified type from the input file (default data/original/essays.csv)",
+		Args:  cobra.ExactArgs(2),
 		RunE:  completeRandom,
 	}
+	addInputFlags(randomCmd)
 	randomCmd.Flags().BoolP("raw", "r", false, "Raw OpenAI Response?")
 	randomCmd.Flags().IntP("max-tokens", "t", 6, "Maximum number of tokens to generate")
 	completeCmd.AddCommand(randomCmd)
@@ -36,10 +42,14 @@ func initCompleteCmd(root *cobra.Command) {
 	batchCmd := &cobra.Command{
 		Use:   "batch <essayType> <modelID> <csvFile>",
 		Short: "Complete a batch of essays",
-		Long:  "Complete a batch of essays of the specified type from data/original/essays.csv",
+		Long:  "Complete a batch of essays of the specified type from the input file (default data/original/essays.csv)",
+		Args:  cobra.ExactArgs(3),
 		RunE:  completeBatch,
 	}
+	addInputFlags(batchCmd)
 	batchCmd.Flags().IntP("max-tokens", "t", 6, "Maximum number of tokens to generate")
+	addFormatFlag(batchCmd)
+	addStoreFlag(batchCmd, "Record the run in this results database")
 	completeCmd.AddCommand(batchCmd)
 }
 
@@ -49,13 +59,14 @@ func completeRandom(cmd *cobra.Command, args []string) error {
 	raw, _ := cmd.Flags().GetBool("raw")
 	maxTokens, _ := cmd.Flags().GetInt("max-tokens")
 	essayType := args[0]
-	if !data.ValidEssayType(essayType) {
-		return fmt.Errorf("essay type %s is not one of: %s", essayType, strings.Join(data.EssayTypes, ", "))
-	}
 	modelID := args[1]
 
 	// Select a random essay:
-	essay, err := data.RandomEssayRecord()
+	essays, _, err := readEssays(cmd, essayType)
+	if err != nil {
+		return err
+	}
+	essay, err := data.RandomEssayRecord(essays, essayType)
 	if err != nil {
 		return err
 	}
@@ -120,17 +131,21 @@ func completeRandom(cmd *cobra.Command, args []string) error {
 }
 
 // completeBatch processes completions for all essays of a specified type for
-// specified model. The output is is placed in the specified CSV file.
+// specified model. The output is is placed in the specified results file.
 func completeBatch(cmd *cobra.Command, args []string) error {
 	startTime := time.Now()
 	ctx := context.Background()
 	maxTokens, _ := cmd.Flags().GetInt("max-tokens")
 	csvFile := args[2]
+	format, err := resultsFormat(cmd, csvFile)
+	if err != nil {
+		return err
+	}
 
 	// Validate the specified essay type:
 	essayType := args[0]
-	if !data.ValidEssayType(essayType) {
-		return fmt.Errorf("essay type %s is not one of: %s", essayType, strings.Join(data.EssayTypes, ", "))
+	if !data.IsHumility(essayType) && !data.IsSpiritual(essayType) {
+		return fmt.Errorf("essay type %s is neither humility nor spiritual", essayType)
 	}
 
 	// Validate the specified model:
@@ -139,55 +154,132 @@ func completeBatch(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("model %s is not a recognized model ID", modelID)
 	}
 
-	// Load the essays:
-	essays, err := data.ReadEssayRecords("data/original/essays.csv")
+	// Load the essays, and start the provenance manifest:
+	essays, _, err := readEssays(cmd, essayType)
+	if err != nil {
+		return err
+	}
+	manifest, err := newProvenance(cmd, essayType)
+	if err != nil {
+		return err
+	}
+
+	// Record the run in the results store, if any:
+	run := store.Run{
+		Command:   store.CompleteBatchCommand,
+		Args:      os.Args,
+		EssayType: essayType,
+		Models:    []string{modelID},
+		Results:   csvFile,
+	}
+	db, err := openRunStore(cmd, &run, essays)
 	if err != nil {
 		return err
 	}
+	if db != nil {
+		defer db.Close()
+	}
+
+	// Complete the essays, and report the time taken:
+	err = completeEssays(ctx, essays, essayType, modelID, maxTokens, csvFile, format, manifest, db, run)
+	if err == nil {
+		err = endRun(db, run)
+	}
+	fmt.Printf("completed %d essays in %s\n", len(essays), time.Since(startTime))
+	return err
+}
+
+// completeEssays completes the essays of a humility or spiritual essay type
+// with a model, writing the results in the format, or the format implied by
+// the file extension if "", and their provenance manifest. Essays that fail
+// are reported and skipped. If db is not nil, the completions, standardized
+// scores, and errors are recorded in the run.
+func completeEssays(ctx context.Context, essays []data.EssayRecord, essayType, modelID string, maxTokens int,
+	csvFile, format string, manifest *provenance.Manifest, db *store.Store, run store.Run) error {
+	var err error
+	var exchanges [][]data.Exchange
+	var storedRequests []store.Request
+	var storedScores []data.EssayScore
+	var storedErrors []store.Error
+
+	// complete requests an essay's completion, and records it.
+	complete := func(i int, essay data.EssayRecord) (openai.Completion, bool) {
+		request := essay.PlainCompletionRequest(essayType, modelID, maxTokens)
+		start := time.Now()
+		completion, e := apiClient.CreateCompletion(ctx, request)
+		r := store.Request{
+			PID:        essay.ID,
+			Model:      modelID,
+			Chat:       openai.Chat{ID: strconv.Itoa(essay.ID), Millis: time.Since(start).Milliseconds()},
+			Completion: &store.Completion{Request: request, Response: completion},
+		}
+		if e != nil {
+			fmt.Printf("%d: pid %d: %v\n", i, essay.ID, e)
+			r.Chat.ErrMsg = e.Error()
+			storedRequests = append(storedRequests, r)
+			storedErrors = append(storedErrors, store.Error{PID: essay.ID, Model: modelID, Stage: store.RequestStage, Message: e.Error()})
+			return completion, false
+		}
+		storedRequests = append(storedRequests, r)
+		manifest.AddCompletion(completion)
+		exchanges = append(exchanges, []data.Exchange{{ID: completion.ID, Request: request, Response: completion}})
+		return completion, true
+	}
+	// score records an essay's standardized score, or the error extracting it.
+	score := func(i int, essay data.EssayRecord, std float64, results string, e error) bool {
+		if e != nil {
+			fmt.Printf("%d: pid %d: %v\n", i, essay.ID, e)
+			exchanges = exchanges[:len(exchanges)-1]
+			storedErrors = append(storedErrors, store.Error{PID: essay.ID, Model: modelID, Stage: store.ExtractStage, Message: e.Error()})
+			return false
+		}
+		storedScores = append(storedScores, data.EssayScore{ID: essay.ID, EssayType: essayType,
+			Essay: essay.SelectEssay(essayType), Score: float32(std), Comments: results})
+		fmt.Printf("%d: pid %d: %s\n", i, essay.ID, results)
+		return true
+	}
 
 	// Humility?
 	if data.IsHumility(essayType) {
 		records := make([]data.HumilityRecord, 0, len(essays))
 		for i, essay := range essays {
-			request := essay.PlainCompletionRequest(essayType, modelID, maxTokens)
-			completion, e := apiClient.CreateCompletion(ctx, request)
-			if e != nil {
-				fmt.Printf("%d: pid %d: %v\n", i, essay.ID, e)
+			completion, ok := complete(i, essay)
+			if !ok {
 				continue
 			}
 			r, e := data.NewHumilityRecord(essay, essayType, completion)
-			if e != nil {
-				fmt.Printf("%d: pid %d: %v\n", i, essay.ID, e)
-				continue
+			if score(i, essay, r.Std, r.Results(), e) {
+				records = append(records, r)
 			}
-			records = append(records, r)
-			fmt.Printf("%d: pid %d: %s\n", i, essay.ID, r.Results())
 		}
-		err = data.WriteHumilityRecords(csvFile, records)
+		out := data.HumilityResults(records)
+		out.Exchanges = exchanges
+		err = data.WriteResults(csvFile, format, out)
 	}
 
 	// Spiritual?
 	if data.IsSpiritual(essayType) {
 		records := make([]data.SpiritualRecord, 0, len(essays))
 		for i, essay := range essays {
-			request := essay.PlainCompletionRequest(essayType, modelID, maxTokens)
-			completion, e := apiClient.CreateCompletion(ctx, request)
-			if e != nil {
-				fmt.Printf("%d: pid %d: %v\n", i, essay.ID, e)
+			completion, ok := complete(i, essay)
+			if !ok {
 				continue
 			}
 			r, e := data.NewSpiritualRecord(essay, essayType, completion)
-			if e != nil {
-				fmt.Printf("%d: pid %d: %v\n", i, essay.ID, e)
-				continue
+			if score(i, essay, r.Std, r.Results(), e) {
+				records = append(records, r)
 			}
-			records = append(records, r)
-			fmt.Printf("%d: pid %d: %s\n", i, essay.ID, r.Results())
 		}
-		err = data.WriteSpiritualRecords(csvFile, records)
+		out := data.SpiritualResults(records)
+		out.Exchanges = exchanges
+		err = data.WriteResults(csvFile, format, out)
 	}
 
-	// Report the time taken:
-	fmt.Printf("completed %d essays in %s\n", len(essays), time.Since(startTime))
+	if err == nil {
+		err = manifest.Write(csvFile)
+	}
+	if err == nil && db != nil {
+		err = storeBatch(db, run.ID, storedRequests, map[string][]data.EssayScore{modelID: storedScores}, storedErrors)
+	}
 	return err
 }
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"content-coding-gpt/pkg/data"
+	"content-coding-gpt/pkg/stats"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// initEvaluateCmd initializes the evaluate command.
+func initEvaluateCmd(root *cobra.Command) {
+	evaluateCmd := &cobra.Command{
+		Use:   "evaluate <humanCsv> <modelCsv>...",
+		Short: "Compare model scores to human coders",
+		Long: "Compare the scores in one or more model results files (chat batch, chat rubric, or complete " +
+			"batch output) to the human-coded scores in a humility or spiritual training CSV file, joined " +
+			"on pid. Reports Pearson and Spearman correlation, ICC(2,1), ICC(3,k), weighted kappa on " +
+			"quantile-binned scores, Krippendorff's alpha (interval), MAE, and RMSE, each with a " +
+			"bootstrap confidence interval, for the composite score and each rubric item in both files. " +
+			"Each file's scores are z-scored before ICC, kappa, alpha, MAE, and RMSE, since the human " +
+			"standardized column and the model scores are on different scales, so these measure " +
+			"consistency; use --standardize=false to compare the raw scores on the same scale, e.g. " +
+			"rubric items, or a standardized model column.",
+		Args: cobra.MinimumNArgs(2),
+		RunE: evaluate,
+	}
+	evaluateCmd.Flags().String("human-column", "standardized", "Human composite score column")
+	evaluateCmd.Flags().String("model-column", "", "Model composite score column (default: score, ensemble, or standardized)")
+	evaluateCmd.Flags().Int("bins", stats.DefaultOptions.Bins, "Number of quantile bins for weighted kappa")
+	evaluateCmd.Flags().Bool("linear", false, "Use linear (instead of quadratic) kappa weights?")
+	evaluateCmd.Flags().Bool("standardize", stats.DefaultOptions.Standardize, "Standardize the scores before ICC, kappa, alpha, MAE, and RMSE?")
+	evaluateCmd.Flags().Int("resamples", stats.DefaultOptions.Resamples, "Number of bootstrap resamples")
+	evaluateCmd.Flags().Float64("level", stats.DefaultOptions.Level, "Confidence level")
+	evaluateCmd.Flags().Int64("seed", stats.DefaultOptions.Seed, "Bootstrap random seed")
+	evaluateCmd.Flags().StringP("output", "o", "", "JSON report file")
+	root.AddCommand(evaluateCmd)
+}
+
+// Evaluation is the agreement between a model results file and the human coders.
+type Evaluation struct {
+	Human          string                     `json:"human"`
+	Model          string                     `json:"model"`
+	HumanColumn    string                     `json:"human_column"`
+	ModelColumn    string                     `json:"model_column"`
+	Matched        int                        `json:"matched"`
+	UnmatchedHuman int                        `json:"unmatched_human"`
+	UnmatchedModel int                        `json:"unmatched_model"`
+	Options        stats.Options              `json:"options"`
+	Composite      stats.Agreement            `json:"composite"`
+	Items          map[string]stats.Agreement `json:"items,omitempty"`
+}
+
+// evaluate compares model results files to a human-coded CSV file.
+func evaluate(cmd *cobra.Command, args []string) error {
+	humanColumn, _ := cmd.Flags().GetString("human-column")
+	modelColumn, _ := cmd.Flags().GetString("model-column")
+	output, _ := cmd.Flags().GetString("output")
+	var o stats.Options
+	o.Bins, _ = cmd.Flags().GetInt("bins")
+	o.LinearKappa, _ = cmd.Flags().GetBool("linear")
+	o.Standardize, _ = cmd.Flags().GetBool("standardize")
+	o.Resamples, _ = cmd.Flags().GetInt("resamples")
+	o.Level, _ = cmd.Flags().GetFloat64("level")
+	o.Seed, _ = cmd.Flags().GetInt64("seed")
+	if o.Bins < 2 {
+		return fmt.Errorf("bins must be at least 2")
+	}
+
+	// Read the human scores:
+	human, humanHeader, err := readScoreTable(args[0])
+	if err != nil {
+		return err
+	}
+	if !containsColumn(humanHeader, humanColumn) {
+		return fmt.Errorf("%s: no %s column", args[0], humanColumn)
+	}
+
+	// Compare each model results file:
+	var evaluations []Evaluation
+	for _, path := range args[1:] {
+		e, err := evaluateFile(args[0], human, humanHeader, path, humanColumn, modelColumn, o)
+		if err != nil {
+			return err
+		}
+		printEvaluation(e)
+		evaluations = append(evaluations, e)
+	}
+
+	// Write the JSON report:
+	if output != "" {
+		return writeEvaluations(output, evaluations)
+	}
+	return nil
+}
+
+// writeEvaluations writes a JSON report of evaluations.
+func writeEvaluations(path string, evaluations []Evaluation) error {
+	j, err := json.MarshalIndent(evaluations, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshalling JSON report: %w", err)
+	}
+	if err := os.WriteFile(path, append(j, '\n'), 0644); err != nil {
+		return fmt.Errorf("error writing JSON report: %w", err)
+	}
+	fmt.Printf("wrote %s\n", path)
+	return nil
+}
+
+// evaluateFile compares a model results file to the human scores read from
+// humanPath by readScoreTable. An empty modelColumn selects the score,
+// ensemble, or standardized column, in that order.
+func evaluateFile(humanPath string, human map[int]map[string]float64, humanHeader []string, path string,
+	humanColumn, modelColumn string, o stats.Options) (Evaluation, error) {
+	e := Evaluation{Human: humanPath, Model: path, HumanColumn: humanColumn, ModelColumn: modelColumn, Options: o}
+	model, modelHeader, err := readScoreTable(path)
+	if err != nil {
+		return e, err
+	}
+	if e.ModelColumn == "" {
+		e.ModelColumn = "standardized"
+		for _, column := range []string{"score", "ensemble"} {
+			if containsColumn(modelHeader, column) {
+				e.ModelColumn = column
+				break
+			}
+		}
+	}
+	if !containsColumn(modelHeader, e.ModelColumn) {
+		return e, fmt.Errorf("%s: no %s column", path, e.ModelColumn)
+	}
+	for id := range model {
+		if _, ok := human[id]; ok {
+			e.Matched++
+		} else {
+			e.UnmatchedModel++
+		}
+	}
+	e.UnmatchedHuman = len(human) - e.Matched
+	if e.Matched == 0 {
+		return e, fmt.Errorf("%s: no pids match %s", path, humanPath)
+	}
+
+	// Composite and rubric item agreement:
+	x, y := pairScores(human, model, humanColumn, e.ModelColumn)
+	e.Composite = stats.Compare(x, y, o)
+	for _, rubric := range []data.Rubric{data.HumilityRubric, data.SpiritualRubric} {
+		for _, item := range rubric.Items {
+			if containsColumn(humanHeader, item.Name) && containsColumn(modelHeader, item.Name) {
+				if e.Items == nil {
+					e.Items = map[string]stats.Agreement{}
+				}
+				x, y := pairScores(human, model, item.Name, item.Name)
+				e.Items[item.Name] = stats.Compare(x, y, o)
+			}
+		}
+	}
+	return e, nil
+}
+
+// readScoreTable reads a results or training file, returning the numeric
+// columns of each row by pid, and the header.
+func readScoreTable(path string) (map[int]map[string]float64, []string, error) {
+	table, err := data.ReadTableFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	idIndex := table.ColumnIndex(data.DefaultEssaySchema.IDColumn)
+	if idIndex < 0 {
+		return nil, nil, fmt.Errorf("%s: no pid column", path)
+	}
+	scores := make(map[int]map[string]float64, len(table.Rows))
+	for _, row := range table.Rows {
+		if idIndex >= len(row) {
+			continue
+		}
+		id, err := data.ParseID(row[idIndex])
+		if err != nil {
+			continue
+		}
+		values := map[string]float64{}
+		for i, column := range table.Header {
+			if i == idIndex || i >= len(row) {
+				continue
+			}
+			if v, err := strconv.ParseFloat(strings.TrimSpace(row[i]), 64); err == nil {
+				values[strings.ToLower(strings.TrimSpace(column))] = v
+			}
+		}
+		scores[id] = values
+	}
+	return scores, table.Header, nil
+}
+
+// containsColumn returns true if the header contains the column.
+func containsColumn(header []string, column string) bool {
+	return data.Table{Header: header}.ColumnIndex(column) >= 0
+}
+
+// pairScores returns the paired human and model scores, ordered by pid, for
+// the pids with numeric values in both columns.
+func pairScores(human, model map[int]map[string]float64, humanColumn, modelColumn string) ([]float64, []float64) {
+	humanColumn, modelColumn = strings.ToLower(humanColumn), strings.ToLower(modelColumn)
+	ids := make([]int, 0, len(model))
+	for id := range model {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	var x, y []float64
+	for _, id := range ids {
+		h, ok := human[id][humanColumn]
+		m, ok2 := model[id][modelColumn]
+		if ok && ok2 {
+			x = append(x, h)
+			y = append(y, m)
+		}
+	}
+	return x, y
+}
+
+// printEvaluation prints the agreement statistics of an evaluation.
+func printEvaluation(e Evaluation) {
+	fmt.Printf("%s vs %s: %d matched (%d human, %d model unmatched)\n",
+		e.Model, e.Human, e.Matched, e.UnmatchedHuman, e.UnmatchedModel)
+	printAgreement(e.HumanColumn+" ~ "+e.ModelColumn, e.Composite, e.Options.Level)
+	names := make([]string, 0, len(e.Items))
+	for name := range e.Items {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		printAgreement(name, e.Items[name], e.Options.Level)
+	}
+}
+
+// printAgreement prints a table of agreement statistics.
+func printAgreement(name string, a stats.Agreement, level float64) {
+	scores := "raw"
+	if a.Standardized {
+		scores = "standardized"
+	}
+	fmt.Printf("  %s (n=%d, %s scores, %.0f%% CI):\n", name, a.N, scores, level*100)
+	rows := []struct {
+		name string
+		e    stats.Estimate
+	}{
+		{"pearson", a.Pearson},
+		{"spearman", a.Spearman},
+		{"icc(2,1)", a.ICC21},
+		{"icc(3,k)", a.ICC3k},
+		{"weighted kappa", a.Kappa},
+		{"krippendorff alpha", a.Alpha},
+		{"mae", a.MAE},
+		{"rmse", a.RMSE},
+	}
+	for _, r := range rows {
+		fmt.Printf("    %-18s %7s [%s, %s]\n", r.name, formatStat(r.e.Value), formatStat(r.e.Lower), formatStat(r.e.Upper))
+	}
+}
+
+// formatStat formats a statistic, which may be undefined (NaN).
+func formatStat(v float64) string {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return "n/a"
+	}
+	return fmt.Sprintf("%.3f", v)
+}
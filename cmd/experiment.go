@@ -0,0 +1,301 @@
+package main
+
+import (
+	"content-coding-gpt/pkg/data"
+	"content-coding-gpt/pkg/experiment"
+	"content-coding-gpt/pkg/openai"
+	"content-coding-gpt/pkg/provenance"
+	"content-coding-gpt/pkg/stats"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// initExperimentCmd initializes the experiment commands.
+func initExperimentCmd(root *cobra.Command) {
+	// Experiment Command
+	experimentCmd := &cobra.Command{
+		Use:   "experiment",
+		Short: "Run prompt experiments",
+		Long:  "Run prompt experiments",
+	}
+	root.AddCommand(experimentCmd)
+
+	// Run Command
+	runCmd := &cobra.Command{
+		Use:   "run <manifest>",
+		Short: "Run a prompt experiment grid",
+		Long: "Run every cell of the grid of templates × models × temperatures × essay types × seeds " +
+			"described by a YAML manifest file. Responses are cached in the run directory " +
+			"(<output>/<name>/cells/<cell>/responses.jsonl), so an interrupted run resumes where it " +
+			"stopped. The cells are ranked by agreement with the human scores in summary.csv.",
+		Args: cobra.ExactArgs(1),
+		RunE: runExperiment,
+	}
+	runCmd.Flags().BoolP("dry-run", "n", false, "List the cells without running them?")
+	experimentCmd.AddCommand(runCmd)
+}
+
+// runExperiment runs the cells of an experiment manifest.
+func runExperiment(cmd *cobra.Command, args []string) error {
+	startTime := time.Now()
+	ctx := context.Background()
+	dryRun, _ := cmd.Flags().GetBool("dry-run")
+
+	// Read the manifest and expand the grid:
+	m, err := experiment.ReadManifest(args[0])
+	if err != nil {
+		return err
+	}
+	cells, err := m.Cells()
+	if err != nil {
+		return err
+	}
+	runDir := m.RunDir()
+	if dryRun {
+		for _, cell := range cells {
+			fmt.Println(cell.Dir(runDir))
+		}
+		fmt.Printf("%d cells\n", len(cells))
+		return nil
+	}
+
+	// Validate the models:
+	for _, model := range m.Models {
+		if !apiClient.ValidModel(ctx, model) {
+			return fmt.Errorf("model %s is not a recognized model ID", model)
+		}
+	}
+
+	// Load the essays and any human scores:
+	if m.EssayPrompts != "" {
+		if err := data.ReadEssayPrompts(m.EssayPrompts); err != nil {
+			return err
+		}
+	}
+	input := m.Input
+	if input == "" {
+		input = data.DefaultEssayFile
+	}
+	schema, err := data.NewEssaySchema(m.IDColumn, m.EssayColumns, nil)
+	if err != nil {
+		return err
+	}
+	essays, schema, err := data.ReadEssayRecords(input, schema)
+	if err != nil {
+		return err
+	}
+	human := map[string]map[int]map[string]float64{}
+	for _, essayType := range m.EssayTypes {
+		if err := validateEssayType(schema, essayType); err != nil {
+			return err
+		}
+		if path, ok := m.Human[essayType]; ok {
+			human[essayType], _, err = readScoreTable(path)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// Record the manifest in the run directory:
+	if err := os.MkdirAll(runDir, 0755); err != nil {
+		return fmt.Errorf("create run directory: %w", err)
+	}
+	manifest, err := os.ReadFile(args[0])
+	if err != nil {
+		return fmt.Errorf("read manifest: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(runDir, "manifest.yaml"), manifest, 0644); err != nil {
+		return fmt.Errorf("write manifest: %w", err)
+	}
+
+	// Run the cells:
+	results := make([]experiment.Result, 0, len(cells))
+	for i, cell := range cells {
+		fmt.Printf("cell %d/%d: %s\n", i+1, len(cells), cell.ID)
+		result, err := runCell(ctx, m, cell, data.FilterEssayRecords(essays, cell.EssayType), human[cell.EssayType])
+		if err != nil {
+			return fmt.Errorf("cell %s: %w", cell.ID, err)
+		}
+		results = append(results, result)
+	}
+
+	// Rank the cells and write the summary:
+	experiment.Rank(results, m.RankBy)
+	if err := experiment.WriteSummary(runDir, results); err != nil {
+		return err
+	}
+	fmt.Printf("%-4s %-56s %6s %8s %8s %8s\n", "rank", "cell", "scored", "n", m.RankBy, "rmse")
+	for i, r := range results {
+		n, rmse := "-", "n/a"
+		if r.Agreement != nil {
+			n, rmse = strconv.Itoa(r.Agreement.N), formatStat(r.Agreement.RMSE.Value)
+		}
+		fmt.Printf("%-4d %-56s %6d %8s %8s %8s\n", i+1, r.Cell.ID, r.Scored, n, formatStat(r.Statistic(m.RankBy)), rmse)
+	}
+	fmt.Printf("completed %d cells in %s: %s\n", len(cells), time.Since(startTime), filepath.Join(runDir, "summary.csv"))
+	return nil
+}
+
+// runCell runs the uncached essays of a cell, then scores every essay and
+// compares the scores to the human scores, if any.
+func runCell(ctx context.Context, m experiment.Manifest, cell experiment.Cell, essays []data.EssayRecord,
+	human map[int]map[string]float64) (experiment.Result, error) {
+	result := experiment.Result{Cell: cell}
+	if m.Limit > 0 && len(essays) > m.Limit {
+		essays = essays[:m.Limit]
+	}
+	result.Essays = len(essays)
+
+	// Configure the chat requests:
+	builder := data.ChatBuilder{Model: cell.Model, Temperature: cell.Temperature, MaxTokens: m.MaxTokens}
+	extractor, scoreRange := m.Extractor, m.ScoreRange
+	if cell.Template != "" {
+		t, err := data.LoadPromptTemplate(cell.Template)
+		if err != nil {
+			return result, err
+		}
+		builder.Template = t
+		if builder.MaxTokens == 0 {
+			builder.MaxTokens = t.MaxTokens
+		}
+		if extractor == "" {
+			extractor = t.ScoreParser
+		}
+		if scoreRange == "" {
+			scoreRange = t.ScoreRange
+		}
+	} else if scoreRange == "" {
+		scoreRange = fmt.Sprintf("%g,%g", data.DefaultScoreRange.Min, data.DefaultScoreRange.Max)
+	}
+	r, err := data.ParseScoreRange(scoreRange)
+	if err != nil {
+		return result, err
+	}
+	x, err := data.NewScoreExtractor(extractor, r)
+	if err != nil {
+		return result, err
+	}
+	if m.Exemplars != "" {
+		_, exemplars, err := data.ReadExemplars(m.Exemplars)
+		if err != nil {
+			return result, err
+		}
+		builder.FewShot = &data.FewShot{Exemplars: exemplars, K: m.Shots, Strategy: m.ExemplarStrategy, Seed: cell.Seed}
+		if builder.FewShot.Strategy == data.SimilarExemplars {
+			builder.FewShot.Embed = func(texts []string) ([][]float32, error) {
+				return apiClient.CreateEmbeddings(ctx, openai.EmbeddingRequest{Model: "text-embedding-ada-002", Input: texts})
+			}
+		}
+	}
+
+	// Build the chat requests, and run those not cached in batches:
+	dir := cell.Dir(m.RunDir())
+	cache, err := experiment.OpenCache(dir, cell)
+	if err != nil {
+		return result, err
+	}
+	requests := make(map[string]openai.ChatRequest, len(essays))
+	var pending []openai.Chat
+	for _, essay := range essays {
+		request, err := builder.ChatRequest(essay, cell.EssayType)
+		if err != nil {
+			return result, err
+		}
+		request.Seed = cell.Seed
+		chat := openai.Chat{ID: strconv.Itoa(essay.ID), Request: request}
+		requests[chat.ID] = request
+		if _, ok := cache.Get(chat.ID, chat.Request); !ok {
+			pending = append(pending, chat)
+		}
+	}
+	if len(pending) < len(essays) {
+		fmt.Printf("  %d of %d essays cached\n", len(essays)-len(pending), len(essays))
+	}
+	done := len(essays) - len(pending)
+	for _, chats := range data.Batch(pending, m.BatchSize) {
+		results := apiClient.ChatBatch(ctx, chats)
+		completed := make([]openai.Chat, 0, len(results))
+		var failed []string
+		for _, chat := range results {
+			completed = append(completed, chat)
+			if chat.ErrMsg != "" {
+				failed = append(failed, fmt.Sprintf("pid %s: %s", chat.ID, strings.TrimSpace(chat.ErrMsg)))
+			}
+		}
+		if err := cache.Add(completed); err != nil {
+			return result, err
+		}
+		for _, f := range failed {
+			fmt.Printf("  %s\n", f)
+		}
+		done += len(chats) - len(failed)
+		fmt.Printf("  %d/%d essays complete\n", done, len(essays))
+	}
+
+	// Score the essays from the cache, recording their provenance:
+	manifest := provenance.New(os.Args)
+	input := m.Input
+	if input == "" {
+		input = data.DefaultEssayFile
+	}
+	for role, path := range map[string]string{
+		"manifest":      filepath.Join(m.RunDir(), "manifest.yaml"),
+		"input":         input,
+		"essay_prompts": m.EssayPrompts,
+		"exemplars":     m.Exemplars,
+		"human":         m.Human[cell.EssayType],
+	} {
+		if err := manifest.AddFile(role, path); err != nil {
+			return result, err
+		}
+	}
+	if err := addTemplateFiles(manifest, cell.Template); err != nil {
+		return result, err
+	}
+	manifest.AddHallmarks(cell.EssayType, data.Hallmarks[cell.EssayType])
+	scores := make([]data.EssayScore, 0, len(essays))
+	var x1, y1 []float64
+	for _, essay := range essays {
+		id := strconv.Itoa(essay.ID)
+		chat, ok := cache.Get(id, requests[id])
+		if !ok {
+			continue
+		}
+		manifest.AddChat(chat.Response)
+		score, err := data.NewEssayScore(essay, cell.EssayType, chat.Response, x, chat.Millis)
+		if err != nil {
+			continue
+		}
+		scores = append(scores, score)
+		if h, ok := human[essay.ID][strings.ToLower(m.HumanColumn)]; ok {
+			x1 = append(x1, h)
+			y1 = append(y1, float64(score.Score))
+		}
+	}
+	result.Scored = len(scores)
+	scoreFile := filepath.Join(dir, "scores.csv")
+	if err := data.WriteEssayScores(scoreFile, scores); err != nil {
+		return result, err
+	}
+	if err := manifest.Write(scoreFile); err != nil {
+		return result, err
+	}
+
+	// Compare the scores to the human scores, standardized:
+	if len(x1) > 1 {
+		o := stats.DefaultOptions
+		o.Resamples = m.Resamples
+		o.Seed = cell.Seed
+		a := stats.Compare(x1, y1, o)
+		result.Agreement = &a
+	}
+	return result, nil
+}
@@ -2,11 +2,17 @@ package main
 
 import (
 	"content-coding-gpt/pkg/data"
+	"content-coding-gpt/pkg/openai"
+	"content-coding-gpt/pkg/stats"
 	"context"
 	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
 )
@@ -30,6 +36,8 @@ func initFileCmd(root *cobra.Command) {
 	}
 	listCmd.Flags().BoolP("verbose", "v", false, "Verbose? (full JSON)")
 	listCmd.Flags().BoolP("raw", "r", false, "Raw OpenAI Response?")
+	listCmd.Flags().String("purpose", "", "Only files with the purpose, e.g. fine-tune")
+	addListFlags(listCmd, true)
 	fileCmd.AddCommand(listCmd)
 
 	// Read Command
@@ -54,6 +62,42 @@ func initFileCmd(root *cobra.Command) {
 	prepareCmd.Flags().BoolP("append", "a", false, "Append to existing file?")
 	fileCmd.AddCommand(prepareCmd)
 
+	// Split Command
+	splitCmd := &cobra.Command{
+		Use:   "split <csvFile> [outputPrefix]",
+		Short: "Split a training CSV file",
+		Long: "Split a humility or spiritual training CSV file into train, validation, and test CSV " +
+			"files (<outputPrefix>_train.csv, etc.; default prefix: the CSV file path without its " +
+			"extension), stratified on the standardized score. Rows with the same pid are kept in " +
+			"the same split. With --folds, the test set is held out and the rest is split into k " +
+			"folds (<outputPrefix>_fold1_train.csv, <outputPrefix>_fold1_validation.csv, ...).",
+		Args: cobra.RangeArgs(1, 2),
+		RunE: splitFile,
+	}
+	splitCmd.Flags().Float64("validation", 0.1, "Fraction of participants in the validation set")
+	splitCmd.Flags().Float64("test", 0.1, "Fraction of participants in the held-out test set")
+	splitCmd.Flags().Int("strata", 5, "Number of standardized score strata")
+	splitCmd.Flags().Int64("seed", 1, "Random seed")
+	splitCmd.Flags().IntP("folds", "k", 0, "Number of cross-validation folds (0: a single split)")
+	fileCmd.AddCommand(splitCmd)
+
+	// Validate Command
+	validateCmd := &cobra.Command{
+		Use:   "validate <jsonlFile>...",
+		Short: "Validate JSONL training files",
+		Long: "Validate legacy (prompt/completion) or chat format JSONL training files before upload: " +
+			"valid JSON, non-empty prompts, the prompt separator suffix, the completion's leading " +
+			"space and stop sequence, duplicate prompts, and per-example token limits. Reports the " +
+			"estimated token totals, training cost, and distribution of completion labels.",
+		Args: cobra.MinimumNArgs(1),
+		RunE: validateFiles,
+	}
+	validateCmd.Flags().StringP("base", "b", "curie", "Base model, for the token limit and cost")
+	validateCmd.Flags().Int("epochs", 4, "Number of training epochs, for the cost")
+	validateCmd.Flags().Int("max-tokens", 0, "Maximum tokens per example (default: the base model's limit)")
+	validateCmd.Flags().Int("max-issues", 20, "Maximum number of issues to print per file")
+	fileCmd.AddCommand(validateCmd)
+
 	// Upload Command
 	uploadCmd := &cobra.Command{
 		Use:   "upload <jsonlFile>",
@@ -74,6 +118,7 @@ func initFileCmd(root *cobra.Command) {
 		RunE:  downloadFile,
 	}
 	downloadCmd.Flags().StringP("output", "o", "", "Output File Path")
+	downloadCmd.Flags().String("sha256", "", "Expected SHA-256 checksum of the file")
 	fileCmd.AddCommand(downloadCmd)
 
 	// Delete Command
@@ -85,6 +130,9 @@ func initFileCmd(root *cobra.Command) {
 		RunE:  deleteFile,
 	}
 	fileCmd.AddCommand(deleteCmd)
+
+	// Prune Command
+	initFilePruneCmd(fileCmd)
 }
 
 // listFiles lists the available files.
@@ -93,8 +141,10 @@ func listFiles(cmd *cobra.Command, args []string) error {
 
 	// Retrieve the raw JSON response:
 	raw, _ := cmd.Flags().GetBool("raw")
+	opts := listOptions(cmd)
+	opts.Purpose, _ = cmd.Flags().GetString("purpose")
 	if raw {
-		body, err := apiClient.ListFilesRaw(ctx)
+		body, err := apiClient.ListFilesRaw(ctx, opts)
 		if err != nil {
 			return err
 		}
@@ -103,7 +153,7 @@ func listFiles(cmd *cobra.Command, args []string) error {
 	}
 
 	// Retrieve the files:
-	files, err := apiClient.ListFiles(ctx)
+	files, err := apiClient.ListFiles(ctx, opts)
 	if err != nil {
 		return err
 	}
@@ -124,6 +174,30 @@ func listFiles(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
+// addListFlags adds the pagination flags of a list command, and optionally the
+// status filter.
+func addListFlags(cmd *cobra.Command, status bool) {
+	cmd.Flags().Int("limit", 0, "Page size (default: the API default)")
+	cmd.Flags().Int("max", 0, "Maximum number listed (default: all)")
+	cmd.Flags().String("after", "", "Start after this ID")
+	if status {
+		cmd.Flags().StringSlice("status", nil, "Only with the status(es), e.g. succeeded")
+	}
+}
+
+// listOptions returns the list options specified by the pagination and filter
+// flags.
+func listOptions(cmd *cobra.Command) openai.ListOptions {
+	var opts openai.ListOptions
+	opts.Limit, _ = cmd.Flags().GetInt("limit")
+	opts.Max, _ = cmd.Flags().GetInt("max")
+	opts.After, _ = cmd.Flags().GetString("after")
+	if cmd.Flags().Lookup("status") != nil {
+		opts.Statuses, _ = cmd.Flags().GetStringSlice("status")
+	}
+	return opts
+}
+
 // readFile reads the details about specified file(s).
 func readFile(cmd *cobra.Command, args []string) error {
 	ctx := context.Background()
@@ -164,17 +238,151 @@ func prepareFile(cmd *cobra.Command, args []string) error {
 	return data.PrepareTrainingFile(csvPath, jsonPath, append)
 }
 
+// splitFile splits a training CSV file into train, validation, and test files.
+func splitFile(cmd *cobra.Command, args []string) error {
+	var splitter data.Splitter
+	splitter.Validation, _ = cmd.Flags().GetFloat64("validation")
+	splitter.Test, _ = cmd.Flags().GetFloat64("test")
+	splitter.Strata, _ = cmd.Flags().GetInt("strata")
+	splitter.Seed, _ = cmd.Flags().GetInt64("seed")
+	folds, _ := cmd.Flags().GetInt("folds")
+	csvPath := args[0]
+	prefix := strings.TrimSuffix(csvPath, filepath.Ext(csvPath))
+	if len(args) > 1 {
+		prefix = args[1]
+	}
+
+	// Split the rows:
+	table, idIndex, scoreIndex, err := data.ReadSplitTable(csvPath)
+	if err != nil {
+		return err
+	}
+	var splits []data.SplitRows
+	if folds > 0 {
+		splits, err = splitter.Folds(table, idIndex, scoreIndex, folds)
+	} else {
+		splits, err = splitter.Split(table, idIndex, scoreIndex)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Write the splits, and report their size and score distribution:
+	for _, split := range splits {
+		path := prefix + "_" + split.Name + ".csv"
+		if err := data.WriteSplitFile(path, split); err != nil {
+			return err
+		}
+		ids := map[string]bool{}
+		var scores []float64
+		for _, row := range split.Rows {
+			ids[row[idIndex]] = true
+			if v, err := strconv.ParseFloat(strings.TrimSpace(row[scoreIndex]), 64); err == nil {
+				scores = append(scores, v)
+			}
+		}
+		fmt.Printf("%-40s %5d rows %5d pids  standardized mean=%s sd=%s\n", path, len(split.Rows), len(ids),
+			formatStat(stats.Mean(scores)), formatStat(stats.SD(scores)))
+	}
+	return nil
+}
+
+// validateFiles validates JSONL training files.
+func validateFiles(cmd *cobra.Command, args []string) error {
+	base, _ := cmd.Flags().GetString("base")
+	epochs, _ := cmd.Flags().GetInt("epochs")
+	maxTokens, _ := cmd.Flags().GetInt("max-tokens")
+	maxIssues, _ := cmd.Flags().GetInt("max-issues")
+	if maxTokens == 0 {
+		maxTokens = openai.ContextLimits[base]
+	}
+	var failed int
+	for _, path := range args {
+		r, err := data.ValidateTrainingFile(path, maxTokens)
+		if err != nil {
+			return err
+		}
+
+		// Issues:
+		for i, issue := range r.Issues {
+			if i == maxIssues {
+				fmt.Printf("  ... %d more issues\n", len(r.Issues)-i)
+				break
+			}
+			fmt.Printf("%s: %s\n", path, issue)
+		}
+		errs := r.Errors()
+		if errs > 0 {
+			failed++
+		}
+		fmt.Printf("%s: %s format, %d examples, %d errors, %d warnings\n",
+			path, r.Format, r.Examples, errs, len(r.Issues)-errs)
+
+		// Tokens and cost:
+		fmt.Printf("  tokens (estimated): prompt=%d completion=%d total=%d, largest example=%d (limit %d)\n",
+			r.PromptTokens, r.CompletionTokens, r.TotalTokens(), r.MaxTokens, maxTokens)
+		if price, ok := openai.TrainingPrices[base]; ok {
+			trained := r.TotalTokens() * epochs
+			fmt.Printf("  training cost (estimated): %d tokens x %d epochs = %d tokens, $%.2f for %s\n",
+				r.TotalTokens(), epochs, trained, float64(trained)/1000*price, base)
+		}
+		printLabelDistribution(r.Labels)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d files have errors", failed, len(args))
+	}
+	return nil
+}
+
+// printLabelDistribution prints the distribution of completion labels: every
+// label if there are few, or else the most common labels and a summary of
+// their final numeric field (e.g. the standardized score).
+func printLabelDistribution(labels map[string]int) {
+	keys := make([]string, 0, len(labels))
+	for label := range labels {
+		keys = append(keys, label)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if labels[keys[i]] != labels[keys[j]] {
+			return labels[keys[i]] > labels[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	const few = 20
+	fmt.Printf("  labels: %d distinct\n", len(keys))
+	for i, label := range keys {
+		if i == few || (len(keys) > few && i == 5) {
+			break
+		}
+		fmt.Printf("    %5d %q\n", labels[label], label)
+	}
+	if len(keys) <= few {
+		return
+	}
+	var values []float64
+	for label, n := range labels {
+		fields := strings.Fields(label)
+		if len(fields) == 0 {
+			continue
+		}
+		if v, err := strconv.ParseFloat(fields[len(fields)-1], 64); err == nil {
+			for i := 0; i < n; i++ {
+				values = append(values, v)
+			}
+		}
+	}
+	if len(values) > 0 {
+		fmt.Printf("  final label field: n=%d min=%s q1=%s median=%s q3=%s max=%s\n", len(values),
+			formatStat(stats.Quantile(values, 0)), formatStat(stats.Quantile(values, 0.25)), formatStat(stats.Median(values)),
+			formatStat(stats.Quantile(values, 0.75)), formatStat(stats.Quantile(values, 1)))
+	}
+}
+
 // uploadFile uploads a JSONL fine-tuning file.
 func uploadFile(cmd *cobra.Command, args []string) error {
 	ctx := context.Background()
 	purpose := cmd.Flag("purpose").Value.String()
-	path := args[0]
-	fileName := filepath.Base(path)
-	data, err := os.ReadFile(path)
-	if err != nil {
-		return fmt.Errorf("upload file %s: %w", path, err)
-	}
-	file, err := apiClient.UploadFile(ctx, fileName, purpose, data)
+	file, err := uploadPath(ctx, args[0], purpose)
 	if err != nil {
 		return err
 	}
@@ -190,27 +398,104 @@ func uploadFile(cmd *cobra.Command, args []string) error {
 func downloadFile(cmd *cobra.Command, args []string) error {
 	ctx := context.Background()
 	path := cmd.Flag("output").Value.String()
+	checksum, _ := cmd.Flags().GetString("sha256")
 	fileID := args[0]
+
+	// Read the file metadata to get the file name and size:
+	file, err := apiClient.ReadFile(ctx, fileID)
+	if err != nil {
+		return fmt.Errorf("download file %s: %w", fileID, err)
+	}
 	if path == "" {
-		// Read the file metadata to get the file name:
-		file, err := apiClient.ReadFile(ctx, fileID)
-		if err != nil {
-			return fmt.Errorf("download file %s: %w", fileID, err)
-		}
 		path = file.FileName
 	}
-	// Download the file:
-	data, err := apiClient.DownloadFile(ctx, fileID)
+	return downloadPath(ctx, fileID, path, openai.TransferOptions{Size: int64(file.Bytes), SHA256: checksum})
+}
+
+// uploadPath streams a file to the API, reporting progress on stderr.
+func uploadPath(ctx context.Context, path, purpose string) (openai.File, error) {
+	var file openai.File
+	f, err := os.Open(path)
+	if err != nil {
+		return file, fmt.Errorf("upload file %s: %w", path, err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return file, fmt.Errorf("upload file %s: %w", path, err)
+	}
+	opts := openai.TransferOptions{Size: info.Size(), Progress: progressPrinter("uploading " + path)}
+	file, t, err := apiClient.UploadFileFrom(ctx, filepath.Base(path), purpose, f, opts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr)
+		return file, err
+	}
+	fmt.Fprintf(os.Stderr, "\ruploaded %s: %s, sha256 %s\n", path, formatBytes(t.Bytes), t.SHA256)
+	return file, nil
+}
+
+// downloadPath streams a file from the API to a path, reporting progress on
+// stderr. The file is written to a temporary file first, so that a failed
+// download leaves no partial file behind.
+func downloadPath(ctx context.Context, fileID, path string, opts openai.TransferOptions) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("download file %s: %w", fileID, err)
+	}
+	defer os.Remove(f.Name())
+	opts.Progress = progressPrinter("downloading " + path)
+	t, err := apiClient.DownloadFileTo(ctx, fileID, f, opts)
 	if err != nil {
+		f.Close()
+		fmt.Fprintln(os.Stderr)
+		return err
+	}
+	if err := f.Close(); err != nil {
 		return fmt.Errorf("download file %s: %w", fileID, err)
 	}
-	// Write the file to disk:
-	if err := os.WriteFile(path, data, 0644); err != nil {
+	if err := os.Chmod(f.Name(), 0644); err != nil {
 		return fmt.Errorf("download file %s: %w", fileID, err)
 	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return fmt.Errorf("download file %s: %w", fileID, err)
+	}
+	fmt.Fprintf(os.Stderr, "\rdownloaded %s: %s, sha256 %s\n", path, formatBytes(t.Bytes), t.SHA256)
 	return nil
 }
 
+// progressPrinter returns a transfer progress function that prints the bytes
+// transferred to stderr, at most twice a second.
+func progressPrinter(label string) func(done, total int64) {
+	var last time.Time
+	return func(done, total int64) {
+		if time.Since(last) < 500*time.Millisecond && done != total {
+			return
+		}
+		last = time.Now()
+		if total > 0 {
+			fmt.Fprintf(os.Stderr, "\r%s: %s of %s (%.0f%%)", label, formatBytes(done), formatBytes(total),
+				100*float64(done)/float64(total))
+		} else {
+			fmt.Fprintf(os.Stderr, "\r%s: %s", label, formatBytes(done))
+		}
+	}
+}
+
+// formatBytes formats a number of bytes in human-readable units, e.g. "12.3 MB".
+func formatBytes(n int64) string {
+	const unit = 1000
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	v, units := float64(n)/unit, "kMGT"
+	i := 0
+	for v >= unit && i < len(units)-1 {
+		v /= unit
+		i++
+	}
+	return fmt.Sprintf("%.1f %cB", v, units[i])
+}
+
 // deleteFile deletes the specified file.
 func deleteFile(cmd *cobra.Command, args []string) error {
 	ctx := context.Background()
@@ -1,13 +1,18 @@
 package main
 
 import (
+	"bytes"
 	"content-coding-gpt/pkg/openai"
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
+	"os"
+	"path/filepath"
 	"time"
 
 	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
 )
 
 // initTuneCmd initializes the fine-tune commands.
@@ -29,6 +34,7 @@ func initTuneCmd(root *cobra.Command) {
 	}
 	listCmd.Flags().BoolP("verbose", "v", false, "Verbose? (full JSON)")
 	listCmd.Flags().BoolP("raw", "r", false, "Raw OpenAI Response?")
+	addListFlags(listCmd, true)
 	tuneCmd.AddCommand(listCmd)
 
 	// Read Command
@@ -52,19 +58,43 @@ func initTuneCmd(root *cobra.Command) {
 	}
 	eventsCmd.Flags().BoolP("verbose", "v", false, "Verbose? (full JSON)")
 	eventsCmd.Flags().BoolP("raw", "r", false, "Raw OpenAI Response?")
+	addListFlags(eventsCmd, false)
 	tuneCmd.AddCommand(eventsCmd)
 
+	// Watch Command
+	watchCmd := &cobra.Command{
+		Use:   "watch <tuneID>",
+		Short: "Follow a fine-tune job until it finishes",
+		Long: "Poll a fine-tune job with backoff, printing new events and status changes, until it " +
+			"finishes. The exit status is 0 if the job succeeded, 2 if it was cancelled, 3 if the " +
+			"watch timed out, 4 if the job failed, and 1 for any other error (e.g. reading the job). " +
+			"With --then download, the result files are downloaded when the job succeeds.",
+		Args: cobra.ExactArgs(1),
+		RunE: watchTune,
+	}
+	watchCmd.Flags().Duration("interval", 10*time.Second, "Initial polling interval")
+	watchCmd.Flags().Duration("max-interval", 5*time.Minute, "Maximum polling interval")
+	watchCmd.Flags().Duration("timeout", 0, "Stop watching after this long (0: never)")
+	watchCmd.Flags().String("then", "", "Step to run when the job succeeds: download")
+	watchCmd.Flags().StringP("output", "o", "data/training", "Output directory for downloaded result files")
+	tuneCmd.AddCommand(watchCmd)
+
 	// Create Command
 	createCmd := &cobra.Command{
-		Use:   "create <trainingFileID> [validationFileID]",
+		Use:   "create [trainingFileID] [validationFileID]",
 		Short: "Create a fine-tuned model",
-		Long:  "Create a fine-tuned model from the provided training file ID.",
-		Args:  cobra.MinimumNArgs(1),
-		RunE:  createTune,
+		Long: "Create a fine-tuned model from the provided training file ID. The request may be read " +
+			"from a YAML or JSON --config file with the fine-tune request fields (training_file, " +
+			"validation_file, model, suffix, n_epochs, batch_size, learning_rate_multiplier, " +
+			"prompt_loss_weight, compute_classification_metrics, classification_n_classes, " +
+			"classification_positive_class, classification_betas); arguments and flags override it. " +
+			"The request is checked before it is submitted.",
+		Args: cobra.MaximumNArgs(2),
+		RunE: createTune,
 	}
 	createCmd.Flags().BoolP("raw", "r", false, "Raw OpenAI Response?")
-	createCmd.Flags().StringP("base", "b", "curie", "Base model (default: curie)")
-	createCmd.Flags().StringP("suffix", "s", "", "Name suffix of the fine-tuned model")
+	addTuneRequestFlags(createCmd)
+	createCmd.Flags().Bool("dry-run", false, "Check and print the request without submitting it")
 	tuneCmd.AddCommand(createCmd)
 
 	// Cancel Command
@@ -86,6 +116,18 @@ func initTuneCmd(root *cobra.Command) {
 		RunE:  deleteTune,
 	}
 	tuneCmd.AddCommand(deleteCmd)
+
+	// Results Command
+	initTuneResultsCmd(tuneCmd)
+
+	// Pipeline Command
+	initTunePipelineCmd(tuneCmd)
+
+	// Sweep Command
+	initTuneSweepCmd(tuneCmd)
+
+	// Prune Command
+	initTunePruneCmd(tuneCmd)
 }
 
 // listTunes lists the fine-tuned models.
@@ -97,8 +139,9 @@ func listTunes(cmd *cobra.Command, args []string) error {
 	if err != nil {
 		return err
 	}
+	opts := listOptions(cmd)
 	if raw {
-		body, e := apiClient.ListFineTunesRaw(ctx)
+		body, e := apiClient.ListFineTunesRaw(ctx, opts)
 		if e != nil {
 			return e
 		}
@@ -107,7 +150,7 @@ func listTunes(cmd *cobra.Command, args []string) error {
 	}
 
 	// Retrieve the fine-tuned models.
-	tunes, err := apiClient.ListFineTunes(ctx)
+	tunes, err := apiClient.ListFineTunes(ctx, opts)
 	if err != nil {
 		return err
 	}
@@ -173,8 +216,9 @@ func listTuneEvents(cmd *cobra.Command, args []string) error {
 	if err != nil {
 		return err
 	}
+	opts := listOptions(cmd)
 	if raw {
-		body, e := apiClient.ListFineTuneEventsRaw(ctx, args[0])
+		body, e := apiClient.ListFineTuneEventsRaw(ctx, args[0], opts)
 		if e != nil {
 			return e
 		}
@@ -183,7 +227,7 @@ func listTuneEvents(cmd *cobra.Command, args []string) error {
 	}
 
 	// Retrieve the events.
-	events, err := apiClient.ListFineTuneEvents(ctx, args[0])
+	events, err := apiClient.ListFineTuneEvents(ctx, args[0], opts)
 	if err != nil {
 		return err
 	}
@@ -208,48 +252,248 @@ func listTuneEvents(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
+// watchTune polls a fine-tune job until it finishes, printing new events and
+// status changes.
+func watchTune(cmd *cobra.Command, args []string) error {
+	ctx := context.Background()
+	interval, _ := cmd.Flags().GetDuration("interval")
+	maxInterval, _ := cmd.Flags().GetDuration("max-interval")
+	timeout, _ := cmd.Flags().GetDuration("timeout")
+	then, _ := cmd.Flags().GetString("then")
+	output, _ := cmd.Flags().GetString("output")
+	id := args[0]
+	if then != "" && then != "download" {
+		return fmt.Errorf("unknown --then step %s; expected: download", then)
+	}
+	if interval <= 0 {
+		return fmt.Errorf("interval must be positive")
+	}
+
+	// Poll until the job finishes:
+	tune, err := waitForTune(ctx, id, interval, maxInterval, timeout)
+	if err != nil {
+		return err
+	}
+
+	// Exit with the job status, downloading the results if requested:
+	switch tune.Status {
+	case openai.FineTuneFailed:
+		return exitError{code: 4, err: fmt.Errorf("fine-tune %s failed", id)}
+	case openai.FineTuneCancelled:
+		return exitError{code: 2, err: fmt.Errorf("fine-tune %s was cancelled", id)}
+	}
+	fmt.Printf("fine-tune %s succeeded: %s\n", id, tune.FineTunedModel)
+	if then == "download" {
+		if _, err := downloadResultFiles(ctx, tune, output); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// waitForTune polls a fine-tune job until it finishes, printing new events
+// and status changes, and backing off from interval to maxInterval while
+// nothing changes. It gives up after five consecutive API errors, or with
+// exit status 3 once the timeout (if any) expires.
+func waitForTune(ctx context.Context, id string, interval, maxInterval, timeout time.Duration) (openai.FineTune, error) {
+	var status string
+	var seen, failures int
+	var tune openai.FineTune
+	startTime := time.Now()
+	wait := interval
+	for {
+		var err error
+		tune, err = apiClient.ReadFineTune(ctx, id)
+		var events []openai.Event
+		if err == nil {
+			events, err = apiClient.ListFineTuneEvents(ctx, id, openai.ListOptions{})
+		}
+		changed := false
+		if err != nil {
+			failures++
+			if failures >= 5 {
+				return tune, err
+			}
+			fmt.Printf("%s: %v (retrying)\n", time.Now().Format(time.TimeOnly), err)
+		} else {
+			failures = 0
+			for ; seen < len(events); seen++ {
+				event := events[seen]
+				fmt.Println(time.Unix(event.CreatedAt, 0), event.Level, event.Message)
+				changed = true
+			}
+			if tune.Status != status {
+				fmt.Printf("%s: %s status: %s\n", time.Now().Format(time.TimeOnly), tune.Name(), tune.Status)
+				status = tune.Status
+				changed = true
+			}
+			if tune.Done() {
+				return tune, nil
+			}
+		}
+		if timeout > 0 && time.Since(startTime) >= timeout {
+			return tune, exitError{code: 3, err: fmt.Errorf("fine-tune %s still %s after %s", id, status, timeout)}
+		}
+		if changed {
+			wait = interval
+		} else if wait = wait * 3 / 2; wait > maxInterval {
+			wait = maxInterval
+		}
+		time.Sleep(wait)
+	}
+}
+
+// downloadResultFiles downloads the result files of a fine-tune job to a
+// directory, returning their paths (<dir>/<tuneID>_<fileName>).
+func downloadResultFiles(ctx context.Context, tune openai.FineTune, dir string) ([]string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("download results of %s: %w", tune.ID, err)
+	}
+	var paths []string
+	for _, file := range tune.ResultFiles {
+		name := file.FileName
+		if name == "" {
+			name = file.ID + ".csv"
+		}
+		path := filepath.Join(dir, tune.ID+"_"+filepath.Base(name))
+		if err := downloadPath(ctx, file.ID, path, openai.TransferOptions{Size: int64(file.Bytes)}); err != nil {
+			return paths, err
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+// readTuneConfig reads a YAML or JSON file of fine-tune request fields.
+func readTuneConfig(path string) (openai.FineTuneRequest, error) {
+	var req openai.FineTuneRequest
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return req, fmt.Errorf("read fine-tune config %s: %w", path, err)
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	if err := dec.Decode(&req); err != nil && err != io.EOF {
+		return req, fmt.Errorf("read fine-tune config %s: %w", path, err)
+	}
+	return req, nil
+}
+
+// addTuneRequestFlags adds the fine-tune request flags to a command.
+func addTuneRequestFlags(cmd *cobra.Command) {
+	cmd.Flags().StringP("config", "c", "", "YAML or JSON file of fine-tune request fields")
+	cmd.Flags().StringP("base", "b", "curie", "Base model (default: curie)")
+	cmd.Flags().StringP("suffix", "s", "", "Name suffix of the fine-tuned model")
+	cmd.Flags().Int("epochs", 0, "Number of epochs (n_epochs; default 4)")
+	cmd.Flags().Int("batch-size", 0, "Batch size (default: ~0.2% of the training examples, at most 256)")
+	cmd.Flags().Float64("learning-rate", 0, "Learning rate multiplier (default: 0.05, 0.1, or 0.2 by batch size)")
+	cmd.Flags().Float64("prompt-loss-weight", 0, "Weight of the loss on the prompt tokens (default 0.01)")
+	cmd.Flags().Bool("classification-metrics", false, "Compute classification metrics on the validation file?")
+	cmd.Flags().Int("n-classes", 0, "Number of classes (required with --classification-metrics)")
+	cmd.Flags().String("positive-class", "", "Positive class completion of a binary classification, e.g. \" yes\"")
+	cmd.Flags().Float64Slice("betas", nil, "F-beta scores to compute for a binary classification, e.g. 0.5,1,2")
+}
+
+// tuneRequest builds a fine-tune request from the --config file, the
+// training and validation file IDs (if not empty), and the flags, in
+// increasing order of precedence, and validates it.
+func tuneRequest(cmd *cobra.Command, trainingFileID, validationFileID string) (openai.FineTuneRequest, error) {
+	var req openai.FineTuneRequest
+	if config, _ := cmd.Flags().GetString("config"); config != "" {
+		var err error
+		if req, err = readTuneConfig(config); err != nil {
+			return req, err
+		}
+	}
+	if trainingFileID != "" {
+		req.TrainingFileID = trainingFileID
+	}
+	if validationFileID != "" {
+		req.ValidationFileID = validationFileID
+	}
+	flags := cmd.Flags()
+	if flags.Changed("base") || req.Model == "" {
+		req.Model, _ = flags.GetString("base")
+	}
+	if flags.Changed("suffix") {
+		req.Suffix, _ = flags.GetString("suffix")
+	}
+	if flags.Changed("epochs") {
+		req.EpochCount, _ = flags.GetInt("epochs")
+	}
+	if flags.Changed("batch-size") {
+		req.BatchSize, _ = flags.GetInt("batch-size")
+	}
+	if flags.Changed("learning-rate") {
+		req.LearningRate, _ = flags.GetFloat64("learning-rate")
+	}
+	if flags.Changed("prompt-loss-weight") {
+		req.PromptLossWeight, _ = flags.GetFloat64("prompt-loss-weight")
+	}
+	if flags.Changed("classification-metrics") {
+		req.ComputeClassificationMetrics, _ = flags.GetBool("classification-metrics")
+	}
+	if flags.Changed("n-classes") {
+		req.ClassificationNClasses, _ = flags.GetInt("n-classes")
+	}
+	if flags.Changed("positive-class") {
+		req.ClassificationPositiveClass, _ = flags.GetString("positive-class")
+	}
+	if flags.Changed("betas") {
+		req.ClassificationBetas, _ = flags.GetFloat64Slice("betas")
+	}
+	return req, req.Validate()
+}
+
 // createTune creates a fine-tuned model.
 func createTune(cmd *cobra.Command, args []string) error {
 	// Gather request parameters
 	ctx := context.Background()
-	base := cmd.Flag("base").Value.String()
-	suffix := cmd.Flag("suffix").Value.String()
-	trainingFileID := args[0]
-	validationFileID := ""
+	var trainingFileID, validationFileID string
+	if len(args) > 0 {
+		trainingFileID = args[0]
+	}
 	if len(args) > 1 {
 		validationFileID = args[1]
 	}
+	req, err := tuneRequest(cmd, trainingFileID, validationFileID)
+	if err != nil {
+		return err
+	}
 	raw, err := cmd.Flags().GetBool("raw")
 	if err != nil {
 		return err
 	}
+	dryRun, _ := cmd.Flags().GetBool("dry-run")
 
 	// Validate the base model.
-	if !apiClient.ValidModel(ctx, base) {
-		return fmt.Errorf("invalid base model: %s", base)
+	if !apiClient.ValidModel(ctx, req.Model) {
+		return fmt.Errorf("invalid base model: %s", req.Model)
 	}
 
 	// Validate the training file ID.
-	_, err = apiClient.ReadFile(ctx, trainingFileID)
+	_, err = apiClient.ReadFile(ctx, req.TrainingFileID)
 	if err != nil {
-		return fmt.Errorf("invalid training file ID %s: %w", trainingFileID, err)
+		return fmt.Errorf("invalid training file ID %s: %w", req.TrainingFileID, err)
 	}
 
 	// Validate the validation file ID.
-	if validationFileID != "" {
-		_, err := apiClient.ReadFile(ctx, validationFileID)
+	if req.ValidationFileID != "" {
+		_, err := apiClient.ReadFile(ctx, req.ValidationFileID)
 		if err != nil {
-			return fmt.Errorf("invalid validation file ID %s: %w", validationFileID, err)
+			return fmt.Errorf("invalid validation file ID %s: %w", req.ValidationFileID, err)
 		}
 	}
+	if dryRun {
+		j, err := json.MarshalIndent(req, "", "  ")
+		if err != nil {
+			return fmt.Errorf("error marshalling FineTuneRequest JSON: %w", err)
+		}
+		fmt.Println(string(j))
+		return nil
+	}
 
 	// Create the fine-tuned model, returning the raw response if requested.
-	req := openai.FineTuneRequest{
-		TrainingFileID:   trainingFileID,
-		ValidationFileID: validationFileID,
-		Model:            base,
-		Suffix:           suffix,
-	}
 	if raw {
 		body, err := apiClient.CreateFineTuneRaw(ctx, req)
 		if err != nil {
@@ -2,6 +2,7 @@ package main
 
 import (
 	"content-coding-gpt/pkg/openai"
+	"errors"
 	"fmt"
 	"os"
 
@@ -35,15 +36,42 @@ func main() {
 	rootCmd.AddCommand(aboutCmd)
 
 	// Initialize the commands:
+	initBatchCmd(rootCmd)
 	initChatCmd(rootCmd)
 	initCompleteCmd(rootCmd)
+	initEvaluateCmd(rootCmd)
+	initExperimentCmd(rootCmd)
 	initFileCmd(rootCmd)
+	initImportCmd(rootCmd)
 	initModelCmd(rootCmd)
+	initProvenanceCmd(rootCmd)
+	initRescoreCmd(rootCmd)
+	initRunsCmd(rootCmd)
 	initTuneCmd(rootCmd)
 
 	// Execute the specified command:
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Println(err)
+		var exit exitError
+		if errors.As(err, &exit) {
+			os.Exit(exit.code)
+		}
 		os.Exit(1)
 	}
 }
+
+// exitError is an error that exits the application with a specific status code.
+type exitError struct {
+	code int
+	err  error
+}
+
+// Error returns the error message.
+func (e exitError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e exitError) Unwrap() error {
+	return e.err
+}
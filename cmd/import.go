@@ -0,0 +1,183 @@
+package main
+
+import (
+	"content-coding-gpt/pkg/data"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// initImportCmd initializes the import command.
+func initImportCmd(root *cobra.Command) {
+	importCmd := &cobra.Command{
+		Use:   "import <csvFile> <xlsxFile>...",
+		Short: "Import essays from XLSX workbooks",
+		Long: "Import essays from one or more XLSX workbooks into an essay CSV file (pid, one column per " +
+			"essay type, and any extra columns). Rows are merged by participant ID across workbooks, " +
+			"keeping the last non-empty value of each extra column. " +
+			"Use --essay-from-file when each workbook holds one essay type, named after the file " +
+			"(e.g. a.xlsx, b1.xlsx), or --prompts to import writing prompts (essay type, prompt) instead.",
+		Args: cobra.MinimumNArgs(1),
+		RunE: importFiles,
+	}
+	importCmd.Flags().StringP("sheet", "s", "", "Sheet name or 1-based index (default: first sheet)")
+	importCmd.Flags().Int("header-row", 1, "1-based header row (0: no header, use column letters)")
+	importCmd.Flags().String("essay-from-file", "", "Essay text column; the essay type is the workbook file name")
+	importCmd.Flags().Bool("prompts", false, "Import writing prompts (essay type, prompt) instead of essays?")
+	importCmd.Flags().BoolP("list", "l", false, "List the sheets and columns of each workbook, without importing")
+	addSchemaFlags(importCmd)
+	root.AddCommand(importCmd)
+}
+
+// importFiles imports essays or writing prompts from XLSX workbooks into a CSV file.
+func importFiles(cmd *cobra.Command, args []string) error {
+	sheet, _ := cmd.Flags().GetString("sheet")
+	headerRow, _ := cmd.Flags().GetInt("header-row")
+	essayFromFile, _ := cmd.Flags().GetString("essay-from-file")
+	prompts, _ := cmd.Flags().GetBool("prompts")
+	list, _ := cmd.Flags().GetBool("list")
+
+	// List the workbook sheets and columns?
+	if list {
+		for _, path := range args {
+			if data.TableFormat(path) != "xlsx" {
+				continue
+			}
+			sheets, err := data.ListXLSXSheets(path)
+			if err != nil {
+				return err
+			}
+			for i, name := range sheets {
+				table, err := data.ReadXLSXFile(path, name, headerRow)
+				if err != nil {
+					return err
+				}
+				fmt.Printf("%s: sheet %d %q: %d rows: %s\n", path, i+1, name, len(table.Rows),
+					strings.Join(table.Header, ", "))
+			}
+		}
+		return nil
+	}
+	if len(args) < 2 {
+		return fmt.Errorf("import: no xlsx files specified")
+	}
+	csvFile, xlsxFiles := args[0], args[1:]
+
+	// Import writing prompts?
+	if prompts {
+		return importPrompts(csvFile, xlsxFiles, sheet, headerRow)
+	}
+
+	// Import the essays, merging the rows by participant ID:
+	schema, err := inputSchema(cmd)
+	if err != nil {
+		return err
+	}
+	records := map[int]*data.EssayRecord{}
+	essayTypes := map[string]bool{}
+	for _, path := range xlsxFiles {
+		table, err := data.ReadXLSXFile(path, sheet, headerRow)
+		if err != nil {
+			return err
+		}
+		fileSchema := schema
+		if essayFromFile != "" {
+			essayType := data.EssayTypeName(strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)))
+			fileSchema.EssayColumns = map[string]string{essayType: essayFromFile}
+		}
+		newRecord, err := fileSchema.RecordReader(table.Header)
+		if err != nil {
+			return fmt.Errorf("import %s: %w", path, err)
+		}
+		var count int
+		for i, row := range table.Rows {
+			r, e := newRecord(row)
+			if e != nil {
+				fmt.Printf("%s: record %d: %v\n", path, i+1, e)
+				continue
+			}
+			record, ok := records[r.ID]
+			if !ok {
+				record = &data.EssayRecord{ID: r.ID, Essays: map[string]string{}, Extra: map[string]string{}}
+				records[r.ID] = record
+			}
+			for essayType, essay := range r.Essays {
+				if _, dup := record.Essays[essayType]; dup {
+					fmt.Printf("%s: record %d: replacing duplicate %s essay for pid %d\n", path, i+1, essayType, r.ID)
+				}
+				record.Essays[essayType] = essay
+				essayTypes[essayType] = true
+			}
+			for column, value := range r.Extra {
+				if value != "" {
+					record.Extra[column] = value
+				}
+			}
+			count++
+		}
+		fmt.Printf("%s: imported %d records\n", path, count)
+	}
+
+	// Write the essay file:
+	types := make([]string, 0, len(essayTypes))
+	for essayType := range essayTypes {
+		types = append(types, essayType)
+	}
+	sort.Strings(types)
+	ids := make([]int, 0, len(records))
+	for id := range records {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	header := append([]string{data.DefaultEssaySchema.IDColumn}, types...)
+	header = append(header, schema.ExtraColumns...)
+	rows := [][]string{header}
+	for _, id := range ids {
+		record := records[id]
+		row := []string{strconv.Itoa(id)}
+		for _, essayType := range types {
+			row = append(row, record.Essays[essayType])
+		}
+		for _, column := range schema.ExtraColumns {
+			row = append(row, record.Extra[column])
+		}
+		rows = append(rows, row)
+	}
+	if err := data.WriteCSVFile(csvFile, rows); err != nil {
+		return err
+	}
+	fmt.Printf("%s: wrote %d participants with essay types: %s\n", csvFile, len(ids), strings.Join(types, ", "))
+	return nil
+}
+
+// importPrompts imports writing prompts from the first two columns (essay type,
+// prompt) of each workbook into an essay prompts CSV file.
+func importPrompts(csvFile string, xlsxFiles []string, sheet string, headerRow int) error {
+	rows := [][]string{data.EssayPromptsCSVHeader}
+	for _, path := range xlsxFiles {
+		table, err := data.ReadXLSXFile(path, sheet, headerRow)
+		if err != nil {
+			return err
+		}
+		for _, row := range table.Rows {
+			if len(row) < 2 {
+				continue
+			}
+			essayType := data.EssayTypeName(row[0])
+			prompt := data.CleanResponse(row[1])
+			if essayType == "" || prompt == "" {
+				continue
+			}
+			rows = append(rows, []string{essayType, prompt})
+		}
+	}
+	if err := data.WriteCSVFile(csvFile, rows); err != nil {
+		return err
+	}
+	fmt.Printf("%s: wrote %d essay prompts\n", csvFile, len(rows)-1)
+	return nil
+}
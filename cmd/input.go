@@ -0,0 +1,67 @@
+package main
+
+import (
+	"content-coding-gpt/pkg/data"
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// addInputFlags adds the essay input file and column-mapping flags to a command.
+func addInputFlags(cmd *cobra.Command) {
+	cmd.Flags().String("input", data.DefaultEssayFile, "Essay input file (CSV, TSV, JSONL, or XLSX)")
+	cmd.Flags().String("essay-prompts", "", "Essay prompts file (essay_type,prompt) for other essay types")
+	addSchemaFlags(cmd)
+}
+
+// addSchemaFlags adds the essay column-mapping flags to a command.
+func addSchemaFlags(cmd *cobra.Command) {
+	cmd.Flags().String("id-column", data.DefaultEssaySchema.IDColumn, "Participant ID column")
+	cmd.Flags().StringToString("essay-column", nil, "Essay type to text column mapping, e.g. dream=q1,award=q4 (default: all other columns)")
+	cmd.Flags().StringSlice("extra-column", nil, "Extra covariate column(s) to carry through to the results")
+}
+
+// inputSchema returns the essay schema specified by the column-mapping flags.
+func inputSchema(cmd *cobra.Command) (data.EssaySchema, error) {
+	idColumn, _ := cmd.Flags().GetString("id-column")
+	essayColumns, _ := cmd.Flags().GetStringToString("essay-column")
+	extraColumns, _ := cmd.Flags().GetStringSlice("extra-column")
+	return data.NewEssaySchema(idColumn, essayColumns, extraColumns)
+}
+
+// validateEssayType returns an error if the schema doesn't provide the essay type.
+func validateEssayType(schema data.EssaySchema, essayType string) error {
+	if !schema.HasEssayType(essayType) {
+		return fmt.Errorf("essay type %s is not one of: %s", essayType, strings.Join(schema.EssayTypes(), ", "))
+	}
+	return nil
+}
+
+// readEssays reads the essay records specified by the input flags, keeping
+// only those that include the specified essay type.
+func readEssays(cmd *cobra.Command, essayType string) ([]data.EssayRecord, data.EssaySchema, error) {
+	input, _ := cmd.Flags().GetString("input")
+	essayPrompts, _ := cmd.Flags().GetString("essay-prompts")
+	schema, err := inputSchema(cmd)
+	if err != nil {
+		return nil, schema, err
+	}
+	if essayPrompts != "" {
+		if err := data.ReadEssayPrompts(essayPrompts); err != nil {
+			return nil, schema, err
+		}
+	}
+	essays, schema, err := data.ReadEssayRecords(input, schema)
+	if err != nil {
+		return nil, schema, err
+	}
+	if err := validateEssayType(schema, essayType); err != nil {
+		return nil, schema, err
+	}
+	essays = data.FilterEssayRecords(essays, essayType)
+	if len(essays) == 0 {
+		return nil, schema, fmt.Errorf("no %s essays found in %s", essayType, input)
+	}
+	return essays, schema, nil
+}
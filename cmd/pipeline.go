@@ -0,0 +1,329 @@
+package main
+
+import (
+	"content-coding-gpt/pkg/data"
+	"content-coding-gpt/pkg/openai"
+	"content-coding-gpt/pkg/provenance"
+	"content-coding-gpt/pkg/stats"
+	"content-coding-gpt/pkg/store"
+	"content-coding-gpt/pkg/tuning"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// initTunePipelineCmd initializes the tune pipeline command.
+func initTunePipelineCmd(tuneCmd *cobra.Command) {
+	pipelineCmd := &cobra.Command{
+		Use:   "pipeline <csvFile>",
+		Short: "Fine-tune and evaluate a model from a human-coded CSV file",
+		Long: "Run the fine-tune steps in order on a humility or spiritual training CSV file: split it " +
+			"into train, validation, and held-out test sets; prepare and validate the JSONL training " +
+			"files; upload them; create the fine-tune job; wait for it to finish; and score the test " +
+			"set with the fine-tuned model, reporting its agreement with the human coders. The files " +
+			"and state are kept in --dir (default data/pipelines/<csvFile name>), and the state is " +
+			"saved after every step, so running the command again resumes an interrupted pipeline " +
+			"with its saved options, submitting a new fine-tune job if the last one failed or was " +
+			"cancelled.",
+		Args: cobra.ExactArgs(1),
+		RunE: tunePipeline,
+	}
+	pipelineCmd.Flags().StringP("essay-type", "e", "", "Essay type (default: from a training_<essayType>.csv file name)")
+	pipelineCmd.Flags().String("dir", "", "Pipeline directory (default data/pipelines/<csvFile name>)")
+	pipelineCmd.Flags().Float64("validation", 0.1, "Fraction of participants in the validation set")
+	pipelineCmd.Flags().Float64("test", 0.2, "Fraction of participants in the held-out test set")
+	pipelineCmd.Flags().Int("strata", 5, "Number of standardized score strata")
+	pipelineCmd.Flags().Int64("seed", 1, "Random seed of the split")
+	addTuneRequestFlags(pipelineCmd)
+	pipelineCmd.Flags().IntP("max-tokens", "t", 16, "Maximum number of tokens to generate when scoring the test set")
+	pipelineCmd.Flags().Duration("interval", 30*time.Second, "Initial polling interval of the fine-tune job")
+	pipelineCmd.Flags().Duration("max-interval", 5*time.Minute, "Maximum polling interval of the fine-tune job")
+	pipelineCmd.Flags().Duration("timeout", 0, "Stop waiting for the fine-tune job after this long (0: never)")
+	pipelineCmd.Flags().Bool("restart", false, "Discard the saved state, and start again")
+	tuneCmd.AddCommand(pipelineCmd)
+}
+
+// tunePipeline runs, or resumes, a fine-tune pipeline.
+func tunePipeline(cmd *cobra.Command, args []string) error {
+	ctx := context.Background()
+	essayType, _ := cmd.Flags().GetString("essay-type")
+	dir, _ := cmd.Flags().GetString("dir")
+	restart, _ := cmd.Flags().GetBool("restart")
+	interval, _ := cmd.Flags().GetDuration("interval")
+	maxInterval, _ := cmd.Flags().GetDuration("max-interval")
+	timeout, _ := cmd.Flags().GetDuration("timeout")
+	csvPath := args[0]
+	name := strings.TrimSuffix(filepath.Base(csvPath), filepath.Ext(csvPath))
+	if dir == "" {
+		dir = filepath.Join("data", "pipelines", name)
+	}
+	if interval <= 0 {
+		return fmt.Errorf("interval must be positive")
+	}
+
+	// Resume the saved pipeline, or start a new one:
+	p, err := tuning.ReadPipeline(dir)
+	switch {
+	case err == nil && !restart:
+		if filepath.Clean(p.Input) != filepath.Clean(csvPath) {
+			return fmt.Errorf("pipeline %s is for %s, not %s; use another --dir, or --restart", dir, p.Input, csvPath)
+		}
+		fmt.Printf("resuming pipeline %s (done: %s); using its saved options\n", dir, strings.Join(p.Done, ", "))
+		if p.Failed() {
+			fmt.Printf("fine-tune %s %s; submitting a new one\n", p.TuneID, p.Status)
+			if err := p.Resubmit(); err != nil {
+				return err
+			}
+		}
+	case err == nil || errors.Is(err, fs.ErrNotExist):
+		if p, err = newPipeline(cmd, dir, csvPath, essayType); err != nil {
+			return err
+		}
+	default:
+		return err
+	}
+
+	// Run the remaining steps:
+	for _, step := range tuning.PipelineSteps {
+		if p.Completed(step) {
+			continue
+		}
+		fmt.Printf("== %s\n", step)
+		var err error
+		switch step {
+		case tuning.SplitStep:
+			err = pipelineSplit(p)
+		case tuning.PrepareStep:
+			err = pipelinePrepare(p)
+		case tuning.ValidateStep:
+			err = pipelineValidate(p)
+		case tuning.UploadStep:
+			err = pipelineUpload(ctx, p)
+		case tuning.CreateStep:
+			err = pipelineCreate(ctx, p)
+		case tuning.WaitStep:
+			err = pipelineWait(ctx, p, interval, maxInterval, timeout)
+		case tuning.EvaluateStep:
+			err = pipelineEvaluate(ctx, p)
+		}
+		if err != nil {
+			return fmt.Errorf("pipeline %s: %s: %w", dir, step, err)
+		}
+		if err := p.Complete(step); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("pipeline %s complete: %s\n", dir, p.FineTunedModel)
+	return nil
+}
+
+// newPipeline creates a pipeline from the flags, checking the options before
+// any work is done.
+func newPipeline(cmd *cobra.Command, dir, csvPath, essayType string) (*tuning.Pipeline, error) {
+	if essayType == "" {
+		name := strings.TrimSuffix(filepath.Base(csvPath), filepath.Ext(csvPath))
+		essayType = strings.TrimPrefix(name, "training_")
+	}
+	if !data.IsHumility(essayType) && !data.IsSpiritual(essayType) {
+		return nil, fmt.Errorf("essay type %s is neither humility nor spiritual; use --essay-type", essayType)
+	}
+	p := tuning.NewPipeline(dir, csvPath, essayType)
+	p.Splitter.Validation, _ = cmd.Flags().GetFloat64("validation")
+	p.Splitter.Test, _ = cmd.Flags().GetFloat64("test")
+	p.Splitter.Strata, _ = cmd.Flags().GetInt("strata")
+	p.Splitter.Seed, _ = cmd.Flags().GetInt64("seed")
+	p.MaxTokens, _ = cmd.Flags().GetInt("max-tokens")
+	if p.Splitter.Test <= 0 {
+		return nil, errors.New("the pipeline evaluates the fine-tuned model on a test set; --test must be positive")
+	}
+
+	// The uploaded file IDs are not known yet, so check the request with
+	// placeholders:
+	validationID := ""
+	if p.Splitter.Validation > 0 {
+		validationID = "validation"
+	}
+	req, err := tuneRequest(cmd, "train", validationID)
+	if err != nil {
+		return nil, err
+	}
+	req.TrainingFileID, req.ValidationFileID = "", ""
+	p.Request = req
+	if !apiClient.ValidModel(context.Background(), req.Model) {
+		return nil, fmt.Errorf("invalid base model: %s", req.Model)
+	}
+	if err := p.Save(); err != nil {
+		return nil, err
+	}
+	fmt.Printf("started pipeline %s\n", dir)
+	return p, nil
+}
+
+// pipelineSplit splits the input file into train, validation, and test files.
+func pipelineSplit(p *tuning.Pipeline) error {
+	table, idIndex, scoreIndex, err := data.ReadSplitTable(p.Input)
+	if err != nil {
+		return err
+	}
+	splits, err := p.Splitter.Split(table, idIndex, scoreIndex)
+	if err != nil {
+		return err
+	}
+	for _, split := range splits {
+		path := p.Path(split.Name + ".csv")
+		if err := data.WriteSplitFile(path, split); err != nil {
+			return err
+		}
+		p.Files[split.Name] = path
+		fmt.Printf("%-40s %5d rows\n", path, len(split.Rows))
+	}
+	return nil
+}
+
+// pipelinePrepare prepares the train and validation training files.
+func pipelinePrepare(p *tuning.Pipeline) error {
+	for _, split := range []string{data.TrainSplit, data.ValidationSplit} {
+		csvPath, ok := p.Files[split]
+		if !ok {
+			continue
+		}
+		path := p.Path(split + ".jsonl")
+		if err := data.PrepareTrainingFile(csvPath, path, false); err != nil {
+			return err
+		}
+		p.JSONL[split] = path
+		fmt.Printf("prepared %s\n", path)
+	}
+	return nil
+}
+
+// pipelineValidate validates the training files for the base model.
+func pipelineValidate(p *tuning.Pipeline) error {
+	for _, split := range []string{data.TrainSplit, data.ValidationSplit} {
+		path, ok := p.JSONL[split]
+		if !ok {
+			continue
+		}
+		r, err := data.ValidateTrainingFile(path, openai.ContextLimits[p.Request.Model])
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s: %d examples, about %d tokens, %d errors, %d warnings\n",
+			path, r.Examples, r.TotalTokens(), r.Errors(), len(r.Issues)-r.Errors())
+		if r.Errors() > 0 {
+			for i, issue := range r.Issues {
+				if i == 20 {
+					fmt.Printf("  ... %d more\n", len(r.Issues)-i)
+					break
+				}
+				fmt.Printf("  %s\n", issue)
+			}
+			return fmt.Errorf("%s has %d errors", path, r.Errors())
+		}
+	}
+	return nil
+}
+
+// pipelineUpload uploads the training files, saving the state after each.
+func pipelineUpload(ctx context.Context, p *tuning.Pipeline) error {
+	for _, split := range []string{data.TrainSplit, data.ValidationSplit} {
+		path, ok := p.JSONL[split]
+		if !ok || p.FileIDs[split] != "" {
+			continue
+		}
+		file, err := uploadPath(ctx, path, "fine-tune")
+		if err != nil {
+			return err
+		}
+		p.FileIDs[split] = file.ID
+		if err := p.Save(); err != nil {
+			return err
+		}
+		fmt.Printf("uploaded %s: %s\n", path, file.ID)
+	}
+	return nil
+}
+
+// pipelineCreate creates the fine-tune job, unless it was created before the
+// pipeline was interrupted, saving the state.
+func pipelineCreate(ctx context.Context, p *tuning.Pipeline) error {
+	if p.TuneID != "" {
+		fmt.Printf("fine-tune %s already created\n", p.TuneID)
+		return nil
+	}
+	req := p.Request
+	req.TrainingFileID, req.ValidationFileID = p.FileIDs[data.TrainSplit], p.FileIDs[data.ValidationSplit]
+	if err := req.Validate(); err != nil {
+		return err
+	}
+	tune, err := apiClient.CreateFineTune(ctx, req)
+	if err != nil {
+		return err
+	}
+	p.TuneID, p.Status = tune.ID, tune.Status
+	if err := p.Save(); err != nil {
+		return err
+	}
+	fmt.Printf("created fine-tune %s\n", tune.ID)
+	return nil
+}
+
+// pipelineWait waits for the fine-tune job to finish, and downloads its
+// result files.
+func pipelineWait(ctx context.Context, p *tuning.Pipeline, interval, maxInterval, timeout time.Duration) error {
+	tune, err := waitForTune(ctx, p.TuneID, interval, maxInterval, timeout)
+	if err != nil {
+		return err
+	}
+	p.Status, p.FineTunedModel = tune.Status, tune.FineTunedModel
+	if err := p.Save(); err != nil {
+		return err
+	}
+	if tune.Status != openai.FineTuneSucceeded {
+		return fmt.Errorf("fine-tune %s %s", tune.ID, tune.Status)
+	}
+	_, err = downloadResultFiles(ctx, tune, p.Dir)
+	return err
+}
+
+// pipelineEvaluate scores the held-out test set with the fine-tuned model,
+// and reports its agreement with the human coders.
+func pipelineEvaluate(ctx context.Context, p *tuning.Pipeline) error {
+	testPath, ok := p.Files[data.TestSplit]
+	if !ok {
+		return errors.New("no test set to evaluate")
+	}
+	essays, err := data.ReadTrainingEssays(testPath, p.EssayType)
+	if err != nil {
+		return err
+	}
+	manifest := provenance.New(os.Args)
+	if err := manifest.AddFile("input", testPath); err != nil {
+		return err
+	}
+	manifest.AddHallmarks(p.EssayType, data.Hallmarks[p.EssayType])
+	p.Results = p.Path("test_results.csv")
+	if err := completeEssays(ctx, essays, p.EssayType, p.FineTunedModel, p.MaxTokens, p.Results, "", manifest, nil, store.Run{}); err != nil {
+		return err
+	}
+
+	// Compare the standardized scores:
+	human, humanHeader, err := readScoreTable(testPath)
+	if err != nil {
+		return err
+	}
+	e, err := evaluateFile(testPath, human, humanHeader, p.Results, "standardized", "standardized", stats.DefaultOptions)
+	if err != nil {
+		return err
+	}
+	printEvaluation(e)
+	p.Evaluation = p.Path("evaluation.json")
+	return writeEvaluations(p.Evaluation, []Evaluation{e})
+}
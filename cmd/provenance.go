@@ -0,0 +1,123 @@
+package main
+
+import (
+	"content-coding-gpt/pkg/data"
+	"content-coding-gpt/pkg/provenance"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// initProvenanceCmd initializes the provenance commands.
+func initProvenanceCmd(root *cobra.Command) {
+	// Provenance Command
+	provenanceCmd := &cobra.Command{
+		Use:   "provenance",
+		Short: "Verify results provenance",
+		Long: "Verify the provenance of results files. Every batch command writes a sidecar manifest " +
+			"(<results>" + provenance.Suffix + ") recording the command line, the input, template, and " +
+			"results file hashes, the hallmarks text hashes, the models and system fingerprints " +
+			"returned by the API, the token usage, timestamps, and the git commit.",
+	}
+	root.AddCommand(provenanceCmd)
+
+	// Verify Command
+	verifyCmd := &cobra.Command{
+		Use:   "verify <resultsFile>...",
+		Short: "Verify the hashes of a results file's provenance manifest",
+		Long: "Recompute the hashes of the files and hallmarks recorded in the sidecar manifest of " +
+			"each results file (or manifest file), and report any that have changed or are missing.",
+		Args: cobra.MinimumNArgs(1),
+		RunE: verifyProvenance,
+	}
+	provenanceCmd.AddCommand(verifyCmd)
+}
+
+// verifyProvenance verifies the provenance manifests of results files.
+func verifyProvenance(cmd *cobra.Command, args []string) error {
+	var failed int
+	for _, path := range args {
+		sidecar := provenance.SidecarPath(path)
+		m, err := provenance.Read(sidecar)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s: %s (%s, git %s)\n", sidecar, m.Start.Format("2006-01-02 15:04:05"), m.End.Sub(m.Start).Round(time.Millisecond), gitLabel(m))
+		for _, c := range m.Verify(sidecar, data.Hallmarks) {
+			if c.Status != provenance.StatusOK {
+				failed++
+			}
+			fmt.Printf("  %-8s %-22s %s\n", c.Status, c.Role, c.Path)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d provenance checks failed", failed)
+	}
+	return nil
+}
+
+// gitLabel returns a short label of a manifest's git commit.
+func gitLabel(m *provenance.Manifest) string {
+	if m.GitCommit == "" {
+		return "unknown"
+	}
+	label := m.GitCommit
+	if len(label) > 12 {
+		label = label[:12]
+	}
+	if m.GitDirty {
+		label += " (modified)"
+	}
+	return label
+}
+
+// newProvenance returns a provenance manifest for the command line, recording
+// the input, essay prompts, prompt template and partial, and exemplar files
+// specified by the command flags, and the hallmarks of the essay type.
+func newProvenance(cmd *cobra.Command, essayType string) (*provenance.Manifest, error) {
+	m := provenance.New(os.Args)
+	for role, flag := range map[string]string{
+		"input":         "input",
+		"essay_prompts": "essay-prompts",
+		"exemplars":     "exemplars",
+	} {
+		if cmd.Flags().Lookup(flag) == nil {
+			continue
+		}
+		path, _ := cmd.Flags().GetString(flag)
+		if err := m.AddFile(role, path); err != nil {
+			return nil, err
+		}
+	}
+	if cmd.Flags().Lookup("prompt") != nil {
+		path, _ := cmd.Flags().GetString("prompt")
+		if err := addTemplateFiles(m, path); err != nil {
+			return nil, err
+		}
+	}
+	m.AddHallmarks(essayType, data.Hallmarks[essayType])
+	return m, nil
+}
+
+// addTemplateFiles records a prompt template file, and each partial file it
+// loads with the role "partial:<path>". An empty path is ignored.
+func addTemplateFiles(m *provenance.Manifest, path string) error {
+	if path == "" {
+		return nil
+	}
+	t, err := data.LoadPromptTemplate(path)
+	if err != nil {
+		return err
+	}
+	if err := m.AddFile("template", path); err != nil {
+		return err
+	}
+	for _, file := range t.PartialFiles {
+		if err := m.AddFile("partial:"+file, file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bufio"
+	"content-coding-gpt/pkg/openai"
+	"content-coding-gpt/pkg/provenance"
+	"content-coding-gpt/pkg/tuning"
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// initTunePruneCmd initializes the tune prune command.
+func initTunePruneCmd(tuneCmd *cobra.Command) {
+	pruneCmd := &cobra.Command{
+		Use:   "prune",
+		Short: "Delete fine-tuned models matching a cleanup policy",
+		Long: "Delete the fine-tuned models that match every filter given: --older-than, a --suffix " +
+			"glob pattern on the model name suffix, --status, and --unreferenced (not recorded in " +
+			"any provenance manifest, pipeline.json, or sweep.json under --manifests, which must " +
+			"exist). The models are listed, and deleted after " +
+			"confirmation (or --yes); --dry-run only lists them.",
+		Args: cobra.NoArgs,
+		RunE: pruneTunes,
+	}
+	addPruneFlags(pruneCmd, "suffix", "Glob pattern of the model name suffix, e.g. 'angry-*'")
+	tuneCmd.AddCommand(pruneCmd)
+}
+
+// initFilePruneCmd initializes the file prune command.
+func initFilePruneCmd(fileCmd *cobra.Command) {
+	pruneCmd := &cobra.Command{
+		Use:   "prune",
+		Short: "Delete uploaded files matching a cleanup policy",
+		Long: "Delete the uploaded files that match every filter given: --older-than, a --name glob " +
+			"pattern, --status, and --unreferenced (not a file of a fine-tuned model recorded in any " +
+			"provenance manifest, pipeline.json, or sweep.json under --manifests, which must exist). " +
+			"The files of fine-tune jobs and batches in " +
+			"progress are never deleted. The files are listed, and deleted after confirmation (or --yes); " +
+			"--dry-run only lists them.",
+		Args: cobra.NoArgs,
+		RunE: pruneFiles,
+	}
+	addPruneFlags(pruneCmd, "name", "Glob pattern of the file name, e.g. '*_validation.jsonl'")
+	fileCmd.AddCommand(pruneCmd)
+}
+
+// addPruneFlags adds the cleanup policy flags to a command, with the named
+// pattern flag.
+func addPruneFlags(cmd *cobra.Command, patternFlag, patternUsage string) {
+	cmd.Flags().String("older-than", "", "Minimum age, e.g. 720h or 30d")
+	cmd.Flags().String(patternFlag, "", patternUsage)
+	cmd.Flags().StringSlice("status", nil, "Status(es), e.g. succeeded or error")
+	cmd.Flags().Bool("unreferenced", false, "Only those not referenced by a provenance manifest or pipeline or sweep state?")
+	cmd.Flags().StringSlice("manifests", []string{"data"}, "Directories searched for provenance manifests and pipeline and sweep state files")
+	cmd.Flags().Bool("dry-run", false, "List without deleting")
+	cmd.Flags().BoolP("yes", "y", false, "Delete without asking for confirmation")
+}
+
+// pruneFilter returns the cleanup policy specified by the flags. At least one
+// filter is required, so that nothing is deleted by accident.
+func pruneFilter(cmd *cobra.Command, patternFlag string) (tuning.PruneFilter, error) {
+	f := tuning.PruneFilter{Now: time.Now()}
+	olderThan, _ := cmd.Flags().GetString("older-than")
+	f.Pattern, _ = cmd.Flags().GetString(patternFlag)
+	f.Statuses, _ = cmd.Flags().GetStringSlice("status")
+	f.Unreferenced, _ = cmd.Flags().GetBool("unreferenced")
+	if olderThan == "" && f.Pattern == "" && len(f.Statuses) == 0 && !f.Unreferenced {
+		return f, fmt.Errorf("no filters; specify at least one of --older-than, --%s, --status, or --unreferenced", patternFlag)
+	}
+	if olderThan != "" {
+		var err error
+		if f.OlderThan, err = parseAge(olderThan); err != nil {
+			return f, err
+		}
+	}
+	if err := f.Validate(); err != nil {
+		return f, err
+	}
+	if f.Unreferenced {
+		manifests, _ := cmd.Flags().GetStringSlice("manifests")
+		var err error
+		if f.Referenced, err = provenance.ReferencedModels(manifests...); err != nil {
+			return f, err
+		}
+		stateModels, err := tuning.StateModels(manifests...)
+		if err != nil {
+			return f, err
+		}
+		for model := range stateModels {
+			f.Referenced[model] = true
+		}
+	}
+	return f, nil
+}
+
+// parseAge parses a duration, which may also be a number of days, e.g. "30d".
+func parseAge(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.ParseFloat(days, 64)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid age %s", s)
+		}
+		return time.Duration(n * float64(24*time.Hour)), nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid age %s", s)
+	}
+	return d, nil
+}
+
+// confirm asks a yes/no question on the terminal, returning true for yes.
+func confirm(question string) bool {
+	fmt.Printf("%s [y/N] ", question)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// pruneTunes deletes the fine-tuned models selected by the cleanup policy.
+func pruneTunes(cmd *cobra.Command, args []string) error {
+	ctx := context.Background()
+	dryRun, _ := cmd.Flags().GetBool("dry-run")
+	yes, _ := cmd.Flags().GetBool("yes")
+	f, err := pruneFilter(cmd, "suffix")
+	if err != nil {
+		return err
+	}
+	tunes, err := apiClient.ListFineTunes(ctx, openai.ListOptions{})
+	if err != nil {
+		return err
+	}
+	selected := f.Tunes(tunes)
+	for _, t := range selected {
+		fmt.Println(t.ID, t.FineTunedModel, t.Status, time.Unix(t.CreatedAt, 0).Format(time.DateOnly))
+	}
+	fmt.Printf("%d of %d fine-tuned models selected\n", len(selected), len(tunes))
+	if dryRun || len(selected) == 0 || (!yes && !confirm(fmt.Sprintf("Delete %d fine-tuned models?", len(selected)))) {
+		return nil
+	}
+	var failed int
+	for _, t := range selected {
+		if err := apiClient.DeleteFineTune(ctx, t.FineTunedModel); err != nil {
+			fmt.Println(err)
+			failed++
+			continue
+		}
+		fmt.Printf("deleted %s\n", t.FineTunedModel)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d fine-tuned models not deleted", failed, len(selected))
+	}
+	return nil
+}
+
+// pruneFiles deletes the uploaded files selected by the cleanup policy.
+func pruneFiles(cmd *cobra.Command, args []string) error {
+	ctx := context.Background()
+	dryRun, _ := cmd.Flags().GetBool("dry-run")
+	yes, _ := cmd.Flags().GetBool("yes")
+	f, err := pruneFilter(cmd, "name")
+	if err != nil {
+		return err
+	}
+	files, err := apiClient.ListFiles(ctx, openai.ListOptions{})
+	if err != nil {
+		return err
+	}
+	tunes, err := apiClient.ListFineTunes(ctx, openai.ListOptions{})
+	if err != nil {
+		return err
+	}
+	batches, err := apiClient.IterateBatches(ctx, openai.ListOptions{}).All()
+	if err != nil {
+		return err
+	}
+	selected := f.Files(files, tunes, batches)
+	for _, file := range selected {
+		fmt.Println(file.ID, file.FileName, file.Purpose, file.Status, time.Unix(file.CreatedAt, 0).Format(time.DateOnly))
+	}
+	fmt.Printf("%d of %d files selected\n", len(selected), len(files))
+	if dryRun || len(selected) == 0 || (!yes && !confirm(fmt.Sprintf("Delete %d files?", len(selected)))) {
+		return nil
+	}
+	var failed int
+	for _, file := range selected {
+		if err := apiClient.DeleteFile(ctx, file.ID); err != nil {
+			fmt.Println(err)
+			failed++
+			continue
+		}
+		fmt.Printf("deleted %s\n", file.ID)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d files not deleted", failed, len(selected))
+	}
+	return nil
+}
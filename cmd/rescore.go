@@ -0,0 +1,164 @@
+package main
+
+import (
+	"content-coding-gpt/pkg/data"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// initRescoreCmd initializes the rescore command.
+func initRescoreCmd(root *cobra.Command) {
+	rescoreCmd := &cobra.Command{
+		Use:   "rescore <resultsCsv>",
+		Short: "Re-score results with a different score extractor",
+		Long: "Re-apply a score extractor to the comments column of a chat results CSV or JSONL file, " +
+			"without calling the API. The score column is replaced (empty if no valid score is found), " +
+			"the previous scores are kept in a previous_score column (replacing any from an earlier " +
+			"rescore), and the number of changed and unparseable rows is reported. Sampled results " +
+			"(--samples) are re-scored from the raw response of every sample in the sample_comments " +
+			"column, and their sampling columns recomputed. For ensemble results, select a model's " +
+			"columns with --score-column and --comments-column, e.g. score_gpt_4 and comments_gpt_4.",
+		Args: cobra.ExactArgs(1),
+		RunE: rescore,
+	}
+	rescoreCmd.Flags().StringP("extractor", "x", "", "Score extractor: first, last, label[:Label], regex:Pattern, json[:field], or likert (default first)")
+	rescoreCmd.Flags().String("score-range", fmt.Sprintf("%g,%g", data.DefaultScoreRange.Min, data.DefaultScoreRange.Max), "Allowed score range, e.g. 0,5")
+	rescoreCmd.Flags().String("score-column", "score", "Score column to replace")
+	rescoreCmd.Flags().String("comments-column", "comments", "Comments column to extract the scores from")
+	rescoreCmd.Flags().Float64("review-sd", 0.2, "Flag sampled essays for review when the sample standard deviation exceeds this")
+	rescoreCmd.Flags().StringP("output", "o", "", "Output results file (default: <resultsCsv>_rescored, with its extension)")
+	addFormatFlag(rescoreCmd)
+	root.AddCommand(rescoreCmd)
+}
+
+// rescore re-applies a score extractor to the comments of a results file.
+func rescore(cmd *cobra.Command, args []string) error {
+	spec, _ := cmd.Flags().GetString("extractor")
+	scoreRange, _ := cmd.Flags().GetString("score-range")
+	scoreColumn, _ := cmd.Flags().GetString("score-column")
+	commentsColumn, _ := cmd.Flags().GetString("comments-column")
+	reviewSD, _ := cmd.Flags().GetFloat64("review-sd")
+	output, _ := cmd.Flags().GetString("output")
+	resultsFile := args[0]
+	if output == "" {
+		ext := filepath.Ext(resultsFile)
+		output = strings.TrimSuffix(resultsFile, ext) + "_rescored" + ext
+	}
+	format, err := resultsFormat(cmd, output)
+	if err != nil {
+		return err
+	}
+
+	// Configure the extractor:
+	r, err := data.ParseScoreRange(scoreRange)
+	if err != nil {
+		return err
+	}
+	extractor, err := data.NewScoreExtractor(spec, r)
+	if err != nil {
+		return err
+	}
+
+	// Read the results:
+	table, err := data.ReadTableFile(resultsFile)
+	if err != nil {
+		return err
+	}
+	scoreIndex := table.ColumnIndex(scoreColumn)
+	if scoreIndex < 0 {
+		return fmt.Errorf("%s: no %s column", resultsFile, scoreColumn)
+	}
+	commentsIndex := table.ColumnIndex(commentsColumn)
+	if commentsIndex < 0 {
+		return fmt.Errorf("%s: no %s column", resultsFile, commentsColumn)
+	}
+
+	// Sampled results have sampling columns with the suffix of the score
+	// column, e.g. n_samples_gpt_4 for score_gpt_4:
+	suffix := strings.TrimPrefix(scoreColumn, "score")
+	sampleIndexes := make([]int, len(data.SampleCSVHeader))
+	for i, column := range data.SampleCSVHeader {
+		sampleIndexes[i] = table.ColumnIndex(column + suffix)
+	}
+	sampled := sampleIndexes[0] >= 0
+	if sampled {
+		for i, index := range sampleIndexes {
+			if index < 0 {
+				return fmt.Errorf("%s: sampled scores without a %s column can't be re-scored; "+
+					"use runs export --extractor on a stored run instead", resultsFile, data.SampleCSVHeader[i]+suffix)
+			}
+		}
+	}
+	sampling := data.Sampling{ReviewSD: reviewSD}
+
+	// Re-score the comments, keeping the previous scores in the
+	// previous_score column, added if needed:
+	header := append([]string{}, table.Header...)
+	previousIndex := table.ColumnIndex("previous_score")
+	if previousIndex < 0 {
+		previousIndex = len(header)
+		header = append(header, "previous_score")
+	}
+	var changed, unchanged, notFound, outOfRange, lost int
+	rows := make([][]string, 0, len(table.Rows))
+	for _, row := range table.Rows {
+		fields := make([]string, len(header))
+		copy(fields, row)
+		previous := strings.TrimSpace(fields[scoreIndex])
+		fields[previousIndex] = previous
+		var score float32
+		if sampled {
+			var s data.EssayScore
+			s.SampleComments, err = data.ParseSampleComments(fields[sampleIndexes[len(sampleIndexes)-1]])
+			if err != nil {
+				return fmt.Errorf("%s: %w", resultsFile, err)
+			}
+			sampling.N = len(s.SampleComments)
+			err = sampling.ScoreSamples(&s, extractor)
+			for i, field := range s.SampleCSVFields() {
+				fields[sampleIndexes[i]] = field
+			}
+			score = s.Score
+		} else {
+			score, err = extractor.Extract(fields[commentsIndex])
+		}
+		if err != nil {
+			if errors.Is(err, data.ErrScoreOutOfRange) {
+				outOfRange++
+			} else {
+				notFound++
+			}
+			if previous != "" {
+				lost++
+			}
+			fields[scoreIndex] = ""
+			rows = append(rows, fields)
+			continue
+		}
+		fields[scoreIndex] = strconv.FormatFloat(float64(score), 'f', 2, 32)
+		if p, err := strconv.ParseFloat(previous, 32); err == nil && strconv.FormatFloat(p, 'f', 2, 32) == fields[scoreIndex] {
+			unchanged++
+		} else {
+			changed++
+		}
+		rows = append(rows, fields)
+	}
+	out := data.Results{Table: data.Table{Header: header, Rows: rows}}
+	if err := data.WriteResults(output, format, out); err != nil {
+		return err
+	}
+
+	// Report the changes:
+	fmt.Printf("rescored %d rows with %s %s: %d changed, %d unchanged, %d unparseable (%d not found, %d out of range)\n",
+		len(table.Rows), extractor, r, changed, unchanged, notFound+outOfRange, notFound, outOfRange)
+	if lost > 0 {
+		fmt.Printf("%d previously scored rows are now unparseable\n", lost)
+	}
+	fmt.Printf("wrote %s\n", output)
+	return nil
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"content-coding-gpt/pkg/data"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// addFormatFlag adds the results format flag to a command.
+func addFormatFlag(cmd *cobra.Command) {
+	cmd.Flags().StringP("format", "F", "", "Results format: "+strings.Join(data.ResultsFormats(), ", ")+
+		" (default: from the file extension, .jsonl, .parquet, or .dta, else csv)")
+}
+
+// resultsFormat returns the results format of a results file, given by the
+// --format flag or implied by the file extension.
+func resultsFormat(cmd *cobra.Command, path string) (string, error) {
+	format, _ := cmd.Flags().GetString("format")
+	if format == "" {
+		return data.ResultsFormat(path), nil
+	}
+	format = strings.ToLower(format)
+	return format, data.ValidResultsFormat(format)
+}
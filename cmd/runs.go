@@ -0,0 +1,476 @@
+package main
+
+import (
+	"content-coding-gpt/pkg/data"
+	"content-coding-gpt/pkg/openai"
+	"content-coding-gpt/pkg/stats"
+	"content-coding-gpt/pkg/store"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// initRunsCmd initializes the runs commands.
+func initRunsCmd(root *cobra.Command) {
+	// Runs Command
+	runsCmd := &cobra.Command{
+		Use:   "runs",
+		Short: "Query the results store",
+		Long: "Query the runs recorded in the SQLite results store by chat batch, chat rubric, complete " +
+			"batch, and batch collect --store: the essays, raw requests and responses, extracted scores, " +
+			"errors, and timings.",
+	}
+	runsCmd.PersistentFlags().String("store", store.DefaultPath, "Results database file")
+	root.AddCommand(runsCmd)
+
+	// List Command
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List the recorded runs",
+		Long:  "List the recorded runs",
+		Args:  cobra.NoArgs,
+		RunE:  listRuns,
+	}
+	runsCmd.AddCommand(listCmd)
+
+	// Show Command
+	showCmd := &cobra.Command{
+		Use:   "show <runId>",
+		Short: "Show a recorded run",
+		Long:  "Show a recorded run: its command line, options, score summary, token usage, timings, and errors",
+		Args:  cobra.ExactArgs(1),
+		RunE:  showRun,
+	}
+	runsCmd.AddCommand(showCmd)
+
+	// Export Command
+	exportCmd := &cobra.Command{
+		Use:   "export <runId> <outputFile>",
+		Short: "Export a recorded run's scores",
+		Long: "Export a recorded run's scores to a results file, or its raw chats to a JSONL file (--chats). " +
+			"If --extractor or --score-range is specified, the scores of a chat batch or batch collect run " +
+			"are re-extracted from the stored responses without calling the API. The scores of chat rubric " +
+			"and complete batch runs are their standardized scores.",
+		Args: cobra.ExactArgs(2),
+		RunE: exportRun,
+	}
+	exportCmd.Flags().StringP("model", "m", "", "Model to export (default: the run's first model)")
+	exportCmd.Flags().StringP("extractor", "x", "", "Re-extract the scores with this extractor (default: the run's)")
+	exportCmd.Flags().String("score-range", "", "Re-extract the scores with this score range (default: the run's)")
+	exportCmd.Flags().Float64("review-sd", 0.2, "Flag re-extracted essays for review when the sample standard deviation exceeds this")
+	exportCmd.Flags().Bool("chats", false, "Export the raw chat requests and responses as JSONL?")
+	addFormatFlag(exportCmd)
+	runsCmd.AddCommand(exportCmd)
+}
+
+// addStoreFlag adds the --store flag, the results store file to record a run
+// in, if any.
+func addStoreFlag(cmd *cobra.Command, usage string) {
+	cmd.Flags().String("store", "", usage+", e.g. "+store.DefaultPath)
+}
+
+// chatRun returns the run of a command that scores chats with an extractor,
+// for the results store.
+func chatRun(cmd *cobra.Command, command string, builder data.ChatBuilder, extractor data.ScoreExtractor,
+	sampling data.Sampling, essayType string, models []string, results string) store.Run {
+	run := store.Run{
+		Command:    command,
+		Args:       os.Args,
+		EssayType:  essayType,
+		Models:     models,
+		Extractor:  extractor.String(),
+		ScoreRange: scoreRangeSpec(cmd, builder),
+		Samples:    sampling.N,
+		SampleMode: sampling.Mode,
+		Results:    results,
+	}
+	run.Template, _ = cmd.Flags().GetString("prompt")
+	return run
+}
+
+// openRunStore opens the results store specified by the --store flag, if any,
+// and records a new run and its essays.
+func openRunStore(cmd *cobra.Command, run *store.Run, essays []data.EssayRecord) (*store.Store, error) {
+	path, _ := cmd.Flags().GetString("store")
+	if path == "" {
+		return nil, nil
+	}
+	db, err := store.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.CreateRun(run); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if err := db.AddEssays(run.ID, run.EssayType, essays); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// endRun records the end of a run in the results store, if any.
+func endRun(db *store.Store, run store.Run) error {
+	if db == nil {
+		return nil
+	}
+	if err := db.EndRun(run.ID); err != nil {
+		return err
+	}
+	fmt.Printf("recorded run %d in the results store\n", run.ID)
+	return nil
+}
+
+// storeBatch records a batch's requests, scores, and errors in the results store.
+func storeBatch(db *store.Store, id int64, requests []store.Request, scores map[string][]data.EssayScore, errs []store.Error) error {
+	if err := db.AddRequests(id, requests); err != nil {
+		return err
+	}
+	for model, s := range scores {
+		if err := db.AddScores(id, model, s); err != nil {
+			return err
+		}
+	}
+	return db.AddErrors(id, errs)
+}
+
+// openStore opens the results store specified by the --store flag.
+func openStore(cmd *cobra.Command) (*store.Store, error) {
+	path, _ := cmd.Flags().GetString("store")
+	if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("results store %s: %w", path, err)
+	}
+	return store.Open(path)
+}
+
+// readRun opens the results store, and reads the specified run.
+func readRun(cmd *cobra.Command, arg string) (*store.Store, store.Run, error) {
+	id, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil {
+		return nil, store.Run{}, fmt.Errorf("invalid run ID %s", arg)
+	}
+	db, err := openStore(cmd)
+	if err != nil {
+		return nil, store.Run{}, err
+	}
+	run, err := db.Run(id)
+	if err != nil {
+		db.Close()
+		return nil, run, err
+	}
+	return db, run, nil
+}
+
+// listRuns lists the recorded runs.
+func listRuns(cmd *cobra.Command, args []string) error {
+	db, err := openStore(cmd)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	runs, err := db.Runs()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%-5s %-19s %-14s %-10s %-30s %6s %6s %6s %9s %s\n",
+		"id", "started", "command", "essay", "models", "essays", "scores", "errors", "tokens", "results")
+	for _, r := range runs {
+		fmt.Printf("%-5d %-19s %-14s %-10s %-30s %6d %6d %6d %9d %s\n", r.ID, r.Started.Local().Format("2006-01-02 15:04:05"),
+			r.Command, r.EssayType, strings.Join(r.Models, ","), r.Essays, r.Scores, r.Errors, r.Usage.TotalTokens, r.Results)
+	}
+	return nil
+}
+
+// showRun shows a recorded run.
+func showRun(cmd *cobra.Command, args []string) error {
+	db, run, err := readRun(cmd, args[0])
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	// Run description:
+	fmt.Printf("run %d: %s\n", run.ID, strings.Join(run.Args, " "))
+	fmt.Printf("  command:    %s\n", run.Command)
+	fmt.Printf("  started:    %s\n", run.Started.Local().Format("2006-01-02 15:04:05"))
+	if !run.Ended.IsZero() {
+		fmt.Printf("  ended:      %s (%s)\n", run.Ended.Local().Format("2006-01-02 15:04:05"), run.Ended.Sub(run.Started))
+	}
+	fmt.Printf("  essay type: %s\n", run.EssayType)
+	fmt.Printf("  models:     %s\n", strings.Join(run.Models, ", "))
+	if run.Template != "" {
+		fmt.Printf("  template:   %s\n", run.Template)
+	}
+	if run.Extractor != "" {
+		fmt.Printf("  extractor:  %s %s\n", run.Extractor, run.ScoreRange)
+	}
+	if run.Samples > 1 {
+		fmt.Printf("  samples:    %d (%s)\n", run.Samples, run.SampleMode)
+	}
+	fmt.Printf("  results:    %s\n", run.Results)
+	fmt.Printf("  essays:     %d (%d requests, %d errors)\n", run.Essays, run.Requests, run.Errors)
+	fmt.Printf("  usage:      %s\n", run.Usage)
+
+	// Response models and fingerprints:
+	requests, err := db.Requests(run.ID, "")
+	if err != nil {
+		return err
+	}
+	responseModels := map[string]int{}
+	for _, r := range requests {
+		if r.Chat.ErrMsg != "" {
+			continue
+		}
+		if r.Completion != nil {
+			responseModels[r.Completion.Response.Model]++
+			continue
+		}
+		label := r.Chat.Response.Model
+		if r.Chat.Response.SystemFingerprint != "" {
+			label += " " + r.Chat.Response.SystemFingerprint
+		}
+		responseModels[label]++
+	}
+	for _, label := range sortedKeys(responseModels) {
+		fmt.Printf("  response:   %s (%d)\n", label, responseModels[label])
+	}
+
+	// Request timings:
+	millis, err := db.Millis(run.ID)
+	if err != nil {
+		return err
+	}
+	if len(millis) > 0 {
+		fmt.Printf("  timings:    median %.0fms, p95 %.0fms, max %.0fms\n",
+			stats.Median(millis), stats.Quantile(millis, 0.95), stats.Quantile(millis, 1))
+	}
+
+	// Score summary per model:
+	for _, model := range run.Models {
+		scores, err := db.Scores(run.ID, model)
+		if err != nil {
+			return err
+		}
+		x := make([]float64, len(scores))
+		for i, s := range scores {
+			x[i] = float64(s.Score)
+		}
+		fmt.Printf("  %s: %d scores, mean %s, sd %s\n", model, len(x), formatStat(stats.Mean(x)), formatStat(stats.SD(x)))
+	}
+
+	// Errors by stage, with a few examples:
+	errs, err := db.Errors(run.ID)
+	if err != nil {
+		return err
+	}
+	stages := map[string]int{}
+	for _, e := range errs {
+		stages[e.Stage]++
+	}
+	for _, stage := range sortedKeys(stages) {
+		fmt.Printf("  %s errors: %d\n", stage, stages[stage])
+	}
+	for i, e := range errs {
+		if i == 5 {
+			fmt.Printf("    ... %d more\n", len(errs)-i)
+			break
+		}
+		fmt.Printf("    pid %d: %s: %s\n", e.PID, e.Model, strings.TrimSpace(e.Message))
+	}
+	return nil
+}
+
+// exportRun exports a recorded run's scores, re-extracting them if requested,
+// or its raw chats.
+func exportRun(cmd *cobra.Command, args []string) error {
+	model, _ := cmd.Flags().GetString("model")
+	spec, _ := cmd.Flags().GetString("extractor")
+	scoreRange, _ := cmd.Flags().GetString("score-range")
+	reviewSD, _ := cmd.Flags().GetFloat64("review-sd")
+	exportChats, _ := cmd.Flags().GetBool("chats")
+	outputFile := args[1]
+	format, err := resultsFormat(cmd, outputFile)
+	if err != nil {
+		return err
+	}
+	db, run, err := readRun(cmd, args[0])
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if model == "" && len(run.Models) > 0 {
+		model = run.Models[0]
+	}
+
+	// Raw chats:
+	if exportChats {
+		requests, err := db.Requests(run.ID, model)
+		if err != nil {
+			return err
+		}
+		var b strings.Builder
+		for _, r := range requests {
+			var chat interface{} = r.Chat
+			if r.Completion != nil {
+				chat = requestExchange(r)
+			}
+			j, err := json.Marshal(chat)
+			if err != nil {
+				return fmt.Errorf("error marshalling JSON chat: %w", err)
+			}
+			b.Write(j)
+			b.WriteByte('\n')
+		}
+		if err := os.WriteFile(outputFile, []byte(b.String()), 0644); err != nil {
+			return fmt.Errorf("error writing %s: %w", outputFile, err)
+		}
+		fmt.Printf("exported %d chats to %s\n", len(requests), outputFile)
+		return nil
+	}
+
+	// Stored scores:
+	essays, err := db.Essays(run.ID)
+	if err != nil {
+		return err
+	}
+	extraColumns := essayExtraColumns(essays)
+	if !cmd.Flags().Changed("extractor") && !cmd.Flags().Changed("score-range") {
+		scores, err := db.Scores(run.ID, model)
+		if err != nil {
+			return err
+		}
+		out := data.EssayScoreResults(scores, extraColumns...)
+		if format == data.JSONLFormat {
+			requests, err := db.Requests(run.ID, model)
+			if err != nil {
+				return err
+			}
+			out.Exchanges = scoreExchanges(requests, scores)
+		}
+		if err := data.WriteResults(outputFile, format, out); err != nil {
+			return err
+		}
+		fmt.Printf("exported %d scores to %s\n", len(scores), outputFile)
+		return nil
+	}
+
+	// Re-extract the scores from the stored responses:
+	if run.Command != store.ChatBatchCommand && run.Command != store.BatchCollectCommand {
+		return fmt.Errorf("run %d: the scores of a %s run can't be re-extracted", run.ID, run.Command)
+	}
+	if !cmd.Flags().Changed("extractor") {
+		spec = run.Extractor
+	}
+	if !cmd.Flags().Changed("score-range") {
+		scoreRange = run.ScoreRange
+	}
+	r, err := data.ParseScoreRange(scoreRange)
+	if err != nil {
+		return err
+	}
+	extractor, err := data.NewScoreExtractor(spec, r)
+	if err != nil {
+		return err
+	}
+	requests, err := db.Requests(run.ID, model)
+	if err != nil {
+		return err
+	}
+	responses := map[int][]openai.Chat{}
+	for _, request := range requests {
+		if request.Chat.ErrMsg == "" {
+			responses[request.PID] = append(responses[request.PID], request.Chat)
+		}
+	}
+	sampling := data.Sampling{N: run.Samples, Mode: run.SampleMode, ReviewSD: reviewSD}
+	var notFound, outOfRange int
+	scores := make([]data.EssayScore, 0, len(essays))
+	for _, essay := range essays {
+		chats := responses[essay.ID]
+		if len(chats) == 0 {
+			continue
+		}
+		var score data.EssayScore
+		if sampling.N > 1 {
+			samples := make([]openai.ChatResponse, len(chats))
+			for i, chat := range chats {
+				samples[i] = chat.Response
+			}
+			score, err = data.NewSampledEssayScore(essay, run.EssayType, samples, extractor, chats[0].Millis, sampling)
+		} else {
+			score, err = data.NewEssayScore(essay, run.EssayType, chats[0].Response, extractor, chats[0].Millis)
+		}
+		if err != nil {
+			if errors.Is(err, data.ErrScoreOutOfRange) {
+				outOfRange++
+			} else {
+				notFound++
+			}
+			continue
+		}
+		scores = append(scores, score)
+	}
+	out := data.EssayScoreResults(scores, extraColumns...)
+	if format == data.JSONLFormat {
+		out.Exchanges = scoreExchanges(requests, scores)
+	}
+	if err := data.WriteResults(outputFile, format, out); err != nil {
+		return err
+	}
+	fmt.Printf("re-extracted %d scores with %s %s to %s (%d not found, %d out of range)\n",
+		len(scores), extractor, r, outputFile, notFound, outOfRange)
+	return nil
+}
+
+// scoreExchanges returns the exchanges of each score from the stored requests.
+func scoreExchanges(requests []store.Request, scores []data.EssayScore) [][]data.Exchange {
+	exchanges := map[int][]data.Exchange{}
+	for _, r := range requests {
+		exchanges[r.PID] = append(exchanges[r.PID], requestExchange(r))
+	}
+	scoreExchanges := make([][]data.Exchange, len(scores))
+	for i, score := range scores {
+		scoreExchanges[i] = exchanges[score.ID]
+	}
+	return scoreExchanges
+}
+
+// requestExchange returns the exchange of a stored chat or completion request.
+func requestExchange(r store.Request) data.Exchange {
+	if r.Completion == nil {
+		return data.ChatExchanges(r.Chat)[0]
+	}
+	e := data.Exchange{ID: r.Chat.ID, Request: r.Completion.Request, Error: r.Chat.ErrMsg, Millis: r.Chat.Millis}
+	if r.Chat.ErrMsg == "" {
+		e.Response = r.Completion.Response
+	}
+	return e
+}
+
+// essayExtraColumns returns the sorted extra columns of the essays.
+func essayExtraColumns(essays []data.EssayRecord) []string {
+	columns := map[string]int{}
+	for _, essay := range essays {
+		for column := range essay.Extra {
+			columns[column]++
+		}
+	}
+	return sortedKeys(columns)
+}
+
+// sortedKeys returns the sorted keys of a map.
+func sortedKeys(m map[string]int) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
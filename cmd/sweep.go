@@ -0,0 +1,245 @@
+package main
+
+import (
+	"content-coding-gpt/pkg/data"
+	"content-coding-gpt/pkg/openai"
+	"content-coding-gpt/pkg/provenance"
+	"content-coding-gpt/pkg/stats"
+	"content-coding-gpt/pkg/store"
+	"content-coding-gpt/pkg/tuning"
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// initTuneSweepCmd initializes the tune sweep command.
+func initTuneSweepCmd(tuneCmd *cobra.Command) {
+	sweepCmd := &cobra.Command{
+		Use:   "sweep <spec.yaml>",
+		Short: "Run a hyperparameter sweep of fine-tune jobs",
+		Long: "Launch a fine-tune job for every combination of base model, epochs, and learning rate " +
+			"multiplier in a sweep spec file, on the same uploaded files, with at most max_concurrent " +
+			"jobs in progress. Each fine-tuned model is evaluated on the held-out test file as it " +
+			"finishes, and the jobs are ranked by agreement with the human coders. The jobs are " +
+			"tracked in <output>/<name>/sweep.json, so running the command again resumes the sweep.",
+		Args: cobra.ExactArgs(1),
+		RunE: tuneSweep,
+	}
+	sweepCmd.Flags().Duration("interval", time.Minute, "Polling interval of the jobs in progress")
+	sweepCmd.Flags().Int("max-concurrent", 0, "Maximum number of jobs in progress (default: the spec's max_concurrent)")
+	sweepCmd.Flags().Bool("dry-run", false, "List the jobs and their state without submitting anything")
+	tuneCmd.AddCommand(sweepCmd)
+}
+
+// tuneSweep runs, or resumes, a hyperparameter sweep.
+func tuneSweep(cmd *cobra.Command, args []string) error {
+	ctx := context.Background()
+	interval, _ := cmd.Flags().GetDuration("interval")
+	maxConcurrent, _ := cmd.Flags().GetInt("max-concurrent")
+	dryRun, _ := cmd.Flags().GetBool("dry-run")
+	if interval <= 0 {
+		return fmt.Errorf("interval must be positive")
+	}
+
+	// Read the spec and state, retrying jobs that could not be created or
+	// evaluated:
+	s, err := tuning.ReadSweep(args[0])
+	if err != nil {
+		return err
+	}
+	if maxConcurrent > 0 {
+		s.MaxConcurrent = maxConcurrent
+	}
+	state, err := tuning.ReadSweepState(s)
+	if err != nil {
+		return err
+	}
+	for _, j := range state.Jobs {
+		switch {
+		case j.TuneID == "":
+			j.Status, j.Error = "", ""
+		case j.Status == openai.FineTuneSucceeded && j.Error != "":
+			j.Results, j.Scored, j.Agreement, j.Error = "", 0, nil, ""
+		}
+	}
+	if dryRun {
+		printSweepJobs(s, state)
+		return nil
+	}
+
+	// Check the models and test set before starting any jobs:
+	for _, model := range s.Models {
+		if !apiClient.ValidModel(ctx, model) {
+			return fmt.Errorf("invalid base model: %s", model)
+		}
+	}
+	essays, err := data.ReadTrainingEssays(s.Test, s.EssayType)
+	if err != nil {
+		return err
+	}
+	human, humanHeader, err := readScoreTable(s.Test)
+	if err != nil {
+		return err
+	}
+	if err := state.Save(s); err != nil {
+		return err
+	}
+
+	// Start jobs up to the cap, poll the active jobs, and evaluate the
+	// succeeded jobs, until every job has finished, giving up after 5
+	// consecutive failures to read a job, as tune watch does:
+	failures := make(map[string]int, len(state.Jobs))
+	for {
+		var active int
+		for _, j := range state.Jobs {
+			if j.Active() {
+				active++
+			}
+		}
+		for _, j := range state.Jobs {
+			if j.TuneID != "" || j.Status != "" || active >= s.MaxConcurrent {
+				continue
+			}
+			tune, err := apiClient.CreateFineTune(ctx, s.JobRequest(j))
+			if err != nil {
+				j.Status, j.Error = openai.FineTuneFailed, err.Error()
+				fmt.Printf("%s: %s: create failed: %v\n", time.Now().Format(time.TimeOnly), j.ID, err)
+			} else {
+				j.TuneID, j.Status = tune.ID, tune.Status
+				active++
+				fmt.Printf("%s: %s: created fine-tune %s\n", time.Now().Format(time.TimeOnly), j.ID, tune.ID)
+			}
+			if err := state.Save(s); err != nil {
+				return err
+			}
+		}
+		for _, j := range state.Jobs {
+			if !j.Active() {
+				continue
+			}
+			tune, err := apiClient.ReadFineTune(ctx, j.TuneID)
+			if err != nil {
+				failures[j.ID]++
+				if failures[j.ID] >= 5 {
+					if err := state.Save(s); err != nil {
+						return err
+					}
+					return fmt.Errorf("sweep job %s: %w", j.ID, err)
+				}
+				fmt.Printf("%s: %s: %v (retrying)\n", time.Now().Format(time.TimeOnly), j.ID, err)
+				continue
+			}
+			failures[j.ID] = 0
+			if tune.Status != j.Status {
+				fmt.Printf("%s: %s: %s status: %s\n", time.Now().Format(time.TimeOnly), j.ID, tune.ID, tune.Status)
+			}
+			j.Status, j.FineTunedModel = tune.Status, tune.FineTunedModel
+		}
+		for _, j := range state.Jobs {
+			if j.Status != openai.FineTuneSucceeded || j.Finished() {
+				continue
+			}
+			if err := evaluateSweepJob(ctx, s, j, essays, human, humanHeader); err != nil {
+				j.Error = err.Error()
+				fmt.Printf("%s: %s: evaluation failed: %v\n", time.Now().Format(time.TimeOnly), j.ID, err)
+			}
+		}
+		if err := state.Save(s); err != nil {
+			return err
+		}
+		finished := true
+		for _, j := range state.Jobs {
+			if !j.Finished() {
+				finished = false
+			}
+		}
+		if finished {
+			break
+		}
+		time.Sleep(interval)
+	}
+
+	// Rank the jobs:
+	printSweepJobs(s, state)
+	return writeSweepSummary(s, state)
+}
+
+// evaluateSweepJob scores the test set with a job's fine-tuned model, and
+// compares the standardized scores with the human coders.
+func evaluateSweepJob(ctx context.Context, s tuning.Sweep, j *tuning.SweepJob, essays []data.EssayRecord,
+	human map[int]map[string]float64, humanHeader []string) error {
+	manifest := provenance.New(os.Args)
+	if err := manifest.AddFile("input", s.Test); err != nil {
+		return err
+	}
+	manifest.AddHallmarks(s.EssayType, data.Hallmarks[s.EssayType])
+	results := filepath.Join(s.Dir(), j.ID+".csv")
+	if err := completeEssays(ctx, essays, s.EssayType, j.FineTunedModel, s.MaxTokens, results, "", manifest, nil, store.Run{}); err != nil {
+		return err
+	}
+	o := stats.DefaultOptions
+	o.Resamples = s.Resamples
+	e, err := evaluateFile(s.Test, human, humanHeader, results, "standardized", "standardized", o)
+	if err != nil {
+		return err
+	}
+	j.Results, j.Scored, j.Agreement = results, e.Matched, &e.Composite
+	fmt.Printf("%s: %s: %s %s\n", time.Now().Format(time.TimeOnly), j.ID, s.RankBy, formatStat(j.Statistic(s.RankBy)))
+	return nil
+}
+
+// printSweepJobs prints the jobs of a sweep, ranked.
+func printSweepJobs(s tuning.Sweep, state *tuning.SweepState) {
+	fmt.Printf("%-4s %-36s %-10s %-36s %6s %8s %8s\n", "rank", "job", "status", "model", "scored", s.RankBy, "rmse")
+	for i, j := range state.Ranked(s.RankBy) {
+		status := j.Status
+		if status == "" {
+			status = "-"
+		}
+		rmse := math.NaN()
+		if j.Agreement != nil {
+			rmse = j.Agreement.RMSE.Value
+		}
+		fmt.Printf("%-4d %-36s %-10s %-36s %6d %8s %8s\n", i+1, j.ID, status, j.FineTunedModel, j.Scored,
+			formatStat(j.Statistic(s.RankBy)), formatStat(rmse))
+		if j.Error != "" {
+			fmt.Printf("     error: %s\n", j.Error)
+		}
+	}
+}
+
+// writeSweepSummary writes the ranked jobs to summary.csv in the sweep
+// directory.
+func writeSweepSummary(s tuning.Sweep, state *tuning.SweepState) error {
+	format := func(v float64) string {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return ""
+		}
+		return strconv.FormatFloat(v, 'f', 4, 64)
+	}
+	records := [][]string{{"rank", "job", "model", "epochs", "learning_rate", "tune_id", "fine_tuned_model", "status",
+		"scored", "pearson", "spearman", "icc21", "icc3k", "kappa", "alpha", "mae", "rmse"}}
+	for i, j := range state.Ranked(s.RankBy) {
+		record := []string{strconv.Itoa(i + 1), j.ID, j.Model, strconv.Itoa(j.Epochs),
+			strconv.FormatFloat(j.LearningRate, 'g', -1, 64), j.TuneID, j.FineTunedModel, j.Status, strconv.Itoa(j.Scored)}
+		if a := j.Agreement; a != nil {
+			record = append(record, format(a.Pearson.Value), format(a.Spearman.Value), format(a.ICC21.Value),
+				format(a.ICC3k.Value), format(a.Kappa.Value), format(a.Alpha.Value), format(a.MAE.Value), format(a.RMSE.Value))
+		} else {
+			record = append(record, "", "", "", "", "", "", "", "")
+		}
+		records = append(records, record)
+	}
+	path := filepath.Join(s.Dir(), "summary.csv")
+	if err := data.WriteCSVFile(path, records); err != nil {
+		return err
+	}
+	fmt.Printf("wrote %s\n", path)
+	return nil
+}
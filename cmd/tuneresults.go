@@ -0,0 +1,164 @@
+package main
+
+import (
+	"content-coding-gpt/pkg/chart"
+	"content-coding-gpt/pkg/tuning"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// resultMetrics maps the --metric flag values to their training and
+// validation result columns.
+var resultMetrics = map[string][2]string{
+	"loss":              {tuning.TrainingLoss, tuning.ValidationLoss},
+	"sequence_accuracy": {tuning.TrainingSequenceAccuracy, tuning.ValidationSequenceAccuracy},
+	"token_accuracy":    {tuning.TrainingTokenAccuracy, tuning.ValidationTokenAccuracy},
+}
+
+// initTuneResultsCmd initializes the tune results command.
+func initTuneResultsCmd(tuneCmd *cobra.Command) {
+	resultsCmd := &cobra.Command{
+		Use:   "results <tuneID|resultsCsv>...",
+		Short: "Analyze the result metrics of fine-tune jobs",
+		Long: "Analyze the step metrics of one or more fine-tune jobs, specified by ID (their result " +
+			"files are downloaded to --output, unless already there) or by result CSV file. For each " +
+			"job, the final smoothed training and validation loss and accuracy are summarized, and " +
+			"overfitting is flagged when the smoothed validation loss rises more than --tolerance " +
+			"above its minimum while the training loss keeps falling. --chart text draws the " +
+			"--metric curves of all jobs in the terminal, and --chart svg writes them to an SVG file.",
+		Args: cobra.MinimumNArgs(1),
+		RunE: tuneResults,
+	}
+	resultsCmd.Flags().Float64("smoothing", 0.9, "Exponential smoothing weight of the curves (0: none)")
+	resultsCmd.Flags().Float64("tolerance", 0.05, "Relative rise of the validation loss above its minimum that flags overfitting")
+	resultsCmd.Flags().String("chart", "", "Chart the curves: text or svg")
+	resultsCmd.Flags().String("metric", "loss", "Metric to chart: loss, sequence_accuracy, or token_accuracy")
+	resultsCmd.Flags().StringP("output", "o", "data/training", "Directory of downloaded result files and SVG charts")
+	tuneCmd.AddCommand(resultsCmd)
+}
+
+// tuneResults summarizes and charts the results of fine-tune jobs.
+func tuneResults(cmd *cobra.Command, args []string) error {
+	ctx := context.Background()
+	smoothing, _ := cmd.Flags().GetFloat64("smoothing")
+	tolerance, _ := cmd.Flags().GetFloat64("tolerance")
+	chartType, _ := cmd.Flags().GetString("chart")
+	metric, _ := cmd.Flags().GetString("metric")
+	output, _ := cmd.Flags().GetString("output")
+	if smoothing < 0 || smoothing >= 1 {
+		return fmt.Errorf("smoothing must be at least 0 and less than 1")
+	}
+	if chartType != "" && chartType != "text" && chartType != "svg" {
+		return fmt.Errorf("unknown chart type %s; expected: text or svg", chartType)
+	}
+	columns, ok := resultMetrics[metric]
+	if !ok {
+		return fmt.Errorf("unknown metric %s; expected: loss, sequence_accuracy, or token_accuracy", metric)
+	}
+
+	// Read the results of each job:
+	var jobs []tuning.Results
+	for _, arg := range args {
+		results, err := readTuneResults(ctx, arg, output)
+		if err != nil {
+			return err
+		}
+		jobs = append(jobs, results)
+	}
+
+	// Summarize the jobs side by side:
+	fmt.Printf("%-30s %6s %9s %10s %10s %10s %10s %10s %10s %s\n", "job", "steps", "examples",
+		"train_loss", "train_seq", "train_tok", "valid_loss", "valid_seq", "valid_tok", "overfitting")
+	for _, results := range jobs {
+		s := tuning.Summarize(results, smoothing, tolerance)
+		overfitting := "-"
+		switch {
+		case s.Overfitting:
+			overfitting = fmt.Sprintf("yes, from step %d (loss %s)", s.BestStep, formatStat(s.BestValidationLoss))
+		case results.HasValidation():
+			overfitting = "no"
+		}
+		fmt.Printf("%-30s %6d %9d %10s %10s %10s %10s %10s %10s %s\n", s.Name, s.Steps, s.ElapsedExamples,
+			formatStat(s.TrainingLoss), formatStat(s.TrainingSequenceAccuracy), formatStat(s.TrainingTokenAccuracy),
+			formatStat(s.ValidationLoss), formatStat(s.ValidationSequenceAccuracy), formatStat(s.ValidationTokenAccuracy),
+			overfitting)
+	}
+	if chartType == "" {
+		return nil
+	}
+
+	// Chart the smoothed curves of the metric:
+	c := chart.Chart{Title: fmt.Sprintf("smoothed %s (%g)", metric, smoothing), XLabel: "step", YLabel: metric}
+	for _, results := range jobs {
+		for i, column := range columns {
+			steps, values := results.Metric(column)
+			if len(values) == 0 {
+				continue
+			}
+			name := results.Name
+			if i > 0 {
+				name += " (validation)"
+			}
+			c.Series = append(c.Series, chart.Series{Name: name, X: steps, Y: tuning.Smooth(values, smoothing)})
+		}
+	}
+	if chartType == "text" {
+		fmt.Println()
+		fmt.Print(c.Text(72, 16))
+		return nil
+	}
+	names := make([]string, len(jobs))
+	for i, results := range jobs {
+		names[i] = results.Name
+	}
+	path := filepath.Join(output, strings.Join(names, "_")+"_"+metric+".svg")
+	if err := os.MkdirAll(output, 0755); err != nil {
+		return fmt.Errorf("write chart %s: %w", path, err)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("write chart %s: %w", path, err)
+	}
+	defer f.Close()
+	if err := c.WriteSVG(f, 900, 500); err != nil {
+		return fmt.Errorf("write chart %s: %w", path, err)
+	}
+	fmt.Printf("wrote %s\n", path)
+	return nil
+}
+
+// readTuneResults reads the results of a fine-tune job from a result CSV file,
+// or by job ID, from its first result file in dir, downloading its result
+// files if they are not there.
+func readTuneResults(ctx context.Context, arg, dir string) (tuning.Results, error) {
+	if _, err := os.Stat(arg); err == nil {
+		results, err := tuning.ReadResultsFile(arg)
+		results.Name = strings.TrimSuffix(filepath.Base(arg), filepath.Ext(arg))
+		return results, err
+	}
+	tune, err := apiClient.ReadFineTune(ctx, arg)
+	if err != nil {
+		return tuning.Results{}, err
+	}
+	if len(tune.ResultFiles) == 0 {
+		return tuning.Results{}, fmt.Errorf("fine-tune %s has no result files (status %s)", tune.ID, tune.Status)
+	}
+	name := tune.ResultFiles[0].FileName
+	if name == "" {
+		name = tune.ResultFiles[0].ID + ".csv"
+	}
+	path := filepath.Join(dir, tune.ID+"_"+filepath.Base(name))
+	if _, err := os.Stat(path); err != nil {
+		if _, err := downloadResultFiles(ctx, tune, dir); err != nil {
+			return tuning.Results{}, err
+		}
+	}
+	results, err := tuning.ReadResultsFile(path)
+	results.Name = tune.ID
+	return results, err
+}
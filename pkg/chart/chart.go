@@ -0,0 +1,184 @@
+// Package chart renders simple line charts as SVG or terminal text.
+package chart
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Series is a named line of (X, Y) points.
+type Series struct {
+	Name string
+	X    []float64
+	Y    []float64
+}
+
+// Chart is a line chart of one or more series.
+type Chart struct {
+	Title  string
+	XLabel string
+	YLabel string
+	Series []Series
+}
+
+// colors are the SVG series colors, and markers the terminal series markers.
+var (
+	colors  = []string{"#1f77b4", "#ff7f0e", "#2ca02c", "#d62728", "#9467bd", "#8c564b", "#e377c2", "#7f7f7f"}
+	markers = []rune{'*', '+', 'o', 'x', '#', '@', '%', '='}
+)
+
+// bounds returns the range of the finite points of the series, widened if
+// empty.
+func (c Chart) bounds() (xMin, xMax, yMin, yMax float64) {
+	xMin, yMin = math.Inf(1), math.Inf(1)
+	xMax, yMax = math.Inf(-1), math.Inf(-1)
+	for _, s := range c.Series {
+		for i := range s.X {
+			x, y := s.X[i], s.Y[i]
+			if math.IsNaN(x) || math.IsNaN(y) || math.IsInf(y, 0) {
+				continue
+			}
+			xMin, xMax = math.Min(xMin, x), math.Max(xMax, x)
+			yMin, yMax = math.Min(yMin, y), math.Max(yMax, y)
+		}
+	}
+	if math.IsInf(xMin, 1) {
+		return 0, 1, 0, 1
+	}
+	if xMax == xMin {
+		xMax = xMin + 1
+	}
+	if yMax == yMin {
+		yMin, yMax = yMin-0.5, yMax+0.5
+	}
+	return xMin, xMax, yMin, yMax
+}
+
+// WriteSVG writes the chart as an SVG image of the given size in pixels.
+func (c Chart) WriteSVG(w io.Writer, width, height int) error {
+	const left, right, top = 70, 20, 40
+	bottom := 50 + 16*((len(c.Series)+2)/3)
+	plotWidth, plotHeight := float64(width-left-right), float64(height-top-bottom)
+	xMin, xMax, yMin, yMax := c.bounds()
+	px := func(x float64) float64 { return left + (x-xMin)/(xMax-xMin)*plotWidth }
+	py := func(y float64) float64 { return top + (yMax-y)/(yMax-yMin)*plotHeight }
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" font-family="sans-serif" font-size="12">`+"\n", width, height)
+	fmt.Fprintf(&b, `<rect width="%d" height="%d" fill="white"/>`+"\n", width, height)
+	fmt.Fprintf(&b, `<text x="%d" y="24" text-anchor="middle" font-size="16">%s</text>`+"\n", width/2, html.EscapeString(c.Title))
+
+	// Axes, grid lines, and tick labels:
+	fmt.Fprintf(&b, `<g stroke="#ccc">`+"\n")
+	for i := 0; i <= 5; i++ {
+		x := xMin + (xMax-xMin)*float64(i)/5
+		y := yMin + (yMax-yMin)*float64(i)/5
+		fmt.Fprintf(&b, `<line x1="%.1f" y1="%d" x2="%.1f" y2="%.1f"/>`+"\n", px(x), top, px(x), top+plotHeight)
+		fmt.Fprintf(&b, `<line x1="%d" y1="%.1f" x2="%.1f" y2="%.1f"/>`+"\n", left, py(y), left+plotWidth, py(y))
+	}
+	fmt.Fprintf(&b, "</g>\n")
+	for i := 0; i <= 5; i++ {
+		x := xMin + (xMax-xMin)*float64(i)/5
+		y := yMin + (yMax-yMin)*float64(i)/5
+		fmt.Fprintf(&b, `<text x="%.1f" y="%.1f" text-anchor="middle">%s</text>`+"\n", px(x), top+plotHeight+16, tick(x))
+		fmt.Fprintf(&b, `<text x="%d" y="%.1f" text-anchor="end">%s</text>`+"\n", left-6, py(y)+4, tick(y))
+	}
+	fmt.Fprintf(&b, `<rect x="%d" y="%d" width="%.1f" height="%.1f" fill="none" stroke="black"/>`+"\n", left, top, plotWidth, plotHeight)
+	fmt.Fprintf(&b, `<text x="%.1f" y="%.1f" text-anchor="middle">%s</text>`+"\n", left+plotWidth/2, top+plotHeight+34, html.EscapeString(c.XLabel))
+	fmt.Fprintf(&b, `<text transform="translate(16,%.1f) rotate(-90)" text-anchor="middle">%s</text>`+"\n", top+plotHeight/2, html.EscapeString(c.YLabel))
+
+	// Series lines and legend:
+	for i, s := range c.Series {
+		color := colors[i%len(colors)]
+		var points []string
+		for j := range s.X {
+			if math.IsNaN(s.X[j]) || math.IsNaN(s.Y[j]) || math.IsInf(s.Y[j], 0) {
+				continue
+			}
+			points = append(points, fmt.Sprintf("%.1f,%.1f", px(s.X[j]), py(s.Y[j])))
+		}
+		fmt.Fprintf(&b, `<polyline fill="none" stroke="%s" stroke-width="1.5" points="%s"/>`+"\n", color, strings.Join(points, " "))
+		lx, ly := left+(i%3)*int(plotWidth/3), int(top+plotHeight)+56+(i/3)*16
+		fmt.Fprintf(&b, `<line x1="%d" y1="%d" x2="%d" y2="%d" stroke="%s" stroke-width="3"/>`+"\n", lx, ly-4, lx+20, ly-4, color)
+		fmt.Fprintf(&b, `<text x="%d" y="%d">%s</text>`+"\n", lx+25, ly, html.EscapeString(s.Name))
+	}
+	fmt.Fprintf(&b, "</svg>\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// Text renders the chart as terminal text, with a plot area of the given
+// width and height in characters. Each series is drawn with its own marker,
+// at the mean of its points in each column.
+func (c Chart) Text(width, height int) string {
+	if width < 10 {
+		width = 10
+	}
+	if height < 3 {
+		height = 3
+	}
+	xMin, xMax, yMin, yMax := c.bounds()
+	grid := make([][]rune, height)
+	for i := range grid {
+		grid[i] = []rune(strings.Repeat(" ", width))
+	}
+	for i, s := range c.Series {
+		marker := markers[i%len(markers)]
+		sums, counts := make([]float64, width), make([]int, width)
+		for j := range s.X {
+			if math.IsNaN(s.X[j]) || math.IsNaN(s.Y[j]) || math.IsInf(s.Y[j], 0) {
+				continue
+			}
+			col := int(math.Round((s.X[j] - xMin) / (xMax - xMin) * float64(width-1)))
+			sums[col] += s.Y[j]
+			counts[col]++
+		}
+		for col := range sums {
+			if counts[col] == 0 {
+				continue
+			}
+			y := sums[col] / float64(counts[col])
+			row := int(math.Round((yMax - y) / (yMax - yMin) * float64(height-1)))
+			grid[row][col] = marker
+		}
+	}
+
+	var b strings.Builder
+	if c.Title != "" {
+		fmt.Fprintf(&b, "%s\n", c.Title)
+	}
+	for i, row := range grid {
+		label := ""
+		switch i {
+		case 0:
+			label = tick(yMax)
+		case height / 2:
+			label = tick((yMax + yMin) / 2)
+		case height - 1:
+			label = tick(yMin)
+		}
+		fmt.Fprintf(&b, "%9s |%s\n", label, string(row))
+	}
+	fmt.Fprintf(&b, "%9s +%s\n", "", strings.Repeat("-", width))
+	lo, hi := tick(xMin), tick(xMax)
+	gap := width - len(lo) - len(hi)
+	if gap < 1 {
+		gap = 1
+	}
+	fmt.Fprintf(&b, "%9s  %s%s%s  %s\n", "", lo, strings.Repeat(" ", gap), hi, c.XLabel)
+	var legend []string
+	for i, s := range c.Series {
+		legend = append(legend, fmt.Sprintf("%c %s", markers[i%len(markers)], s.Name))
+	}
+	fmt.Fprintf(&b, "%9s  %s\n", "", strings.Join(legend, "   "))
+	return b.String()
+}
+
+// tick formats an axis value compactly.
+func tick(v float64) string {
+	return strconv.FormatFloat(v, 'g', 4, 64)
+}
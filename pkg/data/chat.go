@@ -0,0 +1,74 @@
+package data
+
+import (
+	"content-coding-gpt/pkg/openai"
+	"fmt"
+	"strconv"
+)
+
+// ChatBuilder generates chat requests for essays, using either the built-in
+// hallmarks prompt or a PromptTemplate, optionally preceded by few-shot exemplars.
+type ChatBuilder struct {
+	Model       string
+	Temperature float32
+	MaxTokens   int
+	Template    *PromptTemplate // optional
+	FewShot     *FewShot        // optional
+}
+
+// ChatRequest generates the chat request for an essay of the specified type.
+func (b ChatBuilder) ChatRequest(r EssayRecord, essayType string) (openai.ChatRequest, error) {
+	request, err := b.chatRequest(r, essayType)
+	if err != nil || b.FewShot == nil {
+		return request, err
+	}
+
+	// Insert the exemplars, as user/assistant turns, after the system messages:
+	exemplars, err := b.FewShot.Select(r, essayType)
+	if err != nil {
+		return request, err
+	}
+	var shots []openai.Message
+	for _, e := range exemplars {
+		er := EssayRecord{ID: e.ID, Essays: map[string]string{canonicalEssayType(essayType): e.Essay}}
+		shot, err := b.chatRequest(er, essayType)
+		if err != nil {
+			return request, fmt.Errorf("few-shot exemplar %d: %w", e.ID, err)
+		}
+		for _, m := range shot.Messages {
+			if m.Role != openai.SYSTEM {
+				shots = append(shots, m)
+			}
+		}
+		shots = append(shots, b.FewShot.Answer(e))
+	}
+	i := 0
+	for i < len(request.Messages) && request.Messages[i].Role == openai.SYSTEM {
+		i++
+	}
+	messages := append([]openai.Message{}, request.Messages[:i]...)
+	messages = append(messages, shots...)
+	request.Messages = append(messages, request.Messages[i:]...)
+	return request, nil
+}
+
+// chatRequest generates the chat request for an essay, without exemplars.
+func (b ChatBuilder) chatRequest(r EssayRecord, essayType string) (openai.ChatRequest, error) {
+	if b.Template == nil {
+		if Hallmarks[essayType] == "" {
+			return openai.ChatRequest{}, fmt.Errorf("essay type %s has no hallmarks; specify a prompt template", essayType)
+		}
+		return r.ChatRequest(essayType, b.Model, b.Temperature, b.MaxTokens), nil
+	}
+	messages, err := b.Template.Messages(r, essayType)
+	if err != nil {
+		return openai.ChatRequest{}, err
+	}
+	return openai.ChatRequest{
+		Model:       b.Model,
+		Messages:    messages,
+		Temperature: b.Temperature,
+		MaxTokens:   b.MaxTokens,
+		User:        strconv.Itoa(r.ID),
+	}, nil
+}
@@ -100,5 +100,8 @@ func WriteCSVFile(path string, records [][]string) error {
 	if err != nil {
 		return fmt.Errorf("write csv file %s: %w", path, err)
 	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("write csv file %s: %w", path, err)
+	}
 	return nil
 }
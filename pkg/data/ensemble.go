@@ -0,0 +1,200 @@
+package data
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+// Ensemble combines the scores of several models into a weighted mean.
+type Ensemble struct {
+	Models  []string
+	Weights []float64 // per model; defaults to 1
+	Columns []string  // per model CSV column suffix, e.g. "gpt_4"; see ModelColumn
+}
+
+// NewEnsemble creates an Ensemble of models, weighted by the optional weights.
+// An error is returned if a weight names an unknown model or is not positive.
+func NewEnsemble(models []string, weights map[string]float64) (Ensemble, error) {
+	e := Ensemble{Models: models, Weights: make([]float64, len(models)), Columns: modelColumns(models)}
+	for i := range e.Weights {
+		e.Weights[i] = 1
+	}
+	for model, w := range weights {
+		i := e.ModelIndex(model)
+		if i < 0 {
+			return e, fmt.Errorf("ensemble: weight for unknown model %s", model)
+		}
+		if w <= 0 {
+			return e, fmt.Errorf("ensemble: weight for model %s must be positive", model)
+		}
+		e.Weights[i] = w
+	}
+	return e, nil
+}
+
+// ModelIndex returns the index of a model, or -1 if it is not in the ensemble.
+func (e Ensemble) ModelIndex(model string) int {
+	for i, m := range e.Models {
+		if m == model {
+			return i
+		}
+	}
+	return -1
+}
+
+// EnsembleScore holds every model's score for an essay, and their weighted mean.
+type EnsembleScore struct {
+	ID        int
+	EssayType string
+	Essay     string
+	Scores    []*EssayScore // per model; nil if the model failed to score the essay
+	Score     float64       // weighted mean of the available model scores
+	Extra     map[string]string
+}
+
+// Combine returns the EnsembleScore of an essay's per-model scores, and false if
+// no model scored the essay.
+func (e Ensemble) Combine(essay EssayRecord, essayType string, scores []*EssayScore) (EnsembleScore, bool) {
+	s := EnsembleScore{
+		ID:        essay.ID,
+		EssayType: essayType,
+		Essay:     essay.SelectEssay(essayType),
+		Scores:    scores,
+		Extra:     essay.Extra,
+	}
+	var sum, weights float64
+	for i, score := range scores {
+		if score != nil {
+			sum += e.Weights[i] * float64(score.Score)
+			weights += e.Weights[i]
+		}
+	}
+	if weights == 0 {
+		return s, false
+	}
+	s.Score = sum / weights
+	return s, true
+}
+
+// nonAlphanumeric matches the characters replaced in model column names.
+var nonAlphanumeric = regexp.MustCompile(`[^A-Za-z0-9]+`)
+
+// modelColumns returns the CSV column suffix of each model: the model name
+// with every run of other characters than letters and digits replaced by an
+// underscore, and numbered if that is already used, e.g. "gpt_4" and
+// "gpt_4_2" for gpt-4 and gpt.4.
+func modelColumns(models []string) []string {
+	columns := make([]string, len(models))
+	used := make(map[string]bool, len(models))
+	for i, model := range models {
+		name := nonAlphanumeric.ReplaceAllString(model, "_")
+		column := name
+		for n := 2; used[column]; n++ {
+			column = name + "_" + strconv.Itoa(n)
+		}
+		used[column] = true
+		columns[i] = column
+	}
+	return columns
+}
+
+// ModelColumn returns the CSV column name of the ith model, e.g.
+// "score_gpt_4".
+func (e Ensemble) ModelColumn(prefix string, i int) string {
+	return prefix + "_" + e.Columns[i]
+}
+
+// CSVHeader returns the header of a wide ensemble CSV file: pid, essay_type,
+// essay, a score column per model, ensemble, n_models, and a comments column
+// per model, followed, if sampled, by a column per model of each of the
+// SampleCSVHeader columns.
+func (e Ensemble) CSVHeader(sampled bool) []string {
+	header := []string{"pid", "essay_type", "essay"}
+	for i := range e.Models {
+		header = append(header, e.ModelColumn("score", i))
+	}
+	header = append(header, "ensemble", "n_models")
+	for i := range e.Models {
+		header = append(header, e.ModelColumn("comments", i))
+	}
+	if sampled {
+		for _, column := range SampleCSVHeader {
+			for i := range e.Models {
+				header = append(header, e.ModelColumn(column, i))
+			}
+		}
+	}
+	return header
+}
+
+// CSVFields returns the fields of an EnsembleScore, with the sampling fields
+// if sampled; missing scores are empty.
+func (s EnsembleScore) CSVFields(sampled bool) []string {
+	fields := []string{strconv.Itoa(s.ID), s.EssayType, s.Essay}
+	var n int
+	for _, score := range s.Scores {
+		if score == nil {
+			fields = append(fields, "")
+			continue
+		}
+		fields = append(fields, strconv.FormatFloat(float64(score.Score), 'f', 2, 32))
+		n++
+	}
+	fields = append(fields, strconv.FormatFloat(s.Score, 'f', 3, 64), strconv.Itoa(n))
+	for _, score := range s.Scores {
+		if score == nil {
+			fields = append(fields, "")
+		} else {
+			fields = append(fields, score.Comments)
+		}
+	}
+	if !sampled {
+		return fields
+	}
+	samples := make([][]string, len(s.Scores))
+	for i, score := range s.Scores {
+		if score != nil && len(score.Samples) > 0 {
+			samples[i] = score.SampleCSVFields()
+		}
+	}
+	for j := range SampleCSVHeader {
+		for i := range s.Scores {
+			if samples[i] == nil {
+				fields = append(fields, "")
+			} else {
+				fields = append(fields, samples[i][j])
+			}
+		}
+	}
+	return fields
+}
+
+// EnsembleResults returns the wide results of the ensemble scores. If any
+// model's score has samples, the sampling columns of each model are added.
+// The optional extra columns are appended to each row.
+func EnsembleResults(e Ensemble, scores []EnsembleScore, extraColumns ...string) Results {
+	var sampled bool
+	for _, s := range scores {
+		for _, score := range s.Scores {
+			sampled = sampled || (score != nil && len(score.Samples) > 0)
+		}
+	}
+	var r Results
+	r.Header = append(e.CSVHeader(sampled), extraColumns...)
+	r.Rows = make([][]string, len(scores))
+	for i, score := range scores {
+		fields := score.CSVFields(sampled)
+		for _, column := range extraColumns {
+			fields = append(fields, score.Extra[column])
+		}
+		r.Rows[i] = fields
+	}
+	return r
+}
+
+// WriteEnsembleScores writes the ensemble scores to a wide CSV file, with the
+// columns of EnsembleResults.
+func WriteEnsembleScores(path string, e Ensemble, scores []EnsembleScore, extraColumns ...string) error {
+	return WriteResults(path, CSVFormat, EnsembleResults(e, scores, extraColumns...))
+}
@@ -2,18 +2,12 @@ package data
 
 import (
 	"content-coding-gpt/pkg/openai"
-	"errors"
 	"fmt"
-	"io"
 	"math/rand"
-	"os"
 	"strconv"
 	"strings"
 )
 
-// templateCache is a cache of file paths to their corresponding templates.
-var templateCache = map[string]string{}
-
 // SystemMessage is the system message.
 var SystemMessage = openai.Message{
 	Role: openai.SYSTEM,
@@ -42,6 +36,35 @@ var EssayPrompts = map[string]string{
 	"dejavu":   DejavuEssayPrompt,
 }
 
+// EssayPromptsCSVHeader is the header of an essay prompts file, which adds
+// writing prompts for other essay types to EssayPrompts.
+var EssayPromptsCSVHeader = []string{"essay_type", "prompt"}
+
+// ReadEssayPrompts reads an essay prompts file (essay_type,prompt) and adds
+// its writing prompts to EssayPrompts.
+func ReadEssayPrompts(path string) error {
+	table, err := ReadTableFile(path)
+	if err != nil {
+		return fmt.Errorf("read essay prompts %s: %w", path, err)
+	}
+	typeIndex := table.ColumnIndex(EssayPromptsCSVHeader[0])
+	promptIndex := table.ColumnIndex(EssayPromptsCSVHeader[1])
+	if typeIndex < 0 || promptIndex < 0 {
+		return fmt.Errorf("read essay prompts %s: expected columns %s", path, strings.Join(EssayPromptsCSVHeader, ","))
+	}
+	for _, row := range table.Rows {
+		if typeIndex >= len(row) || promptIndex >= len(row) {
+			continue
+		}
+		essayType := EssayTypeName(row[typeIndex])
+		prompt := CleanResponse(row[promptIndex])
+		if essayType != "" && prompt != "" {
+			EssayPrompts[essayType] = prompt
+		}
+	}
+	return nil
+}
+
 // Hallmarks is a map of essay types to their corresponding hallmarks.
 var Hallmarks = map[string]string{
 	"conflict": HumilityHallmarks,
@@ -75,45 +98,49 @@ func IsHumility(essayType string) bool {
 	return essayType == "conflict" || essayType == "angry" || essayType == "award"
 }
 
+// canonicalEssayType returns the essay type used as an EssayRecord key.
+// Note that "conflict" and "angry" are equivalent.
+func canonicalEssayType(essayType string) string {
+	if essayType == "angry" {
+		return "conflict"
+	}
+	return essayType
+}
+
 // EssayRecord contains responses that need to be content-coded.
 type EssayRecord struct {
-	ID       int    `csv:"pid"`
-	Dream    string `csv:"dream"`
-	Dejavu   string `csv:"dejavu"`
-	Conflict string `csv:"conflict"`
-	Award    string `csv:"award"`
+	ID     int               `csv:"pid"`
+	Essays map[string]string `csv:"-"` // essay type -> response text
+	Extra  map[string]string `csv:"-"` // covariate column -> value
 }
 
-// SelectEssay returns the specified essay type from the EssayRecord.
-func (r EssayRecord) SelectEssay(essayType string) string {
-	switch essayType {
-	case "dream":
-		return r.Dream
-	case "dejavu":
-		return r.Dejavu
-	case "conflict", "angry":
-		return r.Conflict
-	case "award":
-		return r.Award
-	default:
-		return ""
+// NewEssayRecord creates an EssayRecord with a single essay of the specified type.
+func NewEssayRecord(id int, essayType string, essay string, extra map[string]string) EssayRecord {
+	return EssayRecord{
+		ID:     id,
+		Essays: map[string]string{canonicalEssayType(essayType): essay},
+		Extra:  extra,
 	}
 }
 
+// SelectEssay returns the specified essay type from the EssayRecord, or an
+// empty string if the participant did not write that essay.
+func (r EssayRecord) SelectEssay(essayType string) string {
+	return r.Essays[canonicalEssayType(essayType)]
+}
+
+// HasEssay returns true if the EssayRecord includes the specified essay type.
+func (r EssayRecord) HasEssay(essayType string) bool {
+	return r.SelectEssay(essayType) != ""
+}
+
 // PlainPrompt converts an EssayRecord to a plain prompt for the specified essay response.
 func (r EssayRecord) PlainPrompt(essayType string) string {
-	switch essayType {
-	case "dream":
-		return r.Dream + PromptSeparator
-	case "dejavu":
-		return r.Dejavu + PromptSeparator
-	case "conflict", "angry":
-		return r.Conflict + PromptSeparator
-	case "award":
-		return r.Award + PromptSeparator
-	default:
+	essay := r.SelectEssay(essayType)
+	if essay == "" {
 		return ""
 	}
+	return essay + PromptSeparator
 }
 
 // CompletionRequest converts an EssayRecord into an OpenAI CompletionRequest
@@ -156,91 +183,49 @@ func (r EssayRecord) ChatRequest(essayType string, model string, temperature flo
 	}
 }
 
-// ChatRequestTemplate converts an EssayRecord into an OpenAI ChatRequest using a
-// specified template file. Use this method to experiment with different prompts.
-// The templates are cached, so it's efficient to use with multiple records.
-//
-// Use the following optional template variables:
-// - {{prompt}}: the essay prompt text
-// - {{essay}}: the essay response text
-func (r EssayRecord) ChatRequestTemplate(essayType string, model string, temperature float32, maxTokens int, templateFile string) (openai.ChatRequest, error) {
-	// Check the cache for the template.
-	template, ok := templateCache[templateFile]
-	if !ok {
-		// Cache miss. Read and cache the template file.
-		f, err := os.Open(templateFile)
-		if err != nil {
-			return openai.ChatRequest{}, fmt.Errorf("error opening template file %s: %w", templateFile, err)
-		}
-		defer f.Close()
-		b, err := io.ReadAll(f)
-		if err != nil {
-			return openai.ChatRequest{}, fmt.Errorf("error reading template file %s: %w", templateFile, err)
-		}
-		template = string(b)
-		templateCache[templateFile] = template
-	}
-
-	// Replace the template variables.
-	prompt := strings.ReplaceAll(template, "{{prompt}}", EssayPrompts[essayType])
-	prompt = strings.ReplaceAll(prompt, "{{essay}}", r.SelectEssay(essayType))
-
-	// Create the ChatRequest.
-	return openai.ChatRequest{
-		Model: model,
-		Messages: []openai.Message{
-			SystemMessage,
-			{Role: openai.USER, Content: prompt},
-		},
-		Temperature: temperature,
-		MaxTokens:   maxTokens,
-		User:        strconv.Itoa(r.ID),
-	}, nil
-}
+// ReadEssayRecords reads a CSV, TSV, JSONL, or XLSX file and returns a slice
+// of EssayRecords, mapping the columns with the provided schema, which is
+// returned resolved against the file header. Rows missing some essays are kept.
+// This is best-effort; errors are logged and broken records are ignored.
+func ReadEssayRecords(path string, schema EssaySchema) ([]EssayRecord, EssaySchema, error) {
+	var records []EssayRecord
 
-// NewEssayRecord creates a new EssayRecord from a slice of strings,
-// ostensibly read from a CSV file.
-func NewEssayRecord(fields []string) (EssayRecord, error) {
-	var record EssayRecord
-	var err error
-	if len(fields) != 5 {
-		return record, errors.New("invalid number of fields")
-	}
-	record.ID, err = strconv.Atoi(fields[0])
+	// Read the table:
+	table, err := ReadTableFile(path)
 	if err != nil {
-		return record, fmt.Errorf("invalid pid %s: %w", fields[0], err)
-	}
-	record.Dream = CleanResponse(fields[1])
-	if record.Dream == "" {
-		return record, errors.New("empty dream")
+		return records, schema, err
 	}
-	record.Dejavu = CleanResponse(fields[2])
-	if record.Dejavu == "" {
-		return record, errors.New("empty dejavu")
-	}
-	record.Conflict = CleanResponse(fields[3])
-	if record.Conflict == "" {
-		return record, errors.New("empty conflict")
+	schema = schema.Resolve(table.Header)
+	newRecord, err := schema.RecordReader(table.Header)
+	if err != nil {
+		return records, schema, fmt.Errorf("read essay records %s: %w", path, err)
 	}
-	record.Award = CleanResponse(fields[4])
-	if record.Award == "" {
-		return record, errors.New("empty award")
+
+	// Convert the rows to records:
+	for i, row := range table.Rows {
+		record, err := newRecord(row)
+		if err != nil {
+			fmt.Printf("%s: record %d: %v\n", path, i+1, err)
+		} else {
+			records = append(records, record)
+		}
 	}
-	return record, nil
+	return records, schema, nil
 }
 
-// ReadEssayRecords reads a CSV file and returns a slice of EssayRecords.
-// This is best-effort; errors are logged and broken records are ignored.
-func ReadEssayRecords(path string) ([]EssayRecord, error) {
-	return ReadCSVRecords(path, NewEssayRecord)
+// FilterEssayRecords returns the EssayRecords that include the specified essay type.
+func FilterEssayRecords(records []EssayRecord, essayType string) []EssayRecord {
+	filtered := make([]EssayRecord, 0, len(records))
+	for _, record := range records {
+		if record.HasEssay(essayType) {
+			filtered = append(filtered, record)
+		}
+	}
+	return filtered
 }
 
-// ReadEssayRecord returns the specified EssayRecord.
-func ReadEssayRecord(id int) (EssayRecord, error) {
-	records, err := ReadEssayRecords("data/original/essays.csv")
-	if err != nil {
-		return EssayRecord{}, fmt.Errorf("read essay record: %w", err)
-	}
+// FindEssayRecord returns the EssayRecord with the specified ID.
+func FindEssayRecord(records []EssayRecord, id int) (EssayRecord, error) {
 	for _, record := range records {
 		if record.ID == id {
 			return record, nil
@@ -249,11 +234,11 @@ func ReadEssayRecord(id int) (EssayRecord, error) {
 	return EssayRecord{}, fmt.Errorf("essay record %d not found", id)
 }
 
-// RandomEssayRecord returns a random EssayRecord.
-func RandomEssayRecord() (EssayRecord, error) {
-	records, err := ReadEssayRecords("data/original/essays.csv")
-	if err != nil {
-		return EssayRecord{}, fmt.Errorf("random essay record: %w", err)
+// RandomEssayRecord returns a random EssayRecord that includes the specified essay type.
+func RandomEssayRecord(records []EssayRecord, essayType string) (EssayRecord, error) {
+	records = FilterEssayRecords(records, essayType)
+	if len(records) == 0 {
+		return EssayRecord{}, fmt.Errorf("random essay record: no %s essays found", essayType)
 	}
 	return records[rand.Intn(len(records))], nil
 }
@@ -264,12 +249,18 @@ type EssayCompletion struct {
 	Response openai.ChatResponse `json:"response"`
 	Score    EssayScore          `json:"score"`
 	ErrMsg   string              `json:"error,omitempty"`
+
+	// Samples holds the responses of any repeated sample requests.
+	Samples []openai.ChatResponse `json:"samples,omitempty"`
 }
 
 // String returns a string representation of an EssayCompletion.
 func (c EssayCompletion) String() string {
 	s := c.Request.String()
 	s += c.Response.String()
+	for _, sample := range c.Samples {
+		s += sample.String()
+	}
 	s += c.Score.String()
 	if c.ErrMsg != "" {
 		s += fmt.Sprintf("error: %s\n", c.ErrMsg)
@@ -286,10 +277,26 @@ type EssayScore struct {
 	Score     float32 `csv:"score" json:"score"`
 	Comments  string  `csv:"comments" json:"comments"`
 	Millis    int64   `csv:"millis" json:"millis"`
+
+	// Extra holds covariate columns carried through from the essay input file.
+	Extra map[string]string `csv:"-" json:"extra,omitempty"`
+
+	// Samples holds the score of each sample when self-consistency sampling is
+	// used, in which case Score is their mean, and SampleComments the raw
+	// response of each sample, valid or not. See NewSampledEssayScore.
+	Samples        []float32 `csv:"-" json:"samples,omitempty"`
+	SampleComments []string  `csv:"-" json:"sample_comments,omitempty"`
+	Median         float32   `csv:"-" json:"median,omitempty"`
+	SD             float32   `csv:"-" json:"sd,omitempty"`
+	Review         bool      `csv:"-" json:"review,omitempty"` // samples disagree?
 }
 
 // String returns a string representation of an EssayScore.
 func (s EssayScore) String() string {
+	if len(s.Samples) > 0 {
+		return fmt.Sprintf("--------------------\nid=%d type=%s score=%.2f median=%.2f sd=%.3f n=%d review=%t millis=%d\n",
+			s.ID, s.EssayType, s.Score, s.Median, s.SD, len(s.Samples), s.Review, s.Millis)
+	}
 	return fmt.Sprintf("--------------------\nid=%d type=%s score=%.2f millis=%d\n",
 		s.ID, s.EssayType, s.Score, s.Millis)
 }
@@ -311,25 +318,55 @@ func (s EssayScore) CSVFields() []string {
 	}
 }
 
-// NewEssayScore creates a new EssayScore from an essay, essay type, chat, and duration.
-func NewEssayScore(essay EssayRecord, essayType string, chat openai.ChatResponse, reverse bool, millis int64) (EssayScore, error) {
-	score, err := chat.ExtractScore(reverse)
+// NewEssayScore creates a new EssayScore from an essay, essay type, chat, and
+// duration, using the extractor to extract the score from the chat response.
+func NewEssayScore(essay EssayRecord, essayType string, chat openai.ChatResponse, extractor ScoreExtractor, millis int64) (EssayScore, error) {
+	content, err := chat.FirstMessageContent()
+	if err != nil {
+		return EssayScore{ID: essay.ID, EssayType: essayType, Essay: essay.SelectEssay(essayType), Millis: millis, Extra: essay.Extra}, err
+	}
+	score, err := extractor.Extract(content)
 	return EssayScore{
 		ID:        essay.ID,
 		EssayType: essayType,
 		Essay:     essay.SelectEssay(essayType),
 		Score:     score,
-		Comments:  chat.Choices[0].Message.Content,
+		Comments:  content,
 		Millis:    millis,
+		Extra:     essay.Extra,
 	}, err
 }
 
-// WriteEssayScores writes a slice of EssayScores to a CSV file.
-func WriteEssayScores(path string, scores []EssayScore) error {
-	csvRecords := make([][]string, len(scores)+1)
-	csvRecords[0] = EssayScore{}.CSVHeader()
+// EssayScoreResults returns the results of a slice of EssayScores. If any
+// score has samples, the SampleCSVHeader columns are added. The optional
+// extra columns are appended to each row, taken from EssayScore.Extra.
+func EssayScoreResults(scores []EssayScore, extraColumns ...string) Results {
+	var sampled bool
+	for _, score := range scores {
+		sampled = sampled || len(score.Samples) > 0
+	}
+	var r Results
+	r.Header = EssayScore{}.CSVHeader()
+	if sampled {
+		r.Header = append(r.Header, SampleCSVHeader...)
+	}
+	r.Header = append(r.Header, extraColumns...)
+	r.Rows = make([][]string, len(scores))
 	for i, score := range scores {
-		csvRecords[i+1] = score.CSVFields()
+		fields := score.CSVFields()
+		if sampled {
+			fields = append(fields, score.SampleCSVFields()...)
+		}
+		for _, column := range extraColumns {
+			fields = append(fields, score.Extra[column])
+		}
+		r.Rows[i] = fields
 	}
-	return WriteCSVFile(path, csvRecords)
+	return r
+}
+
+// WriteEssayScores writes a slice of EssayScores to a CSV file, with the
+// columns of EssayScoreResults.
+func WriteEssayScores(path string, scores []EssayScore, extraColumns ...string) error {
+	return WriteResults(path, CSVFormat, EssayScoreResults(scores, extraColumns...))
 }
@@ -0,0 +1,329 @@
+package data
+
+import (
+	"content-coding-gpt/pkg/openai"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Score extraction errors. An extractor returns an error wrapping
+// ErrScoreNotFound if the response contains no score, or ErrScoreOutOfRange,
+// along with the score, if the score is outside the scheme's allowed range.
+var (
+	ErrScoreNotFound   = errors.New("score not found")
+	ErrScoreOutOfRange = errors.New("score out of range")
+)
+
+// ScoreRange is the range of scores allowed by a coding scheme, inclusive.
+// The zero value allows any score.
+type ScoreRange struct {
+	Min float64
+	Max float64
+}
+
+// DefaultScoreRange is the range of the default chat prompt's composite score.
+var DefaultScoreRange = ScoreRange{Min: -1, Max: 1}
+
+// ParseScoreRange parses a range such as "-1,1" or "0:5". An empty string is
+// the zero ScoreRange.
+func ParseScoreRange(s string) (ScoreRange, error) {
+	if strings.TrimSpace(s) == "" {
+		return ScoreRange{}, nil
+	}
+	lo, hi, ok := strings.Cut(s, ",")
+	if !ok {
+		lo, hi, ok = strings.Cut(s, ":")
+	}
+	if !ok {
+		return ScoreRange{}, fmt.Errorf("score range %q: expected min,max", s)
+	}
+	min, err := strconv.ParseFloat(strings.TrimSpace(lo), 64)
+	if err != nil {
+		return ScoreRange{}, fmt.Errorf("score range %q: %w", s, err)
+	}
+	max, err := strconv.ParseFloat(strings.TrimSpace(hi), 64)
+	if err != nil {
+		return ScoreRange{}, fmt.Errorf("score range %q: %w", s, err)
+	}
+	if min > max {
+		return ScoreRange{}, fmt.Errorf("score range %q: min is greater than max", s)
+	}
+	return ScoreRange{Min: min, Max: max}, nil
+}
+
+// IsZero returns true if the range allows any score.
+func (r ScoreRange) IsZero() bool {
+	return r.Min == 0 && r.Max == 0
+}
+
+// String returns the range as "[min, max]".
+func (r ScoreRange) String() string {
+	if r.IsZero() {
+		return "[any]"
+	}
+	return fmt.Sprintf("[%g, %g]", r.Min, r.Max)
+}
+
+// Check returns the score, and an error wrapping ErrScoreOutOfRange if it is
+// outside the range.
+func (r ScoreRange) Check(score float64) (float32, error) {
+	if !r.IsZero() && (score < r.Min || score > r.Max || math.IsNaN(score)) {
+		return float32(score), fmt.Errorf("%w: %g is outside %s", ErrScoreOutOfRange, score, r)
+	}
+	return float32(score), nil
+}
+
+// ScoreExtractor extracts a score from the text of a chat response.
+type ScoreExtractor interface {
+	// Extract returns the score found in the text. The error wraps
+	// ErrScoreNotFound or ErrScoreOutOfRange.
+	Extract(text string) (float32, error)
+
+	// String returns the extractor specification, e.g. "label:Score".
+	String() string
+}
+
+// ScoreExtractors is a list of the supported extractor names. See NewScoreExtractor.
+var ScoreExtractors = []string{"first", "last", "label", "regex", "json", "likert"}
+
+// NewScoreExtractor creates a ScoreExtractor from a specification, which is
+// an extractor name with an optional argument after a colon:
+//
+//	first               the first number (the default)
+//	last                the last number
+//	label[:Label]       the number following a label, e.g. "Score: 4" (default "Score")
+//	regex:Pattern       the first capture group (or match) of a regular expression
+//	json[:field.path]   a field of a JSON object in the response (default "score")
+//	likert[:word=n,...] a Likert agreement phrase (default: the 7-point scale)
+//
+// The extracted score is validated against the range, unless it is zero.
+func NewScoreExtractor(spec string, r ScoreRange) (ScoreExtractor, error) {
+	name, arg, _ := strings.Cut(strings.TrimSpace(spec), ":")
+	switch strings.ToLower(name) {
+	case "", "first":
+		return NumberExtractor{Range: r}, nil
+	case "last":
+		return NumberExtractor{Last: true, Range: r}, nil
+	case "label":
+		if arg == "" {
+			arg = "Score"
+		}
+		pattern := `(?i)` + regexp.QuoteMeta(arg) + `\W{0,4}?\s*([-+]?\d+(?:\.\d+)?)`
+		return RegexExtractor{Spec: "label:" + arg, Pattern: regexp.MustCompile(pattern), Last: true, Range: r}, nil
+	case "regex":
+		pattern, err := regexp.Compile(arg)
+		if err != nil {
+			return nil, fmt.Errorf("score extractor %s: %w", spec, err)
+		}
+		return RegexExtractor{Spec: spec, Pattern: pattern, Range: r}, nil
+	case "json":
+		if arg == "" {
+			arg = "score"
+		}
+		return JSONExtractor{Field: arg, Range: r}, nil
+	case "likert":
+		scale := DefaultLikertScale
+		if arg != "" {
+			scale = map[string]float64{}
+			for _, pair := range strings.Split(arg, ",") {
+				word, value, ok := strings.Cut(pair, "=")
+				n, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+				if !ok || err != nil || strings.TrimSpace(word) == "" {
+					return nil, fmt.Errorf("score extractor %s: invalid likert pair %q", spec, pair)
+				}
+				scale[strings.TrimSpace(word)] = n
+			}
+		}
+		return NewLikertExtractor(scale, r), nil
+	default:
+		return nil, fmt.Errorf("score extractor %s: unknown extractor; expected one of: %s",
+			spec, strings.Join(ScoreExtractors, ", "))
+	}
+}
+
+// NumberExtractor extracts the first (or last) number in the text.
+type NumberExtractor struct {
+	Last  bool
+	Range ScoreRange
+}
+
+// Extract returns the first (or last) number in the text.
+func (x NumberExtractor) Extract(text string) (float32, error) {
+	scores := openai.ParseScores(text)
+	if len(scores) == 0 {
+		return 0, ErrScoreNotFound
+	}
+	score := scores[0]
+	if x.Last {
+		score = scores[len(scores)-1]
+	}
+	return x.Range.Check(float64(score))
+}
+
+// String returns "first" or "last".
+func (x NumberExtractor) String() string {
+	if x.Last {
+		return "last"
+	}
+	return "first"
+}
+
+// RegexExtractor extracts the first capture group, or the whole match, of the
+// first (or last) match of a regular expression.
+type RegexExtractor struct {
+	Spec    string
+	Pattern *regexp.Regexp
+	Last    bool
+	Range   ScoreRange
+}
+
+// Extract returns the number matched by the regular expression.
+func (x RegexExtractor) Extract(text string) (float32, error) {
+	matches := x.Pattern.FindAllStringSubmatch(text, -1)
+	if len(matches) == 0 {
+		return 0, ErrScoreNotFound
+	}
+	match := matches[0]
+	if x.Last {
+		match = matches[len(matches)-1]
+	}
+	s := match[0]
+	if len(match) > 1 {
+		s = match[1]
+	}
+	score, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %q is not a number", ErrScoreNotFound, s)
+	}
+	return x.Range.Check(score)
+}
+
+// String returns the extractor specification.
+func (x RegexExtractor) String() string {
+	return x.Spec
+}
+
+// JSONExtractor extracts a numeric field from the first JSON object in the
+// text, which may be wrapped in a Markdown code block. The field is a dotted
+// path, e.g. "scores.hum1".
+type JSONExtractor struct {
+	Field string
+	Range ScoreRange
+}
+
+// Extract returns the numeric JSON field.
+func (x JSONExtractor) Extract(text string) (float32, error) {
+	object, err := FindJSONObject(text)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrScoreNotFound, err)
+	}
+	var value interface{} = object
+	for _, key := range strings.Split(x.Field, ".") {
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return 0, fmt.Errorf("%w: no JSON field %s", ErrScoreNotFound, x.Field)
+		}
+		if value, ok = m[key]; !ok {
+			return 0, fmt.Errorf("%w: no JSON field %s", ErrScoreNotFound, x.Field)
+		}
+	}
+	switch v := value.(type) {
+	case float64:
+		return x.Range.Check(v)
+	case string:
+		if score, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+			return x.Range.Check(score)
+		}
+	}
+	return 0, fmt.Errorf("%w: JSON field %s is not a number", ErrScoreNotFound, x.Field)
+}
+
+// String returns the extractor specification.
+func (x JSONExtractor) String() string {
+	return "json:" + x.Field
+}
+
+// FindJSONObject decodes the first JSON object found in the text.
+func FindJSONObject(text string) (map[string]interface{}, error) {
+	for i := strings.IndexByte(text, '{'); i >= 0; {
+		var object map[string]interface{}
+		decoder := json.NewDecoder(strings.NewReader(text[i:]))
+		if err := decoder.Decode(&object); err == nil {
+			return object, nil
+		}
+		j := strings.IndexByte(text[i+1:], '{')
+		if j < 0 {
+			break
+		}
+		i += j + 1
+	}
+	return nil, errors.New("no JSON object found")
+}
+
+// DefaultLikertScale is the 7-point agreement scale used by the humility items.
+var DefaultLikertScale = map[string]float64{
+	"strongly disagree":          1,
+	"disagree":                   2,
+	"somewhat disagree":          3,
+	"slightly disagree":          3,
+	"neither agree nor disagree": 4,
+	"neutral":                    4,
+	"somewhat agree":             5,
+	"slightly agree":             5,
+	"agree":                      6,
+	"strongly agree":             7,
+}
+
+// LikertExtractor extracts the value of the first Likert phrase in the text.
+// Longer phrases take precedence, so "strongly agree" is not read as "agree".
+type LikertExtractor struct {
+	Scale   map[string]float64
+	Range   ScoreRange
+	pattern *regexp.Regexp
+}
+
+// NewLikertExtractor creates a LikertExtractor for a scale of phrases to values.
+func NewLikertExtractor(scale map[string]float64, r ScoreRange) LikertExtractor {
+	phrases := make([]string, 0, len(scale))
+	for phrase := range scale {
+		phrases = append(phrases, phrase)
+	}
+	sort.Slice(phrases, func(i, j int) bool {
+		if len(phrases[i]) != len(phrases[j]) {
+			return len(phrases[i]) > len(phrases[j])
+		}
+		return phrases[i] < phrases[j]
+	})
+	quoted := make([]string, len(phrases))
+	for i, phrase := range phrases {
+		quoted[i] = strings.ReplaceAll(regexp.QuoteMeta(phrase), " ", `\s+`)
+	}
+	pattern := regexp.MustCompile(`(?i)\b(` + strings.Join(quoted, "|") + `)\b`)
+	return LikertExtractor{Scale: scale, Range: r, pattern: pattern}
+}
+
+// Extract returns the value of the first Likert phrase in the text.
+func (x LikertExtractor) Extract(text string) (float32, error) {
+	match := x.pattern.FindString(text)
+	if match == "" {
+		return 0, ErrScoreNotFound
+	}
+	phrase := strings.ToLower(strings.Join(strings.Fields(match), " "))
+	for p, value := range x.Scale {
+		if strings.ToLower(p) == phrase {
+			return x.Range.Check(value)
+		}
+	}
+	return 0, ErrScoreNotFound
+}
+
+// String returns "likert".
+func (x LikertExtractor) String() string {
+	return "likert"
+}
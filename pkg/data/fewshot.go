@@ -0,0 +1,217 @@
+package data
+
+import (
+	"content-coding-gpt/pkg/openai"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+)
+
+// Exemplar strategies select which human-coded essays are used as few-shot examples.
+const (
+	RandomExemplars     = "random"     // uniformly random
+	StratifiedExemplars = "stratified" // one from each score quantile
+	SimilarExemplars    = "similar"    // most similar by embedding
+)
+
+// ExemplarStrategies is a list of the supported exemplar strategies.
+var ExemplarStrategies = []string{RandomExemplars, StratifiedExemplars, SimilarExemplars}
+
+// Exemplar is a human-coded essay, used as a few-shot example.
+type Exemplar struct {
+	ID    int     `json:"pid"`
+	Essay string  `json:"essay"`
+	Score float64 `json:"score"` // human standardized score
+}
+
+// ReadExemplars reads exemplars from a humility or spiritual training CSV file,
+// returning the file type ("humility" or "spiritual") and the exemplars.
+func ReadExemplars(path string) (string, []Exemplar, error) {
+	var exemplars []Exemplar
+	fileType, err := IdentifyCSVFile(path)
+	if err != nil {
+		return fileType, nil, fmt.Errorf("read exemplars: %w", err)
+	}
+	switch fileType {
+	case "humility":
+		records, e := ReadHumilityRecords(path)
+		if e != nil {
+			return fileType, nil, fmt.Errorf("read exemplars: %w", e)
+		}
+		for _, r := range records {
+			exemplars = append(exemplars, Exemplar{ID: r.ID, Essay: r.Response, Score: r.Std})
+		}
+	case "spiritual":
+		records, e := ReadSpiritualRecords(path)
+		if e != nil {
+			return fileType, nil, fmt.Errorf("read exemplars: %w", e)
+		}
+		for _, r := range records {
+			exemplars = append(exemplars, Exemplar{ID: r.ID, Essay: r.Response, Score: r.Std})
+		}
+	default:
+		return fileType, nil, fmt.Errorf("read exemplars %s: unexpected file type %s", path, fileType)
+	}
+	if len(exemplars) == 0 {
+		return fileType, nil, fmt.Errorf("read exemplars %s: no exemplars found", path)
+	}
+	return fileType, exemplars, nil
+}
+
+// FewShot selects k exemplars for each essay, which are added to its chat
+// request as user/assistant turns. Selection is deterministic for a given seed
+// and essay, and the essay itself is never selected as its own exemplar.
+type FewShot struct {
+	// Exemplars is the pool of human-coded essays to select from.
+	Exemplars []Exemplar
+
+	// K is the number of exemplars to select for each essay.
+	K int
+
+	// Strategy is one of the ExemplarStrategies.
+	Strategy string
+
+	// Seed is the random seed; each essay's selection is seeded with Seed+ID.
+	Seed int64
+
+	// Format is the fmt format of the assistant's answer for an exemplar's
+	// score, e.g. "Score: %.2f". The default is "%.2f".
+	Format string
+
+	// Embed returns the embedding vectors of the texts, in order. It is
+	// required by the similar strategy.
+	Embed func(texts []string) ([][]float32, error)
+
+	mu         sync.Mutex
+	embeddings [][]float32 // exemplar embeddings, computed once
+}
+
+// Select returns the exemplars for an essay of the specified type.
+func (f *FewShot) Select(r EssayRecord, essayType string) ([]Exemplar, error) {
+	essay := r.SelectEssay(essayType)
+	rng := rand.New(rand.NewSource(f.Seed + int64(r.ID)))
+
+	// Exclude the essay itself from the candidates:
+	candidates := make([]int, 0, len(f.Exemplars))
+	for i, e := range f.Exemplars {
+		if e.ID != r.ID && e.Essay != essay {
+			candidates = append(candidates, i)
+		}
+	}
+	k := f.K
+	if k > len(candidates) {
+		k = len(candidates)
+	}
+	if k <= 0 {
+		return nil, nil
+	}
+
+	// Select the exemplar indexes:
+	var selected []int
+	switch f.Strategy {
+	case RandomExemplars, "":
+		rng.Shuffle(len(candidates), func(i, j int) { candidates[i], candidates[j] = candidates[j], candidates[i] })
+		selected = candidates[:k]
+	case StratifiedExemplars:
+		sort.SliceStable(candidates, func(i, j int) bool {
+			return f.Exemplars[candidates[i]].Score < f.Exemplars[candidates[j]].Score
+		})
+		for s := 0; s < k; s++ {
+			lo, hi := s*len(candidates)/k, (s+1)*len(candidates)/k
+			selected = append(selected, candidates[lo+rng.Intn(hi-lo)])
+		}
+		rng.Shuffle(len(selected), func(i, j int) { selected[i], selected[j] = selected[j], selected[i] })
+	case SimilarExemplars:
+		similarities, err := f.similarities(essay)
+		if err != nil {
+			return nil, err
+		}
+		sort.SliceStable(candidates, func(i, j int) bool {
+			return similarities[candidates[i]] > similarities[candidates[j]]
+		})
+		selected = candidates[:k]
+		// The most similar exemplar is placed last, closest to the essay:
+		for i, j := 0, len(selected)-1; i < j; i, j = i+1, j-1 {
+			selected[i], selected[j] = selected[j], selected[i]
+		}
+	default:
+		return nil, fmt.Errorf("few-shot: unknown exemplar strategy %s", f.Strategy)
+	}
+
+	exemplars := make([]Exemplar, len(selected))
+	for i, j := range selected {
+		exemplars[i] = f.Exemplars[j]
+	}
+	return exemplars, nil
+}
+
+// similarities returns the cosine similarity of the essay to each exemplar.
+func (f *FewShot) similarities(essay string) ([]float64, error) {
+	if f.Embed == nil {
+		return nil, errors.New("few-shot: the similar strategy requires embeddings")
+	}
+
+	// Embed the exemplars once, in batches:
+	f.mu.Lock()
+	if f.embeddings == nil {
+		texts := make([]string, len(f.Exemplars))
+		for i, e := range f.Exemplars {
+			texts[i] = e.Essay
+		}
+		var embeddings [][]float32
+		for _, batch := range Batch(texts, 100) {
+			vectors, err := f.Embed(batch)
+			if err != nil {
+				f.mu.Unlock()
+				return nil, fmt.Errorf("few-shot: embed exemplars: %w", err)
+			}
+			embeddings = append(embeddings, vectors...)
+		}
+		if len(embeddings) != len(texts) {
+			f.mu.Unlock()
+			return nil, fmt.Errorf("few-shot: embed exemplars: got %d embeddings, want %d", len(embeddings), len(texts))
+		}
+		f.embeddings = embeddings
+	}
+	f.mu.Unlock()
+
+	// Embed the essay and compare:
+	vectors, err := f.Embed([]string{essay})
+	if err != nil {
+		return nil, fmt.Errorf("few-shot: embed essay: %w", err)
+	}
+	if len(vectors) != 1 {
+		return nil, fmt.Errorf("few-shot: embed essay: got %d embeddings, want 1", len(vectors))
+	}
+	similarities := make([]float64, len(f.embeddings))
+	for i, e := range f.embeddings {
+		similarities[i] = cosineSimilarity(vectors[0], e)
+	}
+	return similarities, nil
+}
+
+// cosineSimilarity returns the cosine similarity of two vectors.
+func cosineSimilarity(a, b []float32) float64 {
+	var dot, na, nb float64
+	for i := 0; i < len(a) && i < len(b); i++ {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(na) * math.Sqrt(nb))
+}
+
+// Answer returns the assistant's answer for an exemplar.
+func (f *FewShot) Answer(e Exemplar) openai.Message {
+	format := f.Format
+	if format == "" {
+		format = "%.2f"
+	}
+	return openai.Message{Role: openai.ASSISTANT, Content: fmt.Sprintf(format, e.Score)}
+}
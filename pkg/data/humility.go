@@ -180,12 +180,18 @@ func ReadHumilityRecords(path string) ([]HumilityRecord, error) {
 	return ReadCSVRecords(path, NewHumilityRecordCSV)
 }
 
-// WriteHumilityRecords writes a slice of HumilityRecords to a CSV file.
-func WriteHumilityRecords(path string, records []HumilityRecord) error {
-	csvRecords := make([][]string, len(records)+1)
-	csvRecords[0] = HumilityCSVHeader
+// HumilityResults returns the results of a slice of HumilityRecords.
+func HumilityResults(records []HumilityRecord) Results {
+	var results Results
+	results.Header = HumilityCSVHeader
+	results.Rows = make([][]string, len(records))
 	for i, r := range records {
-		csvRecords[i+1] = r.CSVFields()
+		results.Rows[i] = r.CSVFields()
 	}
-	return WriteCSVFile(path, csvRecords)
+	return results
+}
+
+// WriteHumilityRecords writes a slice of HumilityRecords to a CSV file.
+func WriteHumilityRecords(path string, records []HumilityRecord) error {
+	return WriteResults(path, CSVFormat, HumilityResults(records))
 }
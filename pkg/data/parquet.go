@@ -0,0 +1,289 @@
+package data
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Parquet physical types, repetition types, encodings, and the thrift
+// compact protocol types of their metadata. See
+// https://github.com/apache/parquet-format.
+const (
+	parquetBoolean   = 0
+	parquetInt64     = 2
+	parquetDouble    = 5
+	parquetByteArray = 6
+
+	parquetOptional = 1
+	parquetUTF8     = 0 // converted type
+
+	parquetPlain = 0
+	parquetRLE   = 3
+
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// parquetMagic starts and ends a Parquet file.
+const parquetMagic = "PAR1"
+
+// writeResultsParquet writes the results to a Parquet file, with one
+// optional column per results column: int64, double, boolean, or UTF-8
+// strings, with missing values null. The file has one row group of
+// uncompressed, PLAIN encoded pages.
+func writeResultsParquet(path string, r Results) error {
+	vars := r.Variables()
+	var file bytes.Buffer
+	file.WriteString(parquetMagic)
+
+	// Write a column chunk of one data page per column:
+	chunks := make([]parquetChunk, len(vars))
+	for j, v := range vars {
+		page := r.parquetPage(j, v.Type)
+		var header thriftWriter
+		header.i32(1, 0) // DATA_PAGE
+		header.i32(2, int32(len(page)))
+		header.i32(3, int32(len(page)))
+		header.beginStruct(5) // data_page_header
+		header.i32(1, int32(len(r.Rows)))
+		header.i32(2, parquetPlain)
+		header.i32(3, parquetRLE)
+		header.i32(4, parquetRLE)
+		header.endStruct()
+		header.stop()
+		chunks[j] = parquetChunk{offset: int64(file.Len()), size: int64(header.Len() + len(page)), typ: parquetType(v.Type)}
+		file.Write(header.Bytes())
+		file.Write(page)
+	}
+
+	// Write the file metadata:
+	var meta thriftWriter
+	meta.i32(1, 1) // version
+	meta.beginList(2, thriftStruct, len(vars)+1)
+	meta.beginElement()
+	meta.binary(4, "schema")
+	meta.i32(5, int32(len(vars)))
+	meta.endElement()
+	for _, v := range vars {
+		meta.beginElement()
+		meta.i32(1, parquetType(v.Type))
+		meta.i32(3, parquetOptional)
+		meta.binary(4, v.Name)
+		if v.Type == StringType {
+			meta.i32(6, parquetUTF8)
+		}
+		meta.endElement()
+	}
+	meta.i64(3, int64(len(r.Rows)))
+	meta.beginList(4, thriftStruct, 1)
+	meta.beginElement() // row group
+	meta.beginList(1, thriftStruct, len(chunks))
+	var total int64
+	for j, c := range chunks {
+		meta.beginElement() // column chunk
+		meta.i64(2, c.offset)
+		meta.beginStruct(3) // column metadata
+		meta.i32(1, c.typ)
+		meta.beginList(2, thriftI32, 2)
+		meta.varint(parquetPlain)
+		meta.varint(parquetRLE)
+		meta.beginList(3, thriftBinary, 1)
+		meta.str(vars[j].Name)
+		meta.i32(4, 0) // UNCOMPRESSED
+		meta.i64(5, int64(len(r.Rows)))
+		meta.i64(6, c.size)
+		meta.i64(7, c.size)
+		meta.i64(9, c.offset)
+		meta.endStruct()
+		meta.endElement()
+		total += c.size
+	}
+	meta.i64(2, total)
+	meta.i64(3, int64(len(r.Rows)))
+	meta.endElement()
+	meta.binary(6, "content-coding-gpt")
+	meta.stop()
+	file.Write(meta.Bytes())
+	file.Write(binary.LittleEndian.AppendUint32(nil, uint32(meta.Len())))
+	file.WriteString(parquetMagic)
+
+	if err := os.WriteFile(path, file.Bytes(), 0644); err != nil {
+		return fmt.Errorf("write parquet file %s: %w", path, err)
+	}
+	return nil
+}
+
+// parquetChunk locates a column chunk in a Parquet file.
+type parquetChunk struct {
+	offset int64
+	size   int64
+	typ    int32
+}
+
+// parquetType returns the Parquet physical type of a variable type.
+func parquetType(typ string) int32 {
+	switch typ {
+	case IntType:
+		return parquetInt64
+	case FloatType:
+		return parquetDouble
+	case BoolType:
+		return parquetBoolean
+	default:
+		return parquetByteArray
+	}
+}
+
+// parquetPage returns the data of the page of a column: the definition
+// levels, 1 for a value and 0 for null, then the PLAIN encoded values.
+func (r Results) parquetPage(column int, typ string) []byte {
+	levels := make([]byte, len(r.Rows))
+	var values []byte
+	var bits []bool
+	for i := range r.Rows {
+		s := r.field(i, column)
+		if s == "" {
+			continue
+		}
+		switch typ {
+		case IntType:
+			n, err := strconv.ParseInt(s, 10, 64)
+			if err != nil {
+				continue
+			}
+			values = binary.LittleEndian.AppendUint64(values, uint64(n))
+		case FloatType:
+			f, ok := parseFloat(s)
+			if !ok {
+				continue
+			}
+			values = binary.LittleEndian.AppendUint64(values, math.Float64bits(f))
+		case BoolType:
+			bits = append(bits, strings.EqualFold(s, "true"))
+		default:
+			values = binary.LittleEndian.AppendUint32(values, uint32(len(s)))
+			values = append(values, s...)
+		}
+		levels[i] = 1
+	}
+	if typ == BoolType {
+		values = make([]byte, (len(bits)+7)/8)
+		for i, b := range bits {
+			if b {
+				values[i/8] |= 1 << (i % 8)
+			}
+		}
+	}
+	encoded := rleLevels(levels)
+	page := binary.LittleEndian.AppendUint32(nil, uint32(len(encoded)))
+	page = append(page, encoded...)
+	return append(page, values...)
+}
+
+// rleLevels encodes definition levels of bit width 1 as RLE runs.
+func rleLevels(levels []byte) []byte {
+	var b []byte
+	for i := 0; i < len(levels); {
+		j := i + 1
+		for j < len(levels) && levels[j] == levels[i] {
+			j++
+		}
+		b = binary.AppendUvarint(b, uint64(j-i)<<1)
+		b = append(b, levels[i])
+		i = j
+	}
+	return b
+}
+
+// thriftWriter writes thrift compact protocol structs, as used by the
+// Parquet metadata.
+type thriftWriter struct {
+	bytes.Buffer
+	last  int16   // the last field ID of the current struct
+	stack []int16 // the last field IDs of the enclosing structs
+}
+
+// field writes a field header.
+func (w *thriftWriter) field(id int16, typ byte) {
+	if delta := id - w.last; delta > 0 && delta <= 15 {
+		w.WriteByte(byte(delta)<<4 | typ)
+	} else {
+		w.WriteByte(typ)
+		w.varint(int64(id))
+	}
+	w.last = id
+}
+
+// varint writes a zigzag varint.
+func (w *thriftWriter) varint(n int64) {
+	w.Write(binary.AppendUvarint(nil, uint64(n<<1^n>>63)))
+}
+
+// str writes a length-prefixed string.
+func (w *thriftWriter) str(s string) {
+	w.Write(binary.AppendUvarint(nil, uint64(len(s))))
+	w.WriteString(s)
+}
+
+func (w *thriftWriter) i32(id int16, n int32) {
+	w.field(id, thriftI32)
+	w.varint(int64(n))
+}
+
+func (w *thriftWriter) i64(id int16, n int64) {
+	w.field(id, thriftI64)
+	w.varint(n)
+}
+
+func (w *thriftWriter) binary(id int16, s string) {
+	w.field(id, thriftBinary)
+	w.str(s)
+}
+
+// beginList writes the header of a list field of n elements.
+func (w *thriftWriter) beginList(id int16, elem byte, n int) {
+	w.field(id, thriftList)
+	if n < 15 {
+		w.WriteByte(byte(n)<<4 | elem)
+	} else {
+		w.WriteByte(0xf0 | elem)
+		w.Write(binary.AppendUvarint(nil, uint64(n)))
+	}
+}
+
+// beginStruct writes the header of a struct field, and starts its fields.
+func (w *thriftWriter) beginStruct(id int16) {
+	w.field(id, thriftStruct)
+	w.beginElement()
+}
+
+// beginElement starts the fields of a struct element of a list.
+func (w *thriftWriter) beginElement() {
+	w.stack = append(w.stack, w.last)
+	w.last = 0
+}
+
+// endElement ends the fields of a struct element of a list.
+func (w *thriftWriter) endElement() {
+	w.stop()
+	w.last = w.stack[len(w.stack)-1]
+	w.stack = w.stack[:len(w.stack)-1]
+}
+
+// endStruct ends the fields of a struct field.
+func (w *thriftWriter) endStruct() {
+	w.endElement()
+}
+
+// stop writes the end of a struct.
+func (w *thriftWriter) stop() {
+	w.WriteByte(0)
+}
@@ -0,0 +1,266 @@
+package data
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// thriftFields is a decoded thrift compact protocol struct, by field ID.
+type thriftFields map[int16]interface{}
+
+// readThriftStruct decodes a thrift compact protocol struct with the field
+// types written by thriftWriter.
+func readThriftStruct(r *bytes.Reader) (thriftFields, error) {
+	s := thriftFields{}
+	var id int16
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return s, err
+		}
+		if b == 0 {
+			return s, nil
+		}
+		if delta := int16(b >> 4); delta != 0 {
+			id += delta
+		} else {
+			n, err := binary.ReadVarint(r)
+			if err != nil {
+				return s, err
+			}
+			id = int16(n)
+		}
+		if s[id], err = readThriftValue(r, b&0x0f); err != nil {
+			return s, fmt.Errorf("field %d: %w", id, err)
+		}
+	}
+}
+
+// readThriftValue decodes a thrift compact protocol value of a type.
+func readThriftValue(r *bytes.Reader, typ byte) (interface{}, error) {
+	switch typ {
+	case thriftI32, thriftI64:
+		return binary.ReadVarint(r)
+	case thriftBinary:
+		n, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, err
+		}
+		b := make([]byte, n)
+		_, err = r.Read(b)
+		return string(b), err
+	case thriftList:
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		n := uint64(b >> 4)
+		if n == 15 {
+			if n, err = binary.ReadUvarint(r); err != nil {
+				return nil, err
+			}
+		}
+		list := make([]interface{}, n)
+		for i := range list {
+			if list[i], err = readThriftValue(r, b&0x0f); err != nil {
+				return nil, err
+			}
+		}
+		return list, nil
+	case thriftStruct:
+		return readThriftStruct(r)
+	}
+	return nil, fmt.Errorf("unexpected type %d", typ)
+}
+
+// readParquetColumn decodes the values of a column chunk written by
+// writeResultsParquet, with nil for nulls.
+func readParquetColumn(t *testing.T, file []byte, chunk thriftFields, rows int) []interface{} {
+	t.Helper()
+	meta := chunk[3].(thriftFields)
+	r := bytes.NewReader(file[meta[9].(int64):])
+	header, err := readThriftStruct(r)
+	if err != nil {
+		t.Fatalf("page header: %v", err)
+	}
+	if header[1].(int64) != 0 || header[5].(thriftFields)[1].(int64) != int64(rows) {
+		t.Fatalf("page header %v: not a data page of %d values", header, rows)
+	}
+	page := make([]byte, header[3].(int64))
+	if _, err := r.Read(page); err != nil {
+		t.Fatal(err)
+	}
+
+	// Decode the RLE definition levels:
+	n := binary.LittleEndian.Uint32(page)
+	levels := bytes.NewReader(page[4 : 4+n])
+	var defined []bool
+	for levels.Len() > 0 {
+		run, err := binary.ReadUvarint(levels)
+		if err != nil || run&1 != 0 {
+			t.Fatalf("definition levels: bad run header %d (%v)", run, err)
+		}
+		level, _ := levels.ReadByte()
+		for i := uint64(0); i < run>>1; i++ {
+			defined = append(defined, level == 1)
+		}
+	}
+	if len(defined) != rows {
+		t.Fatalf("got %d definition levels, want %d", len(defined), rows)
+	}
+
+	// Decode the PLAIN values:
+	values := page[4+n:]
+	column := make([]interface{}, rows)
+	var bit int
+	for i, ok := range defined {
+		if !ok {
+			continue
+		}
+		switch meta[1].(int64) {
+		case parquetInt64:
+			column[i] = int64(binary.LittleEndian.Uint64(values))
+			values = values[8:]
+		case parquetDouble:
+			column[i] = math.Float64frombits(binary.LittleEndian.Uint64(values))
+			values = values[8:]
+		case parquetBoolean:
+			column[i] = values[bit/8]&(1<<(bit%8)) != 0
+			bit++
+		case parquetByteArray:
+			size := binary.LittleEndian.Uint32(values)
+			column[i] = string(values[4 : 4+size])
+			values = values[4+size:]
+		}
+	}
+	return column
+}
+
+func TestWriteResultsParquet(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "scores.parquet")
+	if err := WriteResults(path, "", testResults()); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The file starts and ends with the magic number, and the footer is
+	// preceded by its length:
+	if string(file[:4]) != parquetMagic || string(file[len(file)-4:]) != parquetMagic {
+		t.Fatalf("file does not start and end with %s", parquetMagic)
+	}
+	size := int(binary.LittleEndian.Uint32(file[len(file)-8:]))
+	footer := bytes.NewReader(file[len(file)-8-size : len(file)-8])
+	meta, err := readThriftStruct(footer)
+	if err != nil {
+		t.Fatalf("file metadata: %v", err)
+	}
+	if footer.Len() != 0 {
+		t.Errorf("%d bytes after the file metadata", footer.Len())
+	}
+	if rows := meta[3].(int64); rows != 3 {
+		t.Errorf("num_rows = %d, want 3", rows)
+	}
+
+	// The schema is a root and one optional column per results column:
+	schema := meta[2].([]interface{})
+	if root := schema[0].(thriftFields); root[4] != "schema" || root[5].(int64) != 8 {
+		t.Errorf("schema root = %v", root)
+	}
+	columns := []struct {
+		name string
+		typ  int64
+		utf8 bool
+	}{
+		{"pid", parquetInt64, false},
+		{"essay_type", parquetByteArray, true},
+		{"essay", parquetByteArray, true},
+		{"score", parquetDouble, false},
+		{"review", parquetBoolean, false},
+		{"age", parquetInt64, false},
+		{"weight", parquetDouble, false},
+		{"note", parquetByteArray, true},
+	}
+	if len(schema) != len(columns)+1 {
+		t.Fatalf("got %d schema elements, want %d", len(schema), len(columns)+1)
+	}
+	for i, c := range columns {
+		e := schema[i+1].(thriftFields)
+		_, utf8 := e[6]
+		if e[4] != c.name || e[1].(int64) != c.typ || e[3].(int64) != parquetOptional || utf8 != c.utf8 {
+			t.Errorf("schema element %d = %v, want %s of type %d (utf8 %t)", i+1, e, c.name, c.typ, c.utf8)
+		}
+	}
+
+	// The column chunks of the row group hold the values, with nulls for
+	// the missing values:
+	want := [][]interface{}{
+		{int64(1), int64(2), int64(3)},
+		{"dream", "dream", "dream"},
+		{"Short essay\nwith a newline", testEssay, nil},
+		{0.5, nil, -0.25},
+		{true, false, nil},
+		{int64(34), nil, int64(29)},
+		{61.5, 70.0, nil},
+		{"ok", nil, "note, with comma"},
+	}
+	group := meta[4].([]interface{})[0].(thriftFields)
+	chunks := group[1].([]interface{})
+	if len(chunks) != len(want) {
+		t.Fatalf("got %d column chunks, want %d", len(chunks), len(want))
+	}
+	var total int64
+	for j, c := range chunks {
+		chunk := c.(thriftFields)
+		if got := readParquetColumn(t, file, chunk, 3); !reflect.DeepEqual(got, want[j]) {
+			t.Errorf("column %s = %v, want %v", columns[j].name, got, want[j])
+		}
+		total += chunk[3].(thriftFields)[6].(int64)
+	}
+	if group[2].(int64) != total || group[3].(int64) != 3 {
+		t.Errorf("row group size %d and rows %d, want %d and 3", group[2], group[3], total)
+	}
+}
+
+func TestWriteResultsParquetPyArrow(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "scores.parquet")
+	if err := WriteResults(path, "", testResults()); err != nil {
+		t.Fatal(err)
+	}
+	var got struct {
+		Names []string                 `json:"names"`
+		Types []string                 `json:"types"`
+		Rows  []map[string]interface{} `json:"rows"`
+	}
+	readWithPython(t, "pyarrow", `
+import json, sys
+import pyarrow.parquet as pq
+t = pq.read_table(sys.argv[1])
+print(json.dumps({"names": t.schema.names, "types": [str(f.type) for f in t.schema], "rows": t.to_pylist()}))
+`, path, &got)
+	if want := []string{"pid", "essay_type", "essay", "score", "review", "age", "weight", "note"}; !reflect.DeepEqual(got.Names, want) {
+		t.Errorf("names = %q, want %q", got.Names, want)
+	}
+	if want := []string{"int64", "string", "string", "double", "bool", "int64", "double", "string"}; !reflect.DeepEqual(got.Types, want) {
+		t.Errorf("types = %q, want %q", got.Types, want)
+	}
+	want := []map[string]interface{}{
+		{"pid": 1.0, "essay_type": "dream", "essay": "Short essay\nwith a newline", "score": 0.5, "review": true,
+			"age": 34.0, "weight": 61.5, "note": "ok"},
+		{"pid": 2.0, "essay_type": "dream", "essay": testEssay, "score": nil, "review": false,
+			"age": nil, "weight": 70.0, "note": nil},
+		{"pid": 3.0, "essay_type": "dream", "essay": nil, "score": -0.25, "review": nil,
+			"age": 29.0, "weight": nil, "note": "note, with comma"},
+	}
+	if !reflect.DeepEqual(got.Rows, want) {
+		t.Errorf("rows =\n%v\nwant\n%v", got.Rows, want)
+	}
+}
@@ -0,0 +1,489 @@
+package data
+
+import (
+	"bufio"
+	"bytes"
+	"content-coding-gpt/pkg/openai"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Results formats.
+const (
+	CSVFormat     = "csv"     // the CSV columns as coded
+	JSONLFormat   = "jsonl"   // typed objects, with the API requests and responses
+	ParquetFormat = "parquet" // typed, uncompressed Parquet
+	StatsFormat   = "stats"   // CSV with short names and no embedded newlines, plus a codebook
+	StataFormat   = "stata"   // Stata 14+ .dta file, with variable labels
+)
+
+// ResultsWriter writes results to a file in a results format.
+type ResultsWriter func(path string, r Results) error
+
+// resultsWriters are the writers of the results formats, by name.
+var resultsWriters = map[string]ResultsWriter{
+	CSVFormat:     writeResultsCSV,
+	JSONLFormat:   writeResultsJSONL,
+	ParquetFormat: writeResultsParquet,
+	StatsFormat:   writeResultsStats,
+	StataFormat:   writeResultsStata,
+}
+
+// RegisterResultsFormat adds or replaces the writer of a results format.
+func RegisterResultsFormat(name string, w ResultsWriter) {
+	resultsWriters[name] = w
+}
+
+// ResultsFormats returns the names of the results formats, sorted.
+func ResultsFormats() []string {
+	names := make([]string, 0, len(resultsWriters))
+	for name := range resultsWriters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ResultsFormat returns the results format implied by a file's extension:
+// "jsonl", "parquet", or "stata" (.dta), and "csv" otherwise.
+func ResultsFormat(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".jsonl", ".ndjson":
+		return JSONLFormat
+	case ".parquet":
+		return ParquetFormat
+	case ".dta":
+		return StataFormat
+	default:
+		return CSVFormat
+	}
+}
+
+// ValidResultsFormat returns an error if the format is not a results format.
+func ValidResultsFormat(format string) error {
+	if _, ok := resultsWriters[format]; !ok {
+		return fmt.Errorf("results format %s is not one of: %s", format, strings.Join(ResultsFormats(), ", "))
+	}
+	return nil
+}
+
+// WriteResults writes the results to a file in the format, or the format
+// implied by the file extension if the format is "".
+func WriteResults(path, format string, r Results) error {
+	if format == "" {
+		format = ResultsFormat(path)
+	}
+	if err := ValidResultsFormat(format); err != nil {
+		return fmt.Errorf("write results %s: %w", path, err)
+	}
+	return resultsWriters[format](path, r)
+}
+
+// Results is a table of results: the header and rows of CSV fields written by
+// WriteEssayScores and the like, which the other formats convert to typed
+// values. An empty field is a missing value.
+type Results struct {
+	Table
+
+	// Exchanges holds the API requests and responses behind each row, if
+	// any. Only the JSONL format writes them.
+	Exchanges [][]Exchange
+}
+
+// Exchange is an API request and its response or error.
+type Exchange struct {
+	ID       string      `json:"id,omitempty"`
+	Request  interface{} `json:"request"`
+	Response interface{} `json:"response,omitempty"`
+	Error    string      `json:"error,omitempty"`
+	Millis   int64       `json:"millis,omitempty"`
+}
+
+// ChatExchanges returns the exchanges of chats.
+func ChatExchanges(chats ...openai.Chat) []Exchange {
+	exchanges := make([]Exchange, len(chats))
+	for i, chat := range chats {
+		exchanges[i] = Exchange{ID: chat.ID, Request: chat.Request, Error: chat.ErrMsg, Millis: chat.Millis}
+		if chat.ErrMsg == "" {
+			exchanges[i].Response = chat.Response
+		}
+	}
+	return exchanges
+}
+
+// Variable types of results columns.
+const (
+	StringType = "string"
+	IntType    = "int"
+	FloatType  = "float"
+	BoolType   = "bool"
+)
+
+// Variable describes a results column for the typed formats and codebook.
+type Variable struct {
+	Name  string `json:"name"`  // column name, e.g. "essay_type"
+	Short string `json:"short"` // short variable name, e.g. "etype"
+	Label string `json:"label"`
+	Type  string `json:"type"`
+	Width int    `json:"width"` // longest value in bytes
+}
+
+// resultsVariables are the known results columns, with fixed types, so that
+// every results file of a kind has the same schema. Model comments, for
+// example, may all be numbers, and an empty file has no values to infer from.
+// A fixed type that doesn't fit a column's values is widened; see Variables.
+var resultsVariables = map[string]Variable{
+	"pid":             {Short: "pid", Label: "Participant ID", Type: IntType},
+	"essay_type":      {Short: "etype", Label: "Essay type", Type: StringType},
+	"essay":           {Short: "essay", Label: "Essay text", Type: StringType},
+	"score":           {Short: "score", Label: "Content-coded score", Type: FloatType},
+	"comments":        {Short: "comment", Label: "Model response", Type: StringType},
+	"millis":          {Short: "millis", Label: "Response time (ms)", Type: IntType},
+	"n_samples":       {Short: "nsamp", Label: "Number of samples scored", Type: IntType},
+	"median":          {Short: "median", Label: "Median sample score", Type: FloatType},
+	"sd":              {Short: "sd", Label: "Standard deviation of the sample scores", Type: FloatType},
+	"review":          {Short: "review", Label: "Flagged for review: the samples disagree", Type: BoolType},
+	"samples":         {Short: "samples", Label: "Sample scores", Type: StringType},
+	"sample_comments": {Short: "scomment", Label: "Sample responses (JSON array)", Type: StringType},
+	"ensemble":        {Short: "ensemble", Label: "Weighted ensemble score", Type: FloatType},
+	"n_models":        {Short: "nmodels", Label: "Number of models scored", Type: IntType},
+	"response":        {Short: "response", Label: "Essay text", Type: StringType},
+	"layDefinition":   {Short: "laydef", Label: "Lay definition of spirituality", Type: IntType},
+	"standardized":    {Short: "std", Label: "Standardized score", Type: FloatType},
+}
+
+// knownVariable returns the known variable of a column: a results column, a
+// rubric item, or a per-model ensemble score, comments, or sampling column.
+func knownVariable(name string) (Variable, bool) {
+	if v, ok := resultsVariables[name]; ok {
+		return v, true
+	}
+	for _, r := range []Rubric{HumilityRubric, SpiritualRubric} {
+		for _, item := range r.Items {
+			if item.Name == name {
+				return Variable{Short: name, Label: item.Statement, Type: IntType}, true
+			}
+		}
+	}
+	if model, ok := strings.CutPrefix(name, "score_"); ok {
+		return Variable{Short: "sc_" + model, Label: "Score of " + model, Type: FloatType}, true
+	}
+	if model, ok := strings.CutPrefix(name, "comments_"); ok {
+		return Variable{Short: "cm_" + model, Label: "Response of " + model, Type: StringType}, true
+	}
+	for _, column := range SampleCSVHeader {
+		if model, ok := strings.CutPrefix(name, column+"_"); ok {
+			v := resultsVariables[column]
+			return Variable{Short: v.Short + "_" + model, Label: v.Label + " (" + model + ")", Type: v.Type}, true
+		}
+	}
+	return Variable{}, false
+}
+
+// Variables returns the variables of the results columns. The types of
+// other columns, such as covariates, are inferred from their values: int or
+// float if they are all numbers, bool if they are all true or false, and
+// string otherwise. A known column whose values don't all parse as its type,
+// such as a pid of 4.5, is widened rather than losing them: an int column to
+// float if they are all numbers, and any other to string.
+func (r Results) Variables() []Variable {
+	vars := make([]Variable, len(r.Header))
+	used := make(map[string]bool, len(r.Header))
+	for i, name := range r.Header {
+		v, _ := knownVariable(name)
+		v.Name = name
+		if v.Label == "" {
+			v.Label = name
+		}
+		if v.Short == "" {
+			v.Short = name
+		}
+		v.Short = shortName(v.Short, used)
+		switch {
+		case v.Type == "":
+			v.Type = r.inferType(i)
+		case r.fits(i, v.Type):
+		case v.Type == IntType && r.fits(i, FloatType):
+			v.Type = FloatType
+		default:
+			v.Type = StringType
+		}
+		for _, row := range r.Rows {
+			if i < len(row) && len(row[i]) > v.Width {
+				v.Width = len(row[i])
+			}
+		}
+		vars[i] = v
+	}
+	return vars
+}
+
+// inferType infers the type of a column from its values.
+func (r Results) inferType(column int) string {
+	isInt, isFloat, isBool, found := true, true, true, false
+	for _, row := range r.Rows {
+		if column >= len(row) || row[column] == "" {
+			continue
+		}
+		s := row[column]
+		found = true
+		if _, err := strconv.ParseInt(s, 10, 64); err != nil {
+			isInt = false
+		}
+		if _, err := strconv.ParseFloat(s, 64); err != nil {
+			isFloat = false
+		}
+		if !strings.EqualFold(s, "true") && !strings.EqualFold(s, "false") {
+			isBool = false
+		}
+	}
+	switch {
+	case !found:
+		return StringType
+	case isInt:
+		return IntType
+	case isFloat:
+		return FloatType
+	case isBool:
+		return BoolType
+	default:
+		return StringType
+	}
+}
+
+// fits returns true if every value of a column parses as the type.
+func (r Results) fits(column int, typ string) bool {
+	for _, row := range r.Rows {
+		if column >= len(row) || row[column] == "" {
+			continue
+		}
+		s := row[column]
+		switch typ {
+		case IntType:
+			if _, err := strconv.ParseInt(s, 10, 64); err != nil {
+				return false
+			}
+		case FloatType:
+			if _, err := strconv.ParseFloat(s, 64); err != nil {
+				return false
+			}
+		case BoolType:
+			if !strings.EqualFold(s, "true") && !strings.EqualFold(s, "false") {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// field returns the field of a row, or "" if the row is short.
+func (r Results) field(row, column int) string {
+	if column < len(r.Rows[row]) {
+		return r.Rows[row][column]
+	}
+	return ""
+}
+
+// maxShortName is the longest variable name that Stata allows.
+const maxShortName = 32
+
+var (
+	nonVariableChars = regexp.MustCompile(`[^a-z0-9_]+`)
+	reservedNames    = map[string]bool{"_all": true, "_b": true, "byte": true, "_coef": true, "_cons": true,
+		"double": true, "float": true, "if": true, "in": true, "int": true, "long": true, "_n": true, "_pi": true,
+		"_pred": true, "_rc": true, "_skip": true, "strl": true, "using": true, "with": true}
+)
+
+// shortName returns a variable name that R, SPSS, and Stata all accept: at
+// most 32 lowercase letters, digits, and underscores, starting with a letter,
+// and not already used.
+func shortName(name string, used map[string]bool) string {
+	name = strings.Trim(nonVariableChars.ReplaceAllString(strings.ToLower(name), "_"), "_")
+	if name == "" || name[0] < 'a' || name[0] > 'z' || reservedNames[name] {
+		name = "v_" + name
+	}
+	if len(name) > maxShortName {
+		name = strings.TrimRight(name[:maxShortName], "_")
+	}
+	short := name
+	for n := 2; used[short]; n++ {
+		suffix := "_" + strconv.Itoa(n)
+		if len(name)+len(suffix) > maxShortName {
+			short = name[:maxShortName-len(suffix)] + suffix
+		} else {
+			short = name + suffix
+		}
+	}
+	used[short] = true
+	return short
+}
+
+// parseFloat parses a float field, returning false if it is missing or not a
+// finite number.
+func parseFloat(s string) (float64, bool) {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, false
+	}
+	return f, true
+}
+
+// flattenText replaces every run of whitespace, including newlines, with a
+// single space.
+func flattenText(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// writeResultsCSV writes the results to a CSV file as they are.
+func writeResultsCSV(path string, r Results) error {
+	records := make([][]string, 0, len(r.Rows)+1)
+	records = append(records, r.Header)
+	records = append(records, r.Rows...)
+	return WriteCSVFile(path, records)
+}
+
+// writeResultsJSONL writes the results to a JSONL file of typed objects, one
+// per row, with the columns in order, and the row's exchanges, if any.
+func writeResultsJSONL(path string, r Results) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("write jsonl file %s: %w", path, err)
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	vars := r.Variables()
+	var b bytes.Buffer
+	for i := range r.Rows {
+		b.Reset()
+		b.WriteByte('{')
+		for j, v := range vars {
+			if j > 0 {
+				b.WriteByte(',')
+			}
+			key, _ := json.Marshal(v.Name)
+			b.Write(key)
+			b.WriteByte(':')
+			b.Write(jsonValue(v.Type, r.field(i, j)))
+		}
+		if i < len(r.Exchanges) && len(r.Exchanges[i]) > 0 {
+			exchanges, err := json.Marshal(r.Exchanges[i])
+			if err != nil {
+				return fmt.Errorf("write jsonl file %s: row %d: %w", path, i+1, err)
+			}
+			b.WriteString(`,"exchanges":`)
+			b.Write(exchanges)
+		}
+		b.WriteString("}\n")
+		if _, err := w.Write(b.Bytes()); err != nil {
+			return fmt.Errorf("write jsonl file %s: %w", path, err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("write jsonl file %s: %w", path, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("write jsonl file %s: %w", path, err)
+	}
+	return nil
+}
+
+// jsonValue returns the JSON value of a field of a type; a missing value is
+// null.
+func jsonValue(typ, s string) []byte {
+	if s == "" {
+		return []byte("null")
+	}
+	switch typ {
+	case IntType:
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return strconv.AppendInt(nil, n, 10)
+		}
+	case FloatType:
+		if f, ok := parseFloat(s); ok {
+			return strconv.AppendFloat(nil, f, 'g', -1, 64)
+		}
+	case BoolType:
+		return strconv.AppendBool(nil, strings.EqualFold(s, "true"))
+	default:
+		j, _ := json.Marshal(s)
+		return j
+	}
+	return []byte("null")
+}
+
+// CodebookPath returns the path of the codebook of a stats results file,
+// e.g. "scores_codebook.csv" for "scores.csv".
+func CodebookPath(path string) string {
+	return strings.TrimSuffix(path, filepath.Ext(path)) + "_codebook.csv"
+}
+
+// writeResultsStats writes the results to a CSV file that R, SPSS, and Stata
+// import as is: short variable names, text without newlines, booleans as 0
+// or 1, and missing values empty. The codebook of the variables is written
+// to CodebookPath(path).
+func writeResultsStats(path string, r Results) error {
+	vars := r.Variables()
+	records := make([][]string, 0, len(r.Rows)+1)
+	header := make([]string, len(vars))
+	for j, v := range vars {
+		header[j] = v.Short
+	}
+	records = append(records, header)
+	for i := range r.Rows {
+		record := make([]string, len(vars))
+		for j, v := range vars {
+			s := r.field(i, j)
+			switch v.Type {
+			case FloatType:
+				if _, ok := parseFloat(s); !ok {
+					s = ""
+				}
+			case BoolType:
+				if s != "" {
+					s = "0"
+					if strings.EqualFold(r.field(i, j), "true") {
+						s = "1"
+					}
+				}
+			case StringType:
+				s = flattenText(s)
+			}
+			record[j] = s
+		}
+		records = append(records, record)
+	}
+	if err := WriteCSVFile(path, records); err != nil {
+		return err
+	}
+	return writeCodebook(CodebookPath(path), vars)
+}
+
+// writeCodebook writes the codebook of the variables to a CSV file.
+func writeCodebook(path string, vars []Variable) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("write codebook %s: %w", path, err)
+	}
+	defer f.Close()
+	w := csv.NewWriter(f)
+	_ = w.Write([]string{"variable", "column", "type", "width", "label"})
+	for _, v := range vars {
+		_ = w.Write([]string{v.Short, v.Name, v.Type, strconv.Itoa(v.Width), v.Label})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return fmt.Errorf("write codebook %s: %w", path, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("write codebook %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,233 @@
+package data
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testEssay is an essay too long for a Stata fixed-width string.
+var testEssay = strings.Repeat("x", 3000)
+
+// testResults returns results with known and inferred columns of every
+// type, missing values, and text with newlines and commas.
+func testResults() Results {
+	return Results{Table: Table{
+		Header: []string{"pid", "essay_type", "essay", "score", "review", "age", "weight", "note"},
+		Rows: [][]string{
+			{"1", "dream", "Short essay\nwith a newline", "0.50", "true", "34", "61.5", "ok"},
+			{"2", "dream", testEssay, "", "false", "", "70", ""},
+			{"3", "dream", "", "-0.25", "", "29", "", "note, with comma"},
+		},
+	}}
+}
+
+func TestResultsFormat(t *testing.T) {
+	tests := []struct {
+		path, format string
+	}{
+		{"scores.csv", CSVFormat},
+		{"scores.jsonl", JSONLFormat},
+		{"scores.NDJSON", JSONLFormat},
+		{"scores.parquet", ParquetFormat},
+		{"scores.dta", StataFormat},
+		{"scores.tsv", CSVFormat},
+		{"scores", CSVFormat},
+	}
+	for _, tt := range tests {
+		if got := ResultsFormat(tt.path); got != tt.format {
+			t.Errorf("ResultsFormat(%q) = %q, want %q", tt.path, got, tt.format)
+		}
+	}
+	if err := WriteResults(filepath.Join(t.TempDir(), "scores.csv"), "xlsx", testResults()); err == nil {
+		t.Error("WriteResults with an unknown format: no error")
+	}
+}
+
+func TestResultsVariables(t *testing.T) {
+	want := []struct {
+		short, typ string
+	}{
+		{"pid", IntType},
+		{"etype", StringType},
+		{"essay", StringType},
+		{"score", FloatType},
+		{"review", BoolType},
+		{"age", IntType},
+		{"weight", FloatType},
+		{"note", StringType},
+	}
+	vars := testResults().Variables()
+	if len(vars) != len(want) {
+		t.Fatalf("got %d variables, want %d", len(vars), len(want))
+	}
+	for i, v := range vars {
+		if v.Short != want[i].short || v.Type != want[i].typ {
+			t.Errorf("variable %s: got %s %s, want %s %s", v.Name, v.Short, v.Type, want[i].short, want[i].typ)
+		}
+	}
+	if vars[2].Width != len(testEssay) {
+		t.Errorf("essay width = %d, want %d", vars[2].Width, len(testEssay))
+	}
+
+	// An empty file has the fixed types of the known columns:
+	empty := Results{Table: Table{Header: []string{"pid", "comments", "other"}}}
+	for i, typ := range []string{IntType, StringType, StringType} {
+		if v := empty.Variables()[i]; v.Type != typ {
+			t.Errorf("empty %s: type %s, want %s", v.Name, v.Type, typ)
+		}
+	}
+}
+
+func TestResultsVariablesWiden(t *testing.T) {
+	r := Results{Table: Table{
+		Header: []string{"pid", "hum1", "review", "score"},
+		Rows:   [][]string{{"4", "3", "true", "0.5"}, {"4.5", "n/a", "maybe", "high"}},
+	}}
+	for i, typ := range []string{FloatType, StringType, StringType, StringType} {
+		if v := r.Variables()[i]; v.Type != typ {
+			t.Errorf("%s: type %s, want %s", v.Name, v.Type, typ)
+		}
+	}
+
+	// No value is lost:
+	path := filepath.Join(t.TempDir(), "scores.jsonl")
+	if err := WriteResults(path, "", r); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if want := `{"pid":4.5,"hum1":"n/a","review":"maybe","score":"high"}`; len(lines) != 2 || lines[1] != want {
+		t.Errorf("got lines %q, want the second %s", lines, want)
+	}
+}
+
+func TestShortName(t *testing.T) {
+	used := map[string]bool{}
+	tests := []struct {
+		name, short string
+	}{
+		{"Essay Type", "essay_type"},
+		{"essay-type", "essay_type_2"},
+		{"1st draft", "v_1st_draft"},
+		{"int", "v_int"},
+		{"a_very_long_covariate_name_that_goes_on", "a_very_long_covariate_name_that"},
+		{"a_very_long_covariate_name_that_goes_on_and_on", "a_very_long_covariate_name_tha_2"},
+		{"", "v_"},
+	}
+	for _, tt := range tests {
+		if got := shortName(tt.name, used); got != tt.short {
+			t.Errorf("shortName(%q) = %q, want %q", tt.name, got, tt.short)
+		}
+	}
+}
+
+func TestWriteResultsJSONL(t *testing.T) {
+	r := testResults()
+	r.Exchanges = [][]Exchange{{{ID: "1", Request: map[string]string{"model": "gpt-4"}, Response: "0.5", Millis: 12}}}
+	path := filepath.Join(t.TempDir(), "scores.jsonl")
+	if err := WriteResults(path, "", r); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var rows []map[string]interface{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		var row map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &row); err != nil {
+			t.Fatalf("line %d: %v", len(rows)+1, err)
+		}
+		rows = append(rows, row)
+	}
+	want := []map[string]interface{}{
+		{"pid": 1.0, "essay_type": "dream", "essay": "Short essay\nwith a newline", "score": 0.5, "review": true,
+			"age": 34.0, "weight": 61.5, "note": "ok",
+			"exchanges": []interface{}{map[string]interface{}{"id": "1", "request": map[string]interface{}{"model": "gpt-4"},
+				"response": "0.5", "millis": 12.0}}},
+		{"pid": 2.0, "essay_type": "dream", "essay": testEssay, "score": nil, "review": false,
+			"age": nil, "weight": 70.0, "note": nil},
+		{"pid": 3.0, "essay_type": "dream", "essay": nil, "score": -0.25, "review": nil,
+			"age": 29.0, "weight": nil, "note": "note, with comma"},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("got rows\n%v\nwant\n%v", rows, want)
+	}
+}
+
+func TestWriteResultsStats(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "scores.csv")
+	if err := WriteResults(path, StatsFormat, testResults()); err != nil {
+		t.Fatal(err)
+	}
+	records := readTestCSV(t, path)
+	want := [][]string{
+		{"pid", "etype", "essay", "score", "review", "age", "weight", "note"},
+		{"1", "dream", "Short essay with a newline", "0.50", "1", "34", "61.5", "ok"},
+		{"2", "dream", testEssay, "", "0", "", "70", ""},
+		{"3", "dream", "", "-0.25", "", "29", "", "note, with comma"},
+	}
+	if !reflect.DeepEqual(records, want) {
+		t.Errorf("got records\n%q\nwant\n%q", records, want)
+	}
+
+	codebook := readTestCSV(t, CodebookPath(path))
+	if want := []string{"variable", "column", "type", "width", "label"}; !reflect.DeepEqual(codebook[0], want) {
+		t.Errorf("codebook header = %q, want %q", codebook[0], want)
+	}
+	if want := []string{"etype", "essay_type", StringType, "5", "Essay type"}; !reflect.DeepEqual(codebook[2], want) {
+		t.Errorf("codebook row = %q, want %q", codebook[2], want)
+	}
+	if len(codebook) != len(want[0])+1 {
+		t.Errorf("codebook has %d rows, want %d", len(codebook), len(want[0])+1)
+	}
+}
+
+// readTestCSV reads the records of a CSV file.
+func readTestCSV(t *testing.T, path string) [][]string {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	records, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return records
+}
+
+// readWithPython reads a results file with an independent reader: a Python
+// script, run with the file as its argument, that prints the file as JSON,
+// decoded into v. The test is skipped if python3 or the module the script
+// imports is not installed.
+func readWithPython(t *testing.T, module, script, path string, v interface{}) {
+	t.Helper()
+	if err := exec.Command("python3", "-c", "import "+module).Run(); err != nil {
+		t.Skipf("python3 with %s is not installed", module)
+	}
+	out, err := exec.Command("python3", "-c", script, path).Output()
+	if err != nil {
+		if e, ok := err.(*exec.ExitError); ok {
+			t.Fatalf("%s: %v\n%s", module, err, e.Stderr)
+		}
+		t.Fatalf("%s: %v", module, err)
+	}
+	if err := json.Unmarshal(out, v); err != nil {
+		t.Fatalf("%s: %v", module, err)
+	}
+}
@@ -0,0 +1,271 @@
+package data
+
+import (
+	"content-coding-gpt/pkg/openai"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// RubricItem is a statement that human coders rate for each essay.
+type RubricItem struct {
+	Name      string // CSV column, e.g. "hum1"
+	Statement string // statement rated on the Likert scale
+	Reverse   bool   // reverse-scored when computing the standardized score?
+}
+
+// Rubric is the multi-item coding scheme used by the human coders: each
+// statement is rated from Min (strongly disagree) to Max (strongly agree).
+type Rubric struct {
+	Name  string // "humility" or "spiritual"
+	Items []RubricItem
+	Min   int
+	Max   int
+}
+
+// HumilityRubric is the humility coding scheme (hum1-hum6); hum4-hum6 are reverse-scored.
+var HumilityRubric = Rubric{
+	Name: "humility",
+	Items: []RubricItem{
+		{Name: "hum1", Statement: "This person feels that, overall, they are no better or worse than the average person."},
+		{Name: "hum2", Statement: "This person feels that they have both many strengths and flaws."},
+		{Name: "hum3", Statement: "This person feels that they do not deserve more respect than other people."},
+		{Name: "hum4", Statement: "This person feels that they are better than most people.", Reverse: true},
+		{Name: "hum5", Statement: "This person feels that they deserve more respect than everyone else.", Reverse: true},
+		{Name: "hum6", Statement: "This person feels that they do not have very many weaknesses.", Reverse: true},
+	},
+	Min: 1,
+	Max: 7,
+}
+
+// SpiritualRubric is the spirituality coding scheme (spir1-spir4).
+var SpiritualRubric = Rubric{
+	Name: "spiritual",
+	Items: []RubricItem{
+		{Name: "spir1", Statement: "This person likely feels that on a higher level all of us share a common bond."},
+		{Name: "spir2", Statement: "This person likely feels that there is a higher plane of consciousness or spirituality that binds all people."},
+		{Name: "spir3", Statement: "This person likely feels that although dead, images of some of his/her relatives continue to influence his/her current life."},
+		{Name: "spir4", Statement: "This person likely feels that he or she is a link in the chain of his/her family's heritage, a bridge between past and future."},
+	},
+	Min: 1,
+	Max: 7,
+}
+
+// RubricFor returns the rubric for an essay type.
+func RubricFor(essayType string) (Rubric, error) {
+	switch {
+	case IsHumility(essayType):
+		return HumilityRubric, nil
+	case IsSpiritual(essayType):
+		return SpiritualRubric, nil
+	default:
+		return Rubric{}, fmt.Errorf("essay type %s has no rubric", essayType)
+	}
+}
+
+// Range returns the allowed range of an item rating.
+func (r Rubric) Range() ScoreRange {
+	return ScoreRange{Min: float64(r.Min), Max: float64(r.Max)}
+}
+
+// scale describes the rating scale for a prompt.
+func (r Rubric) scale() string {
+	return fmt.Sprintf("on a scale from %d (strongly disagree) to %d (strongly agree)", r.Min, r.Max)
+}
+
+// essayText describes the writing prompt and essay for a rubric prompt.
+func (r Rubric) essayText(e EssayRecord, essayType string) string {
+	text := "A research study participant was given the following writing prompt:\n“"
+	text += EssayPrompts[canonicalEssayType(essayType)]
+	text += "”\n\nThe participant wrote the following:\n“"
+	text += e.SelectEssay(essayType)
+	text += "”\n\n"
+	return text
+}
+
+// ChatRequest generates a single structured chat request that rates every item,
+// answered as a JSON object of item names to ratings.
+func (r Rubric) ChatRequest(e EssayRecord, essayType string, model string, temperature float32, maxTokens int) openai.ChatRequest {
+	prompt := r.essayText(e, essayType)
+	prompt += "Please rate how well each of the following statements describes the participant, "
+	prompt += r.scale() + ":\n"
+	for _, item := range r.Items {
+		prompt += fmt.Sprintf("%s: “%s”\n", item.Name, item.Statement)
+	}
+	prompt += "\nRespond with a JSON object mapping each statement's name to its integer rating, "
+	prompt += "for example {"
+	for i, item := range r.Items {
+		if i > 0 {
+			prompt += ", "
+		}
+		prompt += fmt.Sprintf("%q: %d", item.Name, (r.Min+r.Max)/2)
+	}
+	prompt += "}, and nothing else.\n"
+	return openai.ChatRequest{
+		Model:       model,
+		Messages:    []openai.Message{SystemMessage, {Role: openai.USER, Content: prompt}},
+		Temperature: temperature,
+		MaxTokens:   maxTokens,
+		User:        strconv.Itoa(e.ID),
+	}
+}
+
+// ItemChatRequest generates a chat request that rates a single item, answered
+// with "Score: X" after a brief explanation.
+func (r Rubric) ItemChatRequest(e EssayRecord, essayType string, item RubricItem, model string, temperature float32, maxTokens int) openai.ChatRequest {
+	prompt := r.essayText(e, essayType)
+	prompt += fmt.Sprintf("Please rate how well the following statement describes the participant, %s:\n“%s”\n\n",
+		r.scale(), item.Statement)
+	prompt += "Your response should briefly explain your rating, and end with the rating in this format, "
+	prompt += "\"Score: X\", where X is a single digit.\n"
+	return openai.ChatRequest{
+		Model:       model,
+		Messages:    []openai.Message{SystemMessage, {Role: openai.USER, Content: prompt}},
+		Temperature: temperature,
+		MaxTokens:   maxTokens,
+		User:        strconv.Itoa(e.ID),
+	}
+}
+
+// ExtractRatings extracts the item ratings from a structured chat response.
+func (r Rubric) ExtractRatings(chat openai.ChatResponse) ([]int, error) {
+	content, err := chat.FirstMessageContent()
+	if err != nil {
+		return nil, err
+	}
+	ratings := make([]int, len(r.Items))
+	for i, item := range r.Items {
+		extractor := JSONExtractor{Field: item.Name, Range: r.Range()}
+		ratings[i], err = extractRating(extractor, content)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", item.Name, err)
+		}
+	}
+	return ratings, nil
+}
+
+// ExtractItemRating extracts a single item rating from a per-item chat response.
+func (r Rubric) ExtractItemRating(chat openai.ChatResponse) (int, error) {
+	content, err := chat.FirstMessageContent()
+	if err != nil {
+		return 0, err
+	}
+	extractor, _ := NewScoreExtractor("label:Score", r.Range())
+	return extractRating(extractor, content)
+}
+
+// extractRating extracts a rating, rounded to the nearest integer.
+func extractRating(extractor ScoreExtractor, content string) (int, error) {
+	score, err := extractor.Extract(content)
+	if err != nil {
+		return 0, err
+	}
+	return int(math.Round(float64(score))), nil
+}
+
+// FormatRatings returns the ratings as a string, e.g. "hum1=5 hum2=6 ...".
+func (r Rubric) FormatRatings(ratings []int) string {
+	fields := make([]string, len(ratings))
+	for i, rating := range ratings {
+		fields[i] = fmt.Sprintf("%s=%d", r.Items[i].Name, rating)
+	}
+	return strings.Join(fields, " ")
+}
+
+// RawScore returns the mean item rating, with reverse-scored items reflected
+// (e.g. 8-x on a 1-7 scale).
+func (r Rubric) RawScore(ratings []int) float64 {
+	var sum float64
+	for i, item := range r.Items {
+		if i >= len(ratings) {
+			break
+		}
+		rating := float64(ratings[i])
+		if item.Reverse {
+			rating = float64(r.Min+r.Max) - rating
+		}
+		sum += rating
+	}
+	return sum / float64(len(r.Items))
+}
+
+// Standardize converts raw scores to z-scores, using the sample mean and
+// standard deviation, as in the human-coded training data.
+func Standardize(raw []float64) []float64 {
+	z := make([]float64, len(raw))
+	if len(raw) < 2 {
+		return z
+	}
+	var mean float64
+	for _, x := range raw {
+		mean += x
+	}
+	mean /= float64(len(raw))
+	var ss float64
+	for _, x := range raw {
+		ss += (x - mean) * (x - mean)
+	}
+	sd := math.Sqrt(ss / float64(len(raw)-1))
+	if sd == 0 {
+		return z
+	}
+	for i, x := range raw {
+		z[i] = (x - mean) / sd
+	}
+	return z
+}
+
+// RubricScore is the item ratings for an essay.
+type RubricScore struct {
+	ID       int
+	Response string
+	Ratings  []int
+	Std      float64
+}
+
+// WriteRubricScores standardizes the scores and writes them to a CSV file, with
+// the columns of RubricResults.
+func WriteRubricScores(path string, r Rubric, scores []RubricScore) error {
+	results, err := RubricResults(r, scores)
+	if err != nil {
+		return err
+	}
+	return WriteResults(path, CSVFormat, results)
+}
+
+// RubricResults standardizes the scores and returns their results, with the
+// same columns as the human-coded humility or spiritual training data. The
+// spiritual layDefinition column, which is not a rubric item, is left empty.
+func RubricResults(r Rubric, scores []RubricScore) (Results, error) {
+	raw := make([]float64, len(scores))
+	for i, s := range scores {
+		raw[i] = r.RawScore(s.Ratings)
+	}
+	for i, z := range Standardize(raw) {
+		scores[i].Std = z
+	}
+	switch r.Name {
+	case HumilityRubric.Name:
+		records := make([]HumilityRecord, len(scores))
+		for i, s := range scores {
+			records[i] = HumilityRecord{ID: s.ID, Response: s.Response,
+				S1: s.Ratings[0], S2: s.Ratings[1], S3: s.Ratings[2],
+				S4: s.Ratings[3], S5: s.Ratings[4], S6: s.Ratings[5], Std: s.Std}
+		}
+		return HumilityResults(records), nil
+	case SpiritualRubric.Name:
+		records := make([]SpiritualRecord, len(scores))
+		for i, s := range scores {
+			records[i] = SpiritualRecord{ID: s.ID, Response: s.Response,
+				S1: s.Ratings[0], S2: s.Ratings[1], S3: s.Ratings[2], S4: s.Ratings[3], Std: s.Std}
+		}
+		results := SpiritualResults(records)
+		for _, row := range results.Rows {
+			row[2] = ""
+		}
+		return results, nil
+	default:
+		return Results{}, fmt.Errorf("rubric results: unknown rubric %s", r.Name)
+	}
+}
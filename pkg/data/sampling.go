@@ -0,0 +1,141 @@
+package data
+
+import (
+	"content-coding-gpt/pkg/openai"
+	"content-coding-gpt/pkg/stats"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Self-consistency sampling modes.
+const (
+	SampleChoices = "n"      // one request for N choices
+	SampleRepeat  = "repeat" // N repeated requests
+)
+
+// SampleCSVHeader is the header of the sampling columns written by
+// WriteEssayScores when self-consistency sampling is used.
+var SampleCSVHeader = []string{"n_samples", "median", "sd", "review", "samples", "sample_comments"}
+
+// Sampling configures self-consistency sampling: N samples are scored for
+// each essay, and the essay is flagged for human review if the sample
+// standard deviation exceeds ReviewSD, or fewer than N samples are valid.
+type Sampling struct {
+	N        int     // number of samples per essay
+	Mode     string  // SampleChoices or SampleRepeat
+	ReviewSD float64 // standard deviation that flags an essay for review
+}
+
+// Requests returns the chat requests needed to sample a request: one request
+// with N choices, or N copies of the request.
+func (s Sampling) Requests(request openai.ChatRequest) []openai.ChatRequest {
+	if s.N <= 1 {
+		return []openai.ChatRequest{request}
+	}
+	if s.Mode == SampleRepeat {
+		requests := make([]openai.ChatRequest, s.N)
+		for i := range requests {
+			requests[i] = request
+		}
+		return requests
+	}
+	request.N = s.N
+	return []openai.ChatRequest{request}
+}
+
+// NewSampledEssayScore creates an EssayScore from every choice of the sampled
+// chat responses. The score is the mean of the extracted sample scores, the
+// comments are those of the first choice, and the raw response of every
+// choice is kept in SampleComments. Samples without a valid score are
+// ignored, and the essay is flagged for review, but an error wrapping the
+// extraction errors is returned if there are none.
+func NewSampledEssayScore(essay EssayRecord, essayType string, responses []openai.ChatResponse, extractor ScoreExtractor, millis int64, s Sampling) (EssayScore, error) {
+	score := EssayScore{
+		ID:        essay.ID,
+		EssayType: essayType,
+		Essay:     essay.SelectEssay(essayType),
+		Millis:    millis,
+		Extra:     essay.Extra,
+	}
+	for _, response := range responses {
+		for _, choice := range response.Choices {
+			if score.Comments == "" {
+				score.Comments = choice.Message.Content
+			}
+			score.SampleComments = append(score.SampleComments, choice.Message.Content)
+		}
+	}
+	err := s.ScoreSamples(&score, extractor)
+	return score, err
+}
+
+// ScoreSamples extracts the sample scores of an EssayScore from its
+// SampleComments, and sets its Samples, Score, Median, SD, and Review as
+// NewSampledEssayScore does.
+func (s Sampling) ScoreSamples(score *EssayScore, extractor ScoreExtractor) error {
+	score.Samples, score.Score, score.Median, score.SD = nil, 0, 0, 0
+	var samples []float64
+	var errs []error
+	for _, comments := range score.SampleComments {
+		v, err := extractor.Extract(comments)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		samples = append(samples, float64(v))
+		score.Samples = append(score.Samples, v)
+	}
+	score.Review = true
+	if len(samples) == 0 {
+		if len(errs) == 0 {
+			return errors.New("chat: no choices found")
+		}
+		return fmt.Errorf("no valid samples: %w", errors.Join(errs...))
+	}
+	score.Score = float32(stats.Mean(samples))
+	score.Median = float32(stats.Median(samples))
+	if sd := stats.SD(samples); !math.IsNaN(sd) {
+		score.SD = float32(sd)
+	}
+	score.Review = (s.ReviewSD > 0 && float64(score.SD) > s.ReviewSD) || len(samples) < s.N
+	return nil
+}
+
+// ParseSampleComments parses the sample_comments field written by
+// SampleCSVFields.
+func ParseSampleComments(field string) ([]string, error) {
+	if strings.TrimSpace(field) == "" {
+		return nil, nil
+	}
+	var comments []string
+	if err := json.Unmarshal([]byte(field), &comments); err != nil {
+		return nil, fmt.Errorf("invalid sample_comments %q: %w", field, err)
+	}
+	return comments, nil
+}
+
+// SampleCSVFields returns the sampling fields of an EssayScore. The sample
+// comments are a JSON array of strings.
+func (s EssayScore) SampleCSVFields() []string {
+	samples := make([]string, len(s.Samples))
+	for i, v := range s.Samples {
+		samples[i] = strconv.FormatFloat(float64(v), 'f', -1, 32)
+	}
+	comments := ""
+	if len(s.SampleComments) > 0 {
+		j, _ := json.Marshal(s.SampleComments)
+		comments = string(j)
+	}
+	return []string{
+		strconv.Itoa(len(s.Samples)),
+		strconv.FormatFloat(float64(s.Median), 'f', 2, 32),
+		strconv.FormatFloat(float64(s.SD), 'f', 3, 32),
+		strconv.FormatBool(s.Review),
+		strings.Join(samples, " "),
+		comments,
+	}
+}
@@ -0,0 +1,184 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultEssayFile is the essay input file used when none is specified.
+const DefaultEssayFile = "data/original/essays.csv"
+
+// EssaySchema maps the columns of an essay input file to an EssayRecord.
+type EssaySchema struct {
+	// IDColumn is the name of the participant ID column, e.g. "pid".
+	IDColumn string
+
+	// EssayColumns maps each essay type to the name of its text column. If
+	// empty, every column other than the ID and extra columns is an essay,
+	// and its essay type is the lowercase column name.
+	EssayColumns map[string]string
+
+	// ExtraColumns are covariate columns carried through to the results.
+	ExtraColumns []string
+}
+
+// DefaultEssaySchema describes the layout of data/original/essays.csv:
+// pid,dream,dejavu,conflict,award
+var DefaultEssaySchema = EssaySchema{
+	IDColumn: "pid",
+	EssayColumns: map[string]string{
+		"dream":    "dream",
+		"dejavu":   "dejavu",
+		"conflict": "conflict",
+		"award":    "award",
+	},
+}
+
+// NewEssaySchema creates an EssaySchema from an ID column, a map of essay types
+// to text columns, and a list of extra columns. An empty ID column falls back
+// to "pid", and empty essay columns are inferred from the file header.
+func NewEssaySchema(idColumn string, essayColumns map[string]string, extraColumns []string) (EssaySchema, error) {
+	schema := EssaySchema{
+		IDColumn:     DefaultEssaySchema.IDColumn,
+		ExtraColumns: extraColumns,
+	}
+	if idColumn != "" {
+		schema.IDColumn = idColumn
+	}
+	if len(essayColumns) > 0 {
+		schema.EssayColumns = make(map[string]string, len(essayColumns))
+		for essayType, column := range essayColumns {
+			essayType = EssayTypeName(essayType)
+			if essayType == "" || strings.TrimSpace(column) == "" {
+				return schema, fmt.Errorf("essay schema: invalid essay column %s=%s", essayType, column)
+			}
+			schema.EssayColumns[essayType] = column
+		}
+	}
+	return schema, nil
+}
+
+// EssayTypes returns the essay types provided by the schema, sorted by name.
+func (s EssaySchema) EssayTypes() []string {
+	types := make([]string, 0, len(s.EssayColumns))
+	for essayType := range s.EssayColumns {
+		types = append(types, essayType)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// HasEssayType returns true if the schema provides the specified essay type.
+// Note that "conflict" and "angry" are equivalent.
+func (s EssaySchema) HasEssayType(essayType string) bool {
+	_, ok := s.EssayColumns[canonicalEssayType(essayType)]
+	return ok
+}
+
+// Resolve returns a copy of the schema with the essay columns inferred from the
+// header, if they were not specified.
+func (s EssaySchema) Resolve(header []string) EssaySchema {
+	if len(s.EssayColumns) > 0 {
+		return s
+	}
+	table := Table{Header: header}
+	skip := map[int]bool{table.ColumnIndex(s.IDColumn): true}
+	for _, column := range s.ExtraColumns {
+		skip[table.ColumnIndex(column)] = true
+	}
+	s.EssayColumns = map[string]string{}
+	for i, column := range header {
+		essayType := EssayTypeName(column)
+		if skip[i] || essayType == "" {
+			continue
+		}
+		s.EssayColumns[essayType] = column
+	}
+	return s
+}
+
+// RecordReader returns a constructor that converts rows with the specified
+// header into EssayRecords. An error is returned if a mapped column is missing.
+func (s EssaySchema) RecordReader(header []string) (func([]string) (EssayRecord, error), error) {
+	s = s.Resolve(header)
+	table := Table{Header: header}
+
+	// Locate the columns:
+	idIndex := table.ColumnIndex(s.IDColumn)
+	if idIndex < 0 {
+		return nil, fmt.Errorf("essay schema: id column %q not found", s.IDColumn)
+	}
+	essayIndex := make(map[string]int, len(s.EssayColumns))
+	for essayType, column := range s.EssayColumns {
+		i := table.ColumnIndex(column)
+		if i < 0 {
+			return nil, fmt.Errorf("essay schema: %s column %q not found", essayType, column)
+		}
+		essayIndex[essayType] = i
+	}
+	extraIndex := make(map[string]int, len(s.ExtraColumns))
+	for _, column := range s.ExtraColumns {
+		i := table.ColumnIndex(column)
+		if i < 0 {
+			return nil, fmt.Errorf("essay schema: extra column %q not found", column)
+		}
+		extraIndex[column] = i
+	}
+
+	// Create the constructor:
+	return func(fields []string) (EssayRecord, error) {
+		var record EssayRecord
+		var err error
+		field := func(i int) string {
+			if i < len(fields) {
+				return fields[i]
+			}
+			return ""
+		}
+		record.ID, err = ParseID(field(idIndex))
+		if err != nil {
+			return record, err
+		}
+		record.Essays = make(map[string]string, len(essayIndex))
+		for essayType, i := range essayIndex {
+			if essay := CleanResponse(field(i)); essay != "" {
+				record.Essays[essayType] = essay
+			}
+		}
+		if len(record.Essays) == 0 {
+			return record, errors.New("no essays found")
+		}
+		if len(extraIndex) > 0 {
+			record.Extra = make(map[string]string, len(extraIndex))
+			for column, i := range extraIndex {
+				record.Extra[column] = strings.TrimSpace(field(i))
+			}
+		}
+		return record, nil
+	}, nil
+}
+
+// EssayTypeName normalizes an essay type name taken from a column header, file
+// name, or spreadsheet: it is lowercased and whitespace is removed, so that
+// "Dream" is "dream" and "b 1" is "b1". Note that "angry" becomes "conflict".
+func EssayTypeName(s string) string {
+	return canonicalEssayType(strings.ToLower(strings.Join(strings.Fields(s), "")))
+}
+
+// ParseID parses a participant ID. Spreadsheets often store integer IDs as
+// floating-point numbers (e.g. "2101.0"), so integral floats are accepted.
+func ParseID(s string) (int, error) {
+	s = strings.TrimSpace(s)
+	if id, err := strconv.Atoi(s); err == nil {
+		return id, nil
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || f != math.Trunc(f) {
+		return 0, fmt.Errorf("invalid pid %q", s)
+	}
+	return int(f), nil
+}
@@ -166,12 +166,18 @@ func ReadSpiritualRecords(path string) ([]SpiritualRecord, error) {
 	return ReadCSVRecords(path, NewSpiritualRecordCSV)
 }
 
-// WriteSpiritualRecords writes a slice of SpiritualRecords to a CSV file.
-func WriteSpiritualRecords(path string, records []SpiritualRecord) error {
-	csvRecords := make([][]string, len(records)+1)
-	csvRecords[0] = SpiritualCSVHeader
+// SpiritualResults returns the results of a slice of SpiritualRecords.
+func SpiritualResults(records []SpiritualRecord) Results {
+	var results Results
+	results.Header = SpiritualCSVHeader
+	results.Rows = make([][]string, len(records))
 	for i, r := range records {
-		csvRecords[i+1] = r.CSVFields()
+		results.Rows[i] = r.CSVFields()
 	}
-	return WriteCSVFile(path, csvRecords)
+	return results
+}
+
+// WriteSpiritualRecords writes a slice of SpiritualRecords to a CSV file.
+func WriteSpiritualRecords(path string, records []SpiritualRecord) error {
+	return WriteResults(path, CSVFormat, SpiritualResults(records))
 }
@@ -0,0 +1,332 @@
+package data
+
+import (
+	"content-coding-gpt/pkg/stats"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Split names.
+const (
+	TrainSplit      = "train"
+	ValidationSplit = "validation"
+	TestSplit       = "test"
+)
+
+// SplitRows is a split of a training CSV file: its header and rows.
+type SplitRows struct {
+	Name   string
+	Header []string
+	Rows   [][]string
+}
+
+// Splitter splits the rows of a humility or spiritual training CSV file into
+// train, validation, and test sets, stratified on the standardized score.
+// Rows with the same pid are kept together, so no participant's essays leak
+// across splits. The splits are reproducible for a given Seed.
+type Splitter struct {
+	Validation float64 `json:"validation"` // fraction of participants in the validation set
+	Test       float64 `json:"test"`       // fraction of participants in the test set
+	Strata     int     `json:"strata"`     // number of standardized score strata (quantile bins)
+	Seed       int64   `json:"seed"`
+}
+
+// splitGroup is a participant's rows and mean standardized score.
+type splitGroup struct {
+	rows    []int
+	score   float64
+	stratum int
+}
+
+// ReadSplitTable reads a humility or spiritual training CSV file, returning
+// the table and the indices of its pid and standardized columns.
+func ReadSplitTable(path string) (Table, int, int, error) {
+	fileType, err := IdentifyCSVFile(path)
+	if err != nil {
+		return Table{}, 0, 0, err
+	}
+	if fileType != "humility" && fileType != "spiritual" {
+		return Table{}, 0, 0, fmt.Errorf("split %s: unexpected file type %s", path, fileType)
+	}
+	table, err := ReadTableFile(path)
+	if err != nil {
+		return table, 0, 0, err
+	}
+	idIndex, scoreIndex := table.ColumnIndex("pid"), table.ColumnIndex("standardized")
+	if idIndex < 0 || scoreIndex < 0 {
+		return table, 0, 0, fmt.Errorf("split %s: no pid or standardized column", path)
+	}
+	return table, idIndex, scoreIndex, nil
+}
+
+// groups groups the rows of a table by pid, and assigns each group to a
+// standardized score stratum. Groups without a numeric score, including short
+// rows without a standardized field, form an extra stratum of their own. An
+// error is returned for a row without a pid field.
+func (s Splitter) groups(table Table, idIndex, scoreIndex int) ([]*splitGroup, error) {
+	byID := map[string]*splitGroup{}
+	var groups []*splitGroup
+	sums := map[*splitGroup][]float64{}
+	for i, row := range table.Rows {
+		if idIndex >= len(row) {
+			return nil, fmt.Errorf("split: row %d has %d fields, and no pid", i+1, len(row))
+		}
+		id := strings.TrimSpace(row[idIndex])
+		g, ok := byID[id]
+		if !ok {
+			g = &splitGroup{}
+			byID[id] = g
+			groups = append(groups, g)
+		}
+		g.rows = append(g.rows, i)
+		if scoreIndex >= len(row) {
+			continue
+		}
+		if v, err := strconv.ParseFloat(strings.TrimSpace(row[scoreIndex]), 64); err == nil && !math.IsNaN(v) {
+			sums[g] = append(sums[g], v)
+		}
+	}
+	var scored []*splitGroup
+	for _, g := range groups {
+		if v, ok := sums[g]; ok {
+			g.score = stats.Mean(v)
+			scored = append(scored, g)
+		} else {
+			g.stratum = -1
+		}
+	}
+	strata := s.Strata
+	if strata < 1 {
+		strata = 1
+	}
+	scores := make([]float64, len(scored))
+	for i, g := range scored {
+		scores[i] = g.score
+	}
+	for i, bin := range stats.QuantileBins(scores, strata) {
+		scored[i].stratum = bin
+	}
+	return groups, nil
+}
+
+// strata returns the groups of each stratum, in stratum order, each shuffled.
+func (s Splitter) strata(groups []*splitGroup) [][]*splitGroup {
+	byStratum := map[int][]*splitGroup{}
+	for _, g := range groups {
+		byStratum[g.stratum] = append(byStratum[g.stratum], g)
+	}
+	keys := make([]int, 0, len(byStratum))
+	for k := range byStratum {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+	r := rand.New(rand.NewSource(s.Seed))
+	strata := make([][]*splitGroup, len(keys))
+	for i, k := range keys {
+		strata[i] = byStratum[k]
+		r.Shuffle(len(strata[i]), func(a, b int) { strata[i][a], strata[i][b] = strata[i][b], strata[i][a] })
+	}
+	return strata
+}
+
+// allocate returns the number of groups of each stratum to assign to each
+// split, given the strata sizes and the split fractions. Each split's total is
+// its largest-remainder share of all the groups, and each stratum's shares
+// are its floors plus the largest remainders that fit the totals, so small
+// strata don't all round the same way.
+func allocate(sizes []int, fractions []float64) [][]int {
+	var total int
+	for _, n := range sizes {
+		total += n
+	}
+	targets := largestRemainders(total, fractions)
+	counts := make([][]int, len(sizes))
+	rowLeft := make([]int, len(sizes))
+	colLeft := append([]int{}, targets...)
+	type cell struct {
+		stratum, split int
+		remainder      float64
+	}
+	var cells []cell
+	for i, n := range sizes {
+		counts[i] = make([]int, len(fractions))
+		rowLeft[i] = n
+		for j, f := range fractions {
+			share := float64(n) * f
+			counts[i][j] = int(share + 1e-9) // e.g. 10 * 0.7 is 6.999...
+			rowLeft[i] -= counts[i][j]
+			colLeft[j] -= counts[i][j]
+			cells = append(cells, cell{i, j, share - float64(counts[i][j])})
+		}
+	}
+	sort.SliceStable(cells, func(a, b int) bool { return cells[a].remainder > cells[b].remainder })
+	for _, c := range cells {
+		if rowLeft[c.stratum] > 0 && colLeft[c.split] > 0 {
+			counts[c.stratum][c.split]++
+			rowLeft[c.stratum]--
+			colLeft[c.split]--
+		}
+	}
+	// Assign any groups left over, since the totals always match:
+	for i := range counts {
+		for j := 0; rowLeft[i] > 0 && j < len(fractions); j++ {
+			n := rowLeft[i]
+			if colLeft[j] < n {
+				n = colLeft[j]
+			}
+			counts[i][j] += n
+			rowLeft[i] -= n
+			colLeft[j] -= n
+		}
+	}
+	return counts
+}
+
+// largestRemainders apportions n items by the fractions, which sum to 1,
+// using the largest remainder method.
+func largestRemainders(n int, fractions []float64) []int {
+	counts := make([]int, len(fractions))
+	remainders := make([]int, len(fractions))
+	left := n
+	for j, f := range fractions {
+		counts[j] = int(float64(n)*f + 1e-9)
+		left -= counts[j]
+		remainders[j] = j
+	}
+	sort.SliceStable(remainders, func(a, b int) bool {
+		ra := float64(n)*fractions[remainders[a]] - float64(counts[remainders[a]])
+		rb := float64(n)*fractions[remainders[b]] - float64(counts[remainders[b]])
+		return ra > rb
+	})
+	for k := 0; k < left; k++ {
+		counts[remainders[k%len(remainders)]]++
+	}
+	return counts
+}
+
+// assign allocates the groups of each stratum to the splits by the fractions,
+// returning the groups of each split. An error is returned if a split with a
+// positive fraction would be empty.
+func (s Splitter) assign(strata [][]*splitGroup, fractions []float64, names []string) ([][]*splitGroup, error) {
+	sizes := make([]int, len(strata))
+	var total int
+	for i, stratum := range strata {
+		sizes[i] = len(stratum)
+		total += len(stratum)
+	}
+	splits := make([][]*splitGroup, len(fractions))
+	for i, counts := range allocate(sizes, fractions) {
+		stratum := strata[i]
+		for j, n := range counts {
+			splits[j] = append(splits[j], stratum[:n]...)
+			stratum = stratum[n:]
+		}
+	}
+	for j, f := range fractions {
+		if f > 0 && len(splits[j]) == 0 {
+			return nil, fmt.Errorf("split: the %s set would be empty: %d participants are too few for a fraction of %g",
+				names[j], total, f)
+		}
+	}
+	return splits, nil
+}
+
+// splitRows returns the rows of the groups.
+func splitRows(table Table, name string, groups []*splitGroup) SplitRows {
+	split := SplitRows{Name: name, Header: table.Header}
+	for _, g := range groups {
+		for _, row := range g.rows {
+			split.Rows = append(split.Rows, table.Rows[row])
+		}
+	}
+	return split
+}
+
+// Split splits the table into train, validation, and test sets, omitting the
+// validation or test set if its fraction is zero. Each split has its share of
+// the participants, to the nearest participant, and of each stratum, and an
+// error is returned if a split would be empty.
+func (s Splitter) Split(table Table, idIndex, scoreIndex int) ([]SplitRows, error) {
+	if s.Validation < 0 || s.Test < 0 || s.Validation+s.Test >= 1 {
+		return nil, errors.New("split: validation and test fractions must be non-negative and sum to less than 1")
+	}
+	names := []string{TrainSplit, ValidationSplit, TestSplit}
+	fractions := []float64{1 - s.Validation - s.Test, s.Validation, s.Test}
+	all, err := s.groups(table, idIndex, scoreIndex)
+	if err != nil {
+		return nil, err
+	}
+	groups, err := s.assign(s.strata(all), fractions, names)
+	if err != nil {
+		return nil, err
+	}
+	var splits []SplitRows
+	for j, name := range names {
+		splits = append(splits, splitRows(table, name, groups[j]))
+	}
+	return nonEmptySplits(splits), nil
+}
+
+// Folds splits the table into k stratified folds after holding out the test
+// set, returning the train and validation sets of each fold, and the test
+// set (if any) last. The folds and the test set are allocated as in Split.
+func (s Splitter) Folds(table Table, idIndex, scoreIndex int, k int) ([]SplitRows, error) {
+	if k < 2 {
+		return nil, errors.New("split: at least 2 folds are required")
+	}
+	if s.Test < 0 || s.Test >= 1 {
+		return nil, errors.New("split: test fraction must be non-negative and less than 1")
+	}
+	fractions := make([]float64, k+1)
+	names := make([]string, k+1)
+	for f := 0; f < k; f++ {
+		fractions[f] = (1 - s.Test) / float64(k)
+		names[f] = fmt.Sprintf("fold%d_%s", f+1, ValidationSplit)
+	}
+	fractions[k], names[k] = s.Test, TestSplit
+	all, err := s.groups(table, idIndex, scoreIndex)
+	if err != nil {
+		return nil, err
+	}
+	groups, err := s.assign(s.strata(all), fractions, names)
+	if err != nil {
+		return nil, err
+	}
+	var splits []SplitRows
+	for f := 0; f < k; f++ {
+		var train []*splitGroup
+		for j := 0; j < k; j++ {
+			if j != f {
+				train = append(train, groups[j]...)
+			}
+		}
+		splits = append(splits,
+			splitRows(table, fmt.Sprintf("fold%d_%s", f+1, TrainSplit), train),
+			splitRows(table, names[f], groups[f]))
+	}
+	return nonEmptySplits(append(splits, splitRows(table, TestSplit, groups[k]))), nil
+}
+
+// nonEmptySplits returns the splits with rows.
+func nonEmptySplits(splits []SplitRows) []SplitRows {
+	var nonEmpty []SplitRows
+	for _, s := range splits {
+		if len(s.Rows) > 0 {
+			nonEmpty = append(nonEmpty, s)
+		}
+	}
+	return nonEmpty
+}
+
+// WriteSplitFile writes a split to a CSV file.
+func WriteSplitFile(path string, s SplitRows) error {
+	records := make([][]string, 0, len(s.Rows)+1)
+	records = append(records, s.Header)
+	records = append(records, s.Rows...)
+	return WriteCSVFile(path, records)
+}
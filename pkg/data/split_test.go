@@ -0,0 +1,72 @@
+package data
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// testSplitFile writes a humility training CSV file with a ragged row,
+// returning its path.
+func testSplitFile(t *testing.T) string {
+	t.Helper()
+	lines := []string{strings.Join(HumilityCSVHeader, ",")}
+	for id, std := range []string{"-1.2", "-0.4", "0.3", "", "0.9", "1.5", "-0.8", "0.1", "0.6", "-1.6"} {
+		lines = append(lines, strings.Join([]string{strconv.Itoa(id + 1), "essay", "3", "4", "5", "3", "4", "5", std}, ","))
+	}
+	lines = append(lines, "4,d")
+	path := filepath.Join(t.TempDir(), "humility.csv")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestSplitRaggedRow(t *testing.T) {
+	table, idIndex, scoreIndex, err := ReadSplitTable(testSplitFile(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	splits, err := Splitter{Validation: 0.2, Test: 0.2, Strata: 2, Seed: 1}.Split(table, idIndex, scoreIndex)
+	if err != nil {
+		t.Fatalf("Split: %v", err)
+	}
+
+	// Every row is in exactly one split, and the short row stays with the
+	// other row of its participant:
+	var count int
+	splitsOf := map[string]map[string]bool{}
+	for _, split := range splits {
+		for _, row := range split.Rows {
+			count++
+			if splitsOf[row[0]] == nil {
+				splitsOf[row[0]] = map[string]bool{}
+			}
+			splitsOf[row[0]][split.Name] = true
+		}
+	}
+	if count != len(table.Rows) {
+		t.Errorf("split %d rows, want %d", count, len(table.Rows))
+	}
+	if len(splitsOf["4"]) != 1 {
+		t.Errorf("pid 4 is in splits %v, want one", splitsOf["4"])
+	}
+
+	// Folds group the short row the same way:
+	if _, err := (Splitter{Test: 0.2, Strata: 2, Seed: 1}).Folds(table, idIndex, scoreIndex, 2); err != nil {
+		t.Errorf("Folds: %v", err)
+	}
+}
+
+func TestSplitRowWithoutPID(t *testing.T) {
+	table := Table{
+		Header: []string{"response", "pid", "standardized"},
+		Rows:   [][]string{{"essay", "1", "0.5"}, {"essay", "2", "-0.5"}, {"essay"}},
+	}
+	_, err := Splitter{Test: 0.5, Seed: 1}.Split(table, 1, 2)
+	if err == nil || !strings.Contains(err.Error(), "row 3") {
+		t.Errorf("Split error = %v, want an error naming row 3", err)
+	}
+}
@@ -0,0 +1,237 @@
+package data
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Stata 14+ .dta (format 118) variable types, and their missing values. See
+// https://www.stata.com/help.cgi?dta.
+const (
+	stataStrL   = 32768
+	stataDouble = 65526
+	stataLong   = 65528
+	stataByte   = 65530
+
+	stataMaxStr   = 2045 // longest fixed-width string; longer ones are strLs
+	stataMaxLabel = 80   // longest variable label, in characters
+
+	stataMissingByte = 101
+	stataMissingLong = 2147483621
+	stataMaxLong     = 2147483620
+	stataMinLong     = -2147483647
+)
+
+// stataMissingDouble is the "." missing value of a double.
+var stataMissingDouble = math.Float64frombits(0x7fe0000000000000)
+
+// writeResultsStata writes the results to a Stata 14+ .dta file, with the
+// short variable names, and the variable labels. Ints are longs, or doubles
+// if they are too large; floats are doubles, bools are bytes, and strings
+// are strLs if they are longer than Stata's fixed-width strings.
+func writeResultsStata(path string, r Results) error {
+	vars := r.Variables()
+	types := make([]uint16, len(vars))
+	for j, v := range vars {
+		types[j] = r.stataType(j, v)
+	}
+	var b bytes.Buffer
+	le := binary.LittleEndian
+
+	// Write the header, leaving the map of section offsets to fill in:
+	b.WriteString("<stata_dta><header><release>118</release><byteorder>LSF</byteorder><K>")
+	b.Write(le.AppendUint16(nil, uint16(len(vars))))
+	b.WriteString("</K><N>")
+	b.Write(le.AppendUint64(nil, uint64(len(r.Rows))))
+	b.WriteString("</N><label>")
+	label := "content-coding-gpt results"
+	b.Write(le.AppendUint16(nil, uint16(len(label))))
+	b.WriteString(label)
+	b.WriteString("</label><timestamp>")
+	timestamp := time.Now().Format("02 Jan 2006 15:04")
+	b.WriteByte(byte(len(timestamp)))
+	b.WriteString(timestamp)
+	b.WriteString("</timestamp></header>")
+	offsets := make([]uint64, 14)
+	offsets[1] = uint64(b.Len())
+	b.WriteString("<map>")
+	mapStart := b.Len()
+	b.Write(make([]byte, 8*len(offsets)))
+	b.WriteString("</map>")
+
+	// Write the variable descriptors:
+	offsets[2] = uint64(b.Len())
+	b.WriteString("<variable_types>")
+	for _, t := range types {
+		b.Write(le.AppendUint16(nil, t))
+	}
+	b.WriteString("</variable_types>")
+	offsets[3] = uint64(b.Len())
+	b.WriteString("<varnames>")
+	for _, v := range vars {
+		b.Write(stataField(v.Short, 129))
+	}
+	b.WriteString("</varnames>")
+	offsets[4] = uint64(b.Len())
+	b.WriteString("<sortlist>")
+	b.Write(make([]byte, 2*(len(vars)+1)))
+	b.WriteString("</sortlist>")
+	offsets[5] = uint64(b.Len())
+	b.WriteString("<formats>")
+	for _, t := range types {
+		b.Write(stataField(stataFormat(t), 57))
+	}
+	b.WriteString("</formats>")
+	offsets[6] = uint64(b.Len())
+	b.WriteString("<value_label_names>")
+	b.Write(make([]byte, 129*len(vars)))
+	b.WriteString("</value_label_names>")
+	offsets[7] = uint64(b.Len())
+	b.WriteString("<variable_labels>")
+	for _, v := range vars {
+		b.Write(stataField(truncateRunes(v.Label, stataMaxLabel), 321))
+	}
+	b.WriteString("</variable_labels>")
+	offsets[8] = uint64(b.Len())
+	b.WriteString("<characteristics></characteristics>")
+
+	// Write the observations, collecting the strLs:
+	offsets[9] = uint64(b.Len())
+	b.WriteString("<data>")
+	var strls bytes.Buffer
+	for i := range r.Rows {
+		for j, t := range types {
+			s := r.field(i, j)
+			switch t {
+			case stataDouble:
+				f, ok := parseFloat(s)
+				if !ok {
+					f = stataMissingDouble
+				}
+				b.Write(le.AppendUint64(nil, math.Float64bits(f)))
+			case stataLong:
+				n, err := strconv.ParseInt(s, 10, 32)
+				if err != nil {
+					n = stataMissingLong
+				}
+				b.Write(le.AppendUint32(nil, uint32(int32(n))))
+			case stataByte:
+				switch {
+				case s == "":
+					b.WriteByte(stataMissingByte)
+				case strings.EqualFold(s, "true"):
+					b.WriteByte(1)
+				default:
+					b.WriteByte(0)
+				}
+			case stataStrL:
+				if s == "" {
+					b.Write(make([]byte, 8))
+					continue
+				}
+				// (v,o) is the variable and observation, numbered from 1:
+				v, o := uint64(j+1), uint64(i+1)
+				b.Write(le.AppendUint64(nil, v|o<<16))
+				strls.WriteString("GSO")
+				strls.Write(le.AppendUint32(nil, uint32(v)))
+				strls.Write(le.AppendUint64(nil, o))
+				strls.WriteByte(130) // ASCII, null-terminated
+				strls.Write(le.AppendUint32(nil, uint32(len(s)+1)))
+				strls.WriteString(s)
+				strls.WriteByte(0)
+			default:
+				b.Write(stataField(s, int(t)))
+			}
+		}
+	}
+	b.WriteString("</data>")
+	offsets[10] = uint64(b.Len())
+	b.WriteString("<strls>")
+	b.Write(strls.Bytes())
+	b.WriteString("</strls>")
+	offsets[11] = uint64(b.Len())
+	b.WriteString("<value_labels></value_labels>")
+	offsets[12] = uint64(b.Len())
+	b.WriteString("</stata_dta>")
+	offsets[13] = uint64(b.Len())
+
+	// Fill in the map, and write the file:
+	data := b.Bytes()
+	for k, offset := range offsets {
+		le.PutUint64(data[mapStart+8*k:], offset)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("write stata file %s: %w", path, err)
+	}
+	return nil
+}
+
+// stataType returns the Stata type of a column of a variable.
+func (r Results) stataType(column int, v Variable) uint16 {
+	switch v.Type {
+	case IntType:
+		for i := range r.Rows {
+			if s := r.field(i, column); s != "" {
+				n, err := strconv.ParseInt(s, 10, 64)
+				if err != nil || n < stataMinLong || n > stataMaxLong {
+					return stataDouble
+				}
+			}
+		}
+		return stataLong
+	case FloatType:
+		return stataDouble
+	case BoolType:
+		return stataByte
+	}
+	if v.Width > stataMaxStr {
+		return stataStrL
+	}
+	if v.Width == 0 {
+		return 1
+	}
+	return uint16(v.Width)
+}
+
+// stataFormat returns the display format of a Stata type.
+func stataFormat(t uint16) string {
+	switch t {
+	case stataDouble:
+		return "%10.0g"
+	case stataLong:
+		return "%12.0g"
+	case stataByte:
+		return "%8.0g"
+	case stataStrL:
+		return "%9s"
+	}
+	width := int(t)
+	if width > 40 {
+		width = 40
+	}
+	return "%-" + strconv.Itoa(width) + "s"
+}
+
+// stataField returns s as a null-padded field of n bytes, cut to fit if
+// needed.
+func stataField(s string, n int) []byte {
+	field := make([]byte, n)
+	copy(field, s)
+	return field
+}
+
+// truncateRunes truncates s to at most n characters.
+func truncateRunes(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	runes := []rune(s)
+	return string(runes[:n])
+}
@@ -0,0 +1,225 @@
+package data
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// stataReader reads the sections of a .dta file written by
+// writeResultsStata.
+type stataReader struct {
+	t    *testing.T
+	data []byte
+	pos  int
+}
+
+// expect consumes a tag or other literal text.
+func (r *stataReader) expect(s string) {
+	r.t.Helper()
+	if !bytes.HasPrefix(r.data[r.pos:], []byte(s)) {
+		r.t.Fatalf("offset %d: got %q, want %q", r.pos, r.data[r.pos:r.pos+len(s)], s)
+	}
+	r.pos += len(s)
+}
+
+func (r *stataReader) bytes(n int) []byte {
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return b
+}
+
+func (r *stataReader) uint16() uint16 { return binary.LittleEndian.Uint16(r.bytes(2)) }
+func (r *stataReader) uint32() uint32 { return binary.LittleEndian.Uint32(r.bytes(4)) }
+func (r *stataReader) uint64() uint64 { return binary.LittleEndian.Uint64(r.bytes(8)) }
+
+// field reads a null-padded field of n bytes.
+func (r *stataReader) field(n int) string {
+	b := r.bytes(n)
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+func TestWriteResultsStata(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "scores.dta")
+	if err := WriteResults(path, "", testResults()); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &stataReader{t: t, data: data}
+
+	// The header: release 118, little-endian, K variables and N observations:
+	r.expect("<stata_dta><header><release>118</release><byteorder>LSF</byteorder><K>")
+	k := int(r.uint16())
+	r.expect("</K><N>")
+	n := int(r.uint64())
+	r.expect("</N><label>")
+	if label := r.field(int(r.uint16())); label != "content-coding-gpt results" {
+		t.Errorf("label = %q, want %q", label, "content-coding-gpt results")
+	}
+	r.expect("</label><timestamp>")
+	if size := int(r.bytes(1)[0]); size != 0 && size != 17 {
+		t.Errorf("timestamp length = %d, want 17", size)
+	} else {
+		r.pos += size
+	}
+	r.expect("</timestamp></header>")
+	if k != 8 || n != 3 {
+		t.Fatalf("K = %d and N = %d, want 8 and 3", k, n)
+	}
+
+	// The map locates every section:
+	r.expect("<map>")
+	offsets := make([]int, 14)
+	for i := range offsets {
+		offsets[i] = int(r.uint64())
+	}
+	r.expect("</map>")
+	for i, tag := range []string{"<stata_dta>", "<map>", "<variable_types>", "<varnames>", "<sortlist>",
+		"<formats>", "<value_label_names>", "<variable_labels>", "<characteristics>", "<data>", "<strls>",
+		"<value_labels>", "</stata_dta>"} {
+		if !bytes.HasPrefix(data[offsets[i]:], []byte(tag)) {
+			t.Errorf("map offset %d = %d, which is not %s", i, offsets[i], tag)
+		}
+	}
+	if offsets[13] != len(data) {
+		t.Errorf("map end of file = %d, want %d", offsets[13], len(data))
+	}
+
+	// The variable types, names, and labels, with a fixed-width string for
+	// the short text, and a strL for the long essays:
+	types := []uint16{stataLong, 5, stataStrL, stataDouble, stataByte, stataLong, stataDouble, 16}
+	r.pos = offsets[2]
+	r.expect("<variable_types>")
+	for j, want := range types {
+		if got := r.uint16(); got != want {
+			t.Errorf("variable %d type = %d, want %d", j+1, got, want)
+		}
+	}
+	r.pos = offsets[3]
+	r.expect("<varnames>")
+	var names []string
+	for j := 0; j < k; j++ {
+		names = append(names, r.field(129))
+	}
+	if want := []string{"pid", "etype", "essay", "score", "review", "age", "weight", "note"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("varnames = %q, want %q", names, want)
+	}
+	r.pos = offsets[7]
+	r.expect("<variable_labels>")
+	if label := r.field(321); label != "Participant ID" {
+		t.Errorf("pid label = %q, want %q", label, "Participant ID")
+	}
+
+	// The observations, with missing values, and strL references (v,o):
+	r.pos = offsets[9]
+	r.expect("<data>")
+	var rows [][]interface{}
+	for i := 0; i < n; i++ {
+		var row []interface{}
+		for _, typ := range types {
+			switch typ {
+			case stataLong:
+				row = append(row, int32(r.uint32()))
+			case stataDouble:
+				row = append(row, math.Float64frombits(r.uint64()))
+			case stataByte:
+				row = append(row, r.bytes(1)[0])
+			case stataStrL:
+				row = append(row, r.uint64())
+			default:
+				row = append(row, r.field(int(typ)))
+			}
+		}
+		rows = append(rows, row)
+	}
+	r.expect("</data>")
+	missing := math.Float64frombits(0x7fe0000000000000)
+	want := [][]interface{}{
+		{int32(1), "dream", uint64(3 | 1<<16), 0.5, byte(1), int32(34), 61.5, "ok"},
+		{int32(2), "dream", uint64(3 | 2<<16), missing, byte(0), int32(stataMissingLong), 70.0, ""},
+		{int32(3), "dream", uint64(0), -0.25, byte(stataMissingByte), int32(29), missing, "note, with comma"},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("observations =\n%v\nwant\n%v", rows, want)
+	}
+
+	// The strLs are GSO entries of null-terminated ASCII text:
+	r.expect("<strls>")
+	strls := map[[2]uint64]string{}
+	for strings.HasPrefix(string(data[r.pos:r.pos+3]), "GSO") {
+		r.expect("GSO")
+		v, o := uint64(r.uint32()), r.uint64()
+		if typ := r.bytes(1)[0]; typ != 130 {
+			t.Errorf("GSO (%d,%d) type = %d, want 130", v, o, typ)
+		}
+		s := r.bytes(int(r.uint32()))
+		if s[len(s)-1] != 0 {
+			t.Errorf("GSO (%d,%d) is not null-terminated", v, o)
+		}
+		strls[[2]uint64{v, o}] = string(s[:len(s)-1])
+	}
+	r.expect("</strls>")
+	wantStrls := map[[2]uint64]string{{3, 1}: "Short essay\nwith a newline", {3, 2}: testEssay}
+	if !reflect.DeepEqual(strls, wantStrls) {
+		t.Errorf("strls = %q, want %q", strls, wantStrls)
+	}
+}
+
+func TestStataTypeLongOverflow(t *testing.T) {
+	r := Results{Table: Table{Header: []string{"big"}, Rows: [][]string{{"1"}, {"3000000000"}}}}
+	v := r.Variables()[0]
+	if v.Type != IntType {
+		t.Fatalf("type = %s, want %s", v.Type, IntType)
+	}
+	if typ := r.stataType(0, v); typ != stataDouble {
+		t.Errorf("stata type = %d, want double (%d)", typ, stataDouble)
+	}
+}
+
+func TestWriteResultsStataPandas(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "scores.dta")
+	if err := WriteResults(path, "", testResults()); err != nil {
+		t.Fatal(err)
+	}
+	var got struct {
+		Label  string                   `json:"label"`
+		Labels map[string]string        `json:"labels"`
+		Rows   []map[string]interface{} `json:"rows"`
+	}
+	readWithPython(t, "pandas", `
+import json, sys
+import pandas as pd
+with pd.io.stata.StataReader(sys.argv[1]) as r:
+    df = r.read(convert_missing=False)
+    labels, label = r.variable_labels(), r.data_label
+print(json.dumps({"label": label, "labels": labels, "rows": json.loads(df.to_json(orient="records"))}))
+`, path, &got)
+	if got.Label != "content-coding-gpt results" {
+		t.Errorf("data label = %q, want %q", got.Label, "content-coding-gpt results")
+	}
+	if got.Labels["pid"] != "Participant ID" || got.Labels["note"] != "note" {
+		t.Errorf("variable labels = %q", got.Labels)
+	}
+	want := []map[string]interface{}{
+		{"pid": 1.0, "etype": "dream", "essay": "Short essay\nwith a newline", "score": 0.5, "review": 1.0,
+			"age": 34.0, "weight": 61.5, "note": "ok"},
+		{"pid": 2.0, "etype": "dream", "essay": testEssay, "score": nil, "review": 0.0,
+			"age": nil, "weight": 70.0, "note": ""},
+		{"pid": 3.0, "etype": "dream", "essay": "", "score": -0.25, "review": nil,
+			"age": 29.0, "weight": nil, "note": "note, with comma"},
+	}
+	if !reflect.DeepEqual(got.Rows, want) {
+		t.Errorf("rows =\n%v\nwant\n%v", got.Rows, want)
+	}
+}
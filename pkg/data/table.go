@@ -0,0 +1,198 @@
+package data
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Table is a header row plus data rows, read from a CSV, TSV, JSONL, or XLSX file.
+type Table struct {
+	Header []string
+	Rows   [][]string
+}
+
+// ColumnIndex returns the index of the named column, or -1 if not found.
+// Column names are matched case-insensitively, ignoring surrounding whitespace.
+func (t Table) ColumnIndex(name string) int {
+	name = strings.TrimSpace(name)
+	for i, h := range t.Header {
+		if strings.EqualFold(strings.TrimSpace(h), name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// TableFormat returns the table format implied by a file's extension:
+// "csv", "tsv", "jsonl", or "xlsx". An empty string is returned if it's unknown.
+func TableFormat(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		return "csv"
+	case ".tsv", ".tab":
+		return "tsv"
+	case ".jsonl", ".ndjson":
+		return "jsonl"
+	case ".xlsx":
+		return "xlsx"
+	default:
+		return ""
+	}
+}
+
+// ReadTableFile reads a CSV, TSV, JSONL, or XLSX file into a Table. The format
+// is determined by the file extension. For XLSX files, the header is the first
+// row of the first sheet; use ReadXLSXFile to select another sheet or row.
+func ReadTableFile(path string) (Table, error) {
+	switch TableFormat(path) {
+	case "csv":
+		return readDelimitedFile(path, ',')
+	case "tsv":
+		return readDelimitedFile(path, '\t')
+	case "jsonl":
+		return readJSONLFile(path)
+	case "xlsx":
+		return ReadXLSXFile(path, "", 1)
+	default:
+		return Table{}, fmt.Errorf("read table file %s: unsupported file extension", path)
+	}
+}
+
+// readDelimitedFile reads a CSV or TSV file into a Table.
+func readDelimitedFile(path string, delimiter rune) (Table, error) {
+	var table Table
+
+	// Open a CSV file reader:
+	f, err := os.Open(path)
+	if err != nil {
+		return table, fmt.Errorf("read table file %s: %w", path, err)
+	}
+	defer f.Close()
+	r := csv.NewReader(f)
+	r.Comma = delimiter
+	r.FieldsPerRecord = -1
+	if delimiter == '\t' {
+		r.LazyQuotes = true
+	}
+
+	// Read the header and rows:
+	records, err := r.ReadAll()
+	if err != nil {
+		return table, fmt.Errorf("read table file %s: %w", path, err)
+	}
+	if len(records) == 0 {
+		return table, fmt.Errorf("read table file %s: no header found", path)
+	}
+	table.Header = records[0]
+	if len(table.Header) > 0 {
+		table.Header[0] = strings.TrimPrefix(table.Header[0], "\ufeff")
+	}
+	table.Rows = records[1:]
+	return table, nil
+}
+
+// readJSONLFile reads a JSONL file of flat objects into a Table. The header is
+// the union of all object keys, in order of first appearance. Missing and null
+// values are empty strings, and nested values are kept as JSON text.
+func readJSONLFile(path string) (Table, error) {
+	var table Table
+
+	// Open a JSONL file reader:
+	f, err := os.Open(path)
+	if err != nil {
+		return table, fmt.Errorf("read table file %s: %w", path, err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+
+	// Read the objects, collecting the keys in order of appearance:
+	columns := map[string]int{}
+	var objects []map[string]json.RawMessage
+	line := 0
+	for scanner.Scan() {
+		line++
+		b := bytes.TrimSpace(scanner.Bytes())
+		if len(b) == 0 {
+			continue
+		}
+		keys, obj, err := decodeJSONObject(b)
+		if err != nil {
+			return table, fmt.Errorf("read table file %s: line %d: %w", path, line, err)
+		}
+		for _, key := range keys {
+			if _, ok := columns[key]; !ok {
+				columns[key] = len(table.Header)
+				table.Header = append(table.Header, key)
+			}
+		}
+		objects = append(objects, obj)
+	}
+	if err := scanner.Err(); err != nil {
+		return table, fmt.Errorf("read table file %s: %w", path, err)
+	}
+
+	// Convert the objects to rows:
+	for _, obj := range objects {
+		row := make([]string, len(table.Header))
+		for key, value := range obj {
+			row[columns[key]] = jsonText(value)
+		}
+		table.Rows = append(table.Rows, row)
+	}
+	return table, nil
+}
+
+// decodeJSONObject decodes a JSON object, returning its keys in document order.
+func decodeJSONObject(b []byte) ([]string, map[string]json.RawMessage, error) {
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(b, &obj); err != nil {
+		return nil, nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	if _, err := dec.Token(); err != nil { // opening brace
+		return nil, nil, err
+	}
+	keys := make([]string, 0, len(obj))
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return nil, nil, err
+		}
+		keys = append(keys, t.(string))
+		var skip json.RawMessage
+		if err := dec.Decode(&skip); err != nil {
+			return nil, nil, err
+		}
+	}
+	return keys, obj, nil
+}
+
+// jsonText converts a raw JSON value to a table cell.
+func jsonText(value json.RawMessage) string {
+	var v interface{}
+	dec := json.NewDecoder(bytes.NewReader(value))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return string(value)
+	}
+	switch t := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return t
+	case json.Number:
+		return t.String()
+	case bool:
+		return strconv.FormatBool(t)
+	default:
+		return string(value)
+	}
+}
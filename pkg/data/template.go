@@ -0,0 +1,244 @@
+package data
+
+import (
+	"bytes"
+	"content-coding-gpt/pkg/openai"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"gopkg.in/yaml.v3"
+)
+
+// PromptTemplate is a chat prompt template file. It starts with optional YAML
+// front matter between "---" lines, which may set the request parameters,
+// followed by a text/template body. For example:
+//
+//	---
+//	model: gpt-4
+//	temperature: 0.2
+//	system: You are a psychology research assistant.
+//	score_parser: label:Score
+//	score_range: 0,5
+//	partials: [partials/*.tmpl]
+//	---
+//	{{template "humility_hallmarks"}}
+//	The participant was given the following writing prompt:
+//	“{{.Prompt}}”
+//	The participant wrote the following:
+//	“{{.Essay}}”
+//
+// The body is executed with PromptData. The legacy {{prompt}} and {{essay}}
+// variables are equivalent to {{.Prompt}} and {{.Essay}}. A multi-message
+// conversation is defined by starting each message with a [[system]],
+// [[user]], or [[assistant]] line; text before the first such line is a user
+// message. Partials are named after their file base names, e.g. "rubric" for
+// partials/rubric.tmpl, and the hallmarks are built-in partials named
+// "humility_hallmarks" and "spirituality_hallmarks".
+type PromptTemplate struct {
+	// Path is the template file path.
+	Path string `yaml:"-"`
+
+	// Model is the model ID, e.g. "gpt-3.5-turbo".
+	Model string `yaml:"model"`
+
+	// Temperature is the sampling temperature, if specified.
+	Temperature *float32 `yaml:"temperature"`
+
+	// MaxTokens is the maximum number of tokens to generate.
+	MaxTokens int `yaml:"max_tokens"`
+
+	// System is the system message. If nil, SystemMessage is used unless the
+	// body defines a system message; an empty string omits it.
+	System *string `yaml:"system"`
+
+	// ScoreParser is the score extractor specification (see
+	// NewScoreExtractor), e.g. "first", "last", or "label:Score".
+	ScoreParser string `yaml:"score_parser"`
+
+	// ScoreRange is the allowed score range, e.g. "0,5".
+	ScoreRange string `yaml:"score_range"`
+
+	// Partials are file paths or glob patterns, relative to the template file,
+	// of partial templates to include.
+	Partials []string `yaml:"partials"`
+
+	// PartialFiles are the partial template files matched by Partials.
+	PartialFiles []string `yaml:"-"`
+
+	tmpl *template.Template
+}
+
+// PromptData is the data available to a PromptTemplate body.
+type PromptData struct {
+	ID        int               // participant ID
+	EssayType string            // essay type, e.g. "dream"
+	Prompt    string            // writing prompt for the essay type
+	Essay     string            // participant's essay
+	Hallmarks string            // hallmarks for the essay type
+	Columns   map[string]string // all essays (by essay type) and extra columns
+}
+
+// legacyVariables matches the {{prompt}} and {{essay}} template variables.
+var legacyVariables = regexp.MustCompile(`{{\s*(prompt|essay)\s*}}`)
+
+// frontMatterEnd matches the line that ends the front matter.
+var frontMatterEnd = regexp.MustCompile(`(?m)^---[ \t]*\r?$`)
+
+// messageMarker matches the line that starts a message in a template body.
+var messageMarker = regexp.MustCompile(`(?m)^\[\[(system|user|assistant)\]\][ \t]*\r?\n?`)
+
+// messageSentinel matches the message markers of a template body after they
+// are replaced by markMessages, so that the essays and other data, which
+// never contain NUL characters, cannot start messages of their own.
+var messageSentinel = regexp.MustCompile("\x00(system|user|assistant)\x00")
+
+// markMessages replaces the message markers of template text with sentinels.
+func markMessages(text string) string {
+	return messageMarker.ReplaceAllString(text, "\x00$1\x00")
+}
+
+// stripNUL removes any NUL characters, which would be taken for sentinels.
+func stripNUL(s string) string {
+	return strings.ReplaceAll(s, "\x00", "")
+}
+
+// LoadPromptTemplate reads and parses a prompt template file and its partials.
+func LoadPromptTemplate(path string) (*PromptTemplate, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("load prompt template %s: %w", path, err)
+	}
+	t := &PromptTemplate{Path: path}
+
+	// Parse the front matter:
+	body := string(b)
+	if strings.HasPrefix(body, "---\n") || strings.HasPrefix(body, "---\r\n") {
+		_, rest, _ := strings.Cut(body, "\n")
+		end := frontMatterEnd.FindStringIndex(rest)
+		if end == nil {
+			return nil, fmt.Errorf("load prompt template %s: unterminated front matter", path)
+		}
+		if err := yaml.Unmarshal([]byte(rest[:end[0]]), t); err != nil {
+			return nil, fmt.Errorf("load prompt template %s: front matter: %w", path, err)
+		}
+		body = strings.TrimPrefix(strings.TrimPrefix(rest[end[1]:], "\r"), "\n")
+	}
+
+	// Parse the built-in partials, the partial files, and the body:
+	t.tmpl = template.New(filepath.Base(path)).Option("missingkey=zero")
+	builtins := map[string]string{
+		"humility_hallmarks":     HumilityHallmarks,
+		"spirituality_hallmarks": SpiritualityHallmarks,
+	}
+	for name, text := range builtins {
+		if _, err := t.tmpl.New(name).Parse(text); err != nil {
+			return nil, fmt.Errorf("load prompt template %s: partial %s: %w", path, name, err)
+		}
+	}
+	for _, pattern := range t.Partials {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(filepath.Dir(path), pattern)
+		}
+		files, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("load prompt template %s: partials %s: %w", path, pattern, err)
+		}
+		if len(files) == 0 {
+			return nil, fmt.Errorf("load prompt template %s: no partials match %s", path, pattern)
+		}
+		for _, file := range files {
+			text, err := os.ReadFile(file)
+			if err != nil {
+				return nil, fmt.Errorf("load prompt template %s: %w", path, err)
+			}
+			name := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+			if _, err := t.tmpl.New(name).Parse(markMessages(replaceLegacyVariables(string(text)))); err != nil {
+				return nil, fmt.Errorf("load prompt template %s: partial %s: %w", path, file, err)
+			}
+			t.PartialFiles = append(t.PartialFiles, file)
+		}
+	}
+	if _, err := t.tmpl.Parse(markMessages(replaceLegacyVariables(body))); err != nil {
+		return nil, fmt.Errorf("load prompt template %s: %w", path, err)
+	}
+	return t, nil
+}
+
+// replaceLegacyVariables replaces the {{prompt}} and {{essay}} variables with
+// their PromptData fields.
+func replaceLegacyVariables(text string) string {
+	return legacyVariables.ReplaceAllStringFunc(text, func(s string) string {
+		if strings.Contains(s, "prompt") {
+			return "{{.Prompt}}"
+		}
+		return "{{.Essay}}"
+	})
+}
+
+// NewPromptData creates the PromptData for an essay of the specified type.
+// NUL characters are removed from the data, so that it cannot start messages.
+func NewPromptData(r EssayRecord, essayType string) PromptData {
+	columns := make(map[string]string, len(r.Essays)+len(r.Extra))
+	for k, v := range r.Essays {
+		columns[k] = stripNUL(v)
+	}
+	for k, v := range r.Extra {
+		columns[k] = stripNUL(v)
+	}
+	return PromptData{
+		ID:        r.ID,
+		EssayType: stripNUL(essayType),
+		Prompt:    stripNUL(EssayPrompts[canonicalEssayType(essayType)]),
+		Essay:     stripNUL(r.SelectEssay(essayType)),
+		Hallmarks: stripNUL(Hallmarks[essayType]),
+		Columns:   columns,
+	}
+}
+
+// Messages executes the template for an essay, returning the chat messages.
+func (t *PromptTemplate) Messages(r EssayRecord, essayType string) ([]openai.Message, error) {
+	var buf bytes.Buffer
+	if err := t.tmpl.Execute(&buf, NewPromptData(r, essayType)); err != nil {
+		return nil, fmt.Errorf("execute prompt template %s: %w", t.Path, err)
+	}
+	text := buf.String()
+
+	// Split the text into messages at the template's own markers; any markers
+	// in the data are left as text:
+	var messages []openai.Message
+	add := func(role openai.Role, content string) {
+		if content = strings.TrimSpace(content); content != "" {
+			messages = append(messages, openai.Message{Role: role, Content: content})
+		}
+	}
+	markers := messageSentinel.FindAllStringSubmatchIndex(text, -1)
+	if len(markers) == 0 {
+		add(openai.USER, text)
+	} else {
+		add(openai.USER, text[:markers[0][0]])
+		for i, m := range markers {
+			end := len(text)
+			if i+1 < len(markers) {
+				end = markers[i+1][0]
+			}
+			add(openai.Role(text[m[2]:m[3]]), text[m[1]:end])
+		}
+	}
+	if len(messages) == 0 {
+		return nil, fmt.Errorf("execute prompt template %s: no messages", t.Path)
+	}
+
+	// Prepend the system message, unless the body provides one:
+	if messages[0].Role != openai.SYSTEM {
+		if t.System == nil {
+			messages = append([]openai.Message{SystemMessage}, messages...)
+		} else if *t.System != "" {
+			messages = append([]openai.Message{{Role: openai.SYSTEM, Content: *t.System}}, messages...)
+		}
+	}
+	return messages, nil
+}
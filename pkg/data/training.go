@@ -95,3 +95,35 @@ func PrepareTrainingFile(csvPath string, jsonPath string, appendFile bool) error
 	}
 	return nil
 }
+
+// ReadTrainingEssays reads the essays of a humility or spiritual training CSV
+// file as EssayRecords of the specified essay type, e.g. to score a held-out
+// split with a fine-tuned model.
+func ReadTrainingEssays(csvPath string, essayType string) ([]EssayRecord, error) {
+	fileType, err := IdentifyCSVFile(csvPath)
+	if err != nil {
+		return nil, fmt.Errorf("read training essays %s: %w", csvPath, err)
+	}
+	var essays []EssayRecord
+	switch {
+	case fileType == "humility" && IsHumility(essayType):
+		recs, err := ReadHumilityRecords(csvPath)
+		if err != nil {
+			return nil, fmt.Errorf("read training essays %s: %w", csvPath, err)
+		}
+		for _, r := range recs {
+			essays = append(essays, NewEssayRecord(r.ID, essayType, r.Response, nil))
+		}
+	case fileType == "spiritual" && IsSpiritual(essayType):
+		recs, err := ReadSpiritualRecords(csvPath)
+		if err != nil {
+			return nil, fmt.Errorf("read training essays %s: %w", csvPath, err)
+		}
+		for _, r := range recs {
+			essays = append(essays, NewEssayRecord(r.ID, essayType, r.Response, nil))
+		}
+	default:
+		return nil, fmt.Errorf("read training essays %s: %s file does not match essay type %s", csvPath, fileType, essayType)
+	}
+	return essays, nil
+}
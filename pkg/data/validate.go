@@ -0,0 +1,202 @@
+package data
+
+import (
+	"bufio"
+	"content-coding-gpt/pkg/openai"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Training file formats.
+const (
+	LegacyTrainingFormat = "legacy" // prompt and completion records
+	ChatTrainingFormat   = "chat"   // chat message records
+)
+
+// TrainingIssue is a problem with a record of a training file. Warnings do
+// not prevent fine-tuning, but are probably mistakes.
+type TrainingIssue struct {
+	Line    int
+	Warning bool
+	Message string
+}
+
+// String returns a string representation of a TrainingIssue.
+func (i TrainingIssue) String() string {
+	level := "error"
+	if i.Warning {
+		level = "warning"
+	}
+	return fmt.Sprintf("line %d: %s: %s", i.Line, level, i.Message)
+}
+
+// TrainingReport is the result of validating a training file: its issues,
+// estimated token counts, and distribution of completion labels.
+type TrainingReport struct {
+	Path             string
+	Format           string
+	Examples         int
+	Issues           []TrainingIssue
+	PromptTokens     int
+	CompletionTokens int
+	MaxTokens        int            // estimated tokens of the largest example
+	Labels           map[string]int // trimmed completion -> count
+}
+
+// Errors returns the number of issues that are errors.
+func (r TrainingReport) Errors() int {
+	var n int
+	for _, i := range r.Issues {
+		if !i.Warning {
+			n++
+		}
+	}
+	return n
+}
+
+// TotalTokens returns the estimated number of tokens in the training file.
+func (r TrainingReport) TotalTokens() int {
+	return r.PromptTokens + r.CompletionTokens
+}
+
+// chatTrainingRecord is a chat format training record.
+type chatTrainingRecord struct {
+	Messages []openai.Message `json:"messages"`
+}
+
+// ValidateTrainingFile checks every record of a legacy or chat format JSONL
+// training file. Legacy prompts must end with the PromptSeparator, and
+// completions must start with CompletionStart and end with CompletionStop,
+// as written by PrepareTrainingFile. Chat records must end with an assistant
+// message. Examples longer than maxTokens (estimated) are errors, and
+// duplicate prompts are warnings.
+func ValidateTrainingFile(path string, maxTokens int) (TrainingReport, error) {
+	r := TrainingReport{Path: path, Labels: map[string]int{}}
+	f, err := os.Open(path)
+	if err != nil {
+		return r, fmt.Errorf("validate training file %s: %w", path, err)
+	}
+	defer f.Close()
+
+	prompts := map[string]int{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 1024*1024), 64*1024*1024)
+	var line int
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		issue := func(warning bool, format string, args ...interface{}) {
+			r.Issues = append(r.Issues, TrainingIssue{Line: line, Warning: warning, Message: fmt.Sprintf(format, args...)})
+		}
+
+		// Decode the record, and identify the file format from the first:
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal([]byte(text), &fields); err != nil {
+			issue(false, "invalid JSON: %v", err)
+			continue
+		}
+		format := LegacyTrainingFormat
+		if _, ok := fields["messages"]; ok {
+			format = ChatTrainingFormat
+		}
+		if r.Format == "" {
+			r.Format = format
+		} else if format != r.Format {
+			issue(false, "%s record in a %s file", format, r.Format)
+			continue
+		}
+		r.Examples++
+
+		// Check the record:
+		var prompt, completion string
+		var promptTokens, completionTokens int
+		if format == ChatTrainingFormat {
+			var record chatTrainingRecord
+			if err := json.Unmarshal([]byte(text), &record); err != nil {
+				issue(false, "invalid chat record: %v", err)
+				continue
+			}
+			if len(record.Messages) == 0 {
+				issue(false, "no messages")
+				continue
+			}
+			var users int
+			for i, m := range record.Messages {
+				if !m.Role.IsValid() {
+					issue(false, "message %d: invalid role %q", i+1, m.Role)
+				}
+				if strings.TrimSpace(m.Content) == "" {
+					issue(false, "message %d: empty content", i+1)
+				}
+				if m.Role == openai.USER {
+					users++
+				}
+			}
+			last := record.Messages[len(record.Messages)-1]
+			if users == 0 {
+				issue(false, "no user message")
+			}
+			if last.Role != openai.ASSISTANT {
+				issue(false, "the last message is not an assistant message")
+			}
+			p, _ := json.Marshal(record.Messages[:len(record.Messages)-1])
+			prompt, completion = string(p), last.Content
+			promptTokens = openai.EstimateMessageTokens(record.Messages[:len(record.Messages)-1])
+			completionTokens = openai.EstimateTokens(completion)
+		} else {
+			var record TrainingRecord
+			if err := json.Unmarshal([]byte(text), &record); err != nil {
+				issue(false, "invalid prompt/completion record: %v", err)
+				continue
+			}
+			prompt, completion = record.Prompt, record.Completion
+			switch {
+			case strings.TrimSpace(strings.TrimSuffix(prompt, PromptSeparator)) == "":
+				issue(false, "empty prompt")
+			case !strings.HasSuffix(prompt, PromptSeparator):
+				issue(false, "prompt does not end with the separator %q", PromptSeparator)
+			case strings.Count(prompt, PromptSeparator) > 1:
+				issue(true, "prompt contains the separator %q more than once", PromptSeparator)
+			}
+			switch {
+			case strings.TrimSpace(completion) == "":
+				issue(false, "empty completion")
+			case !strings.HasPrefix(completion, CompletionStart):
+				issue(false, "completion does not start with %q", CompletionStart)
+			case !strings.HasSuffix(completion, CompletionStop):
+				issue(false, "completion does not end with %q", CompletionStop)
+			}
+			promptTokens = openai.EstimateTokens(prompt)
+			completionTokens = openai.EstimateTokens(completion)
+		}
+
+		// Duplicates, tokens, and labels:
+		if first, ok := prompts[prompt]; ok {
+			issue(true, "duplicate prompt of line %d", first)
+		} else {
+			prompts[prompt] = line
+		}
+		tokens := promptTokens + completionTokens
+		if maxTokens > 0 && tokens > maxTokens {
+			issue(false, "about %d tokens, more than the limit of %d", tokens, maxTokens)
+		}
+		if tokens > r.MaxTokens {
+			r.MaxTokens = tokens
+		}
+		r.PromptTokens += promptTokens
+		r.CompletionTokens += completionTokens
+		r.Labels[strings.TrimSpace(completion)]++
+	}
+	if err := scanner.Err(); err != nil {
+		return r, fmt.Errorf("validate training file %s: %w", path, err)
+	}
+	if r.Examples == 0 && len(r.Issues) == 0 {
+		r.Issues = append(r.Issues, TrainingIssue{Line: line, Message: "no training examples"})
+	}
+	return r, nil
+}
@@ -0,0 +1,461 @@
+package data
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// xlsxWorkbook is the xl/workbook.xml part of an XLSX file.
+type xlsxWorkbook struct {
+	Properties struct {
+		Date1904 bool `xml:"date1904,attr"`
+	} `xml:"workbookPr"`
+	Sheets []struct {
+		Name  string `xml:"name,attr"`
+		RelID string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+	} `xml:"sheets>sheet"`
+}
+
+// xlsxRelationships is the xl/_rels/workbook.xml.rels part of an XLSX file.
+type xlsxRelationships struct {
+	Relationships []struct {
+		ID     string `xml:"Id,attr"`
+		Target string `xml:"Target,attr"`
+	} `xml:"Relationship"`
+}
+
+// xlsxText is rich or plain text in a shared or inline string.
+type xlsxText struct {
+	T string `xml:"t"`
+	R []struct {
+		T string `xml:"t"`
+	} `xml:"r"`
+}
+
+// String concatenates the plain text and any rich text runs.
+func (t xlsxText) String() string {
+	s := t.T
+	for _, r := range t.R {
+		s += r.T
+	}
+	return s
+}
+
+// xlsxSharedStrings is the xl/sharedStrings.xml part of an XLSX file.
+type xlsxSharedStrings struct {
+	Items []xlsxText `xml:"si"`
+}
+
+// xlsxStyles is the xl/styles.xml part of an XLSX file, as needed to detect dates.
+type xlsxStyles struct {
+	NumFmts []struct {
+		ID   int    `xml:"numFmtId,attr"`
+		Code string `xml:"formatCode,attr"`
+	} `xml:"numFmts>numFmt"`
+	CellXfs []struct {
+		NumFmtID int `xml:"numFmtId,attr"`
+	} `xml:"cellXfs>xf"`
+}
+
+// xlsxWorksheet is a worksheet part of an XLSX file.
+type xlsxWorksheet struct {
+	Rows []struct {
+		Cells []struct {
+			Ref    string    `xml:"r,attr"`
+			Type   string    `xml:"t,attr"`
+			Style  int       `xml:"s,attr"`
+			Value  string    `xml:"v"`
+			Inline *xlsxText `xml:"is"`
+		} `xml:"c"`
+	} `xml:"sheetData>row"`
+	MergeCells []struct {
+		Ref string `xml:"ref,attr"`
+	} `xml:"mergeCells>mergeCell"`
+}
+
+// xlsxFile provides access to the parts of an open XLSX file.
+type xlsxFile struct {
+	zip      *zip.ReadCloser
+	workbook xlsxWorkbook
+	sheets   map[string]string // sheet name -> part path
+	strings  []string
+	dates    map[int]bool // cell style index -> date format?
+}
+
+// openXLSXFile opens an XLSX file and reads its workbook, shared strings, and styles.
+func openXLSXFile(filePath string) (*xlsxFile, error) {
+	z, err := zip.OpenReader(filePath)
+	if err != nil {
+		return nil, err
+	}
+	x := &xlsxFile{zip: z, sheets: map[string]string{}, dates: map[int]bool{}}
+
+	// Read the workbook and its relationships:
+	if err := x.readPart("xl/workbook.xml", &x.workbook); err != nil {
+		z.Close()
+		return nil, err
+	}
+	var rels xlsxRelationships
+	if err := x.readPart("xl/_rels/workbook.xml.rels", &rels); err != nil {
+		z.Close()
+		return nil, err
+	}
+	targets := make(map[string]string, len(rels.Relationships))
+	for _, rel := range rels.Relationships {
+		if strings.HasPrefix(rel.Target, "/") {
+			targets[rel.ID] = strings.TrimPrefix(rel.Target, "/")
+		} else {
+			targets[rel.ID] = path.Join("xl", rel.Target)
+		}
+	}
+	for _, sheet := range x.workbook.Sheets {
+		x.sheets[sheet.Name] = targets[sheet.RelID]
+	}
+
+	// Read the shared strings and styles, which are both optional:
+	var sst xlsxSharedStrings
+	if err := x.readPart("xl/sharedStrings.xml", &sst); err != nil && !errors.Is(err, errPartNotFound) {
+		z.Close()
+		return nil, err
+	}
+	for _, si := range sst.Items {
+		x.strings = append(x.strings, si.String())
+	}
+	var styles xlsxStyles
+	if err := x.readPart("xl/styles.xml", &styles); err != nil && !errors.Is(err, errPartNotFound) {
+		z.Close()
+		return nil, err
+	}
+	customDates := map[int]bool{}
+	for _, f := range styles.NumFmts {
+		customDates[f.ID] = isDateFormat(f.Code)
+	}
+	for i, xf := range styles.CellXfs {
+		id := xf.NumFmtID
+		x.dates[i] = (id >= 14 && id <= 22) || (id >= 45 && id <= 47) || customDates[id]
+	}
+	return x, nil
+}
+
+// errPartNotFound indicates that an XLSX file doesn't contain a part.
+var errPartNotFound = errors.New("xlsx part not found")
+
+// readPart unmarshals the named XML part of the XLSX file.
+func (x *xlsxFile) readPart(name string, v interface{}) error {
+	for _, f := range x.zip.File {
+		if f.Name != name {
+			continue
+		}
+		r, err := f.Open()
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		defer r.Close()
+		b, err := io.ReadAll(r)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		if err := xml.Unmarshal(b, v); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		return nil
+	}
+	return fmt.Errorf("%s: %w", name, errPartNotFound)
+}
+
+// sheetNames returns the worksheet names in workbook order.
+func (x *xlsxFile) sheetNames() []string {
+	names := make([]string, 0, len(x.workbook.Sheets))
+	for _, sheet := range x.workbook.Sheets {
+		names = append(names, sheet.Name)
+	}
+	return names
+}
+
+// readSheet reads the named worksheet into a grid of cleaned cell values.
+// Merged cells are filled with the value of their top-left cell.
+func (x *xlsxFile) readSheet(name string) ([][]string, error) {
+	part, ok := x.sheets[name]
+	if !ok || part == "" {
+		return nil, fmt.Errorf("sheet %q not found", name)
+	}
+	var ws xlsxWorksheet
+	if err := x.readPart(part, &ws); err != nil {
+		return nil, err
+	}
+
+	// Convert the cells to a grid:
+	var grid [][]string
+	set := func(row, col int, value string) {
+		for len(grid) <= row {
+			grid = append(grid, nil)
+		}
+		for len(grid[row]) <= col {
+			grid[row] = append(grid[row], "")
+		}
+		grid[row][col] = value
+	}
+	for i, r := range ws.Rows {
+		for j, c := range r.Cells {
+			row, col := i, j
+			if c.Ref != "" {
+				var err error
+				row, col, err = parseCellRef(c.Ref)
+				if err != nil {
+					return nil, err
+				}
+			}
+			var value string
+			switch c.Type {
+			case "s":
+				k, err := strconv.Atoi(c.Value)
+				if err != nil || k < 0 || k >= len(x.strings) {
+					return nil, fmt.Errorf("cell %s: invalid shared string %q", c.Ref, c.Value)
+				}
+				value = x.strings[k]
+			case "inlineStr":
+				if c.Inline != nil {
+					value = c.Inline.String()
+				}
+			case "b":
+				value = strconv.FormatBool(c.Value == "1")
+			case "str", "e":
+				value = c.Value
+			default:
+				value = x.formatNumber(c.Value, x.dates[c.Style])
+			}
+			set(row, col, CleanUnicode(value))
+		}
+	}
+
+	// Fill the merged cells:
+	for _, m := range ws.MergeCells {
+		from, to, _ := strings.Cut(m.Ref, ":")
+		r1, c1, err := parseCellRef(from)
+		if err != nil {
+			return nil, err
+		}
+		r2, c2, err := parseCellRef(to)
+		if err != nil {
+			return nil, err
+		}
+		var value string
+		if r1 < len(grid) && c1 < len(grid[r1]) {
+			value = grid[r1][c1]
+		}
+		for row := r1; row <= r2; row++ {
+			for col := c1; col <= c2; col++ {
+				set(row, col, value)
+			}
+		}
+	}
+	return grid, nil
+}
+
+// formatNumber formats a numeric cell value, converting date serial numbers
+// to ISO 8601 dates (or date-times, if there is a time component).
+func (x *xlsxFile) formatNumber(value string, date bool) string {
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return value
+	}
+	if !date {
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	epoch := time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+	if x.workbook.Properties.Date1904 {
+		epoch = time.Date(1904, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	days := math.Floor(f)
+	seconds := math.Round((f - days) * 86400)
+	t := epoch.AddDate(0, 0, int(days)).Add(time.Duration(seconds) * time.Second)
+	if seconds == 0 {
+		return t.Format("2006-01-02")
+	}
+	return t.Format("2006-01-02 15:04:05")
+}
+
+// isDateFormat returns true if a custom number format code displays a date.
+func isDateFormat(code string) bool {
+	inQuote, inBracket := false, false
+	for _, r := range strings.ToLower(code) {
+		switch {
+		case r == '"':
+			inQuote = !inQuote
+		case inQuote:
+		case r == '[':
+			inBracket = true
+		case r == ']':
+			inBracket = false
+		case inBracket:
+		case r == 'd' || r == 'm' || r == 'y' || r == 'h' || r == 's':
+			return true
+		}
+	}
+	return false
+}
+
+// parseCellRef parses a cell reference such as "B12" into 0-based row and column indexes.
+func parseCellRef(ref string) (int, int, error) {
+	ref = strings.ReplaceAll(ref, "$", "")
+	i := 0
+	col := 0
+	for i < len(ref) && ref[i] >= 'A' && ref[i] <= 'Z' {
+		col = col*26 + int(ref[i]-'A'+1)
+		i++
+	}
+	row, err := strconv.Atoi(ref[i:])
+	if i == 0 || err != nil || row < 1 {
+		return 0, 0, fmt.Errorf("invalid cell reference %q", ref)
+	}
+	return row - 1, col - 1, nil
+}
+
+// ListXLSXSheets returns the worksheet names of an XLSX file, in workbook order.
+func ListXLSXSheets(filePath string) ([]string, error) {
+	x, err := openXLSXFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("list xlsx sheets %s: %w", filePath, err)
+	}
+	defer x.zip.Close()
+	return x.sheetNames(), nil
+}
+
+// ReadXLSXFile reads a worksheet of an XLSX file into a Table. The sheet is
+// selected by name or 1-based index; an empty sheet selects the first one. The
+// header is read from the specified 1-based row, and any rows above it are
+// skipped, as are empty rows. A headerRow of 0 generates column letter names
+// (A, B, C...) instead, keeping every row.
+func ReadXLSXFile(filePath string, sheet string, headerRow int) (Table, error) {
+	var table Table
+	x, err := openXLSXFile(filePath)
+	if err != nil {
+		return table, fmt.Errorf("read xlsx file %s: %w", filePath, err)
+	}
+	defer x.zip.Close()
+
+	// Select the sheet:
+	names := x.sheetNames()
+	if len(names) == 0 {
+		return table, fmt.Errorf("read xlsx file %s: no sheets found", filePath)
+	}
+	name := names[0]
+	if sheet != "" {
+		name = sheet
+		if _, ok := x.sheets[sheet]; !ok {
+			i, err := strconv.Atoi(sheet)
+			if err != nil || i < 1 || i > len(names) {
+				return table, fmt.Errorf("read xlsx file %s: sheet %q not found", filePath, sheet)
+			}
+			name = names[i-1]
+		}
+	}
+	grid, err := x.readSheet(name)
+	if err != nil {
+		return table, fmt.Errorf("read xlsx file %s: %w", filePath, err)
+	}
+
+	// Determine the table width:
+	width := 0
+	for _, row := range grid {
+		if len(row) > width {
+			width = len(row)
+		}
+	}
+	pad := func(row []string) []string {
+		padded := make([]string, width)
+		copy(padded, row)
+		return padded
+	}
+
+	// Split the header and data rows:
+	first := 0
+	if headerRow > 0 {
+		if headerRow > len(grid) {
+			return table, fmt.Errorf("read xlsx file %s: header row %d not found", filePath, headerRow)
+		}
+		table.Header = pad(grid[headerRow-1])
+		first = headerRow
+	} else {
+		table.Header = make([]string, width)
+		for i := range table.Header {
+			table.Header[i] = columnName(i)
+		}
+	}
+	for _, row := range grid[first:] {
+		if strings.TrimSpace(strings.Join(row, "")) == "" {
+			continue
+		}
+		table.Rows = append(table.Rows, pad(row))
+	}
+	return table, nil
+}
+
+// columnName returns the spreadsheet column name (A, B, ... Z, AA...) for a 0-based index.
+func columnName(i int) string {
+	name := ""
+	for i++; i > 0; i = (i - 1) / 26 {
+		name = string(rune('A'+(i-1)%26)) + name
+	}
+	return name
+}
+
+// CleanUnicode normalizes text exported from spreadsheets and word processors.
+// It decodes Excel "_xHHHH_" escapes, converts Windows line endings, replaces
+// non-breaking and other exotic spaces with plain spaces, and removes
+// zero-width characters, byte order marks, soft hyphens, invalid UTF-8, and
+// control characters other than newlines and tabs.
+func CleanUnicode(s string) string {
+	s = decodeXLSXEscapes(s)
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	s = strings.ToValidUTF8(s, "")
+	var b strings.Builder
+	b.Grow(len(s))
+	for _, r := range s {
+		switch {
+		case r == '\n' || r == '\t':
+			b.WriteRune(r)
+		case r == '\r':
+			b.WriteRune('\n')
+		case r == '\u200b' || r == '\u200c' || r == '\u200d' || r == '\u2060' || r == '\ufeff' || r == '\u00ad':
+			// zero-width characters, byte order marks, and soft hyphens
+		case r == utf8.RuneError:
+		case unicode.IsControl(r):
+		case unicode.IsSpace(r):
+			b.WriteRune(' ')
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// decodeXLSXEscapes decodes "_xHHHH_" escapes, which Excel uses to store
+// characters that are not valid in XML (e.g. "_x000D_" for a carriage return).
+func decodeXLSXEscapes(s string) string {
+	if !strings.Contains(s, "_x") {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if i+7 <= len(s) && s[i] == '_' && s[i+1] == 'x' && s[i+6] == '_' {
+			if n, err := strconv.ParseUint(s[i+2:i+6], 16, 32); err == nil {
+				b.WriteRune(rune(n))
+				i += 6
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
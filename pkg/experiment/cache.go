@@ -0,0 +1,118 @@
+package experiment
+
+import (
+	"bufio"
+	"bytes"
+	"content-coding-gpt/pkg/openai"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Cache holds a cell's completed chats, keyed by chat ID, backed by a JSONL
+// file in the cell directory so that an interrupted run can be resumed. A
+// cached chat is only used for a request identical to the one it was made
+// with (see Get), so any change to the essays, template, exemplars, or
+// request parameters reruns the affected chats. The scores are always
+// extracted afresh.
+type Cache struct {
+	Path  string
+	Chats map[string]openai.Chat
+}
+
+// OpenCache reads the cached chats in a cell directory, creating the directory
+// and the cell.json file describing the cell if necessary. An error is
+// returned if the directory holds a different cell, e.g. because the
+// template has changed since the cache was written.
+func OpenCache(dir string, cell Cell) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("open cache %s: %w", dir, err)
+	}
+
+	// Check or write the cell description:
+	cellPath := filepath.Join(dir, "cell.json")
+	b, err := os.ReadFile(cellPath)
+	if err == nil {
+		var cached Cell
+		if err := json.Unmarshal(b, &cached); err != nil {
+			return nil, fmt.Errorf("open cache %s: %w", cellPath, err)
+		}
+		if cached != cell {
+			return nil, fmt.Errorf("open cache %s: the cell has changed since it was cached (e.g. template edited); "+
+				"remove the directory to rerun it", dir)
+		}
+	} else if errors.Is(err, os.ErrNotExist) {
+		b, _ = json.MarshalIndent(cell, "", "  ")
+		if err := os.WriteFile(cellPath, append(b, '\n'), 0644); err != nil {
+			return nil, fmt.Errorf("open cache %s: %w", cellPath, err)
+		}
+	} else {
+		return nil, fmt.Errorf("open cache %s: %w", cellPath, err)
+	}
+
+	// Read the cached chats:
+	c := &Cache{Path: filepath.Join(dir, "responses.jsonl"), Chats: map[string]openai.Chat{}}
+	f, err := os.Open(c.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return c, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("open cache %s: %w", c.Path, err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 1024*1024), 64*1024*1024)
+	for scanner.Scan() {
+		var chat openai.Chat
+		if err := json.Unmarshal(scanner.Bytes(), &chat); err != nil {
+			continue // e.g. a line truncated by an interrupted run
+		}
+		c.Chats[chat.ID] = chat
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("open cache %s: %w", c.Path, err)
+	}
+	return c, nil
+}
+
+// Get returns the cached chat with the ID, if it was made with the request.
+func (c *Cache) Get(id string, request openai.ChatRequest) (openai.Chat, bool) {
+	chat, ok := c.Chats[id]
+	if !ok {
+		return chat, false
+	}
+	cached, err := json.Marshal(chat.Request)
+	if err != nil {
+		return chat, false
+	}
+	b, err := json.Marshal(request)
+	if err != nil || !bytes.Equal(cached, b) {
+		return chat, false
+	}
+	return chat, true
+}
+
+// Add appends the successful chats to the cache, replacing any cached chats
+// with the same IDs.
+func (c *Cache) Add(chats []openai.Chat) error {
+	f, err := os.OpenFile(c.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("write cache %s: %w", c.Path, err)
+	}
+	defer f.Close()
+	for _, chat := range chats {
+		if chat.ErrMsg != "" {
+			continue
+		}
+		b, err := json.Marshal(chat)
+		if err != nil {
+			return fmt.Errorf("write cache %s: %w", c.Path, err)
+		}
+		if _, err := f.Write(append(b, '\n')); err != nil {
+			return fmt.Errorf("write cache %s: %w", c.Path, err)
+		}
+		c.Chats[chat.ID] = chat
+	}
+	return nil
+}
@@ -0,0 +1,245 @@
+// Package experiment runs grids of prompt experiments: every combination of
+// prompt templates, models, temperatures, essay types, and seeds described by
+// a manifest file, with results cached in a run directory.
+package experiment
+
+import (
+	"bytes"
+	"content-coding-gpt/pkg/provenance"
+	"content-coding-gpt/pkg/stats"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Manifest describes a prompt experiment. For example:
+//
+//	name: humility-prompts
+//	input: data/original/training_angry.csv
+//	essay_columns: {conflict: response}
+//	human: {conflict: data/original/training_angry.csv}
+//	templates: ["", data/prompts/humility_template.tmpl]
+//	models: [gpt-3.5-turbo, gpt-4]
+//	temperatures: [0, 0.2]
+//	essay_types: [conflict]
+//	seeds: [1, 2]
+//
+// An empty template is the built-in hallmarks prompt.
+type Manifest struct {
+	// Name is the experiment name, and the name of its run directory.
+	Name string `yaml:"name"`
+
+	// Output is the directory containing the run directory (default "data/experiments").
+	Output string `yaml:"output"`
+
+	// Input is the essay input file, and IDColumn, EssayColumns, and
+	// EssayPrompts describe it, as with the chat command flags.
+	Input        string            `yaml:"input"`
+	IDColumn     string            `yaml:"id_column"`
+	EssayColumns map[string]string `yaml:"essay_columns"`
+	EssayPrompts string            `yaml:"essay_prompts"`
+
+	// Human maps each essay type to a human-coded CSV file used to rank cells.
+	Human map[string]string `yaml:"human"`
+
+	// HumanColumn is the human composite score column (default "standardized").
+	HumanColumn string `yaml:"human_column"`
+
+	// The grid dimensions.
+	Templates    []string  `yaml:"templates"`
+	Models       []string  `yaml:"models"`
+	Temperatures []float32 `yaml:"temperatures"`
+	EssayTypes   []string  `yaml:"essay_types"`
+	Seeds        []int64   `yaml:"seeds"`
+
+	// Extractor and ScoreRange select the score extractor, unless a template
+	// specifies them; see data.NewScoreExtractor.
+	Extractor  string `yaml:"extractor"`
+	ScoreRange string `yaml:"score_range"`
+
+	// MaxTokens is the maximum number of tokens to generate.
+	MaxTokens int `yaml:"max_tokens"`
+
+	// Limit is the maximum number of essays per cell (0: all).
+	Limit int `yaml:"limit"`
+
+	// BatchSize is the number of concurrent requests (default 15).
+	BatchSize int `yaml:"batch_size"`
+
+	// Exemplars, Shots, and ExemplarStrategy add few-shot exemplars, selected
+	// with each cell's seed.
+	Exemplars        string `yaml:"exemplars"`
+	Shots            int    `yaml:"shots"`
+	ExemplarStrategy string `yaml:"exemplar_strategy"`
+
+	// RankBy is the agreement statistic used to rank the cells: pearson,
+	// spearman, icc21 (the default), icc3k, kappa, or alpha.
+	RankBy string `yaml:"rank_by"`
+
+	// Resamples is the number of bootstrap resamples (default 200).
+	Resamples int `yaml:"resamples"`
+}
+
+// RankStatistics is a list of the statistics that cells can be ranked by.
+var RankStatistics = stats.RankStatistics
+
+// ReadManifest reads and validates a manifest file, filling in the defaults.
+// Unknown fields are rejected, as in fine-tune config files.
+func ReadManifest(path string) (Manifest, error) {
+	var m Manifest
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return m, fmt.Errorf("read manifest %s: %w", path, err)
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	if err := dec.Decode(&m); err != nil && err != io.EOF {
+		return m, fmt.Errorf("read manifest %s: %w", path, err)
+	}
+	if m.Name == "" {
+		m.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	if m.Output == "" {
+		m.Output = "data/experiments"
+	}
+	if len(m.Templates) == 0 {
+		m.Templates = []string{""}
+	}
+	if len(m.Temperatures) == 0 {
+		m.Temperatures = []float32{0.2}
+	}
+	if len(m.Seeds) == 0 {
+		m.Seeds = []int64{1}
+	}
+	if m.HumanColumn == "" {
+		m.HumanColumn = "standardized"
+	}
+	if m.BatchSize <= 0 {
+		m.BatchSize = 15
+	}
+	if m.Resamples <= 0 {
+		m.Resamples = 200
+	}
+	if m.Exemplars != "" && m.Shots <= 0 {
+		m.Shots = 3
+	}
+	if m.RankBy == "" {
+		m.RankBy = "icc21"
+	}
+	switch {
+	case len(m.Models) == 0:
+		err = errors.New("no models")
+	case len(m.EssayTypes) == 0:
+		err = errors.New("no essay types")
+	case !contains(RankStatistics, m.RankBy):
+		err = fmt.Errorf("rank_by %s is not one of: %s", m.RankBy, strings.Join(RankStatistics, ", "))
+	}
+	if err != nil {
+		return m, fmt.Errorf("read manifest %s: %w", path, err)
+	}
+	return m, nil
+}
+
+// RunDir returns the run directory of the experiment.
+func (m Manifest) RunDir() string {
+	return filepath.Join(m.Output, m.Name)
+}
+
+// Cell is one combination of the experiment grid.
+type Cell struct {
+	ID           string  `json:"id"`
+	Template     string  `json:"template,omitempty"`
+	TemplateHash string  `json:"template_hash,omitempty"`
+	Model        string  `json:"model"`
+	Temperature  float32 `json:"temperature"`
+	EssayType    string  `json:"essay_type"`
+	Seed         int64   `json:"seed"`
+}
+
+// Dir returns the cell's directory within a run directory.
+func (c Cell) Dir(runDir string) string {
+	return filepath.Join(runDir, "cells", c.ID)
+}
+
+// Cells expands the manifest into its grid of cells, in order of essay type,
+// template, model, temperature, and seed.
+func (m Manifest) Cells() ([]Cell, error) {
+	hashes := make(map[string]string, len(m.Templates))
+	for _, t := range m.Templates {
+		if t == "" {
+			continue
+		}
+		hash, err := provenance.FileHash(t)
+		if err != nil {
+			return nil, err
+		}
+		hashes[t] = hash
+	}
+	var cells []Cell
+	ids := map[string]bool{}
+	for _, essayType := range m.EssayTypes {
+		for _, t := range m.Templates {
+			for _, model := range m.Models {
+				for _, temperature := range m.Temperatures {
+					for _, seed := range m.Seeds {
+						c := Cell{
+							Template:     t,
+							TemplateHash: hashes[t],
+							Model:        model,
+							Temperature:  temperature,
+							EssayType:    essayType,
+							Seed:         seed,
+						}
+						c.ID = cellID(c)
+						if ids[c.ID] {
+							return nil, fmt.Errorf("experiment: duplicate cell %s", c.ID)
+						}
+						ids[c.ID] = true
+						cells = append(cells, c)
+					}
+				}
+			}
+		}
+	}
+	return cells, nil
+}
+
+// nonIdentifier matches the characters replaced in cell IDs.
+var nonIdentifier = regexp.MustCompile(`[^A-Za-z0-9.]+`)
+
+// cellID returns a readable, file-safe ID for a cell, e.g.
+// "conflict_humility-template_gpt-4_t0.2_s1".
+func cellID(c Cell) string {
+	template := "default"
+	if c.Template != "" {
+		template = strings.TrimSuffix(filepath.Base(c.Template), filepath.Ext(c.Template))
+	}
+	parts := []string{
+		c.EssayType,
+		template,
+		c.Model,
+		"t" + strconv.FormatFloat(float64(c.Temperature), 'g', -1, 32),
+		"s" + strconv.FormatInt(c.Seed, 10),
+	}
+	for i, p := range parts {
+		parts[i] = strings.Trim(nonIdentifier.ReplaceAllString(p, "-"), "-")
+	}
+	return strings.Join(parts, "_")
+}
+
+// contains returns true if the list contains the string.
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,84 @@
+package experiment
+
+import (
+	"content-coding-gpt/pkg/data"
+	"content-coding-gpt/pkg/stats"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+// Result is the outcome of a cell: the number of essays scored, and the
+// agreement with the human coders, if there is a human file for its essay type.
+type Result struct {
+	Cell      Cell             `json:"cell"`
+	Essays    int              `json:"essays"`
+	Scored    int              `json:"scored"`
+	Agreement *stats.Agreement `json:"agreement,omitempty"`
+}
+
+// Statistic returns the named agreement statistic (see RankStatistics), or
+// NaN if it is unavailable.
+func (r Result) Statistic(name string) float64 {
+	if r.Agreement == nil {
+		return math.NaN()
+	}
+	return r.Agreement.Statistic(name)
+}
+
+// Rank sorts the results by the named statistic, best first; results without
+// the statistic are last.
+func Rank(results []Result, by string) {
+	sort.SliceStable(results, func(i, j int) bool {
+		a, b := results[i].Statistic(by), results[j].Statistic(by)
+		if math.IsNaN(b) {
+			return !math.IsNaN(a)
+		}
+		return a > b
+	})
+}
+
+// SummaryCSVHeader is the header of the summary.csv file of a run.
+var SummaryCSVHeader = []string{"rank", "cell", "essay_type", "template", "model", "temperature", "seed",
+	"essays", "scored", "n", "pearson", "spearman", "icc21", "icc3k", "kappa", "alpha", "mae", "rmse"}
+
+// WriteSummary writes the ranked results to summary.csv and summary.json in
+// the run directory.
+func WriteSummary(runDir string, results []Result) error {
+	format := func(v float64) string {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return ""
+		}
+		return strconv.FormatFloat(v, 'f', 4, 64)
+	}
+	records := [][]string{SummaryCSVHeader}
+	for i, r := range results {
+		c := r.Cell
+		record := []string{strconv.Itoa(i + 1), c.ID, c.EssayType, c.Template, c.Model,
+			strconv.FormatFloat(float64(c.Temperature), 'g', -1, 32), strconv.FormatInt(c.Seed, 10),
+			strconv.Itoa(r.Essays), strconv.Itoa(r.Scored)}
+		if a := r.Agreement; a != nil {
+			record = append(record, strconv.Itoa(a.N), format(a.Pearson.Value), format(a.Spearman.Value),
+				format(a.ICC21.Value), format(a.ICC3k.Value), format(a.Kappa.Value), format(a.Alpha.Value),
+				format(a.MAE.Value), format(a.RMSE.Value))
+		} else {
+			record = append(record, "0", "", "", "", "", "", "", "", "")
+		}
+		records = append(records, record)
+	}
+	if err := data.WriteCSVFile(filepath.Join(runDir, "summary.csv"), records); err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		return fmt.Errorf("write summary: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(runDir, "summary.json"), append(b, '\n'), 0644); err != nil {
+		return fmt.Errorf("write summary: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,299 @@
+package openai
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// Batch statuses.
+const (
+	BatchValidating = "validating"
+	BatchFailed     = "failed"
+	BatchInProgress = "in_progress"
+	BatchFinalizing = "finalizing"
+	BatchCompleted  = "completed"
+	BatchExpired    = "expired"
+	BatchCancelling = "cancelling"
+	BatchCancelled  = "cancelled"
+)
+
+// ChatCompletionsEndpoint is the batch endpoint of chat completion requests.
+const ChatCompletionsEndpoint = "/v1/chat/completions"
+
+// BatchRequest is a request to create a batch of API requests, which are
+// processed asynchronously, at a discount, within the completion window.
+type BatchRequest struct {
+	// InputFileID is the ID of the uploaded JSONL file of requests, with
+	// purpose "batch". See WriteBatchChats.
+	InputFileID string `json:"input_file_id"`
+
+	// Endpoint is the endpoint of every request, e.g. ChatCompletionsEndpoint.
+	Endpoint string `json:"endpoint"`
+
+	// CompletionWindow is the time frame of the batch, e.g. "24h".
+	CompletionWindow string `json:"completion_window"`
+
+	// Metadata holds up to 16 custom key-value pairs.
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// Batch provides information about a batch of API requests.
+type Batch struct {
+	ID               string             `json:"id"`     // e.g. "batch_abc123"
+	Object           string             `json:"object"` // "batch"
+	Endpoint         string             `json:"endpoint"`
+	Errors           *BatchErrors       `json:"errors,omitempty"` // validation errors
+	InputFileID      string             `json:"input_file_id"`
+	CompletionWindow string             `json:"completion_window"`
+	Status           string             `json:"status"`
+	OutputFileID     string             `json:"output_file_id,omitempty"` // successful requests
+	ErrorFileID      string             `json:"error_file_id,omitempty"`  // failed requests
+	RequestCounts    BatchRequestCounts `json:"request_counts"`
+	Metadata         map[string]string  `json:"metadata,omitempty"`
+
+	// The timestamps in epoch seconds, if reached.
+	CreatedAt    int64 `json:"created_at"`
+	InProgressAt int64 `json:"in_progress_at,omitempty"`
+	ExpiresAt    int64 `json:"expires_at,omitempty"`
+	FinalizingAt int64 `json:"finalizing_at,omitempty"`
+	CompletedAt  int64 `json:"completed_at,omitempty"`
+	FailedAt     int64 `json:"failed_at,omitempty"`
+	ExpiredAt    int64 `json:"expired_at,omitempty"`
+	CancellingAt int64 `json:"cancelling_at,omitempty"`
+	CancelledAt  int64 `json:"cancelled_at,omitempty"`
+}
+
+// Done returns true if the batch has completed, failed, expired, or been
+// cancelled. An expired or cancelled batch may have partial output.
+func (b Batch) Done() bool {
+	switch b.Status {
+	case BatchCompleted, BatchFailed, BatchExpired, BatchCancelled:
+		return true
+	}
+	return false
+}
+
+// String returns a one-line summary of the batch.
+func (b Batch) String() string {
+	return fmt.Sprintf("%s %s %d/%d completed, %d failed", b.ID, b.Status,
+		b.RequestCounts.Completed, b.RequestCounts.Total, b.RequestCounts.Failed)
+}
+
+// BatchRequestCounts are the numbers of requests of a batch.
+type BatchRequestCounts struct {
+	Total     int `json:"total"`
+	Completed int `json:"completed"`
+	Failed    int `json:"failed"`
+}
+
+// BatchErrors is the list of errors of a batch that failed validation.
+type BatchErrors struct {
+	Object string       `json:"object"` // "list"
+	Data   []BatchError `json:"data"`
+}
+
+// Error returns the errors, one per line.
+func (e *BatchErrors) Error() string {
+	var s string
+	for i, err := range e.Data {
+		if i > 0 {
+			s += "\n"
+		}
+		s += err.Error()
+	}
+	return s
+}
+
+// BatchError is an error of a batch, or of a request in a batch.
+type BatchError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Param   string `json:"param,omitempty"`
+	Line    int    `json:"line,omitempty"` // of the input file
+}
+
+// Error returns the error message.
+func (e BatchError) Error() string {
+	s := e.Code + ": " + e.Message
+	if e.Line > 0 {
+		s = fmt.Sprintf("line %d: %s", e.Line, s)
+	}
+	return s
+}
+
+// BatchInput is a line of a batch input file: a request and its custom ID.
+type BatchInput struct {
+	CustomID string      `json:"custom_id"`
+	Method   string      `json:"method"`
+	URL      string      `json:"url"`
+	Body     interface{} `json:"body"`
+}
+
+// BatchOutput is a line of a batch output or error file: the response to a
+// request, or its error.
+type BatchOutput struct {
+	ID       string         `json:"id"` // e.g. "batch_req_abc123"
+	CustomID string         `json:"custom_id"`
+	Response *BatchResponse `json:"response,omitempty"`
+	Error    *BatchError    `json:"error,omitempty"`
+}
+
+// BatchResponse is the HTTP response to a request in a batch.
+type BatchResponse struct {
+	StatusCode int             `json:"status_code"`
+	RequestID  string          `json:"request_id"`
+	Body       json.RawMessage `json:"body"`
+}
+
+// WriteBatchChats writes the chat requests to a batch input file, with the
+// chat IDs as the custom IDs, which must be unique.
+func WriteBatchChats(w io.Writer, chats []Chat) error {
+	enc := json.NewEncoder(w)
+	seen := make(map[string]bool, len(chats))
+	for _, chat := range chats {
+		if seen[chat.ID] {
+			return fmt.Errorf("write batch: duplicate custom ID %q", chat.ID)
+		}
+		seen[chat.ID] = true
+		input := BatchInput{CustomID: chat.ID, Method: http.MethodPost, URL: ChatCompletionsEndpoint, Body: chat.Request}
+		if err := enc.Encode(input); err != nil {
+			return fmt.Errorf("write batch: %w", err)
+		}
+	}
+	return nil
+}
+
+// ReadBatchRequests reads the chat requests of a batch input file, keyed by
+// custom ID.
+func ReadBatchRequests(r io.Reader) (map[string]ChatRequest, error) {
+	requests := make(map[string]ChatRequest)
+	dec := json.NewDecoder(r)
+	for line := 1; ; line++ {
+		var in struct {
+			CustomID string      `json:"custom_id"`
+			Body     ChatRequest `json:"body"`
+		}
+		if err := dec.Decode(&in); errors.Is(err, io.EOF) {
+			return requests, nil
+		} else if err != nil {
+			return requests, fmt.Errorf("read batch input: line %d: %w", line, err)
+		}
+		requests[in.CustomID] = in.Body
+	}
+}
+
+// ReadBatchChats reads the chat responses of a batch output or error file,
+// keyed by custom ID. A request that failed has the error in Chat.ErrMsg.
+func ReadBatchChats(r io.Reader) (map[string]Chat, error) {
+	chats := make(map[string]Chat)
+	dec := json.NewDecoder(r)
+	for line := 1; ; line++ {
+		var out BatchOutput
+		if err := dec.Decode(&out); errors.Is(err, io.EOF) {
+			return chats, nil
+		} else if err != nil {
+			return chats, fmt.Errorf("read batch output: line %d: %w", line, err)
+		}
+		chat := Chat{ID: out.CustomID}
+		switch {
+		case out.Error != nil:
+			chat.ErrMsg = out.Error.Error()
+		case out.Response == nil:
+			chat.ErrMsg = "no response"
+		case out.Response.StatusCode != http.StatusOK:
+			var body struct {
+				Error BatchError `json:"error"`
+			}
+			_ = json.Unmarshal(out.Response.Body, &body)
+			chat.ErrMsg = fmt.Sprintf("status code %d: %s", out.Response.StatusCode, body.Error.Message)
+		default:
+			if err := json.Unmarshal(out.Response.Body, &chat.Response); err != nil {
+				chat.ErrMsg = fmt.Sprintf("error unmarshaling response: %v", err)
+			}
+		}
+		chats[chat.ID] = chat
+	}
+}
+
+// CreateBatch creates a batch of the requests in an uploaded input file.
+func (c *Client) CreateBatch(ctx context.Context, req BatchRequest) (Batch, error) {
+	var batch Batch
+	if req.Endpoint == "" {
+		req.Endpoint = ChatCompletionsEndpoint
+	}
+	if req.CompletionWindow == "" {
+		req.CompletionWindow = "24h"
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return batch, fmt.Errorf("create batch: %w", err)
+	}
+	httpReq, err := c.postRequest(ctx, "/batches", bytes.NewReader(body))
+	if err != nil {
+		return batch, fmt.Errorf("create batch: %w", err)
+	}
+	raw, err := c.sendRequest(httpReq)
+	if err != nil {
+		return batch, fmt.Errorf("create batch: %w", err)
+	}
+	if err := json.Unmarshal(raw, &batch); err != nil {
+		return batch, fmt.Errorf("create batch: error unmarshaling response: %w", err)
+	}
+	return batch, nil
+}
+
+// ReadBatchRaw reads the specified batch. It returns the raw JSON response.
+func (c *Client) ReadBatchRaw(ctx context.Context, id string) ([]byte, error) {
+	req, err := c.getRequest(ctx, "/batches/"+id)
+	if err != nil {
+		return nil, fmt.Errorf("read batch %s: %w", id, err)
+	}
+	body, err := c.sendRequest(req)
+	if err != nil {
+		return nil, fmt.Errorf("read batch %s: %w", id, err)
+	}
+	return body, nil
+}
+
+// ReadBatch reads the specified batch.
+func (c *Client) ReadBatch(ctx context.Context, id string) (Batch, error) {
+	var batch Batch
+	body, err := c.ReadBatchRaw(ctx, id)
+	if err != nil {
+		return batch, err
+	}
+	if err := json.Unmarshal(body, &batch); err != nil {
+		return batch, fmt.Errorf("read batch %s: error unmarshaling response: %w", id, err)
+	}
+	return batch, nil
+}
+
+// CancelBatch cancels the specified batch. Its status is "cancelling" for up
+// to 10 minutes before it is "cancelled", with any partial output.
+func (c *Client) CancelBatch(ctx context.Context, id string) (Batch, error) {
+	var batch Batch
+	req, err := c.postRequest(ctx, "/batches/"+id+"/cancel", nil)
+	if err != nil {
+		return batch, fmt.Errorf("cancel batch %s: %w", id, err)
+	}
+	body, err := c.sendRequest(req)
+	if err != nil {
+		return batch, fmt.Errorf("cancel batch %s: %w", id, err)
+	}
+	if err := json.Unmarshal(body, &batch); err != nil {
+		return batch, fmt.Errorf("cancel batch %s: error unmarshaling response: %w", id, err)
+	}
+	return batch, nil
+}
+
+// IterateBatches returns an iterator over the batches, newest first.
+func (c *Client) IterateBatches(ctx context.Context, opts ListOptions) *Iterator[Batch] {
+	return newIterator(ctx, c, "/batches", "list batches", opts,
+		func(b Batch) string { return b.ID },
+		func(b Batch) bool { return opts.hasStatus(b.Status) })
+}
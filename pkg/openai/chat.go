@@ -68,6 +68,10 @@ type ChatRequest struct {
 	// log probabilities of that token. The default is an empty dictionary.
 	LogitBias map[string]int `json:"logit_bias,omitempty"`
 
+	// Seed requests deterministic sampling: repeated requests with the same
+	// seed and parameters should return the same result. The default is none.
+	Seed int64 `json:"seed,omitempty"`
+
 	// User is a unique identifier representing your end-user, which can help
 	// OpenAI to monitor and detect abuse. The default is an empty string.
 	User string `json:"user,omitempty"`
@@ -99,9 +103,13 @@ type ChatResponse struct {
 	ID      string          `json:"id"`      // eg. "chatcmpl-6p9XYPYSTTRi0xEviKjjilqrWU2Ve"
 	Object  string          `json:"object"`  // eg. "chat.completion"
 	Created int64           `json:"created"` // epoch seconds, eg. 1677966478
-	Model   string          `json:"model"`   // eg. "gpt-3.5-turbo"
+	Model   string          `json:"model"`   // eg. "gpt-3.5-turbo-0613"
 	Usage   Usage           `json:"usage"`
 	Choices []MessageChoice `json:"choices"`
+
+	// SystemFingerprint identifies the backend configuration that generated
+	// the response, if reported.
+	SystemFingerprint string `json:"system_fingerprint,omitempty"`
 }
 
 // String supports the fmt.Stringer interface.
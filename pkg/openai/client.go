@@ -6,7 +6,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"mime/multipart"
 	"net/http"
 	"os"
 	"sort"
@@ -169,60 +168,17 @@ func (c *Client) ValidModel(ctx context.Context, id string) bool {
 }
 
 // UploadFile uploads a jsonl file for use with subsequent fine-tuning requests.
+// See UploadFileFrom to stream a large file.
 func (c *Client) UploadFile(ctx context.Context, fileName, purpose string, data []byte) (File, error) {
-	var file File
-
-	// Create the multipart writer
-	var buf bytes.Buffer
-	w := multipart.NewWriter(&buf)
-
-	// File Purpose: usually "fine-tune"
-	if purpose == "" {
-		purpose = "fine-tune"
-	}
-	err := w.WriteField("purpose", purpose)
-	if err != nil {
-		return file, fmt.Errorf("upload file: field purpose: %w", err)
-	}
-
-	// File Name and Data
-	var fw io.Writer
-	fw, err = w.CreateFormFile("file", fileName)
-	if err != nil {
-		return file, fmt.Errorf("upload file: field file: %w", err)
-	}
-	_, err = io.Copy(fw, bytes.NewReader(data))
-	if err != nil {
-		return file, fmt.Errorf("upload file: field file: %w", err)
-	}
-	w.Close()
-
-	// Create the request
-	req, err := c.postRequest(ctx, "/files", &buf)
-	if err != nil {
-		return file, fmt.Errorf("upload file: %w", err)
-	}
-	req.Header.Set("Content-Type", w.FormDataContentType())
-
-	// Send the request
-	body, err := c.sendRequest(req)
-	if err != nil {
-		return file, fmt.Errorf("upload file: send request: %w", err)
-	}
-	if err := json.Unmarshal(body, &file); err != nil {
-		return file, fmt.Errorf("upload file: unmarshaling response: %w", err)
-	}
-	return file, nil
+	file, _, err := c.UploadFileFrom(ctx, fileName, purpose, bytes.NewReader(data), TransferOptions{Size: int64(len(data))})
+	return file, err
 }
 
 // ListFilesRaw lists the currently available files, and provides basic information
-// about each one such as the owner and availability. It returns the raw JSON response.
-func (c *Client) ListFilesRaw(ctx context.Context) ([]byte, error) {
-	req, err := c.getRequest(ctx, "/files")
-	if err != nil {
-		return nil, fmt.Errorf("list files: %w", err)
-	}
-	body, err := c.sendRequest(req)
+// about each one such as the owner and availability. It returns the raw JSON response
+// of the first page, or the page after opts.After.
+func (c *Client) ListFilesRaw(ctx context.Context, opts ListOptions) ([]byte, error) {
+	body, err := c.listRaw(ctx, "/files"+opts.query(opts.After))
 	if err != nil {
 		return nil, fmt.Errorf("list files: %w", err)
 	}
@@ -230,19 +186,12 @@ func (c *Client) ListFilesRaw(ctx context.Context) ([]byte, error) {
 }
 
 // ListFiles lists the currently available files, and provides basic information
-// about each one such as the owner and availability.
-func (c *Client) ListFiles(ctx context.Context) ([]File, error) {
-	// Fetch the raw JSON response:
-	body, err := c.ListFilesRaw(ctx)
+// about each one such as the owner and availability. It reads every page.
+func (c *Client) ListFiles(ctx context.Context, opts ListOptions) ([]File, error) {
+	files, err := c.IterateFiles(ctx, opts).All()
 	if err != nil {
 		return nil, err
 	}
-	// Unmarshal the JSON response into a list of files:
-	var list FileList
-	if err := json.Unmarshal(body, &list); err != nil {
-		return nil, fmt.Errorf("list files: error unmarshaling response: %w", err)
-	}
-	files := list.Data
 	// Sort the files by name and return:
 	sort.Slice(files, func(i, j int) bool { return files[i].FileName < files[j].FileName })
 	return files, nil
@@ -274,17 +223,14 @@ func (c *Client) ReadFile(ctx context.Context, id string) (File, error) {
 	return file, nil
 }
 
-// DownloadFile reads the contents of the specified file.
+// DownloadFile reads the contents of the specified file. See DownloadFileTo to
+// stream a large file.
 func (c *Client) DownloadFile(ctx context.Context, id string) ([]byte, error) {
-	req, err := c.getRequest(ctx, "/files/"+id+"/content")
-	if err != nil {
-		return nil, fmt.Errorf("download file %s: %w", id, err)
-	}
-	body, err := c.sendRequest(req)
-	if err != nil {
-		return nil, fmt.Errorf("download file %s: %w", id, err)
+	var buf bytes.Buffer
+	if _, err := c.DownloadFileTo(ctx, id, &buf, TransferOptions{}); err != nil {
+		return nil, err
 	}
-	return body, nil
+	return buf.Bytes(), nil
 }
 
 // DeleteFile deletes the specified file.
@@ -333,13 +279,10 @@ func (c *Client) CreateFineTune(ctx context.Context, req FineTuneRequest) (FineT
 }
 
 // ListFineTunesRaw lists the currently available fine-tuning jobs, and provides basic information
-// about each one, including job status events. It returns the raw JSON response.
-func (c *Client) ListFineTunesRaw(ctx context.Context) ([]byte, error) {
-	req, err := c.getRequest(ctx, "/fine-tunes")
-	if err != nil {
-		return nil, fmt.Errorf("list fine-tunes: %w", err)
-	}
-	body, err := c.sendRequest(req)
+// about each one, including job status events. It returns the raw JSON response of the first
+// page, or the page after opts.After.
+func (c *Client) ListFineTunesRaw(ctx context.Context, opts ListOptions) ([]byte, error) {
+	body, err := c.listRaw(ctx, "/fine-tunes"+opts.query(opts.After))
 	if err != nil {
 		return nil, fmt.Errorf("list fine-tunes: %w", err)
 	}
@@ -347,19 +290,12 @@ func (c *Client) ListFineTunesRaw(ctx context.Context) ([]byte, error) {
 }
 
 // ListFineTunes lists the currently available fine-tuning jobs, and provides basic information
-// about each one, including job status events.
-func (c *Client) ListFineTunes(ctx context.Context) ([]FineTune, error) {
-	// Fetch the raw JSON response:
-	body, err := c.ListFineTunesRaw(ctx)
+// about each one, including job status events. It reads every page.
+func (c *Client) ListFineTunes(ctx context.Context, opts ListOptions) ([]FineTune, error) {
+	fineTunes, err := c.IterateFineTunes(ctx, opts).All()
 	if err != nil {
 		return nil, err
 	}
-	// Unmarshal the JSON response into a list of fine-tunes:
-	var list FineTuneList
-	if err := json.Unmarshal(body, &list); err != nil {
-		return nil, fmt.Errorf("list fine-tunes: error unmarshaling response: %w", err)
-	}
-	fineTunes := list.Data
 	// Sort the fine-tunes by name or ID and return:
 	sort.Slice(fineTunes, func(i, j int) bool { return fineTunes[i].Name() < fineTunes[j].Name() })
 	return fineTunes, nil
@@ -391,32 +327,19 @@ func (c *Client) ReadFineTune(ctx context.Context, id string) (FineTune, error)
 	return fineTune, nil
 }
 
-// ListFineTuneEventsRaw lists the events for the specified fine-tuning job. It returns the raw JSON response.
-func (c *Client) ListFineTuneEventsRaw(ctx context.Context, id string) ([]byte, error) {
-	req, err := c.getRequest(ctx, "/fine-tunes/"+id+"/events")
-	if err != nil {
-		return nil, fmt.Errorf("list fine-tune events %s: %w", id, err)
-	}
-	body, err := c.sendRequest(req)
+// ListFineTuneEventsRaw lists the events for the specified fine-tuning job. It returns the raw
+// JSON response of the first page, or the page after opts.After.
+func (c *Client) ListFineTuneEventsRaw(ctx context.Context, id string, opts ListOptions) ([]byte, error) {
+	body, err := c.listRaw(ctx, "/fine-tunes/"+id+"/events"+opts.query(opts.After))
 	if err != nil {
 		return nil, fmt.Errorf("list fine-tune events %s: %w", id, err)
 	}
 	return body, nil
 }
 
-// ListFineTuneEvents lists the events for the specified fine-tuning job.
-func (c *Client) ListFineTuneEvents(ctx context.Context, id string) ([]Event, error) {
-	// Fetch the raw JSON response:
-	body, err := c.ListFineTuneEventsRaw(ctx, id)
-	if err != nil {
-		return nil, err
-	}
-	// Unmarshal the JSON response into a list of fine-tune events:
-	var list EventList
-	if err := json.Unmarshal(body, &list); err != nil {
-		return nil, fmt.Errorf("list fine-tune events %s: error unmarshaling response: %w", id, err)
-	}
-	return list.Data, nil
+// ListFineTuneEvents lists the events for the specified fine-tuning job. It reads every page.
+func (c *Client) ListFineTuneEvents(ctx context.Context, id string, opts ListOptions) ([]Event, error) {
+	return c.IterateFineTuneEvents(ctx, id, opts).All()
 }
 
 // CancelFineTune cancels the specified fine-tuning job.
@@ -516,6 +439,47 @@ func (c *Client) ChatCompletion(ctx context.Context, req ChatRequest) (ChatRespo
 	return chat, nil
 }
 
+// CreateEmbeddingsRaw creates embedding vectors for the input texts. It returns the raw JSON response.
+func (c *Client) CreateEmbeddingsRaw(ctx context.Context, req EmbeddingRequest) ([]byte, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("create embeddings: %w", err)
+	}
+	httpReq, err := c.postRequest(ctx, "/embeddings", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("create embeddings: %w", err)
+	}
+	raw, err := c.sendRequest(httpReq)
+	if err != nil {
+		return raw, fmt.Errorf("create embeddings: %w", err)
+	}
+	return raw, nil
+}
+
+// CreateEmbeddings creates embedding vectors for the input texts. The vectors
+// are returned in the same order as the input texts.
+func (c *Client) CreateEmbeddings(ctx context.Context, req EmbeddingRequest) ([][]float32, error) {
+	raw, err := c.CreateEmbeddingsRaw(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	var resp EmbeddingResponse
+	if err := json.Unmarshal(raw, &resp); err != nil {
+		return nil, fmt.Errorf("create embeddings: error unmarshaling response: %w", err)
+	}
+	if len(resp.Data) != len(req.Input) {
+		return nil, fmt.Errorf("create embeddings: expected %d embeddings, received %d", len(req.Input), len(resp.Data))
+	}
+	embeddings := make([][]float32, len(resp.Data))
+	for _, e := range resp.Data {
+		if e.Index < 0 || e.Index >= len(embeddings) {
+			return nil, fmt.Errorf("create embeddings: invalid index %d", e.Index)
+		}
+		embeddings[e.Index] = e.Embedding
+	}
+	return embeddings, nil
+}
+
 // ChatBatch concurrently processes a single batch of chat completions.
 func (c *Client) ChatBatch(ctx context.Context, chats []Chat) map[string]Chat {
 	results := make(chan Chat, len(chats))
@@ -0,0 +1,30 @@
+package openai
+
+// EmbeddingRequest is a request to create embedding vectors for input texts.
+type EmbeddingRequest struct {
+	// Model ID to use for embeddings. Example: "text-embedding-ada-002"
+	Model string `json:"model"`
+
+	// Input is a list of texts to embed. Each text must not exceed the
+	// model's maximum input length (8191 tokens for text-embedding-ada-002).
+	Input []string `json:"input"`
+
+	// User is a unique identifier representing your end-user, which can help
+	// OpenAI to monitor and detect abuse. The default is an empty string.
+	User string `json:"user,omitempty"`
+}
+
+// EmbeddingResponse provides the embedding vectors for a list of input texts.
+type EmbeddingResponse struct {
+	Object string      `json:"object"` // "list" is expected
+	Data   []Embedding `json:"data"`   // list of embeddings, in input order
+	Model  string      `json:"model"`  // eg. "text-embedding-ada-002-v2"
+	Usage  Usage       `json:"usage"`
+}
+
+// Embedding is the embedding vector for a single input text.
+type Embedding struct {
+	Object    string    `json:"object"` // "embedding" is expected
+	Index     int       `json:"index"`  // index of the input text
+	Embedding []float32 `json:"embedding"`
+}
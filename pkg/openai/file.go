@@ -30,6 +30,7 @@ type File struct {
 
 // FileList is a list of files that belong to the user's organization.
 type FileList struct {
-	Object string `json:"object"` // "list" is expected
-	Data   []File `json:"data"`   // list of files
+	Object  string `json:"object"`             // "list" is expected
+	Data    []File `json:"data"`               // list of files
+	HasMore bool   `json:"has_more,omitempty"` // more pages?
 }
@@ -1,5 +1,11 @@
 package openai
 
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
 // TrainingRecord provides a prompt and an expected completion.
 type TrainingRecord struct {
 	// Prompt is the prompt text.
@@ -12,56 +18,116 @@ type TrainingRecord struct {
 // FineTuneRequest is a request to fine-tune a model.
 type FineTuneRequest struct {
 	// TrainingFileID is the ID of an uploaded file containing the training data.
-	TrainingFileID string `json:"training_file"`
+	TrainingFileID string `json:"training_file" yaml:"training_file,omitempty"`
 
 	// ValidationFileID is the ID of an uploaded file containing the validation data.
-	ValidationFileID string `json:"validation_file,omitempty"`
+	ValidationFileID string `json:"validation_file,omitempty" yaml:"validation_file,omitempty"`
 
 	// Model is the name/ID of the base model to fine-tune. The default is "curie".
 	// You can select one of "ada", "babbage", "curie", "davinci", or a fine-tuned model ID.
-	Model string `json:"model,omitempty"`
+	Model string `json:"model,omitempty" yaml:"model,omitempty"`
 
 	// EpochCount is the number of epochs to train for. The default is 4.
 	// An epoch refers to one full cycle through the training dataset.
-	EpochCount int `json:"n_epochs,omitempty"`
+	EpochCount int `json:"n_epochs,omitempty" yaml:"n_epochs,omitempty"`
 
 	// BatchSize is the number of training examples to process in parallel.
 	// By default, the batch size will be dynamically configured to be ~0.2%
 	// of the number of examples in the training set, capped at 256.
-	BatchSize int `json:"batch_size,omitempty"`
+	BatchSize int `json:"batch_size,omitempty" yaml:"batch_size,omitempty"`
 
 	// LearningRate is the learning rate multiplier for the fine-tuning. The fine-tuning learning
 	// rate is the original learning rate used for pretraining, multiplied by this value. By
 	// default, the learning rate multiplier is the 0.05, 0.1, or 0.2 depending on final batch_size.
-	LearningRate float64 `json:"learning_rate_multiplier,omitempty"`
+	LearningRate float64 `json:"learning_rate_multiplier,omitempty" yaml:"learning_rate_multiplier,omitempty"`
 
 	// PromptLossWeight is the weight of the prompt loss. The default is 0.01. The weight to
 	// use for loss on the prompt tokens. This controls how much the model tries to learn to
 	// generate the prompt (as compared to the completion which always has a weight of 1.0),
 	// and can add a stabilizing effect to training when completions are short.
-	PromptLossWeight float64 `json:"prompt_loss_weight,omitempty"`
+	PromptLossWeight float64 `json:"prompt_loss_weight,omitempty" yaml:"prompt_loss_weight,omitempty"`
 
 	// ComputeClassificationMetrics is a flag indicating whether to compute classification metrics.
 	// The default is false. If true, the fine-tuning will compute classification metrics on the
 	// validation set. This can be useful for determining whether the model is overfitting.
-	ComputeClassificationMetrics bool `json:"compute_classification_metrics,omitempty"`
+	ComputeClassificationMetrics bool `json:"compute_classification_metrics,omitempty" yaml:"compute_classification_metrics,omitempty"`
 
 	// ClassificationNClasses is the number of classes to use in a classification task.
 	// This parameter is required for multiclass classification tasks.
-	ClassificationNClasses int `json:"classification_n_classes,omitempty"`
+	ClassificationNClasses int `json:"classification_n_classes,omitempty" yaml:"classification_n_classes,omitempty"`
 
 	// ClassificationPositiveClass is the positive class label for a binary classification task.
 	// This parameter is needed to generate precision, recall, and F1 metrics when doing binary
 	// classification.
-	ClassificationPositiveClass string `json:"classification_positive_class,omitempty"`
+	ClassificationPositiveClass string `json:"classification_positive_class,omitempty" yaml:"classification_positive_class,omitempty"`
 
 	// ClassificationBetas is a list of beta values to use for computing F-beta scores. The
 	// F-beta score is a generalization of F-1 score. This is only used for binary classification.
-	ClassificationBetas []float64 `json:"classification_betas,omitempty"`
+	ClassificationBetas []float64 `json:"classification_betas,omitempty" yaml:"classification_betas,omitempty"`
 
 	// Suffix is a string of up to 40 characters that will be added to your fine-tuned model name.
 	// This can be useful for distinguishing between different fine-tuned models.
-	Suffix string `json:"suffix,omitempty"`
+	Suffix string `json:"suffix,omitempty" yaml:"suffix,omitempty"`
+}
+
+// Validate checks a fine-tune request before it is submitted: the training
+// file is required, the hyperparameters must not be negative, and the
+// classification fields require compute_classification_metrics, which in
+// turn requires a validation file and classification_n_classes (and, for
+// binary classification, classification_positive_class).
+func (r FineTuneRequest) Validate() error {
+	var problems []string
+	problem := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+	if r.TrainingFileID == "" {
+		problem("training_file is required")
+	}
+	if r.EpochCount < 0 {
+		problem("n_epochs must not be negative")
+	}
+	if r.BatchSize < 0 {
+		problem("batch_size must not be negative")
+	}
+	if r.LearningRate < 0 {
+		problem("learning_rate_multiplier must not be negative")
+	}
+	if r.PromptLossWeight < 0 || r.PromptLossWeight > 1 {
+		problem("prompt_loss_weight must be between 0 and 1")
+	}
+	if len(r.Suffix) > 40 {
+		problem("suffix must be at most 40 characters")
+	}
+	if r.ComputeClassificationMetrics {
+		if r.ValidationFileID == "" {
+			problem("compute_classification_metrics requires a validation_file")
+		}
+		switch {
+		case r.ClassificationNClasses == 0:
+			problem("compute_classification_metrics requires classification_n_classes")
+		case r.ClassificationNClasses < 2:
+			problem("classification_n_classes must be at least 2")
+		case r.ClassificationNClasses == 2 && r.ClassificationPositiveClass == "":
+			problem("binary classification requires classification_positive_class")
+		case r.ClassificationNClasses > 2 && r.ClassificationPositiveClass != "":
+			problem("classification_positive_class is only used for binary classification")
+		}
+		if len(r.ClassificationBetas) > 0 && r.ClassificationNClasses != 2 {
+			problem("classification_betas are only used for binary classification")
+		}
+		for _, beta := range r.ClassificationBetas {
+			if beta <= 0 {
+				problem("classification_betas must be positive")
+				break
+			}
+		}
+	} else if r.ClassificationNClasses != 0 || r.ClassificationPositiveClass != "" || len(r.ClassificationBetas) > 0 {
+		problem("classification options require compute_classification_metrics")
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid fine-tune request: %s", strings.Join(problems, "; "))
+	}
+	return nil
 }
 
 // FineTune provides information about an OpenAPI fine-tune job/model.
@@ -109,6 +175,21 @@ type FineTune struct {
 	Events []Event `json:"events,omitempty"`
 }
 
+// Fine-tune job statuses.
+const (
+	FineTunePending   = "pending"
+	FineTuneRunning   = "running"
+	FineTuneSucceeded = "succeeded"
+	FineTuneFailed    = "failed"
+	FineTuneCancelled = "cancelled"
+)
+
+// Done returns true if the fine-tune job has finished: succeeded, failed, or
+// been cancelled.
+func (f FineTune) Done() bool {
+	return f.Status == FineTuneSucceeded || f.Status == FineTuneFailed || f.Status == FineTuneCancelled
+}
+
 // Name returns the fine-tune model name, or model ID if the name is not set.
 func (f FineTune) Name() string {
 	if f.FineTunedModel != "" {
@@ -117,14 +198,33 @@ func (f FineTune) Name() string {
 	return f.ID
 }
 
+// modelTimestamp matches the creation timestamp at the end of a fine-tuned
+// model name, e.g. "-2023-03-01-12-00-00".
+var modelTimestamp = regexp.MustCompile(`-\d{4}(-\d{2}){5}$`)
+
+// Suffix returns the name suffix of the fine-tuned model, e.g. "angry" for
+// "curie:ft-personal:angry-2023-03-01-12-00-00", or an empty string if it
+// has none.
+func (f FineTune) Suffix() string {
+	parts := strings.Split(f.FineTunedModel, ":")
+	if len(parts) < 3 {
+		return ""
+	}
+	return modelTimestamp.ReplaceAllString(parts[len(parts)-1], "")
+}
+
 // FineTuneList provides a list of fine-tuned models.
 type FineTuneList struct {
-	Object string     `json:"object"` // "list" is expected
-	Data   []FineTune `json:"data"`   // list of fine-tunes
+	Object  string     `json:"object"`             // "list" is expected
+	Data    []FineTune `json:"data"`               // list of fine-tunes
+	HasMore bool       `json:"has_more,omitempty"` // more pages?
 }
 
 // Event provides information about an OpenAPI fine-tuning event.
 type Event struct {
+	// ID is the event ID, e.g. "ftevent-abc123", if the API provides one.
+	ID string `json:"id,omitempty"`
+
 	// Object is the object type, e.g. "fine-tune-event".
 	Object string `json:"object"`
 
@@ -140,8 +240,9 @@ type Event struct {
 
 // EventList provides a list of fine-tuning events.
 type EventList struct {
-	Object string  `json:"object"` // "list" is expected
-	Data   []Event `json:"data"`   // list of events
+	Object  string  `json:"object"`             // "list" is expected
+	Data    []Event `json:"data"`               // list of events
+	HasMore bool    `json:"has_more,omitempty"` // more pages?
 }
 
 // HyperParameters provides hyperparameters for fine-tuning.
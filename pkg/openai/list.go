@@ -0,0 +1,208 @@
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+)
+
+// ListOptions are the pagination and filter options of a list request.
+type ListOptions struct {
+	// Limit is the number of objects requested per page, or 0 for the API
+	// default.
+	Limit int
+
+	// After is the cursor to start after: the ID of an object in the list.
+	After string
+
+	// Max is the maximum number of objects returned in all, or 0 for all of
+	// them.
+	Max int
+
+	// Purpose selects the files with the purpose, e.g. "fine-tune".
+	Purpose string
+
+	// Statuses selects the files, fine-tunes, or batches with one of the statuses, e.g.
+	// "succeeded". They are filtered by the client, so every page is read.
+	Statuses []string
+}
+
+// query returns the URL query of a page request, starting after the cursor.
+func (o ListOptions) query(after string) string {
+	q := url.Values{}
+	if o.Limit > 0 {
+		q.Set("limit", strconv.Itoa(o.Limit))
+	}
+	if after != "" {
+		q.Set("after", after)
+	}
+	if o.Purpose != "" {
+		q.Set("purpose", o.Purpose)
+	}
+	if len(q) == 0 {
+		return ""
+	}
+	return "?" + q.Encode()
+}
+
+// hasStatus returns true if no statuses are selected, or status is one of them.
+func (o ListOptions) hasStatus(status string) bool {
+	if len(o.Statuses) == 0 {
+		return true
+	}
+	for _, s := range o.Statuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// page is a page of a list response.
+type page[T any] struct {
+	Data    []T    `json:"data"`
+	HasMore bool   `json:"has_more"`
+	LastID  string `json:"last_id"`
+}
+
+// Iterator reads the objects of a list, following the cursor from page to
+// page. Use it like a bufio.Scanner:
+//
+//	it := client.IterateFiles(ctx, openai.ListOptions{Limit: 100})
+//	for it.Next() {
+//		file := it.Value()
+//		...
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+type Iterator[T any] struct {
+	ctx    context.Context
+	client *Client
+	path   string
+	op     string // the operation in errors, e.g. "list files"
+	opts   ListOptions
+	id     func(T) string
+	keep   func(T) bool
+
+	page    []T
+	next    int
+	after   string
+	hasMore bool
+	pages   int
+	count   int
+	value   T
+	err     error
+}
+
+// newIterator returns an iterator over the list at the path, which reads the
+// cursor of each object with id, and skips the objects that keep rejects.
+func newIterator[T any](ctx context.Context, c *Client, path, op string, opts ListOptions, id func(T) string,
+	keep func(T) bool) *Iterator[T] {
+	return &Iterator[T]{ctx: ctx, client: c, path: path, op: op, opts: opts, id: id, keep: keep, after: opts.After}
+}
+
+// Next advances to the next object, reading the next page if needed. It
+// returns false at the end of the list, after Max objects, or on error.
+func (it *Iterator[T]) Next() bool {
+	for it.err == nil && (it.opts.Max <= 0 || it.count < it.opts.Max) {
+		if it.next < len(it.page) {
+			v := it.page[it.next]
+			it.next++
+			if it.keep != nil && !it.keep(v) {
+				continue
+			}
+			it.value = v
+			it.count++
+			return true
+		}
+		if it.pages > 0 && !it.hasMore {
+			return false
+		}
+		it.err = it.read()
+	}
+	return false
+}
+
+// read reads the next page, and moves the cursor past it.
+func (it *Iterator[T]) read() error {
+	body, err := it.client.listRaw(it.ctx, it.path+it.opts.query(it.after))
+	if err != nil {
+		return fmt.Errorf("%s: %w", it.op, err)
+	}
+	var p page[T]
+	if err := json.Unmarshal(body, &p); err != nil {
+		return fmt.Errorf("%s: error unmarshaling response: %w", it.op, err)
+	}
+	it.page, it.next, it.hasMore = p.Data, 0, p.HasMore
+	it.pages++
+	if !p.HasMore {
+		return nil
+	}
+	after := p.LastID
+	if after == "" && len(p.Data) > 0 {
+		after = it.id(p.Data[len(p.Data)-1])
+	}
+	if after == "" || after == it.after {
+		return fmt.Errorf("%s: page %d has more, but no cursor to follow", it.op, it.pages)
+	}
+	it.after = after
+	return nil
+}
+
+// Value returns the current object.
+func (it *Iterator[T]) Value() T {
+	return it.value
+}
+
+// Err returns the error that stopped the iteration, if any.
+func (it *Iterator[T]) Err() error {
+	return it.err
+}
+
+// Pages returns the number of pages read so far.
+func (it *Iterator[T]) Pages() int {
+	return it.pages
+}
+
+// All reads the rest of the objects.
+func (it *Iterator[T]) All() ([]T, error) {
+	var all []T
+	for it.Next() {
+		all = append(all, it.Value())
+	}
+	return all, it.Err()
+}
+
+// listRaw reads a page of a list. It returns the raw JSON response.
+func (c *Client) listRaw(ctx context.Context, path string) ([]byte, error) {
+	req, err := c.getRequest(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	return c.sendRequest(req)
+}
+
+// IterateFiles returns an iterator over the files, in the order of the API.
+func (c *Client) IterateFiles(ctx context.Context, opts ListOptions) *Iterator[File] {
+	return newIterator(ctx, c, "/files", "list files", opts,
+		func(f File) string { return f.ID },
+		func(f File) bool { return opts.hasStatus(f.Status) })
+}
+
+// IterateFineTunes returns an iterator over the fine-tuning jobs, in the order
+// of the API.
+func (c *Client) IterateFineTunes(ctx context.Context, opts ListOptions) *Iterator[FineTune] {
+	return newIterator(ctx, c, "/fine-tunes", "list fine-tunes", opts,
+		func(f FineTune) string { return f.ID },
+		func(f FineTune) bool { return opts.hasStatus(f.Status) })
+}
+
+// IterateFineTuneEvents returns an iterator over the events of the specified
+// fine-tuning job, oldest first. Statuses do not apply to events.
+func (c *Client) IterateFineTuneEvents(ctx context.Context, id string, opts ListOptions) *Iterator[Event] {
+	return newIterator(ctx, c, "/fine-tunes/"+id+"/events", "list fine-tune events "+id, opts,
+		func(e Event) string { return e.ID }, nil)
+}
@@ -0,0 +1,77 @@
+package openai
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// tokenPieces splits text the way the GPT byte-pair encoders pre-tokenize it:
+// contractions, words, numbers, and punctuation runs, each with an optional
+// leading space, and whitespace runs.
+var tokenPieces = regexp.MustCompile(`'(?:[sdmt]|ll|ve|re)| ?\pL+| ?\pN+| ?[^\s\pL\pN]+|\s+`)
+
+// EstimateTokens estimates the number of tokens in a text. There is no Go
+// tokenizer here, so common words count as one token and longer words as one
+// token per four characters, numbers as one token per three digits, and
+// punctuation as one token per character. It is a rough estimate, suitable
+// for checking limits and estimating costs.
+func EstimateTokens(text string) int {
+	var tokens int
+	for _, piece := range tokenPieces.FindAllString(text, -1) {
+		trimmed := strings.TrimPrefix(piece, " ")
+		n := utf8.RuneCountInString(trimmed)
+		switch r, _ := utf8.DecodeRuneInString(trimmed); {
+		case trimmed == "":
+			tokens++ // a single space
+		case unicode.IsLetter(r):
+			if n <= 7 {
+				tokens++
+			} else {
+				tokens += (n + 3) / 4
+			}
+		case unicode.IsNumber(r):
+			tokens += (n + 2) / 3
+		case strings.TrimSpace(trimmed) == "":
+			tokens++
+		default:
+			tokens += n
+		}
+	}
+	return tokens
+}
+
+// EstimateMessageTokens estimates the number of tokens of chat messages,
+// including the per-message formatting tokens.
+func EstimateMessageTokens(messages []Message) int {
+	tokens := 3 // every reply is primed with <|start|>assistant<|message|>
+	for _, m := range messages {
+		tokens += 4 + EstimateTokens(m.Content)
+	}
+	return tokens
+}
+
+// TrainingPrices are the fine-tuning prices of the base models, in USD per
+// 1,000 training tokens.
+var TrainingPrices = map[string]float64{
+	"ada":           0.0004,
+	"babbage":       0.0006,
+	"curie":         0.003,
+	"davinci":       0.03,
+	"babbage-002":   0.0004,
+	"davinci-002":   0.006,
+	"gpt-3.5-turbo": 0.008,
+}
+
+// ContextLimits are the maximum number of tokens in a fine-tuning example of
+// the base models.
+var ContextLimits = map[string]int{
+	"ada":           2048,
+	"babbage":       2048,
+	"curie":         2048,
+	"davinci":       2048,
+	"babbage-002":   4096,
+	"davinci-002":   4096,
+	"gpt-3.5-turbo": 4096,
+}
@@ -0,0 +1,209 @@
+package openai
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"mime/multipart"
+	"net/http"
+)
+
+// TransferOptions are the options of a streaming upload or download.
+type TransferOptions struct {
+	// Size is the expected size in bytes, verified after the transfer, or 0
+	// if unknown.
+	Size int64
+
+	// SHA256 is the expected hex SHA-256 checksum, verified after the
+	// transfer, or "" if unknown.
+	SHA256 string
+
+	// Progress, if set, is called as the data is transferred, with the bytes
+	// transferred so far, and the total bytes, or -1 if unknown.
+	Progress func(done, total int64)
+}
+
+// Transfer describes the data transferred by a streaming upload or download.
+type Transfer struct {
+	Bytes  int64  `json:"bytes"`
+	SHA256 string `json:"sha256"`
+}
+
+// verify checks the transfer against the expected size and checksum.
+func (t Transfer) verify(opts TransferOptions) error {
+	if opts.Size > 0 && t.Bytes != opts.Size {
+		return fmt.Errorf("transferred %d bytes, expected %d", t.Bytes, opts.Size)
+	}
+	if opts.SHA256 != "" && t.SHA256 != opts.SHA256 {
+		return fmt.Errorf("SHA-256 checksum %s, expected %s", t.SHA256, opts.SHA256)
+	}
+	return nil
+}
+
+// progressReader counts and hashes the data read, and reports progress.
+type progressReader struct {
+	r        io.Reader
+	hash     hash.Hash
+	done     int64
+	total    int64
+	progress func(done, total int64)
+}
+
+// newProgressReader returns a progressReader of r, with total bytes, or -1 if
+// unknown.
+func newProgressReader(r io.Reader, total int64, progress func(done, total int64)) *progressReader {
+	return &progressReader{r: r, hash: sha256.New(), total: total, progress: progress}
+}
+
+// Read reads from the underlying reader.
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if n > 0 {
+		p.hash.Write(b[:n])
+		p.done += int64(n)
+		if p.progress != nil {
+			p.progress(p.done, p.total)
+		}
+	}
+	return n, err
+}
+
+// transfer returns the bytes read and their checksum.
+func (p *progressReader) transfer() Transfer {
+	return Transfer{Bytes: p.done, SHA256: hex.EncodeToString(p.hash.Sum(nil))}
+}
+
+// streamRequest sends the provided HTTP request without the client timeout,
+// which would cut off large transfers, and returns the response for the
+// caller to read and close. The context still cancels the request.
+func (c *Client) streamRequest(req *http.Request) (*http.Response, error) {
+	client := *c.client
+	client.Timeout = 0
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error sending %s request: %w", req.URL.Path, err)
+	}
+	if resp.StatusCode == http.StatusOK {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return nil, fmt.Errorf("%s: %s", resp.Status, req.URL.Path)
+	case http.StatusNotFound:
+		return nil, fmt.Errorf("not found: %s", req.URL.Path)
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	return nil, fmt.Errorf("error sending %s request: status code %d: %s", req.URL.Path, resp.StatusCode, body)
+}
+
+// UploadFileFrom uploads a file for use with subsequent fine-tuning requests,
+// streaming it from r without holding it in memory. The upload is checked
+// against the expected size and checksum in opts, if any, and against the
+// size of the uploaded file.
+func (c *Client) UploadFileFrom(ctx context.Context, fileName, purpose string, r io.Reader,
+	opts TransferOptions) (File, Transfer, error) {
+	var file File
+	if purpose == "" {
+		purpose = "fine-tune"
+	}
+	total := opts.Size
+	if total <= 0 {
+		total = -1
+	}
+	src := newProgressReader(r, total, opts.Progress)
+
+	// Write the multipart body through a pipe, while it is sent:
+	pr, pw := io.Pipe()
+	w := multipart.NewWriter(pw)
+	written := make(chan error, 1)
+	go func() {
+		err := w.WriteField("purpose", purpose)
+		if err != nil {
+			err = fmt.Errorf("field purpose: %w", err)
+		} else {
+			var fw io.Writer
+			if fw, err = w.CreateFormFile("file", fileName); err == nil {
+				_, err = io.Copy(fw, src)
+			}
+			if err == nil {
+				err = w.Close()
+			}
+			if err != nil {
+				err = fmt.Errorf("field file: %w", err)
+			}
+		}
+		pw.CloseWithError(err)
+		written <- err
+	}()
+
+	// Create and send the request
+	req, err := c.postRequest(ctx, "/files", pr)
+	if err != nil {
+		pr.Close()
+		return file, Transfer{}, fmt.Errorf("upload file: %w", err)
+	}
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	resp, err := c.streamRequest(req)
+	pr.Close()
+	if writeErr := <-written; err != nil && writeErr != nil && !errors.Is(writeErr, io.ErrClosedPipe) {
+		return file, Transfer{}, fmt.Errorf("upload file: %w", writeErr)
+	}
+	if err != nil {
+		return file, Transfer{}, fmt.Errorf("upload file: send request: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return file, Transfer{}, fmt.Errorf("upload file: error reading response body: %w", err)
+	}
+	if err := json.Unmarshal(body, &file); err != nil {
+		return file, Transfer{}, fmt.Errorf("upload file: unmarshaling response: %w", err)
+	}
+
+	// Verify the upload
+	t := src.transfer()
+	if err := t.verify(opts); err != nil {
+		return file, t, fmt.Errorf("upload file %s: %w", file.ID, err)
+	}
+	if file.Bytes > 0 && int64(file.Bytes) != t.Bytes {
+		return file, t, fmt.Errorf("upload file %s: uploaded %d bytes, but the file has %d", file.ID, t.Bytes, file.Bytes)
+	}
+	return file, t, nil
+}
+
+// DownloadFileTo streams the contents of the specified file to w, without
+// holding it in memory. The download is checked against the Content-Length
+// of the response, and the expected size and checksum in opts, if any.
+func (c *Client) DownloadFileTo(ctx context.Context, id string, w io.Writer, opts TransferOptions) (Transfer, error) {
+	req, err := c.getRequest(ctx, "/files/"+id+"/content")
+	if err != nil {
+		return Transfer{}, fmt.Errorf("download file %s: %w", id, err)
+	}
+	resp, err := c.streamRequest(req)
+	if err != nil {
+		return Transfer{}, fmt.Errorf("download file %s: %w", id, err)
+	}
+	defer resp.Body.Close()
+	total := resp.ContentLength
+	if total < 0 && opts.Size > 0 {
+		total = opts.Size
+	}
+	src := newProgressReader(resp.Body, total, opts.Progress)
+	if _, err := io.Copy(w, src); err != nil {
+		return src.transfer(), fmt.Errorf("download file %s: %w", id, err)
+	}
+	t := src.transfer()
+	if resp.ContentLength >= 0 && t.Bytes != resp.ContentLength {
+		return t, fmt.Errorf("download file %s: received %d of %d bytes", id, t.Bytes, resp.ContentLength)
+	}
+	if err := t.verify(opts); err != nil {
+		return t, fmt.Errorf("download file %s: %w", id, err)
+	}
+	return t, nil
+}
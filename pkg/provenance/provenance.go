@@ -0,0 +1,304 @@
+// Package provenance records how a results file was produced, in a sidecar
+// manifest written next to it, and verifies the manifest's file hashes.
+package provenance
+
+import (
+	"content-coding-gpt/pkg/openai"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Suffix is appended to a results file path to name its sidecar manifest.
+const Suffix = ".provenance.json"
+
+// ResultsRole is the role of the results file described by a manifest.
+const ResultsRole = "results"
+
+// File is a hashed file.
+type File struct {
+	Path   string `json:"path"`
+	SHA256 string `json:"sha256"`
+}
+
+// Manifest describes how a results file was produced: the command line, the
+// hashes of its input, template, and results files and of the hallmarks
+// text, the models and system fingerprints reported by the API, the token
+// usage, and the git commit of the source.
+type Manifest struct {
+	Args               []string          `json:"args"`
+	Start              time.Time         `json:"start"`
+	End                time.Time         `json:"end"`
+	GitCommit          string            `json:"git_commit,omitempty"`
+	GitDirty           bool              `json:"git_dirty,omitempty"`
+	Files              map[string]File   `json:"files"`               // by role, e.g. "input"
+	Hallmarks          map[string]string `json:"hallmarks,omitempty"` // SHA-256 by essay type
+	Models             []string          `json:"models,omitempty"`    // as returned by the API
+	SystemFingerprints []string          `json:"system_fingerprints,omitempty"`
+	Requests           int               `json:"requests"`
+	Usage              openai.Usage      `json:"usage"`
+
+	mu sync.Mutex
+}
+
+// New creates a Manifest for a command line, started now.
+func New(args []string) *Manifest {
+	m := &Manifest{
+		Args:      args,
+		Start:     time.Now().UTC(),
+		Files:     map[string]File{},
+		Hallmarks: map[string]string{},
+	}
+	m.GitCommit, m.GitDirty = gitCommit()
+	return m
+}
+
+// AddFile hashes a file and records it with the specified role. An empty path
+// is ignored.
+func (m *Manifest) AddFile(role, path string) error {
+	if path == "" {
+		return nil
+	}
+	hash, err := FileHash(path)
+	if err != nil {
+		return fmt.Errorf("provenance: %w", err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Files[role] = File{Path: path, SHA256: hash}
+	return nil
+}
+
+// AddHallmarks records the hash of the hallmarks text of an essay type.
+func (m *Manifest) AddHallmarks(essayType, hallmarks string) {
+	if hallmarks == "" {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Hallmarks[essayType] = TextHash(hallmarks)
+}
+
+// AddChat records the model, system fingerprint, and usage of a chat response.
+func (m *Manifest) AddChat(r openai.ChatResponse) {
+	m.addResponse(r.Model, r.SystemFingerprint, r.Usage)
+}
+
+// AddCompletion records the model and usage of a completion.
+func (m *Manifest) AddCompletion(c openai.Completion) {
+	m.addResponse(c.Model, "", c.Usage)
+}
+
+// addResponse records the model, system fingerprint, and usage of a response.
+func (m *Manifest) addResponse(model, fingerprint string, usage openai.Usage) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Models = addString(m.Models, model)
+	m.SystemFingerprints = addString(m.SystemFingerprints, fingerprint)
+	m.Requests++
+	m.Usage.PromptTokens += usage.PromptTokens
+	m.Usage.CompletionTokens += usage.CompletionTokens
+	m.Usage.TotalTokens += usage.TotalTokens
+}
+
+// Write hashes the results file, and writes the manifest to its sidecar file.
+func (m *Manifest) Write(results string) error {
+	m.End = time.Now().UTC()
+	if err := m.AddFile(ResultsRole, results); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return fmt.Errorf("provenance: error marshalling manifest: %w", err)
+	}
+	if err := os.WriteFile(SidecarPath(results), append(j, '\n'), 0644); err != nil {
+		return fmt.Errorf("provenance: error writing manifest: %w", err)
+	}
+	return nil
+}
+
+// SidecarPath returns the path of the sidecar manifest of a results file, or
+// the path itself if it is a sidecar manifest.
+func SidecarPath(path string) string {
+	if strings.HasSuffix(path, Suffix) {
+		return path
+	}
+	return path + Suffix
+}
+
+// Read reads the sidecar manifest of a results file, or a manifest file.
+func Read(path string) (*Manifest, error) {
+	path = SidecarPath(path)
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("provenance: %w", err)
+	}
+	var m Manifest
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("provenance: %s: %w", path, err)
+	}
+	return &m, nil
+}
+
+// ReferencedModels returns the models recorded by the sidecar manifests found
+// under the root directories. A missing root is an error, since an empty
+// result would leave every model unreferenced.
+func ReferencedModels(roots ...string) (map[string]bool, error) {
+	models := map[string]bool{}
+	for _, root := range roots {
+		err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() || !strings.HasSuffix(path, Suffix) {
+				return nil
+			}
+			m, err := Read(path)
+			if err != nil {
+				return err
+			}
+			for _, model := range m.Models {
+				models[model] = true
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("provenance: %w", err)
+		}
+	}
+	return models, nil
+}
+
+// Verification statuses.
+const (
+	StatusOK      = "OK"
+	StatusChanged = "CHANGED"
+	StatusMissing = "MISSING"
+)
+
+// Check is the result of verifying one hash of a manifest.
+type Check struct {
+	Role     string // file role, or "hallmarks:<essayType>"
+	Path     string
+	Status   string
+	Expected string
+	Actual   string
+}
+
+// Verify recomputes the hashes of the manifest's files and of the current
+// hallmarks texts, by essay type, and compares them to the recorded hashes.
+// The results file is the one named by the sidecar path, if specified, so
+// that a results file and its sidecar can be moved together.
+func (m *Manifest) Verify(sidecar string, hallmarks map[string]string) []Check {
+	roles := make([]string, 0, len(m.Files))
+	for role := range m.Files {
+		roles = append(roles, role)
+	}
+	sort.Strings(roles)
+	var checks []Check
+	for _, role := range roles {
+		f := m.Files[role]
+		c := Check{Role: role, Path: f.Path, Expected: f.SHA256}
+		if role == ResultsRole && sidecar != "" {
+			c.Path = strings.TrimSuffix(sidecar, Suffix)
+		}
+		hash, err := FileHash(c.Path)
+		switch {
+		case err != nil:
+			c.Status = StatusMissing
+		case hash != f.SHA256:
+			c.Status, c.Actual = StatusChanged, hash
+		default:
+			c.Status, c.Actual = StatusOK, hash
+		}
+		checks = append(checks, c)
+	}
+	essayTypes := make([]string, 0, len(m.Hallmarks))
+	for essayType := range m.Hallmarks {
+		essayTypes = append(essayTypes, essayType)
+	}
+	sort.Strings(essayTypes)
+	for _, essayType := range essayTypes {
+		c := Check{Role: "hallmarks:" + essayType, Expected: m.Hallmarks[essayType]}
+		text, ok := hallmarks[essayType]
+		switch {
+		case !ok || text == "":
+			c.Status = StatusMissing
+		case TextHash(text) != c.Expected:
+			c.Status, c.Actual = StatusChanged, TextHash(text)
+		default:
+			c.Status, c.Actual = StatusOK, c.Expected
+		}
+		checks = append(checks, c)
+	}
+	return checks
+}
+
+// FileHash returns the hex SHA-256 hash of a file's contents.
+func FileHash(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("hash %s: %w", path, err)
+	}
+	return TextHash(string(b)), nil
+}
+
+// TextHash returns the hex SHA-256 hash of a text.
+func TextHash(text string) string {
+	sum := sha256.Sum256([]byte(text))
+	return hex.EncodeToString(sum[:])
+}
+
+// gitCommit returns the git commit of the source, and whether the working
+// tree was modified: from the build info if the binary was built with VCS
+// stamping, or else from git in the current directory.
+func gitCommit() (string, bool) {
+	if info, ok := debug.ReadBuildInfo(); ok {
+		var revision string
+		var modified bool
+		for _, s := range info.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				revision = s.Value
+			case "vcs.modified":
+				modified = s.Value == "true"
+			}
+		}
+		if revision != "" {
+			return revision, modified
+		}
+	}
+	out, err := exec.Command("git", "rev-parse", "HEAD").Output()
+	if err != nil {
+		return "", false
+	}
+	status, _ := exec.Command("git", "status", "--porcelain", "--untracked-files=no").Output()
+	return strings.TrimSpace(string(out)), len(strings.TrimSpace(string(status))) > 0
+}
+
+// addString adds a non-empty string to a sorted set of strings.
+func addString(set []string, s string) []string {
+	if s == "" {
+		return set
+	}
+	i := sort.SearchStrings(set, s)
+	if i < len(set) && set[i] == s {
+		return set
+	}
+	set = append(set, "")
+	copy(set[i+1:], set[i:])
+	set[i] = s
+	return set
+}
@@ -0,0 +1,181 @@
+package stats
+
+import "math"
+
+// Ratings is an n×k matrix of ratings: one row per subject (essay), and one
+// column per rater (e.g. human and model). There must be no missing ratings.
+type Ratings [][]float64
+
+// Columns returns the ratings of two raters as a Ratings matrix.
+func Columns(x, y []float64) Ratings {
+	r := make(Ratings, len(x))
+	for i := range x {
+		r[i] = []float64{x[i], y[i]}
+	}
+	return r
+}
+
+// anova returns the two-way ANOVA mean squares of the ratings: rows
+// (subjects), columns (raters), and error.
+func (r Ratings) anova() (msr, msc, mse float64, n, k int) {
+	n = len(r)
+	if n == 0 {
+		return
+	}
+	k = len(r[0])
+	rowMeans := make([]float64, n)
+	colMeans := make([]float64, k)
+	var grand float64
+	for i, row := range r {
+		for j, v := range row {
+			rowMeans[i] += v / float64(k)
+			colMeans[j] += v / float64(n)
+			grand += v / float64(n*k)
+		}
+	}
+	var ssr, ssc, sse float64
+	for i := range rowMeans {
+		ssr += (rowMeans[i] - grand) * (rowMeans[i] - grand)
+	}
+	for j := range colMeans {
+		ssc += (colMeans[j] - grand) * (colMeans[j] - grand)
+	}
+	for i, row := range r {
+		for j, v := range row {
+			e := v - rowMeans[i] - colMeans[j] + grand
+			sse += e * e
+		}
+	}
+	msr = float64(k) * ssr / float64(n-1)
+	msc = float64(n) * ssc / float64(k-1)
+	mse = sse / float64((n-1)*(k-1))
+	return
+}
+
+// ICC21 returns ICC(2,1): the two-way random effects, absolute agreement,
+// single rater intraclass correlation (Shrout & Fleiss, 1979).
+func ICC21(r Ratings) float64 {
+	msr, msc, mse, n, k := r.anova()
+	if n < 2 || k < 2 {
+		return math.NaN()
+	}
+	return (msr - mse) / (msr + float64(k-1)*mse + float64(k)*(msc-mse)/float64(n))
+}
+
+// ICC3k returns ICC(3,k): the two-way mixed effects, consistency, average of
+// k raters intraclass correlation (Shrout & Fleiss, 1979).
+func ICC3k(r Ratings) float64 {
+	msr, _, mse, n, k := r.anova()
+	if n < 2 || k < 2 || msr == 0 {
+		return math.NaN()
+	}
+	return (msr - mse) / msr
+}
+
+// KrippendorffAlpha returns Krippendorff's alpha for interval data, with no
+// missing ratings.
+func KrippendorffAlpha(r Ratings) float64 {
+	var values []float64
+	var observed float64
+	for _, row := range r {
+		k := len(row)
+		if k < 2 {
+			continue
+		}
+		for a := 0; a < k; a++ {
+			for b := 0; b < k; b++ {
+				if a != b {
+					observed += (row[a] - row[b]) * (row[a] - row[b]) / float64(k-1)
+				}
+			}
+		}
+		values = append(values, row...)
+	}
+	total := float64(len(values))
+	if total < 2 {
+		return math.NaN()
+	}
+	m := Mean(values)
+	var ss float64
+	for _, v := range values {
+		ss += (v - m) * (v - m)
+	}
+	expected := 2 * ss / (total - 1)
+	if expected == 0 {
+		return math.NaN()
+	}
+	return 1 - (observed/total)/expected
+}
+
+// QuantileBins assigns each value to one of k bins of (roughly) equal size, by
+// rank, so that raters with different scales can be compared categorically.
+// Tied values are assigned to the same bin.
+func QuantileBins(x []float64, k int) []int {
+	bins := make([]int, len(x))
+	for i, rank := range Ranks(x) {
+		bin := int((rank - 0.5) * float64(k) / float64(len(x)))
+		if bin >= k {
+			bin = k - 1
+		}
+		bins[i] = bin
+	}
+	return bins
+}
+
+// SharedQuantileBins assigns the values of two raters to k bins, with the
+// same bin edges for both: the quantiles of the pooled values. Values equal to
+// an edge are assigned to the lower bin.
+func SharedQuantileBins(a, b []float64, k int) ([]int, []int) {
+	pooled := append(append([]float64{}, a...), b...)
+	edges := make([]float64, k-1)
+	for j := range edges {
+		edges[j] = Quantile(pooled, float64(j+1)/float64(k))
+	}
+	bin := func(x []float64) []int {
+		bins := make([]int, len(x))
+		for i, v := range x {
+			for _, edge := range edges {
+				if v > edge {
+					bins[i]++
+				}
+			}
+		}
+		return bins
+	}
+	return bin(a), bin(b)
+}
+
+// WeightedKappa returns Cohen's weighted kappa for two raters' categories,
+// numbered 0 to k-1, with quadratic weights, or linear weights if specified.
+func WeightedKappa(a, b []int, k int, linear bool) float64 {
+	n := len(a)
+	if n == 0 || n != len(b) || k < 2 {
+		return math.NaN()
+	}
+	observed := make([][]float64, k)
+	for i := range observed {
+		observed[i] = make([]float64, k)
+	}
+	rowTotals := make([]float64, k)
+	colTotals := make([]float64, k)
+	for i := range a {
+		observed[a[i]][b[i]]++
+		rowTotals[a[i]]++
+		colTotals[b[i]]++
+	}
+	var wo, we float64
+	for i := 0; i < k; i++ {
+		for j := 0; j < k; j++ {
+			w := math.Abs(float64(i-j)) / float64(k-1)
+			if !linear {
+				w *= w
+			}
+			wo += w * observed[i][j] / float64(n)
+			we += w * rowTotals[i] * colTotals[j] / float64(n*n)
+		}
+	}
+	if we == 0 {
+		return math.NaN()
+	}
+	return 1 - wo/we
+}
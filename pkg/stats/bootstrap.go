@@ -0,0 +1,84 @@
+package stats
+
+import (
+	"encoding/json"
+	"math"
+	"math/rand"
+)
+
+// Estimate is a statistic with a bootstrap confidence interval.
+type Estimate struct {
+	Value float64
+	Lower float64
+	Upper float64
+}
+
+// Bootstrap estimates a statistic of n paired observations, computed by f from
+// the observation indexes, with a percentile confidence interval at the level
+// (e.g. 0.95) from the specified number of resamples. Resamples for which the
+// statistic is undefined (NaN) are ignored.
+func Bootstrap(n int, f func(idx []int) float64, resamples int, level float64, seed int64) Estimate {
+	idx := make([]int, n)
+	for i := range idx {
+		idx[i] = i
+	}
+	e := Estimate{Value: f(idx), Lower: math.NaN(), Upper: math.NaN()}
+	if n < 2 || resamples <= 0 || math.IsNaN(e.Value) {
+		return e
+	}
+	rng := rand.New(rand.NewSource(seed))
+	values := make([]float64, 0, resamples)
+	sample := make([]int, n)
+	for b := 0; b < resamples; b++ {
+		for i := range sample {
+			sample[i] = rng.Intn(n)
+		}
+		if v := f(sample); !math.IsNaN(v) && !math.IsInf(v, 0) {
+			values = append(values, v)
+		}
+	}
+	if len(values) > 0 {
+		e.Lower = Quantile(values, (1-level)/2)
+		e.Upper = Quantile(values, 1-(1-level)/2)
+	}
+	return e
+}
+
+// MarshalJSON encodes undefined (NaN) values as null.
+func (e Estimate) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Value *float64 `json:"value"`
+		Lower *float64 `json:"lower"`
+		Upper *float64 `json:"upper"`
+	}{finite(e.Value), finite(e.Lower), finite(e.Upper)})
+}
+
+// UnmarshalJSON decodes undefined (null) values as NaN.
+func (e *Estimate) UnmarshalJSON(b []byte) error {
+	var v struct {
+		Value *float64 `json:"value"`
+		Lower *float64 `json:"lower"`
+		Upper *float64 `json:"upper"`
+	}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	e.Value, e.Lower, e.Upper = orNaN(v.Value), orNaN(v.Lower), orNaN(v.Upper)
+	return nil
+}
+
+// orNaN returns the value of a pointer, or NaN if it is nil.
+func orNaN(v *float64) float64 {
+	if v == nil {
+		return math.NaN()
+	}
+	return *v
+}
+
+// finite returns a pointer to the value, or nil if it is NaN or infinite.
+func finite(v float64) *float64 {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return nil
+	}
+	return &v
+}
@@ -0,0 +1,96 @@
+package stats
+
+import "math"
+
+// Options configures the agreement statistics reported by Compare.
+type Options struct {
+	Bins        int     `json:"bins"`         // number of shared quantile bins for weighted kappa
+	LinearKappa bool    `json:"linear_kappa"` // linear (instead of quadratic) kappa weights?
+	Standardize bool    `json:"standardize"`  // z-score each rater before ICC, kappa, alpha, MAE, and RMSE?
+	Resamples   int     `json:"resamples"`    // number of bootstrap resamples
+	Level       float64 `json:"level"`        // confidence level, e.g. 0.95
+	Seed        int64   `json:"seed"`         // bootstrap random seed
+}
+
+// DefaultOptions are the default agreement options, which standardize the
+// scores, since the human scores are usually z-scores and the model scores are
+// not.
+var DefaultOptions = Options{Bins: 5, Standardize: true, Resamples: 1000, Level: 0.95, Seed: 1}
+
+// Agreement holds the agreement statistics between two raters. If
+// Standardized, the scale-dependent statistics compare z-scores, so they
+// measure consistency rather than absolute agreement.
+type Agreement struct {
+	N            int      `json:"n"`
+	Standardized bool     `json:"standardized"`
+	Pearson      Estimate `json:"pearson"`
+	Spearman     Estimate `json:"spearman"`
+	ICC21        Estimate `json:"icc2_1"`
+	ICC3k        Estimate `json:"icc3_k"`
+	Kappa        Estimate `json:"weighted_kappa"`
+	Alpha        Estimate `json:"krippendorff_alpha"`
+	MAE          Estimate `json:"mae"`
+	RMSE         Estimate `json:"rmse"`
+}
+
+// Compare returns the agreement statistics between paired human and model
+// scores, each with a bootstrap confidence interval. The same resamples are
+// used for every statistic. Weighted kappa bins both raters' scores with the
+// same edges, so they must be on the same scale, or standardized.
+func Compare(human, model []float64, o Options) Agreement {
+	x, y := human, model
+	if o.Standardize {
+		x, y = ZScores(human), ZScores(model)
+	}
+	pairs := func(idx []int, a, b []float64) ([]float64, []float64) {
+		sa, sb := make([]float64, len(idx)), make([]float64, len(idx))
+		for i, j := range idx {
+			sa[i], sb[i] = a[j], b[j]
+		}
+		return sa, sb
+	}
+	estimate := func(f func(a, b []float64) float64, a, b []float64) Estimate {
+		return Bootstrap(len(a), func(idx []int) float64 {
+			return f(pairs(idx, a, b))
+		}, o.Resamples, o.Level, o.Seed)
+	}
+	return Agreement{
+		N:            len(human),
+		Standardized: o.Standardize,
+		Pearson:      estimate(Pearson, human, model),
+		Spearman:     estimate(Spearman, human, model),
+		ICC21:        estimate(func(a, b []float64) float64 { return ICC21(Columns(a, b)) }, x, y),
+		ICC3k:        estimate(func(a, b []float64) float64 { return ICC3k(Columns(a, b)) }, x, y),
+		Kappa: estimate(func(a, b []float64) float64 {
+			binsA, binsB := SharedQuantileBins(a, b, o.Bins)
+			return WeightedKappa(binsA, binsB, o.Bins, o.LinearKappa)
+		}, x, y),
+		Alpha: estimate(func(a, b []float64) float64 { return KrippendorffAlpha(Columns(a, b)) }, x, y),
+		MAE:   estimate(MAE, x, y),
+		RMSE:  estimate(RMSE, x, y),
+	}
+}
+
+// RankStatistics lists the agreement statistics, higher is better, that
+// results can be ranked by.
+var RankStatistics = []string{"pearson", "spearman", "icc21", "icc3k", "kappa", "alpha"}
+
+// Statistic returns the value of the named agreement statistic (see
+// RankStatistics), or NaN if it is unknown.
+func (a Agreement) Statistic(name string) float64 {
+	switch name {
+	case "pearson":
+		return a.Pearson.Value
+	case "spearman":
+		return a.Spearman.Value
+	case "icc21":
+		return a.ICC21.Value
+	case "icc3k":
+		return a.ICC3k.Value
+	case "kappa":
+		return a.Kappa.Value
+	case "alpha":
+		return a.Alpha.Value
+	}
+	return math.NaN()
+}
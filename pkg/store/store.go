@@ -0,0 +1,542 @@
+// Package store keeps runs of the batch scoring commands in an embedded
+// SQLite database: the essays, the raw requests and responses, the extracted
+// scores, errors, and timings, so that runs can be queried across experiments
+// and re-scored without calling the API again.
+package store
+
+import (
+	"content-coding-gpt/pkg/data"
+	"content-coding-gpt/pkg/openai"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite" // pure-Go SQLite driver
+)
+
+// DefaultPath is the default database file.
+const DefaultPath = "data/results.db"
+
+// schema creates the database tables.
+const schema = `
+CREATE TABLE IF NOT EXISTS runs (
+	id          INTEGER PRIMARY KEY AUTOINCREMENT,
+	command     TEXT NOT NULL DEFAULT 'chat batch',
+	args        TEXT NOT NULL,
+	essay_type  TEXT NOT NULL,
+	models      TEXT NOT NULL,
+	template    TEXT NOT NULL DEFAULT '',
+	extractor   TEXT NOT NULL DEFAULT '',
+	score_range TEXT NOT NULL DEFAULT '',
+	samples     INTEGER NOT NULL DEFAULT 1,
+	sample_mode TEXT NOT NULL DEFAULT '',
+	results     TEXT NOT NULL DEFAULT '',
+	started     TEXT NOT NULL,
+	ended       TEXT NOT NULL DEFAULT ''
+);
+CREATE TABLE IF NOT EXISTS essays (
+	run_id     INTEGER NOT NULL REFERENCES runs(id),
+	pid        INTEGER NOT NULL,
+	essay_type TEXT NOT NULL,
+	essay      TEXT NOT NULL,
+	extra      TEXT NOT NULL DEFAULT '{}',
+	PRIMARY KEY (run_id, pid)
+);
+CREATE TABLE IF NOT EXISTS requests (
+	run_id            INTEGER NOT NULL REFERENCES runs(id),
+	chat_id           TEXT NOT NULL,
+	pid               INTEGER NOT NULL,
+	model             TEXT NOT NULL,
+	sample            INTEGER NOT NULL,
+	request           TEXT NOT NULL,
+	response          TEXT NOT NULL,
+	response_model    TEXT NOT NULL DEFAULT '',
+	prompt_tokens     INTEGER NOT NULL DEFAULT 0,
+	completion_tokens INTEGER NOT NULL DEFAULT 0,
+	error             TEXT NOT NULL DEFAULT '',
+	millis            INTEGER NOT NULL DEFAULT 0,
+	PRIMARY KEY (run_id, chat_id)
+);
+CREATE TABLE IF NOT EXISTS scores (
+	run_id   INTEGER NOT NULL REFERENCES runs(id),
+	pid      INTEGER NOT NULL,
+	model    TEXT NOT NULL,
+	score    REAL NOT NULL,
+	comments TEXT NOT NULL,
+	millis   INTEGER NOT NULL,
+	samples  TEXT NOT NULL DEFAULT '[]',
+	median   REAL NOT NULL DEFAULT 0,
+	sd       REAL NOT NULL DEFAULT 0,
+	review   INTEGER NOT NULL DEFAULT 0,
+	PRIMARY KEY (run_id, pid, model)
+);
+CREATE TABLE IF NOT EXISTS errors (
+	id      INTEGER PRIMARY KEY AUTOINCREMENT,
+	run_id  INTEGER NOT NULL REFERENCES runs(id),
+	pid     INTEGER NOT NULL,
+	model   TEXT NOT NULL,
+	stage   TEXT NOT NULL,
+	message TEXT NOT NULL
+);
+`
+
+// migrations add the columns of later versions to the tables of an existing
+// database.
+var migrations = []struct {
+	table, column, definition string
+}{
+	{"runs", "command", "TEXT NOT NULL DEFAULT 'chat batch'"},
+}
+
+// Commands that record runs.
+const (
+	ChatBatchCommand     = "chat batch"
+	ChatRubricCommand    = "chat rubric"
+	CompleteBatchCommand = "complete batch" // whose requests are completions
+	BatchCollectCommand  = "batch collect"
+)
+
+// Error stages.
+const (
+	RequestStage = "request" // the API request failed
+	ExtractStage = "extract" // the score could not be extracted
+)
+
+// Store is a SQLite results database.
+type Store struct {
+	db *sql.DB
+}
+
+// Open opens a database file, creating it and its tables if necessary.
+func Open(path string) (*Store, error) {
+	db, err := sql.Open("sqlite", path+"?_pragma=journal_mode(WAL)&_pragma=synchronous(NORMAL)&_pragma=busy_timeout(5000)")
+	if err != nil {
+		return nil, fmt.Errorf("open store %s: %w", path, err)
+	}
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("open store %s: %w", path, err)
+	}
+	s := &Store{db: db}
+	if err := s.migrate(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("open store %s: %w", path, err)
+	}
+	return s, nil
+}
+
+// migrate adds the migrations columns that an existing database lacks.
+func (s *Store) migrate() error {
+	for _, m := range migrations {
+		var n int
+		err := s.db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?`, m.table, m.column).Scan(&n)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			if _, err := s.db.Exec(`ALTER TABLE ` + m.table + ` ADD COLUMN ` + m.column + ` ` + m.definition); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Close closes the database.
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Run describes a run of a command, e.g. ChatBatchCommand. The counts and
+// usage are filled in when a run is read.
+type Run struct {
+	ID         int64
+	Command    string
+	Args       []string
+	EssayType  string
+	Models     []string
+	Template   string
+	Extractor  string
+	ScoreRange string
+	Samples    int
+	SampleMode string
+	Results    string
+	Started    time.Time
+	Ended      time.Time
+
+	Essays   int
+	Requests int
+	Scores   int
+	Errors   int
+	Usage    openai.Usage
+}
+
+// Request is a stored chat request and its response.
+type Request struct {
+	PID    int
+	Model  string
+	Sample int
+	Chat   openai.Chat
+
+	// Completion holds the completion request and response instead, for
+	// CompleteBatchCommand runs, whose Chat holds only the ID, error, and
+	// timing.
+	Completion *Completion
+}
+
+// Completion is a completion request and its response.
+type Completion struct {
+	Request  openai.CompletionRequest `json:"request"`
+	Response openai.Completion        `json:"response"`
+}
+
+// Error is a failed request or score extraction.
+type Error struct {
+	PID     int
+	Model   string
+	Stage   string
+	Message string
+}
+
+// CreateRun inserts a run, setting its ID.
+func (s *Store) CreateRun(r *Run) error {
+	args, _ := json.Marshal(r.Args)
+	if r.Started.IsZero() {
+		r.Started = time.Now()
+	}
+	if r.Command == "" {
+		r.Command = ChatBatchCommand
+	}
+	result, err := s.db.Exec(`INSERT INTO runs (command, args, essay_type, models, template, extractor, score_range,
+		samples, sample_mode, results, started) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		r.Command, string(args), r.EssayType, strings.Join(r.Models, ","), r.Template, r.Extractor, r.ScoreRange,
+		r.Samples, r.SampleMode, r.Results, formatTime(r.Started))
+	if err != nil {
+		return fmt.Errorf("create run: %w", err)
+	}
+	r.ID, err = result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("create run: %w", err)
+	}
+	return nil
+}
+
+// EndRun records the end time of a run.
+func (s *Store) EndRun(id int64) error {
+	if _, err := s.db.Exec(`UPDATE runs SET ended = ? WHERE id = ?`, formatTime(time.Now()), id); err != nil {
+		return fmt.Errorf("end run %d: %w", id, err)
+	}
+	return nil
+}
+
+// AddEssays records the essays of a run.
+func (s *Store) AddEssays(id int64, essayType string, essays []data.EssayRecord) error {
+	return s.transaction(func(tx *sql.Tx) error {
+		stmt, err := tx.Prepare(`INSERT OR REPLACE INTO essays (run_id, pid, essay_type, essay, extra) VALUES (?, ?, ?, ?, ?)`)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+		for _, essay := range essays {
+			extra, _ := json.Marshal(essay.Extra)
+			if _, err := stmt.Exec(id, essay.ID, essayType, essay.SelectEssay(essayType), string(extra)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// AddRequests records the chat requests and responses of a run.
+func (s *Store) AddRequests(id int64, requests []Request) error {
+	return s.transaction(func(tx *sql.Tx) error {
+		stmt, err := tx.Prepare(`INSERT OR REPLACE INTO requests (run_id, chat_id, pid, model, sample, request,
+			response, response_model, prompt_tokens, completion_tokens, error, millis)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+		for _, r := range requests {
+			var request, response interface{} = r.Chat.Request, r.Chat.Response
+			model, usage := r.Chat.Response.Model, r.Chat.Response.Usage
+			if r.Completion != nil {
+				request, response = r.Completion.Request, r.Completion.Response
+				model, usage = r.Completion.Response.Model, r.Completion.Response.Usage
+			}
+			requestJSON, err := json.Marshal(request)
+			if err != nil {
+				return err
+			}
+			responseJSON, err := json.Marshal(response)
+			if err != nil {
+				return err
+			}
+			_, err = stmt.Exec(id, r.Chat.ID, r.PID, r.Model, r.Sample, string(requestJSON), string(responseJSON),
+				model, usage.PromptTokens, usage.CompletionTokens, r.Chat.ErrMsg, r.Chat.Millis)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// AddScores records a model's extracted scores for a run.
+func (s *Store) AddScores(id int64, model string, scores []data.EssayScore) error {
+	return s.transaction(func(tx *sql.Tx) error {
+		stmt, err := tx.Prepare(`INSERT OR REPLACE INTO scores (run_id, pid, model, score, comments, millis,
+			samples, median, sd, review) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+		for _, score := range scores {
+			samples, _ := json.Marshal(score.Samples)
+			if score.Samples == nil {
+				samples = []byte("[]")
+			}
+			_, err := stmt.Exec(id, score.ID, model, score.Score, score.Comments, score.Millis,
+				string(samples), score.Median, score.SD, score.Review)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// AddErrors records the errors of a run.
+func (s *Store) AddErrors(id int64, errs []Error) error {
+	return s.transaction(func(tx *sql.Tx) error {
+		stmt, err := tx.Prepare(`INSERT INTO errors (run_id, pid, model, stage, message) VALUES (?, ?, ?, ?, ?)`)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+		for _, e := range errs {
+			if _, err := stmt.Exec(id, e.PID, e.Model, e.Stage, e.Message); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// runQuery selects runs with their counts and usage.
+const runQuery = `SELECT r.id, r.command, r.args, r.essay_type, r.models, r.template, r.extractor, r.score_range,
+	r.samples, r.sample_mode, r.results, r.started, r.ended,
+	(SELECT COUNT(*) FROM essays WHERE run_id = r.id),
+	(SELECT COUNT(*) FROM requests WHERE run_id = r.id),
+	(SELECT COUNT(*) FROM scores WHERE run_id = r.id),
+	(SELECT COUNT(*) FROM errors WHERE run_id = r.id),
+	(SELECT COALESCE(SUM(prompt_tokens), 0) FROM requests WHERE run_id = r.id),
+	(SELECT COALESCE(SUM(completion_tokens), 0) FROM requests WHERE run_id = r.id)
+	FROM runs r`
+
+// Runs returns every run, in order.
+func (s *Store) Runs() ([]Run, error) {
+	rows, err := s.db.Query(runQuery + ` ORDER BY r.id`)
+	if err != nil {
+		return nil, fmt.Errorf("list runs: %w", err)
+	}
+	defer rows.Close()
+	var runs []Run
+	for rows.Next() {
+		r, err := scanRun(rows)
+		if err != nil {
+			return nil, fmt.Errorf("list runs: %w", err)
+		}
+		runs = append(runs, r)
+	}
+	return runs, rows.Err()
+}
+
+// Run returns a run by ID.
+func (s *Store) Run(id int64) (Run, error) {
+	rows, err := s.db.Query(runQuery+` WHERE r.id = ?`, id)
+	if err != nil {
+		return Run{}, fmt.Errorf("run %d: %w", id, err)
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return Run{}, fmt.Errorf("run %d: %w", id, err)
+		}
+		return Run{}, fmt.Errorf("run %d not found", id)
+	}
+	r, err := scanRun(rows)
+	if err != nil {
+		return r, fmt.Errorf("run %d: %w", id, err)
+	}
+	return r, nil
+}
+
+// scanRun scans a row of the run query.
+func scanRun(rows *sql.Rows) (Run, error) {
+	var r Run
+	var args, models, started, ended string
+	err := rows.Scan(&r.ID, &r.Command, &args, &r.EssayType, &models, &r.Template, &r.Extractor, &r.ScoreRange,
+		&r.Samples, &r.SampleMode, &r.Results, &started, &ended,
+		&r.Essays, &r.Requests, &r.Scores, &r.Errors, &r.Usage.PromptTokens, &r.Usage.CompletionTokens)
+	if err != nil {
+		return r, err
+	}
+	r.Usage.TotalTokens = r.Usage.PromptTokens + r.Usage.CompletionTokens
+	if err := json.Unmarshal([]byte(args), &r.Args); err != nil {
+		return r, err
+	}
+	if models != "" {
+		r.Models = strings.Split(models, ",")
+	}
+	r.Started, _ = time.Parse(time.RFC3339Nano, started)
+	r.Ended, _ = time.Parse(time.RFC3339Nano, ended)
+	return r, nil
+}
+
+// Essays returns the essays of a run, in pid order.
+func (s *Store) Essays(id int64) ([]data.EssayRecord, error) {
+	rows, err := s.db.Query(`SELECT pid, essay_type, essay, extra FROM essays WHERE run_id = ? ORDER BY pid`, id)
+	if err != nil {
+		return nil, fmt.Errorf("run %d essays: %w", id, err)
+	}
+	defer rows.Close()
+	var essays []data.EssayRecord
+	for rows.Next() {
+		var pid int
+		var essayType, essay, extra string
+		if err := rows.Scan(&pid, &essayType, &essay, &extra); err != nil {
+			return nil, fmt.Errorf("run %d essays: %w", id, err)
+		}
+		var extraColumns map[string]string
+		_ = json.Unmarshal([]byte(extra), &extraColumns)
+		essays = append(essays, data.NewEssayRecord(pid, essayType, essay, extraColumns))
+	}
+	return essays, rows.Err()
+}
+
+// Requests returns a model's chat requests and responses of a run, or its
+// completions, in pid and sample order. An empty model returns the requests of
+// every model.
+func (s *Store) Requests(id int64, model string) ([]Request, error) {
+	var command string
+	if err := s.db.QueryRow(`SELECT command FROM runs WHERE id = ?`, id).Scan(&command); err != nil {
+		return nil, fmt.Errorf("run %d requests: %w", id, err)
+	}
+	rows, err := s.db.Query(`SELECT chat_id, pid, model, sample, request, response, error, millis FROM requests
+		WHERE run_id = ? AND (? = '' OR model = ?) ORDER BY pid, model, sample`, id, model, model)
+	if err != nil {
+		return nil, fmt.Errorf("run %d requests: %w", id, err)
+	}
+	defer rows.Close()
+	var requests []Request
+	for rows.Next() {
+		var r Request
+		var request, response string
+		err := rows.Scan(&r.Chat.ID, &r.PID, &r.Model, &r.Sample, &request, &response, &r.Chat.ErrMsg, &r.Chat.Millis)
+		if err != nil {
+			return nil, fmt.Errorf("run %d requests: %w", id, err)
+		}
+		var req, resp interface{} = &r.Chat.Request, &r.Chat.Response
+		if command == CompleteBatchCommand {
+			r.Completion = &Completion{}
+			req, resp = &r.Completion.Request, &r.Completion.Response
+		}
+		if err := json.Unmarshal([]byte(request), req); err != nil {
+			return nil, fmt.Errorf("run %d request %s: %w", id, r.Chat.ID, err)
+		}
+		if err := json.Unmarshal([]byte(response), resp); err != nil {
+			return nil, fmt.Errorf("run %d response %s: %w", id, r.Chat.ID, err)
+		}
+		requests = append(requests, r)
+	}
+	return requests, rows.Err()
+}
+
+// Scores returns a model's stored scores of a run, in pid order.
+func (s *Store) Scores(id int64, model string) ([]data.EssayScore, error) {
+	rows, err := s.db.Query(`SELECT s.pid, e.essay_type, e.essay, e.extra, s.score, s.comments, s.millis,
+		s.samples, s.median, s.sd, s.review FROM scores s JOIN essays e ON e.run_id = s.run_id AND e.pid = s.pid
+		WHERE s.run_id = ? AND s.model = ? ORDER BY s.pid`, id, model)
+	if err != nil {
+		return nil, fmt.Errorf("run %d scores: %w", id, err)
+	}
+	defer rows.Close()
+	var scores []data.EssayScore
+	for rows.Next() {
+		var score data.EssayScore
+		var extra, samples string
+		err := rows.Scan(&score.ID, &score.EssayType, &score.Essay, &extra, &score.Score, &score.Comments,
+			&score.Millis, &samples, &score.Median, &score.SD, &score.Review)
+		if err != nil {
+			return nil, fmt.Errorf("run %d scores: %w", id, err)
+		}
+		_ = json.Unmarshal([]byte(extra), &score.Extra)
+		_ = json.Unmarshal([]byte(samples), &score.Samples)
+		if len(score.Samples) == 0 {
+			score.Samples = nil
+		}
+		scores = append(scores, score)
+	}
+	return scores, rows.Err()
+}
+
+// Errors returns the errors of a run, in order.
+func (s *Store) Errors(id int64) ([]Error, error) {
+	rows, err := s.db.Query(`SELECT pid, model, stage, message FROM errors WHERE run_id = ? ORDER BY id`, id)
+	if err != nil {
+		return nil, fmt.Errorf("run %d errors: %w", id, err)
+	}
+	defer rows.Close()
+	var errs []Error
+	for rows.Next() {
+		var e Error
+		if err := rows.Scan(&e.PID, &e.Model, &e.Stage, &e.Message); err != nil {
+			return nil, fmt.Errorf("run %d errors: %w", id, err)
+		}
+		errs = append(errs, e)
+	}
+	return errs, rows.Err()
+}
+
+// Millis returns the request timings of a run, in milliseconds.
+func (s *Store) Millis(id int64) ([]float64, error) {
+	rows, err := s.db.Query(`SELECT millis FROM requests WHERE run_id = ? AND error = ''`, id)
+	if err != nil {
+		return nil, fmt.Errorf("run %d timings: %w", id, err)
+	}
+	defer rows.Close()
+	var millis []float64
+	for rows.Next() {
+		var m int64
+		if err := rows.Scan(&m); err != nil {
+			return nil, fmt.Errorf("run %d timings: %w", id, err)
+		}
+		millis = append(millis, float64(m))
+	}
+	return millis, rows.Err()
+}
+
+// transaction runs a function in a transaction, committing it if the function
+// succeeds.
+func (s *Store) transaction(f func(tx *sql.Tx) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("store: %w", err)
+	}
+	if err := f(tx); err != nil {
+		_ = tx.Rollback()
+		return fmt.Errorf("store: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("store: %w", err)
+	}
+	return nil
+}
+
+// formatTime formats a time for storage.
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
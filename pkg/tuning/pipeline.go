@@ -0,0 +1,159 @@
+package tuning
+
+import (
+	"content-coding-gpt/pkg/data"
+	"content-coding-gpt/pkg/openai"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Pipeline steps, in order.
+const (
+	SplitStep    = "split"
+	PrepareStep  = "prepare"
+	ValidateStep = "validate"
+	UploadStep   = "upload"
+	CreateStep   = "create"
+	WaitStep     = "wait"
+	EvaluateStep = "evaluate"
+)
+
+// PipelineSteps lists the pipeline steps in order.
+var PipelineSteps = []string{SplitStep, PrepareStep, ValidateStep, UploadStep, CreateStep, WaitStep, EvaluateStep}
+
+// PipelineFile is the name of the pipeline state file in its directory.
+const PipelineFile = "pipeline.json"
+
+// Pipeline is the saved state of a fine-tune pipeline, which takes a
+// human-coded training CSV file through the pipeline steps. The state is
+// saved in the pipeline directory after every step (and every upload), so
+// an interrupted pipeline resumes where it stopped.
+type Pipeline struct {
+	Dir       string                 `json:"-"`
+	Input     string                 `json:"input"`
+	EssayType string                 `json:"essay_type"`
+	Splitter  data.Splitter          `json:"splitter"`
+	Request   openai.FineTuneRequest `json:"request"`    // file IDs are filled in by the create step
+	MaxTokens int                    `json:"max_tokens"` // completion tokens of the evaluation
+
+	// Files maps the split names (train, validation, test) to their CSV
+	// files, and JSONL maps the train and validation splits to their
+	// training files.
+	Files map[string]string `json:"files,omitempty"`
+	JSONL map[string]string `json:"jsonl,omitempty"`
+
+	// FileIDs maps the train and validation splits to their uploaded files.
+	FileIDs map[string]string `json:"file_ids,omitempty"`
+
+	TuneID         string `json:"tune_id,omitempty"`
+	Status         string `json:"status,omitempty"`
+	FineTunedModel string `json:"fine_tuned_model,omitempty"`
+
+	// Results is the held-out test set results file, and Evaluation its
+	// agreement report.
+	Results    string `json:"results,omitempty"`
+	Evaluation string `json:"evaluation,omitempty"`
+
+	Done    []string  `json:"done"`
+	Updated time.Time `json:"updated"`
+}
+
+// NewPipeline creates the state of a new pipeline in a directory.
+func NewPipeline(dir, input, essayType string) *Pipeline {
+	return &Pipeline{
+		Dir:       dir,
+		Input:     input,
+		EssayType: essayType,
+		Files:     map[string]string{},
+		JSONL:     map[string]string{},
+		FileIDs:   map[string]string{},
+	}
+}
+
+// ReadPipeline reads the state of a pipeline from its directory. The error
+// wraps os.ErrNotExist if there is no saved state.
+func ReadPipeline(dir string) (*Pipeline, error) {
+	path := filepath.Join(dir, PipelineFile)
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read pipeline %s: %w", path, err)
+	}
+	p := NewPipeline(dir, "", "")
+	if err := json.Unmarshal(b, p); err != nil {
+		return nil, fmt.Errorf("read pipeline %s: %w", path, err)
+	}
+	return p, nil
+}
+
+// Save writes the state of the pipeline to its directory, replacing the
+// previous state atomically.
+func (p *Pipeline) Save() error {
+	path := filepath.Join(p.Dir, PipelineFile)
+	p.Updated = time.Now().UTC()
+	if err := writeState(path, p); err != nil {
+		return fmt.Errorf("save pipeline %s: %w", path, err)
+	}
+	return nil
+}
+
+// writeState writes a state value to a JSON file atomically, creating its
+// directory if needed.
+func writeState(path string, v interface{}) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path+".tmp", append(b, '\n'), 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// Completed returns true if the step has been completed.
+func (p *Pipeline) Completed(step string) bool {
+	for _, s := range p.Done {
+		if s == step {
+			return true
+		}
+	}
+	return false
+}
+
+// Complete marks the step completed, and saves the state.
+func (p *Pipeline) Complete(step string) error {
+	if !p.Completed(step) {
+		p.Done = append(p.Done, step)
+	}
+	return p.Save()
+}
+
+// Failed returns true if the pipeline's fine-tune job failed or was
+// cancelled.
+func (p *Pipeline) Failed() bool {
+	return p.TuneID != "" && (p.Status == openai.FineTuneFailed || p.Status == openai.FineTuneCancelled)
+}
+
+// Resubmit forgets the pipeline's fine-tune job, so that the create step
+// submits a new one, and saves the state.
+func (p *Pipeline) Resubmit() error {
+	p.TuneID, p.Status, p.FineTunedModel = "", "", ""
+	done := p.Done[:0]
+	for _, step := range p.Done {
+		if step != CreateStep && step != WaitStep {
+			done = append(done, step)
+		}
+	}
+	p.Done = done
+	return p.Save()
+}
+
+// Path returns the path of a file in the pipeline directory.
+func (p *Pipeline) Path(name string) string {
+	return filepath.Join(p.Dir, name)
+}
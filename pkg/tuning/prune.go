@@ -0,0 +1,149 @@
+package tuning
+
+import (
+	"content-coding-gpt/pkg/openai"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+// PruneFilter selects the fine-tuned models or uploaded files to delete. A
+// model or file must match every filter that is set.
+type PruneFilter struct {
+	// OlderThan selects those created more than this long before Now.
+	OlderThan time.Duration
+	Now       time.Time
+
+	// Pattern is a glob pattern (see path.Match) matched against a model's
+	// name suffix, or a file's name.
+	Pattern string
+
+	// Statuses selects those with one of the statuses, e.g. "failed".
+	Statuses []string
+
+	// Unreferenced selects the models that are not in Referenced, the models
+	// recorded by the provenance manifests and the pipeline and sweep state
+	// files, and the files that are not the training, validation, or result
+	// files of such a model.
+	Unreferenced bool
+	Referenced   map[string]bool
+}
+
+// Validate checks the pattern.
+func (f PruneFilter) Validate() error {
+	if _, err := path.Match(f.Pattern, ""); err != nil {
+		return fmt.Errorf("invalid pattern %q: %w", f.Pattern, err)
+	}
+	return nil
+}
+
+// match returns true if the creation time, name, and status match the age,
+// pattern, and status filters.
+func (f PruneFilter) match(createdAt int64, name, status string) bool {
+	if f.OlderThan > 0 && f.Now.Sub(time.Unix(createdAt, 0)) <= f.OlderThan {
+		return false
+	}
+	if f.Pattern != "" {
+		if ok, _ := path.Match(f.Pattern, name); !ok {
+			return false
+		}
+	}
+	if len(f.Statuses) > 0 && !containsString(f.Statuses, status) {
+		return false
+	}
+	return true
+}
+
+// Tunes returns the fine-tune jobs whose models are selected. Jobs without a
+// fine-tuned model have nothing to delete, and are never selected.
+func (f PruneFilter) Tunes(tunes []openai.FineTune) []openai.FineTune {
+	var selected []openai.FineTune
+	for _, t := range tunes {
+		if t.FineTunedModel == "" || !f.match(t.CreatedAt, t.Suffix(), t.Status) {
+			continue
+		}
+		if f.Unreferenced && f.Referenced[t.FineTunedModel] {
+			continue
+		}
+		selected = append(selected, t)
+	}
+	return selected
+}
+
+// Files returns the selected files. The files of fine-tune jobs and batches
+// that have not finished are never selected.
+func (f PruneFilter) Files(files []openai.File, tunes []openai.FineTune, batches []openai.Batch) []openai.File {
+	protected := map[string]bool{}
+	for _, b := range batches {
+		if b.Done() {
+			continue
+		}
+		for _, id := range []string{b.InputFileID, b.OutputFileID, b.ErrorFileID} {
+			if id != "" {
+				protected[id] = true
+			}
+		}
+	}
+	for _, t := range tunes {
+		if t.Done() && !(f.Unreferenced && f.Referenced[t.FineTunedModel]) {
+			continue
+		}
+		for _, list := range [][]openai.File{t.TrainingFiles, t.ValidationFiles, t.ResultFiles} {
+			for _, file := range list {
+				protected[file.ID] = true
+			}
+		}
+	}
+	var selected []openai.File
+	for _, file := range files {
+		if !protected[file.ID] && f.match(file.CreatedAt, file.FileName, file.Status) {
+			selected = append(selected, file)
+		}
+	}
+	return selected
+}
+
+// StateModels returns the fine-tuned models recorded by the pipeline and
+// sweep state files found under the root directories. A missing root is an
+// error, as in provenance.ReferencedModels.
+func StateModels(roots ...string) (map[string]bool, error) {
+	models := map[string]bool{}
+	for _, root := range roots {
+		err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() || (d.Name() != PipelineFile && d.Name() != SweepFile) {
+				return nil
+			}
+			b, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			var state struct {
+				FineTunedModel string     `json:"fine_tuned_model"`
+				Jobs           []SweepJob `json:"jobs"`
+			}
+			if err := json.Unmarshal(b, &state); err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+			if state.FineTunedModel != "" {
+				models[state.FineTunedModel] = true
+			}
+			for _, j := range state.Jobs {
+				if j.FineTunedModel != "" {
+					models[j.FineTunedModel] = true
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("state models: %w", err)
+		}
+	}
+	return models, nil
+}
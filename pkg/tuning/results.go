@@ -0,0 +1,185 @@
+// Package tuning analyses fine-tune jobs: the step metrics of their result
+// files, smoothed learning curves, and signs of overfitting.
+package tuning
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Result is a row of a fine-tune result file: the training metrics of a
+// step, and the validation metrics of the steps that were evaluated against
+// the validation file (NaN otherwise).
+type Result struct {
+	Step                       int
+	ElapsedTokens              int
+	ElapsedExamples            int
+	TrainingLoss               float64
+	TrainingSequenceAccuracy   float64
+	TrainingTokenAccuracy      float64
+	ValidationLoss             float64
+	ValidationSequenceAccuracy float64
+	ValidationTokenAccuracy    float64
+
+	// Classification holds the classification/* metrics, e.g. "accuracy"
+	// or "weighted_f1_score", of the steps they were computed for.
+	Classification map[string]float64
+}
+
+// Results are the rows of a fine-tune result file.
+type Results struct {
+	Name string // job ID or file name
+	Rows []Result
+}
+
+// HasValidation returns true if any step has validation metrics.
+func (r Results) HasValidation() bool {
+	for _, row := range r.Rows {
+		if !math.IsNaN(row.ValidationLoss) {
+			return true
+		}
+	}
+	return false
+}
+
+// Metric names, as in the result file columns.
+const (
+	TrainingLoss               = "training_loss"
+	TrainingSequenceAccuracy   = "training_sequence_accuracy"
+	TrainingTokenAccuracy      = "training_token_accuracy"
+	ValidationLoss             = "validation_loss"
+	ValidationSequenceAccuracy = "validation_sequence_accuracy"
+	ValidationTokenAccuracy    = "validation_token_accuracy"
+)
+
+// Metric returns the steps and values of a metric (a result file column
+// name), omitting the steps without a value.
+func (r Results) Metric(name string) ([]float64, []float64) {
+	var steps, values []float64
+	for _, row := range r.Rows {
+		v := row.Metric(name)
+		if math.IsNaN(v) {
+			continue
+		}
+		steps = append(steps, float64(row.Step))
+		values = append(values, v)
+	}
+	return steps, values
+}
+
+// Metric returns the value of a metric (a result file column name), or NaN.
+func (r Result) Metric(name string) float64 {
+	switch name {
+	case TrainingLoss:
+		return r.TrainingLoss
+	case TrainingSequenceAccuracy:
+		return r.TrainingSequenceAccuracy
+	case TrainingTokenAccuracy:
+		return r.TrainingTokenAccuracy
+	case ValidationLoss:
+		return r.ValidationLoss
+	case ValidationSequenceAccuracy:
+		return r.ValidationSequenceAccuracy
+	case ValidationTokenAccuracy:
+		return r.ValidationTokenAccuracy
+	}
+	if v, ok := r.Classification[strings.TrimPrefix(name, "classification/")]; ok {
+		return v
+	}
+	return math.NaN()
+}
+
+// ReadResultsFile reads a fine-tune result file.
+func ReadResultsFile(path string) (Results, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Results{}, fmt.Errorf("read fine-tune results %s: %w", path, err)
+	}
+	defer f.Close()
+	r, err := ParseResults(f)
+	if err != nil {
+		return r, fmt.Errorf("read fine-tune results %s: %w", path, err)
+	}
+	return r, nil
+}
+
+// ParseResults parses a fine-tune result file in CSV format. The step and
+// training columns are required; the validation and classification columns
+// are optional, and may be empty.
+func ParseResults(r io.Reader) (Results, error) {
+	var results Results
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return results, err
+	}
+	if len(records) == 0 {
+		return results, fmt.Errorf("no header")
+	}
+	columns := map[string]int{}
+	for i, h := range records[0] {
+		columns[strings.TrimSpace(h)] = i
+	}
+	for _, name := range []string{"step", TrainingLoss, TrainingSequenceAccuracy, TrainingTokenAccuracy} {
+		if _, ok := columns[name]; !ok {
+			return results, fmt.Errorf("no %s column", name)
+		}
+	}
+	for n, record := range records[1:] {
+		field := func(name string) (float64, error) {
+			i, ok := columns[name]
+			if !ok || i >= len(record) || strings.TrimSpace(record[i]) == "" {
+				return math.NaN(), nil
+			}
+			v, err := strconv.ParseFloat(strings.TrimSpace(record[i]), 64)
+			if err != nil {
+				return v, fmt.Errorf("line %d: %s: %w", n+2, name, err)
+			}
+			return v, nil
+		}
+		var row Result
+		var values [9]float64
+		for i, name := range []string{"step", "elapsed_tokens", "elapsed_examples",
+			TrainingLoss, TrainingSequenceAccuracy, TrainingTokenAccuracy,
+			ValidationLoss, ValidationSequenceAccuracy, ValidationTokenAccuracy} {
+			if values[i], err = field(name); err != nil {
+				return results, err
+			}
+		}
+		if math.IsNaN(values[0]) {
+			return results, fmt.Errorf("line %d: no step", n+2)
+		}
+		row.Step, row.ElapsedTokens, row.ElapsedExamples = int(values[0]), intValue(values[1]), intValue(values[2])
+		row.TrainingLoss, row.TrainingSequenceAccuracy, row.TrainingTokenAccuracy = values[3], values[4], values[5]
+		row.ValidationLoss, row.ValidationSequenceAccuracy, row.ValidationTokenAccuracy = values[6], values[7], values[8]
+		for name := range columns {
+			if !strings.HasPrefix(name, "classification/") {
+				continue
+			}
+			v, err := field(name)
+			if err != nil {
+				return results, err
+			}
+			if !math.IsNaN(v) {
+				if row.Classification == nil {
+					row.Classification = map[string]float64{}
+				}
+				row.Classification[strings.TrimPrefix(name, "classification/")] = v
+			}
+		}
+		results.Rows = append(results.Rows, row)
+	}
+	return results, nil
+}
+
+// intValue converts a count to an int, with NaN as 0.
+func intValue(v float64) int {
+	if math.IsNaN(v) {
+		return 0
+	}
+	return int(v)
+}
@@ -0,0 +1,103 @@
+package tuning
+
+import (
+	"math"
+)
+
+// Smooth returns the exponentially weighted moving average of the values,
+// with the given weight (0-1) of the previous average, bias-corrected so the
+// first values are not pulled toward zero. A weight of 0 returns the values.
+func Smooth(values []float64, weight float64) []float64 {
+	smoothed := make([]float64, len(values))
+	var average, norm float64
+	for i, v := range values {
+		average = weight*average + (1-weight)*v
+		norm = weight*norm + (1 - weight)
+		smoothed[i] = average / norm
+	}
+	return smoothed
+}
+
+// Summary summarizes the learning curves of a fine-tune job: the final
+// smoothed training and validation metrics, and the step where the smoothed
+// validation loss was lowest. Validation metrics are NaN if the job had no
+// validation file.
+type Summary struct {
+	Name            string
+	Steps           int
+	ElapsedTokens   int
+	ElapsedExamples int
+
+	TrainingLoss             float64
+	TrainingSequenceAccuracy float64
+	TrainingTokenAccuracy    float64
+
+	ValidationLoss             float64
+	ValidationSequenceAccuracy float64
+	ValidationTokenAccuracy    float64
+
+	// BestStep is the step with the lowest smoothed validation loss, and
+	// BestValidationLoss that loss.
+	BestStep           int
+	BestValidationLoss float64
+
+	// Overfitting is true if the smoothed validation loss has risen more than
+	// the tolerance above its minimum, while the smoothed training loss kept
+	// falling, from BestStep on.
+	Overfitting bool
+}
+
+// Summarize summarizes fine-tune results, smoothing the curves with the
+// given weight (see Smooth). Overfitting is detected when the final smoothed
+// validation loss exceeds its minimum by more than the relative tolerance,
+// e.g. 0.05, and the final smoothed training loss is below its value at that
+// minimum.
+func Summarize(r Results, weight, tolerance float64) Summary {
+	s := Summary{Name: r.Name, BestValidationLoss: math.NaN()}
+	if len(r.Rows) == 0 {
+		s.TrainingLoss, s.TrainingSequenceAccuracy, s.TrainingTokenAccuracy = math.NaN(), math.NaN(), math.NaN()
+		s.ValidationLoss, s.ValidationSequenceAccuracy, s.ValidationTokenAccuracy = math.NaN(), math.NaN(), math.NaN()
+		return s
+	}
+	last := r.Rows[len(r.Rows)-1]
+	s.Steps, s.ElapsedTokens, s.ElapsedExamples = last.Step, last.ElapsedTokens, last.ElapsedExamples
+	final := func(name string) float64 {
+		_, values := r.Metric(name)
+		if len(values) == 0 {
+			return math.NaN()
+		}
+		return Smooth(values, weight)[len(values)-1]
+	}
+	s.TrainingLoss = final(TrainingLoss)
+	s.TrainingSequenceAccuracy = final(TrainingSequenceAccuracy)
+	s.TrainingTokenAccuracy = final(TrainingTokenAccuracy)
+	s.ValidationLoss = final(ValidationLoss)
+	s.ValidationSequenceAccuracy = final(ValidationSequenceAccuracy)
+	s.ValidationTokenAccuracy = final(ValidationTokenAccuracy)
+
+	// Find the minimum smoothed validation loss, and compare the training
+	// loss there with its final value:
+	steps, values := r.Metric(ValidationLoss)
+	if len(values) == 0 {
+		return s
+	}
+	smoothed := Smooth(values, weight)
+	best := 0
+	for i, v := range smoothed {
+		if v < smoothed[best] {
+			best = i
+		}
+	}
+	s.BestStep, s.BestValidationLoss = int(steps[best]), smoothed[best]
+	trainingSteps, trainingValues := r.Metric(TrainingLoss)
+	training := Smooth(trainingValues, weight)
+	var atBest float64
+	for i, step := range trainingSteps {
+		if int(step) > s.BestStep {
+			break
+		}
+		atBest = training[i]
+	}
+	s.Overfitting = s.ValidationLoss > s.BestValidationLoss*(1+tolerance) && s.TrainingLoss < atBest
+	return s
+}
@@ -0,0 +1,339 @@
+package tuning
+
+import (
+	"bytes"
+	"content-coding-gpt/pkg/data"
+	"content-coding-gpt/pkg/openai"
+	"content-coding-gpt/pkg/stats"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Sweep describes a hyperparameter sweep: a fine-tune job for every
+// combination of base model, epochs, and learning rate multiplier, on the
+// same uploaded files. For example:
+//
+//	name: angry-sweep
+//	training_file: file-abc123
+//	validation_file: file-def456
+//	test: data/pipelines/training_angry/test.csv
+//	essay_type: angry
+//	models: [ada, curie]
+//	epochs: [2, 4]
+//	learning_rates: [0.05, 0.1, 0.2]
+//	max_concurrent: 2
+//
+// An epochs or learning rate of 0 is the API default. A request n_epochs or
+// learning_rate_multiplier is the only value of its grid dimension.
+type Sweep struct {
+	// Name is the sweep name, and the name of its directory.
+	Name string `yaml:"name"`
+
+	// Output is the directory containing the sweep directory (default "data/sweeps").
+	Output string `yaml:"output"`
+
+	// TrainingFile and ValidationFile are the uploaded file IDs.
+	TrainingFile   string `yaml:"training_file"`
+	ValidationFile string `yaml:"validation_file"`
+
+	// Test is the held-out humility or spiritual training CSV file that each
+	// fine-tuned model is evaluated on, as EssayType essays.
+	Test      string `yaml:"test"`
+	EssayType string `yaml:"essay_type"`
+
+	// The grid dimensions.
+	Models        []string  `yaml:"models"`
+	Epochs        []int     `yaml:"epochs"`
+	LearningRates []float64 `yaml:"learning_rates"`
+
+	// Request holds the other fine-tune request fields of every job, e.g.
+	// batch_size or prompt_loss_weight.
+	Request openai.FineTuneRequest `yaml:"request"`
+
+	// Suffix is the fine-tuned model name suffix of every job.
+	Suffix string `yaml:"suffix"`
+
+	// MaxConcurrent is the maximum number of jobs in progress (default 2).
+	MaxConcurrent int `yaml:"max_concurrent"`
+
+	// MaxTokens is the maximum number of tokens generated per test essay
+	// (default 16).
+	MaxTokens int `yaml:"max_tokens"`
+
+	// RankBy is the agreement statistic used to rank the jobs: pearson,
+	// spearman, icc21 (the default), icc3k, kappa, or alpha.
+	RankBy string `yaml:"rank_by"`
+
+	// Resamples is the number of bootstrap resamples (default 200).
+	Resamples int `yaml:"resamples"`
+}
+
+// ReadSweep reads and validates a sweep spec file, filling in the defaults.
+// Unknown fields are rejected, as in fine-tune config files.
+func ReadSweep(path string) (Sweep, error) {
+	var s Sweep
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return s, fmt.Errorf("read sweep %s: %w", path, err)
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	if err := dec.Decode(&s); err != nil && err != io.EOF {
+		return s, fmt.Errorf("read sweep %s: %w", path, err)
+	}
+	if s.Name == "" {
+		s.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	if s.Output == "" {
+		s.Output = "data/sweeps"
+	}
+	switch {
+	case len(s.Epochs) == 0:
+		s.Epochs = []int{s.Request.EpochCount}
+	case s.Request.EpochCount != 0:
+		return s, fmt.Errorf("read sweep %s: request n_epochs conflicts with epochs; give one or the other", path)
+	}
+	switch {
+	case len(s.LearningRates) == 0:
+		s.LearningRates = []float64{s.Request.LearningRate}
+	case s.Request.LearningRate != 0:
+		return s, fmt.Errorf("read sweep %s: request learning_rate_multiplier conflicts with learning_rates; "+
+			"give one or the other", path)
+	}
+	s.Models, s.Epochs, s.LearningRates = uniqueValues(s.Models), uniqueValues(s.Epochs), uniqueValues(s.LearningRates)
+	if s.MaxConcurrent <= 0 {
+		s.MaxConcurrent = 2
+	}
+	if s.MaxTokens <= 0 {
+		s.MaxTokens = 16
+	}
+	if s.RankBy == "" {
+		s.RankBy = "icc21"
+	}
+	if s.Resamples <= 0 {
+		s.Resamples = 200
+	}
+	switch {
+	case len(s.Models) == 0:
+		err = errors.New("no models")
+	case s.TrainingFile == "":
+		err = errors.New("no training_file")
+	case s.Test == "":
+		err = errors.New("no test file")
+	case !data.IsHumility(s.EssayType) && !data.IsSpiritual(s.EssayType):
+		err = fmt.Errorf("essay_type %q is neither humility nor spiritual", s.EssayType)
+	case !containsString(stats.RankStatistics, s.RankBy):
+		err = fmt.Errorf("rank_by %s is not one of: %s", s.RankBy, strings.Join(stats.RankStatistics, ", "))
+	}
+	if err == nil {
+		models := map[string]string{}
+		for _, job := range s.Jobs() {
+			if model, ok := models[job.ID]; ok {
+				err = fmt.Errorf("models %s and %s have the same job ID %s", model, job.Model, job.ID)
+				break
+			}
+			models[job.ID] = job.Model
+			if err = s.JobRequest(job).Validate(); err != nil {
+				err = fmt.Errorf("job %s: %w", job.ID, err)
+				break
+			}
+		}
+	}
+	if err != nil {
+		return s, fmt.Errorf("read sweep %s: %w", path, err)
+	}
+	return s, nil
+}
+
+// Dir returns the sweep directory.
+func (s Sweep) Dir() string {
+	return filepath.Join(s.Output, s.Name)
+}
+
+// Jobs expands the sweep into its grid of jobs, in order of model, epochs,
+// and learning rate multiplier.
+func (s Sweep) Jobs() []*SweepJob {
+	var jobs []*SweepJob
+	for _, model := range s.Models {
+		for _, epochs := range s.Epochs {
+			for _, rate := range s.LearningRates {
+				j := &SweepJob{Model: model, Epochs: epochs, LearningRate: rate}
+				j.ID = sweepJobID(j)
+				jobs = append(jobs, j)
+			}
+		}
+	}
+	return jobs
+}
+
+// JobRequest returns the fine-tune request of a job.
+func (s Sweep) JobRequest(j *SweepJob) openai.FineTuneRequest {
+	req := s.Request
+	req.TrainingFileID, req.ValidationFileID = s.TrainingFile, s.ValidationFile
+	req.Model, req.EpochCount, req.LearningRate = j.Model, j.Epochs, j.LearningRate
+	if s.Suffix != "" {
+		req.Suffix = s.Suffix
+	}
+	return req
+}
+
+// SweepJob is the state of a sweep job: its hyperparameters, fine-tune job,
+// and evaluation on the test set.
+type SweepJob struct {
+	ID           string  `json:"id"`
+	Model        string  `json:"model"`
+	Epochs       int     `json:"epochs,omitempty"`
+	LearningRate float64 `json:"learning_rate,omitempty"`
+
+	TuneID         string `json:"tune_id,omitempty"`
+	Status         string `json:"status,omitempty"`
+	FineTunedModel string `json:"fine_tuned_model,omitempty"`
+
+	Results   string           `json:"results,omitempty"`
+	Scored    int              `json:"scored,omitempty"`
+	Agreement *stats.Agreement `json:"agreement,omitempty"`
+	Error     string           `json:"error,omitempty"`
+}
+
+// Active returns true if the job has been created, and has not finished.
+func (j *SweepJob) Active() bool {
+	return j.TuneID != "" && !(openai.FineTune{Status: j.Status}).Done()
+}
+
+// Finished returns true if the job failed or was cancelled, or succeeded and
+// has been evaluated.
+func (j *SweepJob) Finished() bool {
+	switch j.Status {
+	case openai.FineTuneFailed, openai.FineTuneCancelled:
+		return true
+	case openai.FineTuneSucceeded:
+		return j.Results != "" || j.Error != ""
+	}
+	return false
+}
+
+// Statistic returns the named agreement statistic of the job, or NaN.
+func (j *SweepJob) Statistic(name string) float64 {
+	if j.Agreement == nil {
+		return math.NaN()
+	}
+	return j.Agreement.Statistic(name)
+}
+
+// sweepJobID returns a readable, file-safe ID for a job, e.g. "curie_e4_lr0.1",
+// with "auto" for the API defaults.
+func sweepJobID(j *SweepJob) string {
+	epochs, rate := "auto", "auto"
+	if j.Epochs > 0 {
+		epochs = strconv.Itoa(j.Epochs)
+	}
+	if j.LearningRate > 0 {
+		rate = strconv.FormatFloat(j.LearningRate, 'g', -1, 64)
+	}
+	model := strings.Trim(strings.NewReplacer(":", "-", "/", "-", " ", "-").Replace(j.Model), "-")
+	return model + "_e" + epochs + "_lr" + rate
+}
+
+// SweepFile is the name of the sweep state file in its directory.
+const SweepFile = "sweep.json"
+
+// SweepState is the saved state of a sweep's jobs.
+type SweepState struct {
+	Jobs    []*SweepJob `json:"jobs"`
+	Updated time.Time   `json:"updated"`
+}
+
+// ReadSweepState reads the state of a sweep from its directory, and
+// reconciles it with the spec: jobs added to the spec are added, and jobs
+// removed from it are dropped unless they have already been created.
+func ReadSweepState(s Sweep) (*SweepState, error) {
+	state := &SweepState{}
+	path := filepath.Join(s.Dir(), SweepFile)
+	b, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(b, state); err != nil {
+			return nil, fmt.Errorf("read sweep state %s: %w", path, err)
+		}
+	case !errors.Is(err, os.ErrNotExist):
+		return nil, fmt.Errorf("read sweep state %s: %w", path, err)
+	}
+	saved := map[string]*SweepJob{}
+	for _, j := range state.Jobs {
+		saved[j.ID] = j
+	}
+	jobs := s.Jobs()
+	inSpec := map[string]bool{}
+	for i, j := range jobs {
+		inSpec[j.ID] = true
+		if job, ok := saved[j.ID]; ok {
+			jobs[i] = job
+		}
+	}
+	for _, j := range state.Jobs {
+		if !inSpec[j.ID] && j.TuneID != "" {
+			jobs = append(jobs, j)
+		}
+	}
+	state.Jobs = jobs
+	return state, nil
+}
+
+// Save writes the sweep state to the sweep directory, replacing the previous
+// state atomically.
+func (state *SweepState) Save(s Sweep) error {
+	path := filepath.Join(s.Dir(), SweepFile)
+	state.Updated = time.Now().UTC()
+	if err := writeState(path, state); err != nil {
+		return fmt.Errorf("save sweep state %s: %w", path, err)
+	}
+	return nil
+}
+
+// Ranked returns the jobs sorted by the named statistic, best first; jobs
+// without the statistic are last, in grid order.
+func (state *SweepState) Ranked(by string) []*SweepJob {
+	jobs := append([]*SweepJob{}, state.Jobs...)
+	sort.SliceStable(jobs, func(i, k int) bool {
+		a, b := jobs[i].Statistic(by), jobs[k].Statistic(by)
+		if math.IsNaN(b) {
+			return !math.IsNaN(a)
+		}
+		return a > b
+	})
+	return jobs
+}
+
+// uniqueValues returns the values without duplicates, in order.
+func uniqueValues[T comparable](values []T) []T {
+	seen := make(map[T]bool, len(values))
+	unique := values[:0]
+	for _, v := range values {
+		if !seen[v] {
+			seen[v] = true
+			unique = append(unique, v)
+		}
+	}
+	return unique
+}
+
+// containsString returns true if the list contains the string.
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}